@@ -1,35 +1,38 @@
 package main
 
 import (
-	"github.com/mat-sik/file-server-go/internal/client"
-	"github.com/mat-sik/file-server-go/internal/message"
+	"context"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/cli"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/tracing"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
-//go:generate protoc --proto_path=./../.. --go_out=./../.. --go_opt=module=github.com/mat-sik/file-server-go netmsg.proto
-func main() {
-	webClient, err := client.NewClient(":44696")
-	if err != nil {
-		panic(err)
-	}
-
-	getFileReq := message.GetFileRequest{Filename: "foo.txt"}
-
-	_, err = webClient.Run(getFileReq)
-	if err != nil {
-		panic(err)
-	}
-
-	delFileReq := message.DeleteFileRequest{Filename: "foo.txt"}
+// netmsg.pb.go and netmsg_grpc.pb.go are generated by protoc with the protoc-gen-go and
+// protoc-gen-go-grpc plugins on the PATH:
+//
+//	go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.36.5
+//	go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1
+//	go generate ./cmd/client
+//
+//go:generate protoc --proto_path=./../.. --go_out=./../.. --go_opt=module=github.com/mat-sik/file-server-go --go-grpc_out=./../.. --go-grpc_opt=module=github.com/mat-sik/file-server-go netmsg.proto
 
-	_, err = webClient.Run(delFileReq)
+func main() {
+	traceExporter, err := tracing.Setup(envs.TraceExporter, envs.TracePath)
 	if err != nil {
-		panic(err)
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(cli.ExitUsage)
 	}
 
-	putFileReq := message.PutFileRequest{Filename: "foo.txt"}
-
-	_, err = webClient.Run(putFileReq)
-	if err != nil {
-		panic(err)
-	}
+	// Interrupting the client aborts the transfer in progress instead of killing the process, so
+	// that partial downloads are removed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := cli.Run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	fsutil.LoggedClose(traceExporter)
+	os.Exit(code)
 }
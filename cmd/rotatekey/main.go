@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"io/fs"
+	"log/slog"
+)
+
+// Rewraps the data keys of all encrypted files with a new master key. It must be run while the
+// server is stopped, afterward SERVER_MASTER_KEY_PATH should point at the new key file.
+func main() {
+	storagePath := flag.String("storage", envs.ServerStoragePath, "server storage directory")
+	oldKeyPath := flag.String("old-key", envs.ServerMasterKeyPath, "current master key file")
+	newKeyPath := flag.String("new-key", "", "new master key file, generated when it does not exist")
+	flag.Parse()
+
+	if *storagePath == "" || *oldKeyPath == "" || *newKeyPath == "" {
+		flag.Usage()
+		panic("storage, old-key and new-key are required")
+	}
+
+	oldKey, err := files.LoadMasterKey(*oldKeyPath)
+	if err != nil {
+		panic(err)
+	}
+	newKey, err := loadOrGenerateMasterKey(*newKeyPath)
+	if err != nil {
+		panic(err)
+	}
+
+	rotated, err := files.RotateMasterKey(*storagePath, oldKey, newKey)
+	if err != nil {
+		panic(err)
+	}
+	slog.Info("Rotated master key", "files", rotated, "from", oldKey.ID(), "to", newKey.ID())
+}
+
+func loadOrGenerateMasterKey(path string) (*files.MasterKey, error) {
+	key, err := files.LoadMasterKey(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Info("Generating new master key", "path", path)
+		return files.GenerateMasterKey(path)
+	}
+	return key, err
+}
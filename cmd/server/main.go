@@ -2,11 +2,35 @@ package main
 
 import (
 	"context"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/logging"
 	"github.com/mat-sik/file-server-go/internal/server"
+	"github.com/mat-sik/file-server-go/internal/tracing"
 )
 
-//go:generate protoc --proto_path=./../.. --go_out=./../.. --go_opt=module=github.com/mat-sik/file-server-go netmsg.proto
+// netmsg.pb.go and netmsg_grpc.pb.go are generated by protoc with the protoc-gen-go and
+// protoc-gen-go-grpc plugins on the PATH:
+//
+//	go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.36.5
+//	go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1
+//	go generate ./cmd/server
+//
+//go:generate protoc --proto_path=./../.. --go_out=./../.. --go_opt=module=github.com/mat-sik/file-server-go --go-grpc_out=./../.. --go-grpc_opt=module=github.com/mat-sik/file-server-go netmsg.proto
+
 func main() {
+	logFile, err := logging.Setup()
+	if err != nil {
+		panic(err)
+	}
+	defer fsutil.LoggedClose(logFile)
+
+	traceExporter, err := tracing.Setup(envs.TraceExporter, envs.TracePath)
+	if err != nil {
+		panic(err)
+	}
+	defer fsutil.LoggedClose(traceExporter)
+
 	ctx := context.Background()
 	if err := server.Run(ctx, ":44696"); err != nil {
 		panic(err)
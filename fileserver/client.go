@@ -0,0 +1,199 @@
+// Package fileserver is a client for the native protocol of the file server.
+//
+// A Client is safe for concurrent use, it keeps a bounded pool of connections and runs every
+// request on one of its own. Its methods return a *StatusError, which matches ErrNotFound and the
+// other errors of this package with errors.Is, when the server rejects a request. Any other error
+// means the connection failed, the Client closes it and uses a new one for later requests.
+//
+// Requests that fail on their connection are retried on a new one with exponential backoff, see
+// WithRetries. Uploads and deletions carry an idempotency key, with which the server answers a
+// retry of a request that already ran with its original response instead of running it again.
+// Moves and copies are never retried, neither are transfers whose reader or writer can not be
+// rewound. DownloadFile and UploadFile transfer the parts of large files over several connections
+// at once and retry the parts that failed on their own. UpdateFile sends only what differs from
+// the version of a file the server has.
+//
+// Requests give up once their context is done. A request that already started then closes its
+// connection, which interrupts it wherever it waits for the server, and returns an error matching
+// the error of the context.
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+type Client struct {
+	client client.Client
+}
+
+// FileInfo describes a file stored on the server.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Dial connects to the server listening on addr, ctx only bounds establishing the first
+// connection.
+func Dial(ctx context.Context, addr string, opts ...Option) (*Client, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	dialer := net.Dialer{Timeout: o.dialTimeout}
+	c, err := client.NewPooledClient(ctx, client.PoolConfig{
+		Dial: func(ctx context.Context) (netmsg.Session, error) {
+			conn, err := dialer.DialContext(ctx, "tcp4", addr)
+			if err != nil {
+				return netmsg.Session{}, err
+			}
+			return netmsg.NewSessionWithTimeouts(conn, 0, o.ioTimeout), nil
+		},
+		MaxSessions: o.maxConnections,
+		MaxIdleTime: o.maxIdleTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !o.compression {
+		c = c.WithAcceptEncodings()
+	}
+	c = c.WithRetryPolicy(o.retry)
+	if o.partSize > 0 {
+		c = c.WithPartSize(int(o.partSize))
+	}
+	return &Client{client: c}, nil
+}
+
+// GetFile writes the content of the file name into w and returns its size. If it fails w may
+// have received part of the content.
+func (c *Client) GetFile(ctx context.Context, name string, w io.Writer) (int64, error) {
+	res, err := c.client.Get(ctx, name, w)
+	if err != nil {
+		return 0, err
+	}
+	if err = statusError("get", name, res.Status, http.StatusOK); err != nil {
+		return 0, err
+	}
+	return int64(res.Size), nil
+}
+
+// PutFile stores size bytes read from r as the file name, replacing the file if it exists. A
+// cancelled upload fails with the next write to the connection, not while r blocks.
+func (c *Client) PutFile(ctx context.Context, name string, r io.Reader, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("fileserver: put %s: negative size %d", name, size)
+	}
+	res, err := c.client.Put(ctx, name, r, int(size))
+	if err != nil {
+		return err
+	}
+	return statusError("put", name, res.Status, http.StatusCreated)
+}
+
+// DownloadFile is GetFile for large files, it splits files larger than a part into ranges
+// downloaded over up to WithMaxConnections connections at once and written to w at their offsets.
+// It fails with ErrChanged if the file is replaced meanwhile. If it fails w may have received part
+// of the content.
+func (c *Client) DownloadFile(ctx context.Context, name string, w io.WriterAt) (int64, error) {
+	res, err := c.client.GetParallel(ctx, name, w)
+	if err != nil {
+		return 0, err
+	}
+	if err = statusError("download", name, res.Status, http.StatusOK); err != nil {
+		return 0, err
+	}
+	return int64(res.Size), nil
+}
+
+// UploadFile is PutFile for large files, it splits files larger than a part into parts uploaded
+// over up to WithMaxConnections connections at once. The server replaces the file only once all
+// parts arrived and the SHA-256 of the file matches, a failed upload leaves the file as it was.
+func (c *Client) UploadFile(ctx context.Context, name string, r io.ReaderAt, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("fileserver: upload %s: negative size %d", name, size)
+	}
+	res, err := c.client.PutParallel(ctx, name, r, int(size), time.Time{})
+	if err != nil {
+		return err
+	}
+	return statusError("upload", name, res.Status, http.StatusCreated)
+}
+
+// UpdateFile is UploadFile for files the server has another version of, it sends only the blocks
+// that differ from that version and references to those that do not. The server rebuilds the file
+// and replaces it only if the SHA-256 of the rebuilt file matches. Files the server does not have,
+// or that changed on the server while they were compared, are uploaded whole.
+func (c *Client) UpdateFile(ctx context.Context, name string, r io.ReaderAt, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("fileserver: update %s: negative size %d", name, size)
+	}
+	res, err := c.client.PutDelta(ctx, name, r, int(size), time.Time{})
+	if err != nil {
+		return err
+	}
+	return statusError("update", name, res.Status, http.StatusCreated)
+}
+
+func (c *Client) Delete(ctx context.Context, name string) error {
+	res, err := c.client.Delete(ctx, name)
+	if err != nil {
+		return err
+	}
+	return statusError("delete", name, res.Status, http.StatusOK)
+}
+
+// List returns the names of the files matching the regular expression pattern.
+func (c *Client) List(ctx context.Context, pattern string) ([]string, error) {
+	res, err := c.client.List(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err = statusError("list", pattern, res.Status, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return res.Filenames, nil
+}
+
+func (c *Client) Stat(ctx context.Context, name string) (FileInfo, error) {
+	res, err := c.client.Stat(ctx, name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if err = statusError("stat", name, res.Status, http.StatusOK); err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Name: name, Size: int64(res.Size), ModTime: res.ModTime}, nil
+}
+
+// Move renames the file from to to, replacing to if it exists.
+func (c *Client) Move(ctx context.Context, from string, to string) error {
+	res, err := c.client.Move(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	return statusError("move", from, res.Status, http.StatusOK)
+}
+
+// Copy copies the file from to to, replacing to if it exists.
+func (c *Client) Copy(ctx context.Context, from string, to string) error {
+	res, err := c.client.Copy(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	return statusError("copy", from, res.Status, http.StatusCreated)
+}
+
+// Close closes the idle connections and the others once their requests are done. Requests made
+// afterwards fail with ErrClosed.
+func (c *Client) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,55 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"net/http"
+)
+
+var (
+	ErrInvalid     = errors.New("fileserver: invalid name or pattern")
+	ErrNotFound    = errors.New("fileserver: file not found")
+	ErrLocked      = errors.New("fileserver: file is locked")
+	ErrUnsupported = errors.New("fileserver: unsupported encoding")
+	// ErrChecksum means the parts or the delta of an upload did not match the checksum of the file,
+	// its reader returned different content while it was uploaded.
+	ErrChecksum = errors.New("fileserver: checksum mismatch")
+	ErrClosed   = client.ErrClientClosed
+	ErrChanged  = client.ErrFileChanged
+)
+
+// StatusError is returned when the server answers a request with a status other than success.
+type StatusError struct {
+	Op     string
+	Name   string
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fileserver: %s %s: %d %s", e.Op, e.Name, e.Status, http.StatusText(e.Status))
+}
+
+// Unwrap returns the error of this package the status stands for, if there is one.
+func (e *StatusError) Unwrap() error {
+	switch e.Status {
+	case http.StatusBadRequest:
+		return ErrInvalid
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusLocked:
+		return ErrLocked
+	case http.StatusUnsupportedMediaType:
+		return ErrUnsupported
+	case http.StatusUnprocessableEntity:
+		return ErrChecksum
+	}
+	return nil
+}
+
+func statusError(op string, name string, status int, success int) error {
+	if status == success {
+		return nil
+	}
+	return &StatusError{Op: op, Name: name, Status: status}
+}
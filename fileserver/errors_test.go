@@ -0,0 +1,30 @@
+package fileserver
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_should_MatchStatusErrors(t *testing.T) {
+	testCases := []struct {
+		status int
+		want   error
+	}{
+		{status: 400, want: ErrInvalid},
+		{status: 404, want: ErrNotFound},
+		{status: 415, want: ErrUnsupported},
+		{status: 422, want: ErrChecksum},
+		{status: 423, want: ErrLocked},
+		{status: 500, want: nil},
+	}
+
+	for _, tc := range testCases {
+		err := statusError("get", "foo.txt", tc.status, 200)
+		if got := errors.Unwrap(err); got != tc.want {
+			t.Errorf("status %d unwrapped to %v want %v", tc.status, got, tc.want)
+		}
+	}
+	if err := statusError("get", "foo.txt", 200, 200); err != nil {
+		t.Errorf("success returned %v", err)
+	}
+}
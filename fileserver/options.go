@@ -0,0 +1,86 @@
+package fileserver
+
+import (
+	"github.com/mat-sik/file-server-go/internal/client"
+	"time"
+)
+
+type Option func(*options)
+
+type options struct {
+	dialTimeout    time.Duration
+	ioTimeout      time.Duration
+	compression    bool
+	maxConnections int
+	maxIdleTime    time.Duration
+	retry          client.RetryPolicy
+	partSize       int64
+}
+
+func defaultOptions() options {
+	return options{
+		dialTimeout: 10 * time.Second,
+		compression: true,
+		retry:       client.DefaultRetryPolicy,
+	}
+}
+
+// WithDialTimeout bounds establishing the connection, 10 seconds unless set.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithIOTimeout fails a request once reading from or writing to the connection makes no progress
+// for timeout. Zero, the default, waits for as long as the context of the request allows.
+func WithIOTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.ioTimeout = timeout
+	}
+}
+
+// WithCompression lets the server compress downloads, which it does by default.
+func WithCompression(enabled bool) Option {
+	return func(o *options) {
+		o.compression = enabled
+	}
+}
+
+// WithMaxConnections bounds the connections open at once, 4 unless set. Requests beyond it wait
+// for a connection to become free.
+func WithMaxConnections(n int) Option {
+	return func(o *options) {
+		o.maxConnections = n
+	}
+}
+
+// WithMaxIdleTime closes connections unused for longer than timeout, a minute unless set. It
+// should stay below the idle timeout of the server.
+func WithMaxIdleTime(timeout time.Duration) Option {
+	return func(o *options) {
+		o.maxIdleTime = timeout
+	}
+}
+
+// WithRetries makes up to maxAttempts attempts of requests that fail on their connection. Before
+// the n-th retry the client waits a random duration of up to initialBackoff doubled n-1 times, at
+// most maxBackoff. Unless set, requests are attempted 4 times, waiting up to 100ms at first and up
+// to 2s at most. A maxAttempts of 1 disables retries.
+func WithRetries(maxAttempts int, initialBackoff time.Duration, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.retry = client.RetryPolicy{
+			MaxAttempts:    maxAttempts,
+			InitialBackoff: initialBackoff,
+			MaxBackoff:     maxBackoff,
+		}
+	}
+}
+
+// WithPartSize sets the size of the parts DownloadFile and UploadFile split larger files into, 8
+// MiB unless set.
+func WithPartSize(size int64) Option {
+	return func(o *options) {
+		o.partSize = size
+	}
+}
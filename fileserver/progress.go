@@ -0,0 +1,42 @@
+package fileserver
+
+import (
+	"context"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"time"
+)
+
+// Progress describes a download of GetFile or an upload of PutFile in flight.
+type Progress struct {
+	Name    string
+	Upload  bool
+	Done    int64
+	Total   int64
+	Elapsed time.Duration
+}
+
+// Rate returns the average transfer rate so far in bytes per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Done) / p.Elapsed.Seconds()
+}
+
+// ETA estimates the time left at the average rate so far, zero if there is no rate yet.
+func (p Progress) ETA() time.Duration {
+	rate := p.Rate()
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Total-p.Done) / rate * float64(time.Second))
+}
+
+// ContextWithProgress returns a context that has the transfers of the requests made with it
+// report their progress to fn. fn is called as a transfer starts, at most every 100ms while it
+// runs, and once it is complete. A retried transfer starts over.
+func ContextWithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return client.ContextWithProgress(ctx, func(p client.Progress) {
+		fn(Progress{Name: p.Filename, Upload: p.Upload, Done: p.Done, Total: p.Total, Elapsed: p.Elapsed})
+	})
+}
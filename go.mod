@@ -2,4 +2,27 @@ module github.com/mat-sik/file-server-go
 
 go 1.23.0
 
-require google.golang.org/protobuf v1.36.5
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/pkg/sftp v1.13.9
+	github.com/prometheus/client_golang v1.20.5
+	golang.org/x/crypto v0.33.0
+	golang.org/x/net v0.35.0
+	golang.org/x/term v0.29.0
+	golang.org/x/time v0.10.0
+	google.golang.org/grpc v1.72.2
+	google.golang.org/protobuf v1.36.5
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250218202821-56aae31c358a // indirect
+)
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	"os"
+)
+
+// Store holds the users the server authenticates, loaded from a JSON file such as:
+//
+//	{"users": [{
+//		"name": "alice",
+//		"passwordHash": "$2a$10$...",
+//		"authorizedKeys": ["ssh-ed25519 AAAA... alice@laptop"],
+//		"accessKeys": [{"id": "AKIDALICE", "secret": "..."}]
+//	}]}
+//
+// Password hashes are bcrypt hashes, authorized keys use the OpenSSH authorized_keys format.
+type Store struct {
+	users          map[string]User
+	accessKeys     map[string]accessKey
+	authorizedKeys map[string][]ssh.PublicKey
+}
+
+type User struct {
+	Name           string      `json:"name"`
+	PasswordHash   string      `json:"passwordHash,omitempty"`
+	AuthorizedKeys []string    `json:"authorizedKeys,omitempty"`
+	AccessKeys     []AccessKey `json:"accessKeys,omitempty"`
+}
+
+// AccessKey is an S3 style key pair, the secret is needed in plain text to verify signatures.
+type AccessKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type accessKey struct {
+	secret string
+	user   string
+}
+
+type storeFile struct {
+	Users []User `json:"users"`
+}
+
+func LoadStore(path string) (*Store, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var parsed storeFile
+	if err = json.Unmarshal(content, &parsed); err != nil {
+		return nil, fmt.Errorf("user store %s: %w", path, err)
+	}
+	return NewStore(parsed.Users)
+}
+
+func NewStore(users []User) (*Store, error) {
+	store := &Store{
+		users:          make(map[string]User, len(users)),
+		accessKeys:     make(map[string]accessKey),
+		authorizedKeys: make(map[string][]ssh.PublicKey),
+	}
+	for _, user := range users {
+		if user.Name == "" {
+			return nil, fmt.Errorf("user store contains a user without a name")
+		}
+		if _, ok := store.users[user.Name]; ok {
+			return nil, fmt.Errorf("user %s is defined twice", user.Name)
+		}
+		store.users[user.Name] = user
+
+		for _, key := range user.AccessKeys {
+			if key.ID == "" || key.Secret == "" {
+				return nil, fmt.Errorf("user %s has an access key without an id or secret", user.Name)
+			}
+			if _, ok := store.accessKeys[key.ID]; ok {
+				return nil, fmt.Errorf("access key %s is defined twice", key.ID)
+			}
+			store.accessKeys[key.ID] = accessKey{secret: key.Secret, user: user.Name}
+		}
+
+		for _, line := range user.AuthorizedKeys {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("user %s has an invalid authorized key: %w", user.Name, err)
+			}
+			store.authorizedKeys[user.Name] = append(store.authorizedKeys[user.Name], key)
+		}
+	}
+	return store, nil
+}
+
+// CheckPassword reports whether password is the password of the user. Unknown users and users
+// without a password take as long to reject as a wrong password.
+func (s *Store) CheckPassword(name string, password string) bool {
+	hash := []byte(s.users[name].PasswordHash)
+	if len(hash) == 0 {
+		hash = unmatchableHash
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil && len(s.users[name].PasswordHash) > 0
+}
+
+func (s *Store) CheckPublicKey(name string, key ssh.PublicKey) bool {
+	marshaled := key.Marshal()
+	for _, authorized := range s.authorizedKeys[name] {
+		if bytes.Equal(authorized.Marshal(), marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmatchableHash is the bcrypt hash of a random password nobody knows.
+var unmatchableHash = []byte("$2a$10$gh5s3S4.1YJ3S0qhvZHHYOuLrb91/XIt.XgB5dt2JAgy4cGgGdPAe")
+
+// AccessKey returns the secret of the access key id and the name of the user owning it.
+func (s *Store) AccessKey(id string) (secret string, user string, ok bool) {
+	key, ok := s.accessKeys[id]
+	return key.secret, key.user, ok
+}
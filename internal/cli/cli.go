@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Exit codes of Run. When several files are processed, the code of the first failure is returned.
+const (
+	ExitOK = iota
+	// ExitFailure means the connection or a local file operation failed.
+	ExitFailure
+	ExitUsage
+	ExitNotFound
+	// ExitRejected means the server refused the request with a client error other than not found.
+	ExitRejected
+	ExitServerError
+)
+
+// Run executes the command line args, without the program name, and returns the exit code.
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&c.addr, "addr", defaultAddr(), "address of the server, defaults to CLIENT_SERVER_ADDR")
+	flags.BoolVar(&c.json, "json", false, "print results as JSON lines")
+	flags.StringVar(&c.progress, "progress", progressAuto, "draw progress bars of transfers: auto (on terminals), always or never")
+	flags.IntVar(&c.parallel, "parallel", defaultParallel, "connections the parts of large files are transferred over at once")
+	flags.Usage = func() {
+		_, _ = fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	if c.parallel < 1 {
+		_, _ = fmt.Fprintf(stderr, "invalid -parallel %d\n", c.parallel)
+		flags.Usage()
+		return ExitUsage
+	}
+	if c.progress != progressAuto && c.progress != progressAlways && c.progress != progressNever {
+		_, _ = fmt.Fprintf(stderr, "invalid -progress %q\n", c.progress)
+		flags.Usage()
+		return ExitUsage
+	}
+
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return ExitUsage
+	}
+	return command(ctx, c, flags.Args()[1:])
+}
+
+var commands = map[string]func(ctx context.Context, c *cli, args []string) int{
+	"get":  runGet,
+	"put":  runPut,
+	"rm":   runRemove,
+	"ls":   runList,
+	"stat": runStat,
+	"mv":   runMove,
+	"cp":   runCopy,
+	"sync": runSync,
+}
+
+func init() {
+	// The shell runs the other commands, listing it above would make the map refer to itself.
+	commands["shell"] = runShell
+}
+
+// defaultParallel is the number of sessions of the client, which bounds the parts transferred at
+// once.
+const defaultParallel = 4
+
+func defaultAddr() string {
+	if envs.ClientServerAddr != "" {
+		return envs.ClientServerAddr
+	}
+	return ":44696"
+}
+
+type cli struct {
+	addr     string
+	json     bool
+	progress string
+	parallel int
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	// dataOnStdout moves the results to stderr while file content is written to stdout.
+	dataOnStdout bool
+	exitCode     int
+	client       *client.Client
+	// persistent keeps the connection open across the commands of the shell.
+	persistent bool
+}
+
+func (c *cli) connect() (client.Client, error) {
+	if c.client == nil {
+		webClient, err := client.NewPooledClient(context.Background(), client.PoolConfig{
+			Dial:        client.DialTCP(c.addr),
+			MaxSessions: c.parallel,
+		})
+		if err != nil {
+			return client.Client{}, err
+		}
+		c.client = &webClient
+	}
+	return *c.client, nil
+}
+
+func (c *cli) close() {
+	if c.client != nil && !c.persistent {
+		if err := c.client.Close(); err != nil {
+			c.errorf("closing connection: %v", err)
+		}
+		c.client = nil
+	}
+}
+
+// result is what is printed about every processed file.
+type result struct {
+	Command   string     `json:"command"`
+	Name      string     `json:"name,omitempty"`
+	To        string     `json:"to,omitempty"`
+	Path      string     `json:"path,omitempty"`
+	Status    int        `json:"status,omitempty"`
+	Size      *int       `json:"size,omitempty"`
+	ModTime   *time.Time `json:"modTime,omitempty"`
+	Filenames []string   `json:"filenames,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	// DryRun marks what sync -n would do, Reason is why sync transfers or deletes the file.
+	DryRun bool   `json:"dryRun,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// report prints r and records the exit code it implies.
+func (c *cli) report(r result) {
+	code := exitCodeOf(r)
+	if c.exitCode == ExitOK {
+		c.exitCode = code
+	}
+
+	out := c.stdout
+	if code != ExitOK || c.dataOnStdout {
+		out = c.stderr
+	}
+	if c.json {
+		if err := json.NewEncoder(out).Encode(r); err != nil {
+			c.exitCode = ExitFailure
+		}
+		return
+	}
+	_, _ = fmt.Fprintln(out, describe(r))
+}
+
+func (c *cli) fail(command string, name string, err error) {
+	c.report(result{Command: command, Name: name, Error: err.Error()})
+}
+
+func (c *cli) errorf(format string, args ...any) {
+	_, _ = fmt.Fprintf(c.stderr, format+"\n", args...)
+	if c.exitCode == ExitOK {
+		c.exitCode = ExitFailure
+	}
+}
+
+func exitCodeOf(r result) int {
+	switch {
+	case r.Error != "":
+		return ExitFailure
+	case r.DryRun:
+		return ExitOK
+	case r.Status >= 200 && r.Status < 300:
+		return ExitOK
+	case r.Status == http.StatusNotFound:
+		return ExitNotFound
+	case r.Status >= 400 && r.Status < 500:
+		return ExitRejected
+	case r.Status >= 500:
+		return ExitServerError
+	}
+	return ExitFailure
+}
+
+func describe(r result) string {
+	subject := r.Name
+	if r.To != "" {
+		subject += " -> " + r.To
+	}
+	switch {
+	case r.Path == "":
+	case r.Name == "":
+		subject = r.Path
+	case r.Command == "put":
+		subject = r.Path + " -> " + subject
+	default:
+		subject += " -> " + r.Path
+	}
+	if r.DryRun {
+		return fmt.Sprintf("would %s %s (%s)", r.Command, subject, r.Reason)
+	}
+	if r.Error != "" {
+		return fmt.Sprintf("%s %s: %s", r.Command, subject, r.Error)
+	}
+	if exitCodeOf(r) != ExitOK {
+		return fmt.Sprintf("%s %s: %d %s", r.Command, subject, r.Status, http.StatusText(r.Status))
+	}
+	if r.Command == "stat" {
+		return fmt.Sprintf("%s\t%d\t%s", r.Name, *r.Size, r.ModTime.Format(time.RFC3339))
+	}
+	if r.Size != nil {
+		return fmt.Sprintf("%s %s (%d bytes)", r.Command, subject, *r.Size)
+	}
+	return fmt.Sprintf("%s %s", r.Command, subject)
+}
+
+// newFlags returns the flag set of a command, printing usage and errors to stderr.
+func (c *cli) newFlags(command string, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(c.stderr, "usage: client [global flags] %s %s\n", command, synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func (c *cli) usageError(flags *flag.FlagSet, format string, args ...any) int {
+	_, _ = fmt.Fprintf(c.stderr, format+"\n", args...)
+	flags.Usage()
+	return ExitUsage
+}
+
+var errNotRegular = errors.New("not a regular file")
+
+const usage = `usage: client [global flags] <command> [flags] [arguments]
+
+commands:
+  get   download files
+  put   upload files
+  rm    delete files
+  ls    list files matching a regular expression
+  stat  show size and modification time of files
+  mv    rename a file
+  cp    copy a file
+  sync  mirror a directory to the server (push) or from it (pull)
+  shell run commands interactively over one connection
+
+global flags:
+`
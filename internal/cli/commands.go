@@ -0,0 +1,439 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+func runGet(ctx context.Context, c *cli, args []string) int {
+	flags := c.newFlags("get", "[-r] [-dir directory] [-o path] name...")
+	output := flags.String("o", "", `local path of a single file, - writes it to stdout`)
+	dir := flags.String("dir", ".", "directory files are downloaded into")
+	recursive := flags.Bool("r", false, "treat names as regular expressions and download every match")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() == 0 {
+		return c.usageError(flags, "get needs at least one name")
+	}
+	if *output != "" && (flags.NArg() > 1 || *recursive) {
+		return c.usageError(flags, "-o needs exactly one name and no -r")
+	}
+	c.dataOnStdout = *output == "-"
+
+	webClient, err := c.connect()
+	if err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	defer c.close()
+
+	names := flags.Args()
+	if *recursive {
+		if names, err = c.match(ctx, "get", names); err != nil {
+			return c.exitCode
+		}
+	}
+	for _, name := range names {
+		path := *output
+		if path == "" {
+			path = filepath.Join(*dir, name)
+		}
+		c.get(ctx, webClient, name, path)
+	}
+	return c.exitCode
+}
+
+func (c *cli) get(ctx context.Context, webClient client.Client, name string, path string) {
+	// Bars are cleared before results are printed, which would otherwise continue their line.
+	ctx, clearProgress := c.withProgress(ctx)
+	if path == "-" {
+		res, err := webClient.Get(ctx, name, c.stdout)
+		clearProgress()
+		if err != nil {
+			c.fail("get", name, err)
+			return
+		}
+		c.report(result{Command: "get", Name: name, Path: path, Status: res.Status, Size: sizeIf(res.Status, res.Size)})
+		return
+	}
+
+	res, err := c.download(ctx, webClient, name, path, false)
+	clearProgress()
+	if err != nil {
+		c.fail("get", name, err)
+		return
+	}
+	c.report(result{Command: "get", Name: name, Path: path, Status: res.Status, Size: sizeIf(res.Status, res.Size)})
+}
+
+// download writes the file name to path, giving it the modification time of the file on the server
+// if keepModTime is set. Neither a failed or interrupted download nor an error response touch the
+// file at path.
+func (c *cli) download(
+	ctx context.Context,
+	webClient client.Client,
+	name string,
+	path string,
+	keepModTime bool,
+) (message.GetFileResponse, error) {
+	file, err := fsutil.CreatePartial(path)
+	if err != nil {
+		return message.GetFileResponse{}, err
+	}
+	res, err := webClient.GetParallel(ctx, name, file)
+	if err == nil && res.Status == http.StatusOK && keepModTime {
+		err = os.Chtimes(file.Name(), res.ModTime, res.ModTime)
+	}
+	if err == nil && res.Status == http.StatusOK {
+		err = file.Commit()
+	} else if abortErr := file.Abort(); abortErr != nil {
+		c.errorf("removing partial download of %s: %v", path, abortErr)
+	}
+	return res, err
+}
+
+func runPut(ctx context.Context, c *cli, args []string) int {
+	flags := c.newFlags("put", "[-r] [-delta] [-name name] path...")
+	remoteName := flags.String("name", "", "name of a single uploaded file, required when reading - (stdin)")
+	recursive := flags.Bool("r", false, "upload the regular files in directories and their subdirectories")
+	delta := flags.Bool("delta", false, "send only what differs from the files on the server")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() == 0 {
+		return c.usageError(flags, "put needs at least one path")
+	}
+	if *remoteName != "" && flags.NArg() > 1 {
+		return c.usageError(flags, "-name needs exactly one path")
+	}
+	if slices.Contains(flags.Args(), "-") && *remoteName == "" {
+		return c.usageError(flags, "reading stdin needs -name")
+	}
+
+	uploads, err := collectUploads(flags.Args(), *recursive)
+	if err != nil {
+		c.errorf("put: %v", err)
+		return c.exitCode
+	}
+	if *remoteName != "" {
+		uploads[0].name = *remoteName
+	}
+
+	webClient, err := c.connect()
+	if err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	defer c.close()
+
+	for _, u := range uploads {
+		c.put(ctx, webClient, u, *delta)
+	}
+	return c.exitCode
+}
+
+type upload struct {
+	path string
+	name string
+}
+
+// collectUploads resolves paths to the files to upload. The server stores files in a flat
+// namespace, so files found in subdirectories are uploaded by their base name and two of them
+// sharing one is an error.
+func collectUploads(paths []string, recursive bool) ([]upload, error) {
+	var uploads []upload
+	byName := make(map[string]string)
+	add := func(path string) error {
+		name := filepath.Base(path)
+		if other, ok := byName[name]; ok {
+			return fmt.Errorf("%s and %s would both be uploaded as %s", other, path, name)
+		}
+		byName[name] = path
+		uploads = append(uploads, upload{path: path, name: name})
+		return nil
+	}
+
+	for _, path := range paths {
+		if path == "-" {
+			uploads = append(uploads, upload{path: path})
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err = add(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !recursive {
+			return nil, fmt.Errorf("%s is a directory, use -r to upload its files", path)
+		}
+		err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			return add(path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uploads, nil
+}
+
+func (c *cli) put(ctx context.Context, webClient client.Client, u upload, delta bool) {
+	file, size, err := c.openUpload(u.path)
+	if err != nil {
+		c.fail("put", u.name, err)
+		return
+	}
+	defer fsutil.LoggedClose(file)
+
+	progressCtx, clearProgress := c.withProgress(ctx)
+	upload := webClient.PutParallel
+	if delta {
+		upload = webClient.PutDelta
+	}
+	res, err := upload(progressCtx, u.name, file, size, time.Time{})
+	clearProgress()
+	if err != nil {
+		c.fail("put", u.name, err)
+		return
+	}
+	c.report(result{Command: "put", Name: u.name, Path: u.path, Status: res.Status, Size: sizeIf(res.Status, size)})
+}
+
+// openUpload opens the file at path, stdin is spooled to a temporary file first because the
+// protocol declares the size of an upload before its content.
+func (c *cli) openUpload(path string) (*os.File, int, error) {
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := file.Stat()
+		if err == nil && !info.Mode().IsRegular() {
+			err = errNotRegular
+		}
+		if err != nil {
+			fsutil.LoggedClose(file)
+			return nil, 0, err
+		}
+		return file, int(info.Size()), nil
+	}
+
+	spool, err := os.CreateTemp("", "client-stdin-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	// The spool is removed right away, the open file stays readable until it is closed.
+	if err = os.Remove(spool.Name()); err != nil {
+		fsutil.LoggedClose(spool)
+		return nil, 0, err
+	}
+	size, err := io.Copy(spool, c.stdin)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		fsutil.LoggedClose(spool)
+		return nil, 0, err
+	}
+	return spool, int(size), nil
+}
+
+func runRemove(ctx context.Context, c *cli, args []string) int {
+	flags := c.newFlags("rm", "[-r] name...")
+	recursive := flags.Bool("r", false, "treat names as regular expressions and delete every match")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() == 0 {
+		return c.usageError(flags, "rm needs at least one name")
+	}
+
+	webClient, err := c.connect()
+	if err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	defer c.close()
+
+	names := flags.Args()
+	if *recursive {
+		if names, err = c.match(ctx, "rm", names); err != nil {
+			return c.exitCode
+		}
+	}
+	for _, name := range names {
+		res, err := webClient.Delete(ctx, name)
+		if err != nil {
+			c.fail("rm", name, err)
+			continue
+		}
+		c.report(result{Command: "rm", Name: name, Status: res.Status})
+	}
+	return c.exitCode
+}
+
+func runList(ctx context.Context, c *cli, args []string) int {
+	flags := c.newFlags("ls", "[pattern]")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() > 1 {
+		return c.usageError(flags, "ls takes at most one pattern")
+	}
+
+	webClient, err := c.connect()
+	if err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	defer c.close()
+
+	res, err := webClient.List(ctx, flags.Arg(0))
+	if err != nil {
+		c.fail("ls", flags.Arg(0), err)
+		return c.exitCode
+	}
+	slices.Sort(res.Filenames)
+	if c.json || res.Status != http.StatusOK {
+		c.report(result{Command: "ls", Name: flags.Arg(0), Status: res.Status, Filenames: res.Filenames})
+		return c.exitCode
+	}
+	for _, filename := range res.Filenames {
+		_, _ = fmt.Fprintln(c.stdout, filename)
+	}
+	return c.exitCode
+}
+
+func runStat(ctx context.Context, c *cli, args []string) int {
+	flags := c.newFlags("stat", "name...")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() == 0 {
+		return c.usageError(flags, "stat needs at least one name")
+	}
+
+	webClient, err := c.connect()
+	if err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	defer c.close()
+
+	for _, name := range flags.Args() {
+		res, err := webClient.Stat(ctx, name)
+		if err != nil {
+			c.fail("stat", name, err)
+			continue
+		}
+		r := result{Command: "stat", Name: name, Status: res.Status}
+		if res.Status == http.StatusOK {
+			r.Size = &res.Size
+			r.ModTime = &res.ModTime
+		}
+		c.report(r)
+	}
+	return c.exitCode
+}
+
+func runMove(ctx context.Context, c *cli, args []string) int {
+	return c.runTransferBetween("mv", args, func(webClient client.Client, from string, to string) (int, error) {
+		res, err := webClient.Move(ctx, from, to)
+		return res.Status, err
+	})
+}
+
+func runCopy(ctx context.Context, c *cli, args []string) int {
+	return c.runTransferBetween("cp", args, func(webClient client.Client, from string, to string) (int, error) {
+		res, err := webClient.Copy(ctx, from, to)
+		return res.Status, err
+	})
+}
+
+func (c *cli) runTransferBetween(
+	command string,
+	args []string,
+	transfer func(webClient client.Client, from string, to string) (int, error),
+) int {
+	flags := c.newFlags(command, "from to")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() != 2 {
+		return c.usageError(flags, "%s needs a source and a destination name", command)
+	}
+
+	webClient, err := c.connect()
+	if err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	defer c.close()
+
+	from, to := flags.Arg(0), flags.Arg(1)
+	status, err := transfer(webClient, from, to)
+	if err != nil {
+		c.fail(command, from, err)
+		return c.exitCode
+	}
+	c.report(result{Command: command, Name: from, To: to, Status: status})
+	return c.exitCode
+}
+
+// match returns the names of all files matching any of patterns.
+func (c *cli) match(ctx context.Context, command string, patterns []string) ([]string, error) {
+	webClient, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, pattern := range patterns {
+		if _, err = regexp.Compile(pattern); err != nil {
+			c.fail(command, pattern, err)
+			return nil, err
+		}
+		res, err := webClient.List(ctx, pattern)
+		if err != nil {
+			c.fail(command, pattern, err)
+			return nil, err
+		}
+		if res.Status != http.StatusOK {
+			c.report(result{Command: command, Name: pattern, Status: res.Status})
+			return nil, errors.New("listing failed")
+		}
+		for _, name := range res.Filenames {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func sizeIf(status int, size int) *int {
+	if status < 200 || status >= 300 {
+		return nil
+	}
+	return &size
+}
@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressAuto   = "auto"
+	progressAlways = "always"
+	progressNever  = "never"
+)
+
+// withProgress returns a context that has transfers draw a progress bar on stderr and a function
+// that removes the bar once the transfer is done. Bars are drawn on terminals only, unless
+// -progress says otherwise.
+func (c *cli) withProgress(ctx context.Context) (context.Context, func()) {
+	width, terminal := terminalWidth(c.stderr)
+	if c.progress == progressNever || c.progress == progressAuto && (!terminal || c.json) {
+		return ctx, func() {}
+	}
+	bar := &progressBar{out: c.stderr, width: width}
+	return client.ContextWithProgress(ctx, bar.draw), bar.clear
+}
+
+func terminalWidth(w io.Writer) (int, bool) {
+	file, ok := w.(*os.File)
+	if !ok || !term.IsTerminal(int(file.Fd())) {
+		return defaultProgressWidth, false
+	}
+	width, _, err := term.GetSize(int(file.Fd()))
+	if err != nil || width <= 0 {
+		return defaultProgressWidth, true
+	}
+	return width, true
+}
+
+// progressBar redraws a single line, such as
+//
+//	report.pdf [=========>          ]  48%  12.0 MiB/25.0 MiB  4.0 MiB/s  ETA 3s
+type progressBar struct {
+	out   io.Writer
+	width int
+	drawn bool
+}
+
+func (b *progressBar) draw(p client.Progress) {
+	percent := 100
+	if p.Total > 0 {
+		percent = int(p.Done * 100 / p.Total)
+	}
+	stats := fmt.Sprintf(" %3d%%  %s/%s  %s/s", percent, formatBytes(p.Done), formatBytes(p.Total), formatBytes(int64(p.Rate())))
+	if eta := p.ETA(); p.Done < p.Total && eta > 0 {
+		stats += "  ETA " + eta.Round(time.Second).String()
+	}
+
+	name := p.Filename
+	barWidth := b.width - len(stats) - 4 - min(len(name), maxProgressName)
+	if barWidth < minProgressBar {
+		barWidth = 0
+	}
+	if len(name) > maxProgressName {
+		name = name[:maxProgressName-1] + "~"
+	}
+
+	line := name
+	if barWidth > 0 {
+		filled := barWidth * percent / 100
+		arrow := strings.Repeat("=", filled)
+		if filled < barWidth {
+			arrow += ">" + strings.Repeat(" ", barWidth-filled-1)
+		}
+		line += " [" + arrow + "]"
+	}
+	// Erasing the rest of the line removes what is left of a longer line drawn before.
+	_, _ = fmt.Fprint(b.out, "\r"+line+stats+"\x1b[K")
+	b.drawn = true
+}
+
+func (b *progressBar) clear() {
+	if b.drawn {
+		_, _ = fmt.Fprint(b.out, "\r\x1b[K")
+		b.drawn = false
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n), 0
+	for value >= unit && prefix < len("KMGTPE") {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[prefix-1])
+}
+
+const (
+	defaultProgressWidth = 80
+	maxProgressName      = 24
+	minProgressBar       = 10
+)
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"bytes"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_DrawProgressBar(t *testing.T) {
+	var out bytes.Buffer
+	bar := &progressBar{out: &out, width: 80}
+
+	bar.draw(client.Progress{Filename: "report.pdf", Done: 12 << 20, Total: 25 << 20, Elapsed: 3 * time.Second})
+	line := out.String()
+	bar.clear()
+
+	for _, expected := range []string{"report.pdf [", "=>", "]  48%", "12.0 MiB/25.0 MiB", "4.0 MiB/s", "ETA 3s"} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("%q does not contain %q", line, expected)
+		}
+	}
+	if visible := len(strings.TrimSuffix(strings.TrimPrefix(line, "\r"), "\x1b[K")); visible > 80 {
+		t.Errorf("drew %d characters on a line of 80", visible)
+	}
+	if !strings.HasSuffix(out.String(), "\r\x1b[K") {
+		t.Errorf("the bar was not cleared: %q", out.String())
+	}
+}
+
+func Test_should_FormatBytes(t *testing.T) {
+	testCases := map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1024:          "1.0 KiB",
+		1536 * 1024:   "1.5 MiB",
+		5 * (1 << 40): "5.0 TiB",
+	}
+	for n, want := range testCases {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q want %q", n, got, want)
+		}
+	}
+}
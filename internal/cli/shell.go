@@ -0,0 +1,366 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"golang.org/x/term"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// runShell reads commands line by line and runs them over one session. On a terminal lines can
+// be edited, earlier ones recalled with the arrow keys and names completed with tab.
+func runShell(ctx context.Context, c *cli, args []string) int {
+	flags := c.newFlags("shell", "")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() != 0 {
+		return c.usageError(flags, "shell takes no arguments")
+	}
+
+	webClient, err := c.connect()
+	if err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	c.persistent = true
+	defer func() {
+		c.persistent = false
+		c.close()
+	}()
+
+	sh := &shell{cli: c, client: webClient, cwd: "/"}
+	readLine, restore, err := sh.lineReader()
+	if err != nil {
+		c.errorf("shell: %v", err)
+		return c.exitCode
+	}
+	defer restore()
+
+	for {
+		line, err := readLine()
+		if errors.Is(err, io.EOF) {
+			return ExitOK
+		}
+		if err != nil {
+			c.errorf("shell: %v", err)
+			return ExitFailure
+		}
+		if sh.execute(ctx, line) {
+			return ExitOK
+		}
+	}
+}
+
+type shell struct {
+	cli      *cli
+	client   client.Client
+	terminal *term.Terminal
+	cwd      string
+	history  []string
+}
+
+// lineReader reads from a terminal in raw mode when stdin and stdout are one, otherwise plain
+// lines without a prompt, so that scripts can be piped into the shell.
+func (sh *shell) lineReader() (func() (string, error), func(), error) {
+	c := sh.cli
+	stdin, inOK := c.stdin.(*os.File)
+	stdout, outOK := c.stdout.(*os.File)
+	if !inOK || !outOK || !term.IsTerminal(int(stdin.Fd())) || !term.IsTerminal(int(stdout.Fd())) {
+		scanner := bufio.NewScanner(c.stdin)
+		return func() (string, error) {
+			if scanner.Scan() {
+				return scanner.Text(), nil
+			}
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}, func() {}, nil
+	}
+
+	state, err := term.MakeRaw(int(stdin.Fd()))
+	if err != nil {
+		return nil, nil, err
+	}
+	sh.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{stdin, stdout}, sh.prompt())
+	if width, height, err := term.GetSize(int(stdout.Fd())); err == nil {
+		_ = sh.terminal.SetSize(width, height)
+	}
+	sh.terminal.AutoCompleteCallback = sh.autoComplete
+	// Raw mode needs carriage returns, the terminal adds them to everything written through it.
+	stderr := c.stderr
+	c.stdout, c.stderr = sh.terminal, sh.terminal
+
+	return sh.terminal.ReadLine, func() {
+		c.stdout, c.stderr = stdout, stderr
+		if err := term.Restore(int(stdin.Fd()), state); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+	}, nil
+}
+
+func (sh *shell) prompt() string {
+	return "fileserver:" + sh.cwd + "> "
+}
+
+// execute runs one line and reports whether the shell should exit.
+func (sh *shell) execute(ctx context.Context, line string) bool {
+	words, err := splitWords(line)
+	if err != nil {
+		sh.cli.errorf("%v", err)
+		return false
+	}
+	if len(words) == 0 {
+		return false
+	}
+	sh.history = append(sh.history, line)
+
+	c := sh.cli
+	c.exitCode, c.dataOnStdout = ExitOK, false
+	name, args := words[0], words[1:]
+	switch name {
+	case "exit", "quit":
+		return true
+	case "help":
+		_, _ = fmt.Fprint(c.stdout, shellHelp)
+	case "history":
+		for i, entry := range sh.history {
+			_, _ = fmt.Fprintf(c.stdout, "%4d  %s\n", i+1, entry)
+		}
+	case "pwd":
+		_, _ = fmt.Fprintln(c.stdout, sh.cwd)
+	case "cd":
+		sh.changeDirectory(args)
+	case "lpwd":
+		dir, err := os.Getwd()
+		if err != nil {
+			c.errorf("lpwd: %v", err)
+			return false
+		}
+		_, _ = fmt.Fprintln(c.stdout, dir)
+	case "lcd":
+		if len(args) != 1 {
+			c.errorf("lcd needs exactly one directory")
+			return false
+		}
+		if err = os.Chdir(args[0]); err != nil {
+			c.errorf("lcd: %v", err)
+		}
+	case "shell":
+		c.errorf("already in the shell")
+	default:
+		command, ok := commands[name]
+		if !ok {
+			c.errorf("unknown command %q, try help", name)
+			return false
+		}
+		command(ctx, c, args)
+	}
+	return false
+}
+
+// changeDirectory moves the remote working directory. The server stores all files in one flat
+// namespace, so its root is the only directory there is for now.
+func (sh *shell) changeDirectory(args []string) {
+	if len(args) > 1 {
+		sh.cli.errorf("cd takes at most one directory")
+		return
+	}
+	target := "/"
+	if len(args) == 1 {
+		target = resolveDirectory(sh.cwd, args[0])
+	}
+	if target != "/" {
+		sh.cli.errorf("cd %s: no such directory", args[0])
+		return
+	}
+	sh.cwd = target
+	if sh.terminal != nil {
+		sh.terminal.SetPrompt(sh.prompt())
+	}
+}
+
+func resolveDirectory(cwd string, dir string) string {
+	if !strings.HasPrefix(dir, "/") {
+		dir = cwd + "/" + dir
+	}
+	return filepath.ToSlash(filepath.Clean(dir))
+}
+
+func (sh *shell) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	newLine, newPos, candidates := completeLine(line, pos, sh.candidates)
+	if len(candidates) > 1 && sh.terminal != nil {
+		_, _ = fmt.Fprintln(sh.terminal, strings.Join(candidates, "  "))
+	}
+	return newLine, newPos, true
+}
+
+// candidates returns the completions of prefix: command names for the first word, local paths
+// for the arguments of put, sync and lcd, and the names of remote files otherwise.
+func (sh *shell) candidates(command string, prefix string) []string {
+	switch {
+	case command == "":
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		names = append(names, shellBuiltins...)
+		return withPrefix(names, prefix)
+	case strings.HasPrefix(prefix, "-"):
+		return nil
+	case command == "put" || command == "sync" || command == "lcd":
+		return localCandidates(prefix)
+	}
+
+	res, err := sh.client.List(context.Background(), "^"+regexp.QuoteMeta(prefix))
+	if err != nil || res.Status != http.StatusOK {
+		return nil
+	}
+	return res.Filenames
+}
+
+func localCandidates(prefix string) []string {
+	matches, err := filepath.Glob(globEscape(prefix) + "*")
+	if err != nil {
+		return nil
+	}
+	for i, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			matches[i] = match + string(filepath.Separator)
+		}
+	}
+	return matches
+}
+
+func globEscape(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+func withPrefix(names []string, prefix string) []string {
+	var matching []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			matching = append(matching, name)
+		}
+	}
+	return matching
+}
+
+// completeLine completes the word that ends at pos. A single candidate replaces the word, several
+// extend it by their common prefix and are returned so that they can be listed.
+func completeLine(line string, pos int, candidates func(command string, prefix string) []string) (string, int, []string) {
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	prefix := line[start:pos]
+	var command string
+	if start > 0 {
+		command = strings.Fields(line)[0]
+	}
+
+	matches := slices.Sorted(slices.Values(withPrefix(candidates(command, prefix), prefix)))
+	matches = slices.Compact(matches)
+	switch len(matches) {
+	case 0:
+		return line, pos, nil
+	case 1:
+		completion := matches[0]
+		if !strings.HasSuffix(completion, string(filepath.Separator)) {
+			completion += " "
+		}
+		return line[:start] + completion + line[pos:], start + len(completion), nil
+	}
+	common := longestCommonPrefix(matches)
+	return line[:start] + common + line[pos:], start + len(common), matches
+}
+
+func longestCommonPrefix(words []string) string {
+	common := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	return common
+}
+
+// splitWords splits a line at unquoted white space. Single quotes keep everything literal,
+// within double quotes and outside of quotes a backslash escapes the next character.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+var shellBuiltins = []string{"cd", "exit", "help", "history", "lcd", "lpwd", "pwd", "quit"}
+
+const shellHelp = `commands:
+  get, put, rm, ls, stat, mv, cp, sync   as on the command line, -h shows their flags
+  pwd, cd [directory]                    print or change the remote directory
+  lpwd, lcd directory                    print or change the local directory
+  history                                list the commands entered so far
+  help                                   show this help
+  exit, quit                             leave the shell, as does end of input
+`
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_should_splitWords(t *testing.T) {
+	words, err := splitWords(`get  -o 'a b.txt' "c\"d" e\ f`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"get", "-o", "a b.txt", `c"d`, "e f"}; !reflect.DeepEqual(words, want) {
+		t.Fatalf("got %q want %q", words, want)
+	}
+	if _, err = splitWords(`get 'open`); err == nil {
+		t.Fatal("expected an error for an unterminated quote")
+	}
+}
+
+func Test_should_completeLine(t *testing.T) {
+	remote := func(command string, prefix string) []string {
+		if command == "" {
+			return []string{"get", "put", "pwd"}
+		}
+		return []string{"report-2023.txt", "report-2024.txt", "notes.txt"}
+	}
+	testCases := []struct {
+		name       string
+		line       string
+		pos        int
+		wantLine   string
+		wantPos    int
+		candidates []string
+	}{
+		{name: "command", line: "g", pos: 1, wantLine: "get ", wantPos: 4},
+		{name: "ambiguous command", line: "p", pos: 1, wantLine: "p", wantPos: 1, candidates: []string{"put", "pwd"}},
+		{name: "single file", line: "get no", pos: 6, wantLine: "get notes.txt ", wantPos: 14},
+		{
+			name:       "common prefix",
+			line:       "rm rep x",
+			pos:        6,
+			wantLine:   "rm report-202 x",
+			wantPos:    13,
+			candidates: []string{"report-2023.txt", "report-2024.txt"},
+		},
+		{name: "no match", line: "get zz", pos: 6, wantLine: "get zz", wantPos: 6},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			line, pos, candidates := completeLine(tc.line, tc.pos, remote)
+			if line != tc.wantLine || pos != tc.wantPos || !reflect.DeepEqual(candidates, tc.candidates) {
+				t.Fatalf("got %q %d %q want %q %d %q", line, pos, candidates, tc.wantLine, tc.wantPos, tc.candidates)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/client"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// runSync mirrors the files of a local directory to the server, or those of the server to a local
+// directory. The server stores files in a flat namespace, so only the regular files directly in the
+// directory are mirrored. Files are compared by size and modification time, and by checksum if
+// only the modification times differ, and transferred only if they differ.
+func runSync(ctx context.Context, c *cli, args []string) int {
+	flags := c.newFlags("sync", "push|pull [-delete] [-n] [-match regexp] directory")
+	deleteExtra := flags.Bool("delete", false, "delete files the source does not have from the destination")
+	dryRun := flags.Bool("n", false, "print what would be transferred and deleted without doing it")
+	pattern := flags.String("match", "", "regular expression the names of mirrored files must match")
+	if len(args) == 0 || args[0] != syncPush && args[0] != syncPull {
+		return c.usageError(flags, "sync needs push or pull")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() != 1 {
+		return c.usageError(flags, "sync needs exactly one directory")
+	}
+	match, err := regexp.Compile(*pattern)
+	if err != nil {
+		return c.usageError(flags, "invalid -match: %v", err)
+	}
+
+	s := syncer{cli: c, push: args[0] == syncPush, dir: flags.Arg(0), dryRun: *dryRun}
+	local, err := listLocal(s.dir, match)
+	if os.IsNotExist(err) && !s.push {
+		if !s.dryRun {
+			err = os.MkdirAll(s.dir, 0755)
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		c.errorf("sync: %v", err)
+		return c.exitCode
+	}
+
+	if s.webClient, err = c.connect(); err != nil {
+		c.errorf("connecting to %s: %v", c.addr, err)
+		return c.exitCode
+	}
+	defer c.close()
+
+	listed, err := s.webClient.List(ctx, *pattern)
+	if err != nil {
+		c.fail("sync", *pattern, err)
+		return c.exitCode
+	}
+	if listed.Status != http.StatusOK {
+		c.report(result{Command: "sync", Name: *pattern, Status: listed.Status})
+		return c.exitCode
+	}
+
+	remote := listed.Filenames
+	if !s.push {
+		remote = s.pullable(remote)
+	}
+	names := slices.Clone(remote)
+	for name := range local {
+		if !slices.Contains(remote, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		_, inSource := local[name]
+		inDestination := slices.Contains(remote, name)
+		if !s.push {
+			inSource, inDestination = inDestination, inSource
+		}
+		switch {
+		case inSource && inDestination:
+			s.update(ctx, name, local[name])
+		case inSource:
+			s.transfer(ctx, name, reasonNew)
+		case *deleteExtra:
+			s.delete(ctx, name)
+		}
+	}
+
+	if !c.json {
+		s.summarize()
+	}
+	return c.exitCode
+}
+
+const (
+	syncPush = "push"
+	syncPull = "pull"
+	// reasonNew is why a file the destination does not have is transferred.
+	reasonNew = "new"
+)
+
+// syncer carries out what runSync found to differ and counts what it did.
+type syncer struct {
+	*cli
+	webClient   client.Client
+	push        bool
+	dir         string
+	dryRun      bool
+	transferred int
+	deleted     int
+	unchanged   int
+}
+
+// update transfers the file name if its copies differ. Copies that only differ by their
+// modification times are given that of the source, so that they are not compared by checksum
+// again. A push sends the local time with a delta that copies the whole stored version.
+func (s *syncer) update(ctx context.Context, name string, local fileState) {
+	stat, err := s.webClient.Stat(ctx, name)
+	if err != nil || stat.Status != http.StatusOK {
+		s.reportFailure("stat", name, stat.Status, err)
+		return
+	}
+	remote := fileState{size: stat.Size, modTime: stat.ModTime}
+
+	switch compare(local, remote) {
+	case differentSize:
+		s.transfer(ctx, name, "size differs")
+		return
+	case sameContent:
+		s.unchanged++
+		return
+	}
+
+	path := filepath.Join(s.dir, name)
+	localChecksum, err := checksumFile(path)
+	if err != nil {
+		s.fail("sync", name, err)
+		return
+	}
+	remoteChecksum, err := s.webClient.Checksum(ctx, name)
+	if err != nil || remoteChecksum.Status != http.StatusOK {
+		s.reportFailure("stat", name, remoteChecksum.Status, err)
+		return
+	}
+	if localChecksum != remoteChecksum.Checksum {
+		s.transfer(ctx, name, "content differs")
+		return
+	}
+	s.unchanged++
+	if s.dryRun {
+		return
+	}
+	if s.push {
+		status, _, err := s.upload(ctx, name, path, true)
+		if err != nil || exitCodeOf(result{Status: status}) != ExitOK {
+			s.reportFailure("put", name, status, err)
+		}
+		return
+	}
+	if err = os.Chtimes(path, remoteChecksum.ModTime, remoteChecksum.ModTime); err != nil {
+		s.fail("sync", name, err)
+	}
+}
+
+func (s *syncer) transfer(ctx context.Context, name string, reason string) {
+	path := filepath.Join(s.dir, name)
+	command := "get"
+	if s.push {
+		command = "put"
+	}
+	if s.dryRun {
+		s.transferred++
+		s.report(result{Command: command, Name: name, Path: path, DryRun: true, Reason: reason})
+		return
+	}
+
+	progressCtx, clearProgress := s.withProgress(ctx)
+	var status, size int
+	var err error
+	if s.push {
+		status, size, err = s.upload(progressCtx, name, path, reason != reasonNew)
+	} else {
+		res, downloadErr := s.download(progressCtx, s.webClient, name, path, true)
+		status, size, err = res.Status, res.Size, downloadErr
+	}
+	clearProgress()
+	if err != nil {
+		s.fail(command, name, err)
+		return
+	}
+	if exitCodeOf(result{Status: status}) == ExitOK {
+		s.transferred++
+	}
+	s.report(result{Command: command, Name: name, Path: path, Status: status, Size: sizeIf(status, size), Reason: reason})
+}
+
+// upload puts the file at path, which the server gives the modification time of the local file.
+// Files the server has a version of already are sent as a delta against it.
+func (s *syncer) upload(ctx context.Context, name string, path string, update bool) (int, int, error) {
+	file, size, err := s.openUpload(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fsutil.LoggedClose(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	upload := s.webClient.PutParallel
+	if update {
+		upload = s.webClient.PutDelta
+	}
+	res, err := upload(ctx, name, file, size, info.ModTime())
+	return res.Status, size, err
+}
+
+func (s *syncer) delete(ctx context.Context, name string) {
+	if s.dryRun {
+		s.deleted++
+		if s.push {
+			s.report(result{Command: "rm", Name: name, DryRun: true, Reason: "extraneous"})
+		} else {
+			s.report(result{Command: "rm", Path: filepath.Join(s.dir, name), DryRun: true, Reason: "extraneous"})
+		}
+		return
+	}
+
+	if s.push {
+		res, err := s.webClient.Delete(ctx, name)
+		if err != nil {
+			s.fail("rm", name, err)
+			return
+		}
+		if res.Status == http.StatusOK {
+			s.deleted++
+		}
+		s.report(result{Command: "rm", Name: name, Status: res.Status})
+		return
+	}
+
+	path := filepath.Join(s.dir, name)
+	if err := os.Remove(path); err != nil {
+		s.report(result{Command: "rm", Path: path, Error: err.Error()})
+		return
+	}
+	s.deleted++
+	// Local removals report the status a removal on the server would.
+	s.report(result{Command: "rm", Path: path, Status: http.StatusOK})
+}
+
+// pullable returns the names of the remote files that can be pulled into the directory, the way
+// listLocal picks local files. A name that is not one the server could have stored is reported as
+// a failure, it could lead out of the directory. Partial downloads are left out.
+func (s *syncer) pullable(names []string) []string {
+	var pullable []string
+	for _, name := range names {
+		switch {
+		case !files.ValidFilename(name):
+			s.fail("sync", name, errInvalidRemoteName)
+		case !fsutil.IsPartial(name):
+			pullable = append(pullable, name)
+		}
+	}
+	return pullable
+}
+
+var errInvalidRemoteName = errors.New("the server listed an invalid filename")
+
+func (s *syncer) reportFailure(command string, name string, status int, err error) {
+	if err != nil {
+		s.fail(command, name, err)
+		return
+	}
+	s.report(result{Command: command, Name: name, Status: status})
+}
+
+func (s *syncer) summarize() {
+	direction := syncPull
+	if s.push {
+		direction = syncPush
+	}
+	if s.dryRun {
+		_, _ = fmt.Fprintf(s.stdout, "sync %s: %d to transfer, %d to delete, %d unchanged\n",
+			direction, s.transferred, s.deleted, s.unchanged)
+		return
+	}
+	_, _ = fmt.Fprintf(s.stdout, "sync %s: %d transferred, %d deleted, %d unchanged\n",
+		direction, s.transferred, s.deleted, s.unchanged)
+}
+
+type fileState struct {
+	size    int
+	modTime time.Time
+}
+
+type comparison int
+
+const (
+	sameContent comparison = iota
+	differentSize
+	// differentModTime means the content may differ, only checksums can tell.
+	differentModTime
+)
+
+// compare tells the copies of a file apart by size, and assumes that copies of the same size last
+// modified at the same time have the same content.
+func compare(local fileState, remote fileState) comparison {
+	switch {
+	case local.size != remote.size:
+		return differentSize
+	case !local.modTime.Equal(remote.modTime):
+		return differentModTime
+	}
+	return sameContent
+}
+
+// listLocal returns the regular files directly in dir whose names match and could be those of
+// files on the server. Partial downloads are left out.
+func listLocal(dir string, match *regexp.Regexp) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	local := make(map[string]fileState)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !files.ValidFilename(name) || fsutil.IsPartial(name) || !match.MatchString(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		local[name] = fileState{size: int(info.Size()), modTime: info.ModTime()}
+	}
+	return local, nil
+}
+
+// checksumFile returns the hex encoded SHA-256 of the file at path, as the server computes it.
+func checksumFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fsutil.LoggedClose(file)
+
+	digest := sha256.New()
+	if _, err = io.Copy(digest, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_compare(t *testing.T) {
+	modified := time.Unix(1700000000, 123)
+	tests := []struct {
+		name   string
+		local  fileState
+		remote fileState
+		want   comparison
+	}{
+		{name: "same size and time", local: fileState{3, modified}, remote: fileState{3, modified}, want: sameContent},
+		{name: "other size", local: fileState{3, modified}, remote: fileState{4, modified}, want: differentSize},
+		{name: "other time", local: fileState{3, modified}, remote: fileState{3, modified.Add(1)}, want: differentModTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compare(tt.local, tt.remote); got != tt.want {
+				t.Errorf("got %d want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_listLocal(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"notes.txt", "other.log", ".notes.txt.partial-123"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.txt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("notes.txt", filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := listLocal(dir, regexp.MustCompile(`\.txt$`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(local) != 1 || local["notes.txt"].size != len("notes.txt") {
+		t.Errorf("got %v want only notes.txt", local)
+	}
+}
+
+func Test_pullable(t *testing.T) {
+	var stderr bytes.Buffer
+	s := syncer{cli: &cli{stdout: &bytes.Buffer{}, stderr: &stderr}}
+
+	pullable := s.pullable([]string{"notes.txt", "../escape.txt", "dir/nested.txt", "..", ".notes.txt.partial-123"})
+
+	if !slices.Equal(pullable, []string{"notes.txt"}) {
+		t.Errorf("got %v want only notes.txt", pullable)
+	}
+	if s.exitCode != ExitFailure || !bytes.Contains(stderr.Bytes(), []byte("../escape.txt")) {
+		t.Errorf("invalid names were not reported, exit code %d", s.exitCode)
+	}
+}
@@ -2,31 +2,125 @@ package client
 
 import (
 	"context"
+	"github.com/mat-sik/file-server-go/internal/codec"
 	"github.com/mat-sik/file-server-go/internal/message"
 	"github.com/mat-sik/file-server-go/internal/netmsg"
 	"net"
 )
 
+// Client is safe for concurrent use, every request runs on a session of its own. Requests that
+// fail on their session are retried on a new one as far as they can be, see RetryPolicy.
 type Client struct {
-	sessionHandler sessionHandler
+	pool            *pool
+	acceptEncodings []codec.Encoding
+	retry           RetryPolicy
+	partSize        int
+	storageDir      string
 }
 
 func NewClient(addr string) (Client, error) {
-	conn, err := net.Dial("tcp4", addr)
+	return NewPooledClient(context.Background(), PoolConfig{Dial: DialTCP(addr)})
+}
+
+// NewPooledClient dials the first session right away, so that an unreachable server fails early.
+func NewPooledClient(ctx context.Context, config PoolConfig) (Client, error) {
+	p := newPool(config)
+	session, err := p.acquire(ctx, false)
 	if err != nil {
 		return Client{}, err
 	}
+	p.release(session, false)
 
-	session := netmsg.NewSession(conn)
 	return Client{
-		sessionHandler: sessionHandler{
-			session: session,
-		},
+		pool:            p,
+		acceptEncodings: []codec.Encoding{codec.Zstd, codec.Gzip},
+		retry:           DefaultRetryPolicy,
+		partSize:        defaultPartSize,
 	}, nil
 }
 
+func DialTCP(addr string) Dialer {
+	return func(ctx context.Context) (netmsg.Session, error) {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp4", addr)
+		if err != nil {
+			return netmsg.Session{}, err
+		}
+		return netmsg.NewSession(conn), nil
+	}
+}
+
+// WithAcceptEncodings returns a copy of c that lets the server send downloads of Get in one of
+// encodings, or only as they are stored if there are none.
+func (c Client) WithAcceptEncodings(encodings ...codec.Encoding) Client {
+	c.acceptEncodings = encodings
+	return c
+}
+
+// WithStorageDir returns a copy of c whose Run reads uploads from and writes downloads to dir.
+func (c Client) WithStorageDir(dir string) Client {
+	c.storageDir = dir
+	return c
+}
+
+// Run sends req, reading uploads from and writing downloads to the storage directory, see
+// WithStorageDir.
 func (c Client) Run(req message.Request) (message.Response, error) {
-	ctx := context.Background()
+	return c.RunContext(context.Background(), req)
+}
+
+// RunContext is Run aborted once ctx is done. Uploads and deletions without an idempotency key
+// get one.
+func (c Client) RunContext(ctx context.Context, req message.Request) (res message.Response, err error) {
+	switch typed := req.(type) {
+	case message.PutFileRequest:
+		if typed.IdempotencyKey == "" {
+			typed.IdempotencyKey = newIdempotencyKey()
+		}
+		req = typed
+	case message.DeleteFileRequest:
+		if typed.IdempotencyKey == "" {
+			typed.IdempotencyKey = newIdempotencyKey()
+		}
+		req = typed
+	}
+	ctx, span := startRequestSpan(ctx, req)
+	defer endRequestSpan(span, &err)
+
+	// Every attempt opens the files in the storage directory anew.
+	rewind := always
+	switch req.(type) {
+	case message.MoveFileRequest, message.CopyFileRequest:
+		rewind = never
+	}
+	err = c.withRetries(ctx, rewind, func(sh sessionHandler) (err error) {
+		res, err = sh.handleRequest(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// withSession runs request on a session of the pool, which it closes instead of reusing if the
+// request fails. Retries check the health of idle sessions however briefly they were idle, the
+// failure of the previous attempt may have ended them too.
+func (c Client) withSession(ctx context.Context, retry bool, request func(sessionHandler) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	session, err := c.pool.acquire(ctx, retry)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.pool.release(session, err != nil)
+	}()
+
+	sh := sessionHandler{session: session, storageDir: c.storageDir}
+	defer sh.abortOnDone(ctx)(&err)
+	return request(sh)
+}
 
-	return c.sessionHandler.handleRequest(ctx, req)
+// Close closes the idle sessions, the others once their requests are done.
+func (c Client) Close() error {
+	return c.pool.close()
 }
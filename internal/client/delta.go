@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/mat-sik/file-server-go/internal/delta"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PutDelta uploads size bytes read from reader as filename like PutParallel does, except that if
+// the server has a version of the file already, only what differs from that version is sent. The
+// server rebuilds the file out of its version and replaces it at once, and only if the checksum of
+// the rebuilt file matches. If the file changed on the server since PutDelta read its signature,
+// or the delta would not be smaller than the file, the whole file is uploaded instead.
+func (c Client) PutDelta(
+	ctx context.Context,
+	filename string,
+	reader io.ReaderAt,
+	size int,
+	modTime time.Time,
+) (res message.PutFileResponse, err error) {
+	ctx, span := startRequestSpan(ctx, message.PutDeltaRequest{Filename: filename, Size: size})
+	defer endRequestSpan(span, &err)
+
+	signature, err := exchange[message.GetSignatureResponse](ctx, c, message.GetSignatureRequest{Filename: filename}, always)
+	if err != nil {
+		return message.PutFileResponse{}, err
+	}
+	if signature.Status == http.StatusNotFound {
+		return c.PutParallel(ctx, filename, reader, size, modTime)
+	}
+	if signature.Status != http.StatusOK {
+		return message.PutFileResponse{Status: signature.Status}, nil
+	}
+
+	sig := delta.Signature{BlockSize: signature.BlockSize, Size: signature.Size, Blocks: signature.Blocks}
+	ops, checksum, err := diffFile(ctx, reader, size, sig)
+	if err != nil {
+		return message.PutFileResponse{}, err
+	}
+	deltaSize := delta.EncodedSize(ops)
+	if deltaSize >= size {
+		return c.PutParallel(ctx, filename, reader, size, modTime)
+	}
+
+	req := message.PutDeltaRequest{
+		Filename:       filename,
+		BlockSize:      sig.BlockSize,
+		Size:           size,
+		DeltaSize:      deltaSize,
+		Checksum:       checksum,
+		IdempotencyKey: newIdempotencyKey(),
+		ModTime:        modTime,
+	}
+	var put message.PutDeltaResponse
+	err = c.withRetries(ctx, always, func(sh sessionHandler) (err error) {
+		if err = sh.session.SendMessage(ctx, req); err != nil {
+			return err
+		}
+		progress := startProgress(ctx, filename, true, size, deltaSize)
+		if err = sh.session.StreamToNet(ctx, progress.reader(delta.NewReader(ops, reader)), deltaSize); err != nil {
+			return err
+		}
+		progress.finish()
+		put, err = receiveResponse[message.PutDeltaResponse](ctx, sh)
+		return err
+	})
+	if err != nil {
+		return message.PutFileResponse{}, err
+	}
+	switch put.Status {
+	case http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity:
+		return c.PutParallel(ctx, filename, reader, size, modTime)
+	}
+	return message.PutFileResponse{Status: put.Status}, nil
+}
+
+// diffFile returns the delta of the size bytes of reader against the version sig describes and
+// the hex encoded SHA-256 of those bytes, it gives up once ctx is done.
+func diffFile(ctx context.Context, reader io.ReaderAt, size int, sig delta.Signature) ([]delta.Op, string, error) {
+	digest := sha256.New()
+	source := io.TeeReader(contextReader{ctx: ctx, reader: io.NewSectionReader(reader, 0, int64(size))}, digest)
+	ops, err := delta.Diff(source, sig)
+	if err != nil {
+		return nil, "", err
+	}
+	return ops, hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
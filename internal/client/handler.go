@@ -3,15 +3,19 @@ package client
 import (
 	"context"
 	"errors"
-	"github.com/mat-sik/file-server-go/internal/files"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
 	"github.com/mat-sik/file-server-go/internal/message"
 	"github.com/mat-sik/file-server-go/internal/netmsg"
 	"os"
-	"time"
+	"path/filepath"
 )
 
 type sessionHandler struct {
 	session netmsg.Session
+	// storageDir is where the requests of Run read uploads from and write downloads to.
+	storageDir string
 }
 
 func (sh sessionHandler) handleRequest(ctx context.Context, req message.Request) (message.Response, error) {
@@ -21,7 +25,7 @@ func (sh sessionHandler) handleRequest(ctx context.Context, req message.Request)
 
 	ctx = setValuesInContext(ctx, req)
 
-	res, err := sh.receiveResponse()
+	res, err := sh.receiveResponse(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -44,36 +48,60 @@ func setValuesInContext(ctx context.Context, req message.Request) context.Contex
 }
 
 func (sh sessionHandler) deliverRequest(ctx context.Context, req message.Request) error {
-	ctx, cancel := context.WithTimeout(ctx, timeForRequest)
-	defer cancel()
-
 	switch req := req.(type) {
 	case message.PutFileRequest:
 		return sh.streamRequest(ctx, req)
 	default:
-		return sh.session.SendMessage(req)
+		return sh.session.SendMessage(ctx, req)
 	}
 }
 
 func (sh sessionHandler) streamRequest(ctx context.Context, req message.PutFileRequest) error {
-	path := files.BuildClientFilePath(req.Filename)
+	path := filepath.Join(sh.storageDir, req.Filename)
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	defer files.LoggedClose(file)
+	defer fsutil.LoggedClose(file)
 
-	fileSize, err := files.SizeOf(file)
+	fileSize, err := fsutil.SizeOf(file)
+	if err != nil {
+		return err
+	}
 	req.Size = fileSize
 
-	if err = sh.session.SendMessage(req); err != nil {
+	if req.Encoding == codec.Identity {
+		if err = sh.session.SendMessage(ctx, req); err != nil {
+			return err
+		}
+		progress := startProgress(ctx, req.Filename, true, fileSize, fileSize)
+		if err = sh.session.StreamToNet(ctx, progress.reader(file), fileSize); err != nil {
+			return err
+		}
+		progress.finish()
+		return nil
+	}
+
+	spooled, err := codec.Spool(req.Encoding, file)
+	if err != nil {
 		return err
 	}
-	return sh.session.StreamToNet(ctx, file, fileSize)
+	defer fsutil.LoggedClose(spooled)
+	req.EncodedSize = spooled.Size()
+
+	if err = sh.session.SendMessage(ctx, req); err != nil {
+		return err
+	}
+	progress := startProgress(ctx, req.Filename, true, fileSize, spooled.Size())
+	if err = sh.session.StreamToNet(ctx, progress.reader(spooled), spooled.Size()); err != nil {
+		return err
+	}
+	progress.finish()
+	return nil
 }
 
-func (sh sessionHandler) receiveResponse() (message.Response, error) {
-	msg, err := sh.session.ReceiveMessage()
+func (sh sessionHandler) receiveResponse(ctx context.Context) (message.Response, error) {
+	_, msg, err := sh.session.ReceiveMessage(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +124,12 @@ func (sh sessionHandler) handleResponse(ctx context.Context, res message.Respons
 		handleDeleteFileResponse(ctx, res)
 	case message.GetFilenamesResponse:
 		handleGetFilenamesResponse(ctx, res)
+	case message.StatFileResponse:
+		handleStatFileResponse(ctx, res)
+	case message.MoveFileResponse:
+		handleMoveFileResponse(ctx, res)
+	case message.CopyFileResponse:
+		handleCopyFileResponse(ctx, res)
 	default:
 		return errors.New("unexpected response type")
 	}
@@ -108,7 +142,19 @@ func (sh sessionHandler) handleGetFileResponse(
 	res message.GetFileResponse,
 ) error {
 	filename := filenameFromContextOrPanic(ctx)
-	return handelGetFileResponse(ctx, sh.session, filename, res)
+	return handelGetFileResponse(ctx, sh.session, filepath.Join(sh.storageDir, filename), filename, res)
 }
 
-const timeForRequest = 5 * time.Second
+// abortOnDone closes the session once ctx is done, which interrupts whichever read or write of it
+// blocks. Where the request stopped is unknown then, so the session can not be used any more. The
+// returned function replaces the error the closed connection caused with the one of ctx.
+func (sh sessionHandler) abortOnDone(ctx context.Context) func(err *error) {
+	stop := context.AfterFunc(ctx, func() {
+		fsutil.LoggedClose(sh.session)
+	})
+	return func(err *error) {
+		if !stop() {
+			*err = fmt.Errorf("request aborted, session closed: %w", context.Cause(ctx))
+		}
+	}
+}
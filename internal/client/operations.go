@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/tracing"
+	"io"
+	"net/http"
+)
+
+// The operations below transfer file content from and to arbitrary readers and writers instead
+// of the client storage directory Run uses. Response statuses other than success are returned
+// without an error, errors mean the session failed. Like Run, they give up once ctx is done and
+// report the progress of transfers to the ProgressFunc of ctx.
+
+// Get downloads filename into writer. It is retried only if writer can be truncated back to where
+// it started, as files can, or the download failed before writing to it.
+func (c Client) Get(ctx context.Context, filename string, writer io.Writer) (res message.GetFileResponse, err error) {
+	req := message.GetFileRequest{
+		Filename:        filename,
+		AcceptEncodings: c.acceptEncodings,
+	}
+	ctx, span := startRequestSpan(ctx, req)
+	defer endRequestSpan(span, &err)
+
+	writer, rewind := rewindWriter(writer)
+	err = c.withRetries(ctx, rewind, func(sh sessionHandler) error {
+		if err := sh.session.SendMessage(ctx, req); err != nil {
+			return err
+		}
+		if res, err = receiveResponse[message.GetFileResponse](ctx, sh); err != nil || res.Status != http.StatusOK {
+			return err
+		}
+
+		progress := startProgress(ctx, filename, false, res.Size, res.Size)
+		written, err := sh.session.DecodeFromNet(ctx, progress.writer(writer), res.Encoding, res.TransferSize(), res.Size)
+		if err != nil {
+			return err
+		}
+		if written != res.Size {
+			return fmt.Errorf("declared size of %d bytes, received %d bytes", res.Size, written)
+		}
+		progress.finish()
+		return nil
+	})
+	if err != nil {
+		return message.GetFileResponse{}, err
+	}
+	return res, nil
+}
+
+// Put uploads size bytes read from reader as filename. It is retried only if reader can seek back
+// to where it started or the upload failed before reading from it.
+func (c Client) Put(ctx context.Context, filename string, reader io.Reader, size int) (message.PutFileResponse, error) {
+	return c.put(ctx, message.PutFileRequest{Filename: filename, Size: size}, reader)
+}
+
+func (c Client) put(ctx context.Context, req message.PutFileRequest, reader io.Reader) (res message.PutFileResponse, err error) {
+	req.IdempotencyKey = newIdempotencyKey()
+	ctx, span := startRequestSpan(ctx, req)
+	defer endRequestSpan(span, &err)
+
+	reader, rewind := rewindReader(reader)
+	err = c.withRetries(ctx, rewind, func(sh sessionHandler) (err error) {
+		if err = sh.session.SendMessage(ctx, req); err != nil {
+			return err
+		}
+		progress := startProgress(ctx, req.Filename, true, req.Size, req.Size)
+		if err = sh.session.StreamToNet(ctx, progress.reader(reader), req.Size); err != nil {
+			return err
+		}
+		progress.finish()
+		res, err = receiveResponse[message.PutFileResponse](ctx, sh)
+		return err
+	})
+	return res, err
+}
+
+func (c Client) Delete(ctx context.Context, filename string) (message.DeleteFileResponse, error) {
+	req := message.DeleteFileRequest{Filename: filename, IdempotencyKey: newIdempotencyKey()}
+	return exchange[message.DeleteFileResponse](ctx, c, req, always)
+}
+
+// List returns the names of all files matching the regular expression pattern.
+func (c Client) List(ctx context.Context, pattern string) (message.GetFilenamesResponse, error) {
+	return exchange[message.GetFilenamesResponse](ctx, c, message.GetFilenamesRequest{MatchRegex: pattern}, always)
+}
+
+func (c Client) Stat(ctx context.Context, filename string) (message.StatFileResponse, error) {
+	return exchange[message.StatFileResponse](ctx, c, message.StatFileRequest{Filename: filename}, always)
+}
+
+// Checksum is Stat also returning the checksum of the file, which the server reads the whole file
+// for.
+func (c Client) Checksum(ctx context.Context, filename string) (message.StatFileResponse, error) {
+	return exchange[message.StatFileResponse](ctx, c, message.StatFileRequest{Filename: filename, Checksum: true}, always)
+}
+
+// Move is not retried, the server can not tell a retry from a new request.
+func (c Client) Move(ctx context.Context, from string, to string) (message.MoveFileResponse, error) {
+	return exchange[message.MoveFileResponse](ctx, c, message.MoveFileRequest{From: from, To: to}, never)
+}
+
+// Copy is not retried, the server can not tell a retry from a new request.
+func (c Client) Copy(ctx context.Context, from string, to string) (message.CopyFileResponse, error) {
+	return exchange[message.CopyFileResponse](ctx, c, message.CopyFileRequest{From: from, To: to}, never)
+}
+
+// exchange sends a request without payload and receives its response.
+func exchange[T message.Response](ctx context.Context, c Client, req message.Request, rewind func() bool) (res T, err error) {
+	ctx, span := startRequestSpan(ctx, req)
+	defer endRequestSpan(span, &err)
+
+	err = c.withRetries(ctx, rewind, func(sh sessionHandler) (err error) {
+		if err = sh.session.SendMessage(ctx, req); err != nil {
+			return err
+		}
+		res, err = receiveResponse[T](ctx, sh)
+		return err
+	})
+	return res, err
+}
+
+func receiveResponse[T message.Response](ctx context.Context, sh sessionHandler) (T, error) {
+	var typed T
+	res, err := sh.receiveResponse(ctx)
+	if err != nil {
+		return typed, err
+	}
+	typed, ok := res.(T)
+	if !ok {
+		return typed, fmt.Errorf("expected %T, received %T", typed, res)
+	}
+	return typed, nil
+}
+
+// startRequestSpan starts the root span of a request, the server continues its trace.
+func startRequestSpan(ctx context.Context, req message.Request) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "client.run", tracing.String("request", fmt.Sprintf("%T", req)))
+	if req, ok := req.(message.FilenameGetter); ok {
+		span.SetAttributes(tracing.String("filename", req.GetFilename()))
+	}
+	return ctx, span
+}
+
+func endRequestSpan(span *tracing.Span, err *error) {
+	span.SetError(*err)
+	span.End()
+}
@@ -0,0 +1,284 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// GetParallel and PutParallel split files larger than a part into ranges transferred over up to
+// all sessions of the pool at once. A part that fails on its session is retried on its own, as
+// the RetryPolicy allows. Smaller files are transferred like Get and Put do.
+
+// ErrFileChanged is returned by GetParallel if the file is replaced while its ranges download.
+var ErrFileChanged = errors.New("file changed during the transfer")
+
+// WithPartSize returns a copy of c whose parallel transfers split files into parts of size bytes.
+func (c Client) WithPartSize(size int) Client {
+	c.partSize = size
+	return c
+}
+
+// GetParallel downloads filename into writer, the ranges of the file are written at their
+// offsets. If it fails writer may have received part of the content.
+func (c Client) GetParallel(ctx context.Context, filename string, writer io.WriterAt) (res message.GetFileResponse, err error) {
+	ctx, span := startRequestSpan(ctx, message.GetFileRequest{Filename: filename})
+	defer endRequestSpan(span, &err)
+
+	stat, err := c.Stat(ctx, filename)
+	if err != nil || stat.Status != http.StatusOK {
+		return message.GetFileResponse{Status: stat.Status}, err
+	}
+	if stat.Size <= c.partSize {
+		return c.Get(ctx, filename, io.NewOffsetWriter(writer, 0))
+	}
+
+	progress := startProgress(ctx, filename, false, stat.Size, stat.Size)
+	err = c.inParallel(ctx, splitParts(stat.Size, c.partSize), func(ctx context.Context, part filePart) error {
+		return c.getRange(ctx, filename, part, stat.ModTime, writer, progress)
+	})
+	if failed := (partFailed{}); errors.As(err, &failed) {
+		return message.GetFileResponse{Status: failed.status}, nil
+	}
+	if err != nil {
+		return message.GetFileResponse{}, err
+	}
+	progress.finish()
+	return message.GetFileResponse{Status: http.StatusOK, Size: stat.Size, ModTime: stat.ModTime}, nil
+}
+
+// getRange downloads part of the file, which must still be the one last modified at modTime.
+func (c Client) getRange(
+	ctx context.Context,
+	filename string,
+	part filePart,
+	modTime time.Time,
+	writer io.WriterAt,
+	progress *progressTracker,
+) error {
+	req := message.GetFileRequest{Filename: filename, Offset: part.offset, Length: part.size}
+	return c.withRetries(ctx, always, func(sh sessionHandler) error {
+		if err := sh.session.SendMessage(ctx, req); err != nil {
+			return err
+		}
+		res, err := receiveResponse[message.GetFileResponse](ctx, sh)
+		if err != nil {
+			return err
+		}
+		switch {
+		case res.Status == http.StatusNotFound || res.Status == http.StatusRequestedRangeNotSatisfiable:
+			return ErrFileChanged
+		case res.Status != http.StatusPartialContent:
+			return partFailed{status: res.Status}
+		case !res.ModTime.Equal(modTime) || res.Size != part.size:
+			return ErrFileChanged
+		}
+
+		counting := &countingWriter{Writer: io.NewOffsetWriter(writer, int64(part.offset))}
+		written, err := sh.session.DecodeFromNet(ctx, progress.writer(counting), res.Encoding, res.TransferSize(), part.size)
+		if err == nil && written != part.size {
+			err = fmt.Errorf("declared size of %d bytes, received %d bytes", part.size, written)
+		}
+		if err != nil {
+			progress.rewind(counting.written)
+		}
+		return err
+	})
+}
+
+// PutParallel uploads size bytes read from reader as filename, last modified at modTime unless it
+// is zero. The server assembles the parts and replaces the file with them only once all arrived and
+// their checksum matches, a failed upload leaves the file as it was.
+func (c Client) PutParallel(
+	ctx context.Context,
+	filename string,
+	reader io.ReaderAt,
+	size int,
+	modTime time.Time,
+) (res message.PutFileResponse, err error) {
+	if size <= c.partSize {
+		req := message.PutFileRequest{Filename: filename, Size: size, ModTime: modTime}
+		return c.put(ctx, req, io.NewSectionReader(reader, 0, int64(size)))
+	}
+	ctx, span := startRequestSpan(ctx, message.PutFileRequest{Filename: filename, Size: size})
+	defer endRequestSpan(span, &err)
+
+	created, err := exchange[message.CreateUploadResponse](ctx, c, message.CreateUploadRequest{Filename: filename, Size: size}, always)
+	if err != nil || created.Status != http.StatusOK {
+		return message.PutFileResponse{Status: created.Status}, err
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			c.abortUpload(ctx, created.UploadID)
+		}
+	}()
+
+	checksum := make(chan checksumResult, 1)
+	checksumCtx, stopChecksum := context.WithCancel(ctx)
+	defer stopChecksum()
+	go func() {
+		sum, err := checksumOf(checksumCtx, io.NewSectionReader(reader, 0, int64(size)))
+		checksum <- checksumResult{sum: sum, err: err}
+	}()
+
+	progress := startProgress(ctx, filename, true, size, size)
+	err = c.inParallel(ctx, splitParts(size, c.partSize), func(ctx context.Context, part filePart) error {
+		return c.putPart(ctx, created.UploadID, part, reader, progress)
+	})
+	if failed := (partFailed{}); errors.As(err, &failed) {
+		return message.PutFileResponse{Status: failed.status}, nil
+	}
+	if err != nil {
+		return message.PutFileResponse{}, err
+	}
+	progress.finish()
+
+	sum := <-checksum
+	if sum.err != nil {
+		return message.PutFileResponse{}, sum.err
+	}
+	req := message.CompleteUploadRequest{
+		UploadID:       created.UploadID,
+		Checksum:       sum.sum,
+		IdempotencyKey: newIdempotencyKey(),
+		ModTime:        modTime,
+	}
+	complete, err := exchange[message.CompleteUploadResponse](ctx, c, req, always)
+	if err != nil {
+		return message.PutFileResponse{}, err
+	}
+	completed = complete.Status == http.StatusCreated
+	return message.PutFileResponse{Status: complete.Status}, nil
+}
+
+func (c Client) putPart(ctx context.Context, uploadID string, part filePart, reader io.ReaderAt, progress *progressTracker) error {
+	req := message.PutPartRequest{UploadID: uploadID, Offset: part.offset, Size: part.size}
+	return c.withRetries(ctx, always, func(sh sessionHandler) (err error) {
+		counting := &countingReader{Reader: io.NewSectionReader(reader, int64(part.offset), int64(part.size))}
+		defer func() {
+			if err != nil {
+				progress.rewind(counting.read)
+			}
+		}()
+
+		if err = sh.session.SendMessage(ctx, req); err != nil {
+			return err
+		}
+		if err = sh.session.StreamToNet(ctx, progress.reader(counting), part.size); err != nil {
+			return err
+		}
+		res, err := receiveResponse[message.PutPartResponse](ctx, sh)
+		if err != nil {
+			return err
+		}
+		if res.Status != http.StatusOK {
+			return partFailed{status: res.Status}
+		}
+		return nil
+	})
+}
+
+// abortUpload removes the parts of an upload that did not complete. It runs even if ctx is done,
+// a failure only leaves the parts behind.
+func (c Client) abortUpload(ctx context.Context, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+	defer cancel()
+	res, err := exchange[message.AbortUploadResponse](ctx, c, message.AbortUploadRequest{UploadID: uploadID}, always)
+	if err != nil || res.Status != http.StatusOK {
+		slog.Debug("Aborting upload failed", "uploadId", uploadID, "status", res.Status, "err", err)
+	}
+}
+
+type filePart struct {
+	offset int
+	size   int
+}
+
+func splitParts(size int, partSize int) []filePart {
+	parts := make([]filePart, 0, (size+partSize-1)/partSize)
+	for offset := 0; offset < size; offset += partSize {
+		parts = append(parts, filePart{offset: offset, size: min(partSize, size-offset)})
+	}
+	return parts
+}
+
+// inParallel runs transfer for every part, as many at once as the pool has sessions. The first
+// part that fails cancels the others and its error is returned.
+func (c Client) inParallel(ctx context.Context, parts []filePart, transfer func(context.Context, filePart) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	queue := make(chan filePart)
+	var wg sync.WaitGroup
+	for range min(len(parts), c.pool.size()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range queue {
+				if err := transfer(ctx, part); err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+
+enqueue:
+	for _, part := range parts {
+		select {
+		case queue <- part:
+		case <-ctx.Done():
+			break enqueue
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// partFailed ends a parallel transfer whose part the server answered with status.
+type partFailed struct {
+	status int
+}
+
+func (e partFailed) Error() string {
+	return fmt.Sprintf("part failed with status %d", e.status)
+}
+
+type checksumResult struct {
+	sum string
+	err error
+}
+
+// checksumOf returns the hex encoded SHA-256 of what reader reads, it gives up once ctx is done.
+func checksumOf(ctx context.Context, reader io.Reader) (string, error) {
+	digest := sha256.New()
+	buffer := make([]byte, checksumBufferSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := reader.Read(buffer)
+		digest.Write(buffer[:n])
+		if errors.Is(err, io.EOF) {
+			return hex.EncodeToString(digest.Sum(nil)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+const (
+	defaultPartSize    = 8 * 1024 * 1024
+	checksumBufferSize = 256 * 1024
+	abortTimeout       = 10 * time.Second
+)
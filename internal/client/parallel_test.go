@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_should_SplitParts(t *testing.T) {
+	testCases := []struct {
+		size int
+		want []filePart
+	}{
+		{size: 0, want: []filePart{}},
+		{size: 10, want: []filePart{{offset: 0, size: 10}}},
+		{size: 25, want: []filePart{{offset: 0, size: 10}, {offset: 10, size: 10}, {offset: 20, size: 5}}},
+	}
+	for _, tc := range testCases {
+		if got := splitParts(tc.size, 10); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitParts(%d, 10) = %v want %v", tc.size, got, tc.want)
+		}
+	}
+}
+
+func Test_should_StopParallelTransfer_When_PartFails(t *testing.T) {
+	c := Client{pool: newPool(PoolConfig{MaxSessions: 2})}
+	errPart := errors.New("part failed")
+	var running, started atomic.Int32
+
+	err := c.inParallel(context.Background(), splitParts(100, 1), func(ctx context.Context, part filePart) error {
+		started.Add(1)
+		if running.Add(1) > 2 {
+			t.Error("more parts ran at once than the pool has sessions")
+		}
+		defer running.Add(-1)
+		if part.offset == 3 {
+			return errPart
+		}
+		return nil
+	})
+
+	if !errors.Is(err, errPart) {
+		t.Fatalf("got %v want %v", err, errPart)
+	}
+	if n := started.Load(); n >= 100 {
+		t.Fatalf("all %d parts ran after one failed", n)
+	}
+}
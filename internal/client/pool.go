@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"sync"
+	"time"
+)
+
+// Dialer opens a new session with the server.
+type Dialer func(ctx context.Context) (netmsg.Session, error)
+
+type PoolConfig struct {
+	Dial Dialer
+	// MaxSessions bounds the sessions open at once, requests beyond it wait for one to be released.
+	MaxSessions int
+	// MaxIdleTime closes sessions unused for longer, it should stay below the idle timeout of the
+	// server.
+	MaxIdleTime time.Duration
+}
+
+const (
+	defaultMaxSessions = 4
+	defaultMaxIdleTime = time.Minute
+	// Sessions idle for less are handed out without checking their health first.
+	healthCheckAfter = time.Second
+)
+
+var ErrClientClosed = errors.New("client closed")
+
+// pool hands every request a session of its own. Sessions are reused most recently released
+// first, sessions a request failed on are closed instead.
+type pool struct {
+	dial        Dialer
+	maxIdleTime time.Duration
+	slots       chan struct{}
+
+	mu     sync.Mutex
+	idle   []idleSession
+	closed bool
+}
+
+type idleSession struct {
+	session netmsg.Session
+	since   time.Time
+}
+
+func newPool(config PoolConfig) *pool {
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = defaultMaxSessions
+	}
+	if config.MaxIdleTime <= 0 {
+		config.MaxIdleTime = defaultMaxIdleTime
+	}
+	return &pool{
+		dial:        config.Dial,
+		maxIdleTime: config.MaxIdleTime,
+		slots:       make(chan struct{}, config.MaxSessions),
+	}
+}
+
+// size is the number of sessions that may be open at once.
+func (p *pool) size() int {
+	return cap(p.slots)
+}
+
+// acquire returns an idle session that is still healthy or dials a new one, waiting while
+// MaxSessions are in use. Sessions idle for less than healthCheckAfter are only checked if
+// checkHealth is set.
+func (p *pool) acquire(ctx context.Context, checkHealth bool) (netmsg.Session, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return netmsg.Session{}, ctx.Err()
+	}
+
+	for {
+		idle, ok, err := p.popIdle()
+		if err != nil {
+			<-p.slots
+			return netmsg.Session{}, err
+		}
+		if !ok {
+			break
+		}
+		idleFor := time.Since(idle.since)
+		if idleFor < p.maxIdleTime && (idleFor < healthCheckAfter && !checkHealth || idle.session.Healthy()) {
+			return idle.session, nil
+		}
+		closeQuietly(idle.session)
+	}
+
+	session, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+		return netmsg.Session{}, err
+	}
+	return session, nil
+}
+
+func (p *pool) popIdle() (idleSession, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return idleSession{}, false, ErrClientClosed
+	}
+	if len(p.idle) == 0 {
+		return idleSession{}, false, nil
+	}
+	idle := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return idle, true, nil
+}
+
+// release returns session to the pool, or closes it if the request failed on it and its state is
+// unknown.
+func (p *pool) release(session netmsg.Session, failed bool) {
+	defer func() {
+		<-p.slots
+	}()
+
+	p.mu.Lock()
+	if failed || p.closed {
+		p.mu.Unlock()
+		closeQuietly(session)
+		return
+	}
+	p.idle = append(p.idle, idleSession{session: session, since: time.Now()})
+	p.mu.Unlock()
+}
+
+// close closes the idle sessions, sessions in use are closed once they are released.
+func (p *pool) close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+
+	var errs []error
+	for _, idle := range idle {
+		errs = append(errs, idle.session.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// closeQuietly closes a session that is already known to be broken, failing to close it is no news.
+func closeQuietly(session netmsg.Session) {
+	_ = session.Close()
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_should_BoundSessions_And_ReuseReleasedOnes(t *testing.T) {
+	dialer := &pipeDialer{}
+	p := newPool(PoolConfig{Dial: dialer.dial, MaxSessions: 2})
+	defer p.close()
+	ctx := context.Background()
+
+	first := mustAcquire(t, p)
+	second := mustAcquire(t, p)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(waitCtx, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+
+	p.release(second, false)
+	third := mustAcquire(t, p)
+	p.release(first, false)
+	p.release(third, false)
+
+	if dialed := dialer.dialed.Load(); dialed != 2 {
+		t.Fatalf("dialed %d sessions want 2", dialed)
+	}
+}
+
+func Test_should_ReplaceFailedAndExpiredSessions(t *testing.T) {
+	dialer := &pipeDialer{}
+	p := newPool(PoolConfig{Dial: dialer.dial, MaxIdleTime: time.Nanosecond})
+	defer p.close()
+
+	p.release(mustAcquire(t, p), true)
+	p.release(mustAcquire(t, p), false)
+	time.Sleep(time.Millisecond)
+	p.release(mustAcquire(t, p), false)
+
+	if dialed := dialer.dialed.Load(); dialed != 3 {
+		t.Fatalf("dialed %d sessions want 3", dialed)
+	}
+}
+
+func Test_should_FailAcquire_When_Closed(t *testing.T) {
+	dialer := &pipeDialer{}
+	p := newPool(PoolConfig{Dial: dialer.dial})
+
+	inUse := mustAcquire(t, p)
+	p.release(mustAcquire(t, p), false)
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+	p.release(inUse, false)
+
+	if _, err := p.acquire(context.Background(), false); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("got %v want %v", err, ErrClientClosed)
+	}
+	deadline := time.Now().Add(time.Second)
+	for dialer.dialed.Load() != dialer.closed.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d sessions remain open", dialer.dialed.Load()-dialer.closed.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func mustAcquire(t *testing.T, p *pool) netmsg.Session {
+	session, err := p.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+type pipeDialer struct {
+	dialed atomic.Int32
+	closed atomic.Int32
+}
+
+func (d *pipeDialer) dial(context.Context) (netmsg.Session, error) {
+	conn, peer := net.Pipe()
+	d.dialed.Add(1)
+	go func() {
+		// The peer only notices the close of the session, it never answers.
+		_, _ = peer.Read(make([]byte, 1))
+		_ = peer.Close()
+		d.closed.Add(1)
+	}()
+	return netmsg.NewSession(conn), nil
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress describes a transfer in flight. Done and Total count the bytes of the file, which
+// differ from those sent if the transfer is compressed.
+type Progress struct {
+	Filename string
+	Upload   bool
+	Done     int64
+	Total    int64
+	Elapsed  time.Duration
+}
+
+// Rate returns the average transfer rate so far in bytes per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Done) / p.Elapsed.Seconds()
+}
+
+// ETA estimates the time left at the average rate so far, zero if there is no rate yet.
+func (p Progress) ETA() time.Duration {
+	rate := p.Rate()
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Total-p.Done) / rate * float64(time.Second))
+}
+
+// ProgressFunc is called as a transfer starts, at most every 100ms while it runs, and once it is
+// complete. A retried transfer starts over, a retried part of one takes back what it transferred.
+// Calls for one transfer never overlap, even if its parts run at once.
+type ProgressFunc func(Progress)
+
+func ContextWithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// startProgress tracks a transfer of total bytes of a file, of which transferSize bytes pass the
+// reader or writer of the tracker. It returns nil, which tracks nothing, if ctx has no
+// ProgressFunc.
+func startProgress(ctx context.Context, filename string, upload bool, total int, transferSize int) *progressTracker {
+	fn, ok := ctx.Value(progressKey{}).(ProgressFunc)
+	if !ok {
+		return nil
+	}
+	t := &progressTracker{
+		fn:           fn,
+		progress:     Progress{Filename: filename, Upload: upload, Total: int64(total)},
+		transferSize: int64(transferSize),
+		start:        time.Now(),
+	}
+	t.report(t.start)
+	return t
+}
+
+// progressTracker may be shared by the parts of a transfer that run at once.
+type progressTracker struct {
+	mu           sync.Mutex
+	fn           ProgressFunc
+	progress     Progress
+	transferSize int64
+	transferred  int64
+	start        time.Time
+	reported     time.Time
+	reportedDone int64
+}
+
+func (t *progressTracker) add(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.transferred += int64(n)
+	t.progress.Done = t.transferred
+	if t.transferSize > 0 && t.transferSize != t.progress.Total {
+		t.progress.Done = t.transferred * t.progress.Total / t.transferSize
+	}
+	now := time.Now()
+	if now.Sub(t.reported) >= progressInterval {
+		t.report(now)
+	}
+}
+
+// finish reports the completed transfer, unless the last report already did.
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reportedDone != t.progress.Done {
+		t.report(time.Now())
+	}
+}
+
+// rewind takes back the n bytes an attempt of a part transferred before it failed.
+func (t *progressTracker) rewind(n int64) {
+	if t != nil {
+		t.add(int(-n))
+	}
+}
+
+func (t *progressTracker) report(now time.Time) {
+	t.progress.Elapsed = now.Sub(t.start)
+	t.reported, t.reportedDone = now, t.progress.Done
+	t.fn(t.progress)
+}
+
+func (t *progressTracker) reader(reader io.Reader) io.Reader {
+	if t == nil {
+		return reader
+	}
+	return &progressReader{Reader: reader, tracker: t}
+}
+
+func (t *progressTracker) writer(writer io.Writer) io.Writer {
+	if t == nil {
+		return writer
+	}
+	return &progressWriter{Writer: writer, tracker: t}
+}
+
+type progressReader struct {
+	io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.tracker.add(n)
+	return n, err
+}
+
+type progressWriter struct {
+	io.Writer
+	tracker *progressTracker
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.tracker.add(n)
+	return n, err
+}
+
+type progressKey struct{}
+
+const progressInterval = 100 * time.Millisecond
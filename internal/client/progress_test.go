@@ -0,0 +1,83 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_ReportProgress(t *testing.T) {
+	var reports []Progress
+	ctx := ContextWithProgress(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+	})
+
+	progress := startProgress(ctx, "foo.txt", true, 1000, 1000)
+	reader := progress.reader(strings.NewReader(strings.Repeat("x", 1000)))
+	for _, n := range []int64{400, 100} {
+		if _, err := io.Copy(io.Discard, io.LimitReader(reader, n)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(progressInterval)
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatal(err)
+	}
+	progress.finish()
+	progress.finish()
+
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports want 3: %+v", len(reports), reports)
+	}
+	if first := reports[0]; first.Done != 0 || first.Total != 1000 || first.Filename != "foo.txt" || !first.Upload {
+		t.Fatalf("unexpected first report %+v", first)
+	}
+	if middle := reports[1]; middle.Done != 500 {
+		t.Fatalf("unexpected throttled report %+v", middle)
+	}
+	if last := reports[2]; last.Done != 1000 {
+		t.Fatalf("unexpected last report %+v", last)
+	}
+}
+
+func Test_should_ScaleProgress_Of_EncodedTransfers(t *testing.T) {
+	var last Progress
+	ctx := ContextWithProgress(context.Background(), func(p Progress) {
+		last = p
+	})
+
+	progress := startProgress(ctx, "foo.txt", true, 1000, 100)
+	if _, err := io.Copy(io.Discard, progress.reader(bytes.NewReader(make([]byte, 100)))); err != nil {
+		t.Fatal(err)
+	}
+	progress.finish()
+
+	if last.Done != 1000 || last.Total != 1000 {
+		t.Fatalf("got %+v want 1000 of 1000 bytes", last)
+	}
+}
+
+func Test_should_EstimateRemainingTime(t *testing.T) {
+	p := Progress{Done: 250, Total: 1000, Elapsed: time.Second}
+	if rate := p.Rate(); rate != 250 {
+		t.Fatalf("got rate %v want 250", rate)
+	}
+	if eta := p.ETA(); eta != 3*time.Second {
+		t.Fatalf("got ETA %v want 3s", eta)
+	}
+	if eta := (Progress{Total: 1000}).ETA(); eta != 0 {
+		t.Fatalf("got ETA %v without a rate", eta)
+	}
+}
+
+func Test_should_TrackNothing_Without_ProgressFunc(t *testing.T) {
+	progress := startProgress(context.Background(), "foo.txt", false, 10, 10)
+	var buffer bytes.Buffer
+	if writer := progress.writer(&buffer); writer != &buffer {
+		t.Fatal("wrapped the writer without a ProgressFunc")
+	}
+	progress.finish()
+}
@@ -2,17 +2,19 @@ package client
 
 import (
 	"context"
-	"github.com/mat-sik/file-server-go/internal/files"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
 	"github.com/mat-sik/file-server-go/internal/message"
 	"github.com/mat-sik/file-server-go/internal/netmsg"
 	"log/slog"
 	"net/http"
-	"os"
 )
 
 func handelGetFileResponse(
 	ctx context.Context,
 	session netmsg.Session,
+	path string,
 	filename string,
 	res message.GetFileResponse,
 ) error {
@@ -21,26 +23,42 @@ func handelGetFileResponse(
 		return nil
 	}
 
-	if err := downloadFile(ctx, session, filename, res.Size); err != nil {
+	if err := downloadFile(ctx, session, path, filename, res); err != nil {
 		return err
 	}
 
-	slog.Info("GET file response:", "filename", filename, "status", res.Status, "size", res.Size)
+	slog.Info("GET file response:",
+		"filename", filename,
+		"status", res.Status,
+		"size", res.Size,
+		"encoding", res.Encoding,
+		"transferred", res.TransferSize(),
+	)
 	return nil
 }
 
 func downloadFile(
 	ctx context.Context,
 	session netmsg.Session,
+	path string,
 	filename string,
-	fileSize int,
+	res message.GetFileResponse,
 ) error {
-	path := files.BuildClientFilePath(filename)
-	file, err := os.Create(path)
+	file, err := fsutil.CreatePartial(path)
 	if err != nil {
 		return err
 	}
-	return session.StreamFromNet(ctx, file, fileSize)
+
+	progress := startProgress(ctx, filename, false, res.Size, res.Size)
+	written, err := session.DecodeFromNet(ctx, progress.writer(file), res.Encoding, res.TransferSize(), res.Size)
+	if err == nil && written != res.Size {
+		err = fmt.Errorf("declared size of %d bytes, received %d bytes", res.Size, written)
+	}
+	if err != nil {
+		return errors.Join(err, file.Abort())
+	}
+	progress.finish()
+	return file.Commit()
 }
 
 func handlePutFileResponse(ctx context.Context, res message.PutFileResponse) {
@@ -61,3 +79,18 @@ func handleGetFilenamesResponse(ctx context.Context, res message.GetFilenamesRes
 	}
 	slog.Info("GET filenames response:", "filenames", res.Filenames, "pattern", pattern, "status", res.Status)
 }
+
+func handleStatFileResponse(ctx context.Context, res message.StatFileResponse) {
+	filename := filenameFromContextOrPanic(ctx)
+	slog.Info("STAT file response:", "filename", filename, "status", res.Status, "size", res.Size, "modTime", res.ModTime)
+}
+
+func handleMoveFileResponse(ctx context.Context, res message.MoveFileResponse) {
+	filename := filenameFromContextOrPanic(ctx)
+	slog.Info("MOVE file response:", "filename", filename, "status", res.Status)
+}
+
+func handleCopyFileResponse(ctx context.Context, res message.CopyFileResponse) {
+	filename := filenameFromContextOrPanic(ctx)
+	slog.Info("COPY file response:", "filename", filename, "status", res.Status)
+}
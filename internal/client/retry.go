@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// RetryPolicy decides how often a request that failed on its connection is retried on a new one.
+// The n-th retry waits a random duration of up to InitialBackoff doubled n-1 times, at most
+// MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+}
+
+// WithRetryPolicy returns a copy of c that retries requests as policy says.
+func (c Client) WithRetryPolicy(policy RetryPolicy) Client {
+	c.retry = policy
+	return c
+}
+
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	ceiling := p.InitialBackoff
+	for i := 1; i < retry && ceiling < p.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, p.MaxBackoff)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// withRetries runs request until it succeeds, fails for a reason other than its connection, or
+// the policy gives up. rewind prepares another attempt and reports whether there can be one,
+// requests that consumed their input can not be retried without it.
+func (c Client) withRetries(ctx context.Context, rewind func() bool, request func(sessionHandler) error) error {
+	for attempt := 1; ; attempt++ {
+		err := c.withSession(ctx, attempt > 1, request)
+		if err == nil || attempt >= c.retry.MaxAttempts || !transient(err) || ctx.Err() != nil || !rewind() {
+			return err
+		}
+
+		wait := c.retry.backoff(attempt)
+		slog.Debug("Retrying request", "attempt", attempt+1, "backoff", wait, "err", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retrying after %v: %w", err, context.Cause(ctx))
+		}
+	}
+}
+
+// transient reports whether err is a failure of the connection, which a new one may not run into.
+func transient(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func always() bool {
+	return true
+}
+
+func never() bool {
+	return false
+}
+
+// rewindReader returns a rewind function for requests uploading from reader. They can be retried
+// if nothing was read yet or reader can seek back to where it started.
+func rewindReader(reader io.Reader) (io.Reader, func() bool) {
+	counting := &countingReader{Reader: reader}
+	seeker, ok := reader.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		ok = err == nil
+	}
+	return counting, func() bool {
+		if counting.read == 0 {
+			return true
+		}
+		if !ok {
+			return false
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return false
+		}
+		counting.read = 0
+		return true
+	}
+}
+
+// rewindWriter returns a rewind function for requests downloading into writer. They can be retried
+// if nothing was written yet or writer can be truncated to where it started, as files can.
+func rewindWriter(writer io.Writer) (io.Writer, func() bool) {
+	counting := &countingWriter{Writer: writer}
+	truncater, ok := writer.(interface {
+		io.Seeker
+		Truncate(size int64) error
+	})
+	var start int64
+	if ok {
+		var err error
+		start, err = truncater.Seek(0, io.SeekCurrent)
+		ok = err == nil
+	}
+	return counting, func() bool {
+		if counting.written == 0 {
+			return true
+		}
+		if !ok {
+			return false
+		}
+		if err := truncater.Truncate(start); err != nil {
+			return false
+		}
+		if _, err := truncater.Seek(start, io.SeekStart); err != nil {
+			return false
+		}
+		counting.written = 0
+		return true
+	}
+}
+
+type countingReader struct {
+	io.Reader
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// newIdempotencyKey returns a key that identifies all attempts of one mutating request.
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	_, _ = cryptorand.Read(key)
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_BoundBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for retry, ceiling := range []time.Duration{10, 20, 40, 50, 50} {
+		for range 100 {
+			if wait := policy.backoff(retry + 1); wait < 0 || wait > ceiling*time.Millisecond {
+				t.Fatalf("retry %d waits %v, more than %v", retry+1, wait, ceiling*time.Millisecond)
+			}
+		}
+	}
+}
+
+func Test_should_ClassifyTransientErrors(t *testing.T) {
+	testCases := []struct {
+		err       error
+		transient bool
+	}{
+		{err: io.EOF, transient: true},
+		{err: fmt.Errorf("receiving: %w", io.ErrUnexpectedEOF), transient: true},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, transient: true},
+		{err: os.ErrDeadlineExceeded, transient: true},
+		{err: ErrClientClosed, transient: false},
+		{err: errors.New("declared size of 3 bytes, received 2 bytes"), transient: false},
+	}
+
+	for _, tc := range testCases {
+		if got := transient(tc.err); got != tc.transient {
+			t.Errorf("transient(%v) = %v want %v", tc.err, got, tc.transient)
+		}
+	}
+}
+
+func Test_should_RewindReader(t *testing.T) {
+	seekable, rewind := rewindReader(strings.NewReader("content"))
+	if _, err := io.ReadAll(io.LimitReader(seekable, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if !rewind() {
+		t.Fatal("could not rewind a seekable reader")
+	}
+	if all, _ := io.ReadAll(seekable); string(all) != "content" {
+		t.Fatalf("read %q after rewinding", all)
+	}
+
+	stream, rewind := rewindReader(io.MultiReader(strings.NewReader("content")))
+	if !rewind() {
+		t.Fatal("could not rewind an unread reader")
+	}
+	if _, err := io.ReadAll(io.LimitReader(stream, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if rewind() {
+		t.Fatal("rewound a reader that can not seek")
+	}
+}
+
+func Test_should_RewindWriter(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	truncatable, rewind := rewindWriter(file)
+	if _, err = truncatable.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	if !rewind() {
+		t.Fatal("could not rewind a file")
+	}
+	if _, err = truncatable.Write([]byte("full")); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(file.Name()); string(content) != "full" {
+		t.Fatalf("got %q want %q", content, "full")
+	}
+
+	buffer, rewind := rewindWriter(&bytes.Buffer{})
+	if _, err = buffer.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	if rewind() {
+		t.Fatal("rewound a buffer")
+	}
+}
@@ -0,0 +1,113 @@
+package codec
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+)
+
+type Encoding int32
+
+const (
+	Identity Encoding = iota
+	Gzip
+	Zstd
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case Identity:
+		return "identity"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("encoding(%d)", int32(e))
+	}
+}
+
+func ParseEncoding(name string) (Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "identity", "none":
+		return Identity, nil
+	case "gzip":
+		return Gzip, nil
+	case "zstd":
+		return Zstd, nil
+	default:
+		return Identity, fmt.Errorf("unknown encoding %q", name)
+	}
+}
+
+func (e Encoding) Supported() bool {
+	return e == Identity || e == Gzip || e == Zstd
+}
+
+// Negotiate picks the encoding the server prefers among the ones offered by the peer.
+func Negotiate(offered []Encoding) Encoding {
+	for _, preferred := range preference {
+		for _, encoding := range offered {
+			if encoding == preferred {
+				return preferred
+			}
+		}
+	}
+	return Identity
+}
+
+var preference = []Encoding{Zstd, Gzip}
+
+// ErrTooLarge is returned when content decodes to more bytes than it was declared to have, which
+// is where decoding stops so that a small payload cannot expand without bound.
+var ErrTooLarge = errors.New("decoded content exceeds its declared size")
+
+func NewWriter(e Encoding, w io.Writer) (io.WriteCloser, error) {
+	switch e {
+	case Identity:
+		return nopWriteCloser{Writer: w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %v", e)
+	}
+}
+
+func NewReader(e Encoding, r io.Reader) (io.ReadCloser, error) {
+	switch e {
+	case Identity:
+		return io.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{Decoder: decoder}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %v", e)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func Test_should_EncodeAndDecode_RoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("file-server-go "), 4096)
+
+	for _, encoding := range []Encoding{Identity, Gzip, Zstd} {
+		t.Run(encoding.String(), func(t *testing.T) {
+			spooled, err := Spool(encoding, bytes.NewReader(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer spooled.Close()
+
+			if encoding != Identity && spooled.Size() >= len(payload) {
+				t.Fatalf("got encoded size %d, want less than %d", spooled.Size(), len(payload))
+			}
+
+			decoder, err := NewReader(encoding, io.LimitReader(spooled, int64(spooled.Size())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+
+			out, err := io.ReadAll(decoder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, payload) {
+				t.Fatalf("decoded payload differs from the original")
+			}
+		})
+	}
+}
+
+func Test_should_Negotiate_PreferredEncoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		offered  []Encoding
+		expected Encoding
+	}{
+		{name: "Nothing offered", offered: nil, expected: Identity},
+		{name: "Only gzip", offered: []Encoding{Gzip}, expected: Gzip},
+		{name: "Zstd preferred over gzip", offered: []Encoding{Gzip, Zstd}, expected: Zstd},
+		{name: "Unknown ignored", offered: []Encoding{Encoding(42)}, expected: Identity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Negotiate(tc.offered); got != tc.expected {
+				t.Fatalf("got %v want %v", got, tc.expected)
+			}
+		})
+	}
+}
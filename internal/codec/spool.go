@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Spooled holds an encoded copy of a payload in a temporary file, so that its encoded
+// length can be declared before the bytes are streamed.
+type Spooled struct {
+	file *os.File
+	size int
+}
+
+func Spool(e Encoding, r io.Reader) (*Spooled, error) {
+	file, err := os.CreateTemp("", "file-server-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &Spooled{file: file}
+
+	if err = encodeInto(e, file, r); err != nil {
+		return nil, errors.Join(err, spooled.Close())
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, errors.Join(err, spooled.Close())
+	}
+	spooled.size = int(offset)
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Join(err, spooled.Close())
+	}
+	return spooled, nil
+}
+
+func encodeInto(e Encoding, w io.Writer, r io.Reader) error {
+	encoder, err := NewWriter(e, w)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(encoder, r); err != nil {
+		return errors.Join(err, encoder.Close())
+	}
+	return encoder.Close()
+}
+
+func (s *Spooled) Read(p []byte) (int, error) {
+	return s.file.Read(p)
+}
+
+func (s *Spooled) Size() int {
+	return s.size
+}
+
+func (s *Spooled) Close() error {
+	return errors.Join(s.file.Close(), os.Remove(s.file.Name()))
+}
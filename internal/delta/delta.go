@@ -0,0 +1,89 @@
+// Package delta transfers a new version of a file as the difference to an old one, as rsync does.
+// The holder of the old version, the base, describes its blocks with a Signature. The holder of
+// the new version finds those blocks in it with Diff and sends only references to them and the
+// literal data in between, from which Apply rebuilds the new version out of the base.
+package delta
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math"
+)
+
+// Signature describes the blocks of a base of Size bytes, all of which are BlockSize bytes long
+// except for the last one, which may be shorter.
+type Signature struct {
+	BlockSize int
+	Size      int
+	Blocks    []Block
+}
+
+// Block holds the rolling checksum of a block, cheap to compute at every offset of the new version,
+// and its SHA-256, which tells whether a block of the same rolling checksum is really the same.
+type Block struct {
+	Weak   uint32
+	Strong [sha256.Size]byte
+}
+
+// BlockSize returns the block size for a base of size bytes. It grows with the square root of the
+// size, which balances the size of the signature against the literal data a change costs, and so
+// that the signature never has more than maxBlocks blocks.
+func BlockSize(size int) int {
+	blockSize := int(math.Sqrt(float64(size)))
+	blockSize = (blockSize + blockSizeAlignment - 1) / blockSizeAlignment * blockSizeAlignment
+	blockSize = min(max(blockSize, minBlockSize), maxBlockSize)
+	return max(blockSize, (size+maxBlocks-1)/maxBlocks)
+}
+
+// Sign computes the signature of the size bytes read from base.
+func Sign(base io.Reader, size int, blockSize int) (Signature, error) {
+	if blockSize <= 0 {
+		return Signature{}, errors.New("block size must be positive")
+	}
+	sig := Signature{BlockSize: blockSize, Size: size, Blocks: make([]Block, 0, blockCount(size, blockSize))}
+	buffer := make([]byte, blockSize)
+	for offset := 0; offset < size; offset += blockSize {
+		block := buffer[:min(blockSize, size-offset)]
+		if _, err := io.ReadFull(base, block); err != nil {
+			return Signature{}, err
+		}
+		sig.Blocks = append(sig.Blocks, Block{Weak: weakSum(block), Strong: sha256.Sum256(block)})
+	}
+	return sig, nil
+}
+
+func (sig Signature) blockLength(block int) int {
+	return min(sig.BlockSize, sig.Size-block*sig.BlockSize)
+}
+
+func blockCount(size int, blockSize int) int {
+	return (size + blockSize - 1) / blockSize
+}
+
+// weakSum is the rolling checksum of rsync: the sum of the bytes and the sum of the running sums,
+// both modulo 2^16.
+func weakSum(block []byte) uint32 {
+	var a, b uint32
+	for i, x := range block {
+		a += uint32(x)
+		b += uint32(len(block)-i) * uint32(x)
+	}
+	return a&0xffff | b<<16
+}
+
+// roll moves the window of length bytes that weak is the checksum of by one byte, out leaves it
+// and in enters it.
+func roll(weak uint32, out byte, in byte, length int) uint32 {
+	a := weak&0xffff - uint32(out) + uint32(in)
+	b := weak>>16 - uint32(length)*uint32(out) + a
+	return a&0xffff | b<<16
+}
+
+const (
+	minBlockSize       = 2 * 1024
+	maxBlockSize       = 128 * 1024
+	blockSizeAlignment = 1024
+	// maxBlocks bounds the signature to a few tens of megabytes, which fits into one message.
+	maxBlocks = 1 << 20
+)
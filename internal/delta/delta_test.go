@@ -0,0 +1,161 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+func Test_shouldRebuildNewVersion(t *testing.T) {
+	base := randomBytes(1, 10*1024+100)
+	tests := []struct {
+		name       string
+		newVersion []byte
+		// maxLiteral bounds the literal data the delta may hold.
+		maxLiteral int
+	}{
+		{name: "unchanged", newVersion: base, maxLiteral: 0},
+		{name: "byte changed", newVersion: replaced(base, 5000, []byte{base[5000] + 1}), maxLiteral: 1024},
+		{name: "bytes inserted", newVersion: inserted(base, 3000, []byte("inserted")), maxLiteral: 1024 + 8},
+		{name: "bytes removed", newVersion: append(bytes.Clone(base[:3000]), base[3100:]...), maxLiteral: 2048},
+		{name: "appended", newVersion: append(bytes.Clone(base), []byte("appended")...), maxLiteral: 100 + 8},
+		{name: "truncated", newVersion: base[:4096], maxLiteral: 0},
+		{name: "blocks swapped", newVersion: append(bytes.Clone(base[2048:4096]), base[:2048]...), maxLiteral: 0},
+		{name: "unrelated", newVersion: randomBytes(2, 5000), maxLiteral: 5000},
+		{name: "empty", newVersion: nil, maxLiteral: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := Sign(bytes.NewReader(base), len(base), 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ops, err := Diff(bytes.NewReader(tt.newVersion), sig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := io.ReadAll(NewReader(ops, bytes.NewReader(tt.newVersion)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rebuilt bytes.Buffer
+			written, err := Apply(&rebuilt, bytes.NewReader(base), sig.BlockSize, bytes.NewReader(encoded), int64(len(tt.newVersion)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(rebuilt.Bytes(), tt.newVersion) || written != int64(len(tt.newVersion)) {
+				t.Fatalf("rebuilt %d bytes that differ from the %d of the new version", written, len(tt.newVersion))
+			}
+			if len(encoded) != EncodedSize(ops) {
+				t.Errorf("encoded %d bytes, EncodedSize returned %d", len(encoded), EncodedSize(ops))
+			}
+			if literal := literalSize(ops); literal > tt.maxLiteral {
+				t.Errorf("sent %d literal bytes want at most %d", literal, tt.maxLiteral)
+			}
+		})
+	}
+}
+
+func Test_shouldMergeCopiesOfConsecutiveBlocks(t *testing.T) {
+	base := randomBytes(3, 8*1024)
+	sig, err := Sign(bytes.NewReader(base), len(base), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := Diff(bytes.NewReader(base), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops) != 1 || ops[0] != (Op{Block: 0, Count: 8}) {
+		t.Errorf("got %+v want one copy of all 8 blocks", ops)
+	}
+}
+
+func Test_shouldRejectInvalidDelta(t *testing.T) {
+	base := bytes.NewReader(randomBytes(4, 2500))
+	tests := []struct {
+		name  string
+		delta []byte
+		want  error
+	}{
+		{name: "unknown instruction", delta: []byte{'x'}, want: ErrMalformed},
+		{name: "truncated literal", delta: []byte{literalTag, 10, 'a'}, want: ErrMalformed},
+		{name: "truncated copy", delta: []byte{copyTag, 1}, want: ErrMalformed},
+		{name: "copy of no blocks", delta: []byte{copyTag, 0, 0}, want: ErrMalformed},
+		{name: "block past the end", delta: []byte{copyTag, 3, 1}, want: ErrUnknownBlock},
+		{name: "blocks past the end", delta: []byte{copyTag, 1, 3}, want: ErrUnknownBlock},
+		{name: "copies past the size", delta: []byte{copyTag, 0, 3, copyTag, 0, 3}, want: ErrTooLong},
+		{name: "literal past the size", delta: []byte{copyTag, 0, 3, literalTag, 0xc0, 0x0c, 'a'}, want: ErrTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Apply(io.Discard, base, 1024, bytes.NewReader(tt.delta), 4096)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_roll(t *testing.T) {
+	data := randomBytes(5, 300)
+	weak := weakSum(data[:100])
+	for i := 1; i+100 <= len(data); i++ {
+		weak = roll(weak, data[i-1], data[i+99], 100)
+		if want := weakSum(data[i : i+100]); weak != want {
+			t.Fatalf("rolled to %d got %x want %x", i, weak, want)
+		}
+	}
+}
+
+func Test_BlockSize(t *testing.T) {
+	tests := []struct {
+		size int
+		want int
+	}{
+		{size: 0, want: minBlockSize},
+		{size: 100 * 1024 * 1024, want: 10 * 1024},
+		{size: 5 << 30, want: 72 * 1024},
+		{size: 1 << 40, want: 1 << 20},
+	}
+	for _, tt := range tests {
+		if got := BlockSize(tt.size); got != tt.want {
+			t.Errorf("BlockSize(%d) got %d want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func literalSize(ops []Op) int {
+	size := 0
+	for _, op := range ops {
+		if !op.copies() {
+			size += op.Length
+		}
+	}
+	return size
+}
+
+func replaced(data []byte, offset int, replacement []byte) []byte {
+	data = bytes.Clone(data)
+	copy(data[offset:], replacement)
+	return data
+}
+
+func inserted(data []byte, offset int, insertion []byte) []byte {
+	return append(append(bytes.Clone(data[:offset]), insertion...), data[offset:]...)
+}
+
+func randomBytes(seed uint64, size int) []byte {
+	random := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(random.Uint32())
+	}
+	return data
+}
@@ -0,0 +1,253 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Op is one instruction of a delta: either copy Count blocks of the base starting at Block, or,
+// if Count is zero, send the Length bytes of the new version at Offset as they are.
+type Op struct {
+	Block  int
+	Count  int
+	Offset int
+	Length int
+}
+
+func (op Op) copies() bool {
+	return op.Count > 0
+}
+
+// Diff reads the new version from reader and returns the instructions that rebuild it out of the
+// base sig describes. Literal data is referred to by its offset, NewReader reads it again.
+func Diff(reader io.Reader, sig Signature) ([]Op, error) {
+	if sig.BlockSize <= 0 {
+		return nil, errors.New("block size must be positive")
+	}
+	d := differ{
+		sig:    sig,
+		index:  make(map[uint32][]int),
+		last:   -1,
+		reader: reader,
+		buffer: make([]byte, 0, max(4*sig.BlockSize, minBufferSize)),
+	}
+	for block, b := range sig.Blocks {
+		if sig.blockLength(block) == sig.BlockSize {
+			d.index[b.Weak] = append(d.index[b.Weak], block)
+		} else {
+			d.last = block
+		}
+	}
+	if err := d.run(); err != nil {
+		return nil, err
+	}
+	return d.ops, nil
+}
+
+type differ struct {
+	sig   Signature
+	index map[uint32][]int
+	// last is the final block if it is shorter than the others, -1 otherwise. It can only be found
+	// at the end of the new version.
+	last   int
+	reader io.Reader
+	buffer []byte
+	// start is the offset of the first byte of buffer in the new version, pos that of the window
+	// within buffer and literal the offset of the first byte not covered by ops yet.
+	start   int
+	pos     int
+	literal int
+	eof     bool
+	ops     []Op
+}
+
+func (d *differ) run() error {
+	blockSize := d.sig.BlockSize
+	var weak uint32
+	rolling := false
+	for {
+		// One byte past the window is needed to roll it forward.
+		if err := d.fill(blockSize + 1); err != nil {
+			return err
+		}
+		available := len(d.buffer) - d.pos
+		if available < blockSize {
+			break
+		}
+		window := d.buffer[d.pos : d.pos+blockSize]
+		if !rolling {
+			weak = weakSum(window)
+			rolling = true
+		}
+		if block, ok := d.match(weak, window, d.index[weak]); ok {
+			d.copyBlock(block, blockSize)
+			rolling = false
+			continue
+		}
+		if available == blockSize {
+			break
+		}
+		weak = roll(weak, d.buffer[d.pos], d.buffer[d.pos+blockSize], blockSize)
+		d.pos++
+	}
+
+	end := d.start + len(d.buffer)
+	if d.last >= 0 {
+		length := d.sig.blockLength(d.last)
+		if tail := len(d.buffer) - length; tail >= d.pos {
+			window := d.buffer[tail:]
+			if block, ok := d.match(weakSum(window), window, []int{d.last}); ok {
+				d.pos = tail
+				d.copyBlock(block, length)
+			}
+		}
+	}
+	d.addLiteral(end)
+	return nil
+}
+
+// fill reads until buffer holds need bytes from the window on or the new version ended.
+func (d *differ) fill(need int) error {
+	for !d.eof && len(d.buffer)-d.pos < need {
+		if len(d.buffer) == cap(d.buffer) {
+			n := copy(d.buffer, d.buffer[d.pos:])
+			d.buffer = d.buffer[:n]
+			d.start += d.pos
+			d.pos = 0
+		}
+		n, err := d.reader.Read(d.buffer[len(d.buffer):cap(d.buffer)])
+		d.buffer = d.buffer[:len(d.buffer)+n]
+		if errors.Is(err, io.EOF) {
+			d.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match returns the block among candidates that window holds, preferring the one following the
+// block copied last so that the copies merge into one instruction.
+func (d *differ) match(weak uint32, window []byte, candidates []int) (int, bool) {
+	if len(candidates) == 0 {
+		return 0, false
+	}
+	strong := sha256.Sum256(window)
+	found, ok := 0, false
+	for _, block := range candidates {
+		if d.sig.Blocks[block].Weak != weak || d.sig.Blocks[block].Strong != strong {
+			continue
+		}
+		if n := len(d.ops); n > 0 && d.ops[n-1].copies() && d.ops[n-1].Block+d.ops[n-1].Count == block {
+			return block, true
+		}
+		if !ok {
+			found, ok = block, true
+		}
+	}
+	return found, ok
+}
+
+func (d *differ) copyBlock(block int, length int) {
+	d.addLiteral(d.start + d.pos)
+	if n := len(d.ops); n > 0 && d.ops[n-1].copies() && d.ops[n-1].Block+d.ops[n-1].Count == block {
+		d.ops[n-1].Count++
+	} else {
+		d.ops = append(d.ops, Op{Block: block, Count: 1})
+	}
+	d.pos += length
+	d.literal = d.start + d.pos
+}
+
+func (d *differ) addLiteral(end int) {
+	if end > d.literal {
+		d.ops = append(d.ops, Op{Offset: d.literal, Length: end - d.literal})
+	}
+	d.literal = end
+}
+
+// EncodedSize returns the number of bytes NewReader encodes ops into.
+func EncodedSize(ops []Op) int {
+	size := 0
+	for _, op := range ops {
+		size += len(op.header())
+		if !op.copies() {
+			size += op.Length
+		}
+	}
+	return size
+}
+
+// NewReader encodes ops, reading literal data from source, which must still hold the new version
+// Diff read.
+func NewReader(ops []Op, source io.ReaderAt) io.Reader {
+	return &encoder{ops: ops, source: source}
+}
+
+type encoder struct {
+	ops     []Op
+	source  io.ReaderAt
+	current io.Reader
+}
+
+func (e *encoder) Read(p []byte) (int, error) {
+	for {
+		if e.current != nil {
+			n, err := e.current.Read(p)
+			if errors.Is(err, io.EOF) {
+				e.current = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		if len(e.ops) == 0 {
+			return 0, io.EOF
+		}
+		op := e.ops[0]
+		e.ops = e.ops[1:]
+		e.current = bytes.NewReader(op.header())
+		if !op.copies() {
+			e.current = io.MultiReader(e.current, newExactReader(io.NewSectionReader(e.source, int64(op.Offset), int64(op.Length)), op.Length))
+		}
+	}
+}
+
+// header encodes the instruction, without the literal data that follows it.
+func (op Op) header() []byte {
+	if op.copies() {
+		return binary.AppendUvarint(binary.AppendUvarint([]byte{copyTag}, uint64(op.Block)), uint64(op.Count))
+	}
+	return binary.AppendUvarint([]byte{literalTag}, uint64(op.Length))
+}
+
+// exactReader fails if its reader ends early, as a source that shrank since Diff read it does.
+type exactReader struct {
+	reader    io.Reader
+	remaining int
+}
+
+func newExactReader(reader io.Reader, size int) *exactReader {
+	return &exactReader{reader: reader, remaining: size}
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= n
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+const (
+	copyTag    = 'c'
+	literalTag = 'l'
+	// minBufferSize keeps reads of the new version large when blocks are small.
+	minBufferSize = 256 * 1024
+)
@@ -0,0 +1,121 @@
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrMalformed is returned by Apply for a delta that is not a sequence of instructions.
+	ErrMalformed = errors.New("malformed delta")
+	// ErrUnknownBlock is returned by Apply for a delta that refers to blocks past the end of the
+	// base, as one made for another version of it may.
+	ErrUnknownBlock = errors.New("delta refers to blocks the base does not have")
+	// ErrTooLong is returned by Apply for a delta that rebuilds more than the expected size.
+	ErrTooLong = errors.New("delta rebuilds more than the expected size")
+)
+
+// Apply writes the new version the delta read from reader rebuilds out of base, whose blocks are
+// blockSize bytes long, and returns its size. It stops before writing more than size bytes.
+func Apply(writer io.Writer, base io.ReadSeeker, blockSize int, reader io.Reader, size int64) (int64, error) {
+	if blockSize <= 0 {
+		return 0, fmt.Errorf("%w: block size must be positive", ErrMalformed)
+	}
+	baseSize, err := base.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	blocks := uint64(baseSize / int64(blockSize))
+	if baseSize%int64(blockSize) != 0 {
+		blocks++
+	}
+
+	delta := bufio.NewReader(reader)
+	var written int64
+	for {
+		tag, err := delta.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+
+		var n int64
+		switch tag {
+		case copyTag:
+			block, count, err := readCopy(delta)
+			if err != nil {
+				return written, err
+			}
+			if block >= blocks || count > blocks-block {
+				return written, fmt.Errorf("%w: %d blocks at %d of %d", ErrUnknownBlock, count, block, blocks)
+			}
+			offset := int64(block) * int64(blockSize)
+			length := min(int64(count)*int64(blockSize), baseSize-offset)
+			if length > size-written {
+				return written, tooLong(written+length, size)
+			}
+			n, err = copyBlocks(writer, base, offset, length)
+			if err != nil {
+				return written + n, err
+			}
+		case literalTag:
+			length, err := binary.ReadUvarint(delta)
+			if err != nil {
+				return written, malformed(err)
+			}
+			if length > uint64(size-written) {
+				return written, tooLong(int64(min(length, 1<<62))+written, size)
+			}
+			if n, err = io.CopyN(writer, delta, int64(min(length, 1<<62))); err != nil {
+				return written + n, malformed(err)
+			}
+		default:
+			return written, fmt.Errorf("%w: unknown instruction %q", ErrMalformed, tag)
+		}
+		written += n
+	}
+}
+
+func readCopy(delta *bufio.Reader) (uint64, uint64, error) {
+	block, err := binary.ReadUvarint(delta)
+	if err != nil {
+		return 0, 0, malformed(err)
+	}
+	count, err := binary.ReadUvarint(delta)
+	if err != nil {
+		return 0, 0, malformed(err)
+	}
+	if count == 0 {
+		return 0, 0, fmt.Errorf("%w: copy of no blocks", ErrMalformed)
+	}
+	return block, count, nil
+}
+
+// copyBlocks copies length bytes of base at offset.
+func copyBlocks(writer io.Writer, base io.ReadSeeker, offset int64, length int64) (int64, error) {
+	if _, err := base.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(writer, base, length)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func tooLong(length int64, size int64) error {
+	return fmt.Errorf("%w: at least %d bytes for a file of %d bytes", ErrTooLong, length, size)
+}
+
+// malformed reports a delta that ended within an instruction.
+func malformed(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %w", ErrMalformed, io.ErrUnexpectedEOF)
+	}
+	return err
+}
@@ -3,8 +3,40 @@ package envs
 import "os"
 
 var (
-	ClientStoragePath = clientStoragePath()
-	ServerStoragePath = serverStoragePath()
+	ClientStoragePath          = clientStoragePath()
+	ClientServerAddr           = clientServerAddr()
+	ServerStoragePath          = serverStoragePath()
+	ServerCompressionPolicy    = serverCompressionPolicy()
+	ServerMasterKeyPath        = serverMasterKeyPath()
+	ServerHTTPAddr             = serverHTTPAddr()
+	ServerGRPCAddr             = serverGRPCAddr()
+	ServerWebDAVAddr           = serverWebDAVAddr()
+	ServerS3Addr               = serverS3Addr()
+	ServerS3RootBucket         = serverS3RootBucket()
+	ServerUsersPath            = serverUsersPath()
+	ServerSFTPAddr             = serverSFTPAddr()
+	ServerSFTPHostKeyPath      = serverSFTPHostKeyPath()
+	ServerWebSocketAddr        = serverWebSocketAddr()
+	ServerWebSocketOrigins     = serverWebSocketOrigins()
+	ServerMetricsAddr          = serverMetricsAddr()
+	ServerMaxConnections       = serverMaxConnections()
+	ServerMaxConnectionsPerIP  = serverMaxConnectionsPerIP()
+	ServerIdleTimeout          = serverIdleTimeout()
+	ServerIOTimeout            = serverIOTimeout()
+	ServerThrottleGlobal       = serverThrottleGlobal()
+	ServerThrottlePerPrincipal = serverThrottlePerPrincipal()
+	ServerThrottleClasses      = serverThrottleClasses()
+	ServerAdminAddr            = serverAdminAddr()
+	ServerAdminToken           = serverAdminToken()
+	ServerIdempotencyTTL       = serverIdempotencyTTL()
+	ServerUploadTTL            = serverUploadTTL()
+	LogFormat                  = logFormat()
+	LogLevel                   = logLevel()
+	LogPath                    = logPath()
+	LogMaxSize                 = logMaxSize()
+	LogMaxBackups              = logMaxBackups()
+	TraceExporter              = traceExporter()
+	TracePath                  = tracePath()
 )
 
 func serverStoragePath() string {
@@ -14,3 +46,131 @@ func serverStoragePath() string {
 func clientStoragePath() string {
 	return os.Getenv("CLIENT_STORAGE_PATH")
 }
+
+func clientServerAddr() string {
+	return os.Getenv("CLIENT_SERVER_ADDR")
+}
+
+func serverCompressionPolicy() string {
+	return os.Getenv("SERVER_COMPRESSION_POLICY")
+}
+
+func serverMasterKeyPath() string {
+	return os.Getenv("SERVER_MASTER_KEY_PATH")
+}
+
+func serverHTTPAddr() string {
+	return os.Getenv("SERVER_HTTP_ADDR")
+}
+
+func serverGRPCAddr() string {
+	return os.Getenv("SERVER_GRPC_ADDR")
+}
+
+func serverWebDAVAddr() string {
+	return os.Getenv("SERVER_WEBDAV_ADDR")
+}
+
+func serverS3Addr() string {
+	return os.Getenv("SERVER_S3_ADDR")
+}
+
+func serverS3RootBucket() string {
+	return os.Getenv("SERVER_S3_ROOT_BUCKET")
+}
+
+func serverUsersPath() string {
+	return os.Getenv("SERVER_USERS_PATH")
+}
+
+func serverSFTPAddr() string {
+	return os.Getenv("SERVER_SFTP_ADDR")
+}
+
+func serverSFTPHostKeyPath() string {
+	return os.Getenv("SERVER_SFTP_HOST_KEY_PATH")
+}
+
+func serverWebSocketAddr() string {
+	return os.Getenv("SERVER_WEBSOCKET_ADDR")
+}
+
+func serverWebSocketOrigins() string {
+	return os.Getenv("SERVER_WEBSOCKET_ORIGINS")
+}
+
+func serverMetricsAddr() string {
+	return os.Getenv("SERVER_METRICS_ADDR")
+}
+
+func logFormat() string {
+	return os.Getenv("LOG_FORMAT")
+}
+
+func logLevel() string {
+	return os.Getenv("LOG_LEVEL")
+}
+
+func logPath() string {
+	return os.Getenv("LOG_PATH")
+}
+
+func logMaxSize() string {
+	return os.Getenv("LOG_MAX_SIZE")
+}
+
+func logMaxBackups() string {
+	return os.Getenv("LOG_MAX_BACKUPS")
+}
+
+func traceExporter() string {
+	return os.Getenv("TRACE_EXPORTER")
+}
+
+func tracePath() string {
+	return os.Getenv("TRACE_PATH")
+}
+
+func serverMaxConnections() string {
+	return os.Getenv("SERVER_MAX_CONNECTIONS")
+}
+
+func serverMaxConnectionsPerIP() string {
+	return os.Getenv("SERVER_MAX_CONNECTIONS_PER_IP")
+}
+
+func serverIdleTimeout() string {
+	return os.Getenv("SERVER_IDLE_TIMEOUT")
+}
+
+func serverIOTimeout() string {
+	return os.Getenv("SERVER_IO_TIMEOUT")
+}
+
+func serverThrottleGlobal() string {
+	return os.Getenv("SERVER_THROTTLE_GLOBAL")
+}
+
+func serverThrottlePerPrincipal() string {
+	return os.Getenv("SERVER_THROTTLE_PER_PRINCIPAL")
+}
+
+func serverThrottleClasses() string {
+	return os.Getenv("SERVER_THROTTLE_CLASSES")
+}
+
+func serverAdminAddr() string {
+	return os.Getenv("SERVER_ADMIN_ADDR")
+}
+
+func serverAdminToken() string {
+	return os.Getenv("SERVER_ADMIN_TOKEN")
+}
+
+func serverIdempotencyTTL() string {
+	return os.Getenv("SERVER_IDEMPOTENCY_TTL")
+}
+
+func serverUploadTTL() string {
+	return os.Getenv("SERVER_UPLOAD_TTL")
+}
@@ -0,0 +1,420 @@
+package files
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MasterKey wraps the per-file data keys that encrypt file bodies at rest.
+type MasterKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+func LoadMasterKey(path string) (*MasterKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("master key file %s: %w", path, err)
+	}
+	return newMasterKey(key)
+}
+
+// GenerateMasterKey writes a fresh random master key to path, refusing to overwrite an existing file.
+func GenerateMasterKey(path string) (*MasterKey, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+	if err = file.Close(); err != nil {
+		return nil, err
+	}
+	return newMasterKey(key)
+}
+
+func newMasterKey(key []byte) (*MasterKey, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes long, got %d", dataKeySize, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey := &MasterKey{aead: aead}
+	digest := sha256.Sum256(key)
+	copy(masterKey.id[:], digest[:])
+	return masterKey, nil
+}
+
+func (k *MasterKey) ID() string {
+	return hex.EncodeToString(k.id[:])
+}
+
+// Encrypted files extend the stored header with the data key wrapped by the master key:
+//
+//	key id [8] | wrap nonce [12] | wrapped data key [48] | stream nonce prefix [7]
+type encryptionHeader struct {
+	keyID       [keyIDSize]byte
+	wrapNonce   [wrapNonceSize]byte
+	wrappedKey  [wrappedKeySize]byte
+	noncePrefix [noncePrefixSize]byte
+}
+
+func newEncryptionHeader(key *MasterKey) (encryptionHeader, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return encryptionHeader{}, nil, err
+	}
+
+	header := encryptionHeader{}
+	if _, err := rand.Read(header.noncePrefix[:]); err != nil {
+		return encryptionHeader{}, nil, err
+	}
+	if err := header.wrap(key, dataKey); err != nil {
+		return encryptionHeader{}, nil, err
+	}
+	return header, dataKey, nil
+}
+
+func (h *encryptionHeader) wrap(key *MasterKey, dataKey []byte) error {
+	if _, err := rand.Read(h.wrapNonce[:]); err != nil {
+		return err
+	}
+	h.keyID = key.id
+	key.aead.Seal(h.wrappedKey[:0], h.wrapNonce[:], dataKey, key.id[:])
+	return nil
+}
+
+func (h *encryptionHeader) unwrap(key *MasterKey) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("file is encrypted, but no master key is configured")
+	}
+	if h.keyID != key.id {
+		return nil, fmt.Errorf("file is encrypted with master key %x, configured key is %s", h.keyID, key.ID())
+	}
+	return key.aead.Open(nil, h.wrapNonce[:], h.wrappedKey[:], key.id[:])
+}
+
+func (h *encryptionHeader) encode() []byte {
+	buffer := make([]byte, 0, encryptionHeaderSize)
+	buffer = append(buffer, h.keyID[:]...)
+	buffer = append(buffer, h.wrapNonce[:]...)
+	buffer = append(buffer, h.wrappedKey[:]...)
+	return append(buffer, h.noncePrefix[:]...)
+}
+
+func decodeEncryptionHeader(buffer []byte) encryptionHeader {
+	header := encryptionHeader{}
+	buffer = buffer[copy(header.keyID[:], buffer):]
+	buffer = buffer[copy(header.wrapNonce[:], buffer):]
+	buffer = buffer[copy(header.wrappedKey[:], buffer):]
+	copy(header.noncePrefix[:], buffer)
+	return header
+}
+
+func readEncryptionHeader(reader io.Reader) (encryptionHeader, error) {
+	buffer := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(reader, buffer); err != nil {
+		return encryptionHeader{}, err
+	}
+	return decodeEncryptionHeader(buffer), nil
+}
+
+// Bodies are sealed in chunks of chunkSize plaintext bytes. Each nonce is the prefix from the
+// header, the chunk counter and a flag marking the final chunk, so reordered, dropped or
+// truncated chunks fail authentication. Every chunk is bound to the stored header, see chunkAAD.
+type chunkWriter struct {
+	writer  io.Writer
+	aead    cipher.AEAD
+	nonce   chunkNonce
+	buffer  []byte
+	sealed  []byte
+	counter uint32
+	// header must hold the logical size by the time the writer is closed.
+	header storedHeader
+}
+
+func newChunkWriter(
+	writer io.Writer,
+	dataKey []byte,
+	noncePrefix [noncePrefixSize]byte,
+	header storedHeader,
+) (*chunkWriter, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkWriter{
+		writer: writer,
+		aead:   aead,
+		nonce:  chunkNonce{prefix: noncePrefix},
+		buffer: make([]byte, 0, chunkSize),
+		sealed: make([]byte, 0, chunkSize+tagSize),
+		header: header,
+	}, nil
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(w.buffer) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buffer[len(w.buffer):chunkSize], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *chunkWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *chunkWriter) seal(final bool) error {
+	if w.counter == maxChunks {
+		return errors.New("file too large to encrypt")
+	}
+	w.sealed = w.aead.Seal(w.sealed[:0], w.nonce.build(w.counter, final), w.buffer, chunkAAD(w.header, final))
+	w.buffer = w.buffer[:0]
+	w.counter++
+	_, err := w.writer.Write(w.sealed)
+	return err
+}
+
+type chunkReader struct {
+	reader    io.Reader
+	aead      cipher.AEAD
+	nonce     chunkNonce
+	remaining int64
+	sealed    []byte
+	plain     []byte
+	counter   uint32
+	header    storedHeader
+}
+
+func newChunkReader(
+	reader io.Reader,
+	sealedSize int64,
+	dataKey []byte,
+	noncePrefix [noncePrefixSize]byte,
+	header storedHeader,
+) (*chunkReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{
+		reader:    reader,
+		aead:      aead,
+		nonce:     chunkNonce{prefix: noncePrefix},
+		remaining: sealedSize,
+		sealed:    make([]byte, chunkSize+tagSize),
+		header:    header,
+	}, nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.remaining == 0 {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *chunkReader) open() error {
+	sealed := r.sealed[:min(int64(len(r.sealed)), r.remaining)]
+	if _, err := io.ReadFull(r.reader, sealed); err != nil {
+		return err
+	}
+	r.remaining -= int64(len(sealed))
+
+	final := r.remaining == 0
+	plain, err := r.aead.Open(sealed[:0], r.nonce.build(r.counter, final), sealed, chunkAAD(r.header, final))
+	if err != nil {
+		return fmt.Errorf("chunk %d failed authentication: %w", r.counter, err)
+	}
+	r.plain = plain
+	r.counter++
+	return nil
+}
+
+type chunkNonce struct {
+	prefix [noncePrefixSize]byte
+	nonce  [gcmNonceSize]byte
+}
+
+func (n *chunkNonce) build(counter uint32, final bool) []byte {
+	copy(n.nonce[:], n.prefix[:])
+	binary.BigEndian.PutUint32(n.nonce[noncePrefixSize:], counter)
+	n.nonce[gcmNonceSize-1] = 0
+	if final {
+		n.nonce[gcmNonceSize-1] = 1
+	}
+	return n.nonce[:]
+}
+
+// verifyFinalChunk authenticates the final of the sealedSize bytes of chunks at offset in file.
+// Only the final chunk binds the logical size, which is checked so before any content is read, be
+// it only the first part of it.
+func verifyFinalChunk(
+	file io.ReaderAt,
+	offset int64,
+	sealedSize int64,
+	dataKey []byte,
+	noncePrefix [noncePrefixSize]byte,
+	header storedHeader,
+) error {
+	if sealedSize < tagSize {
+		return errors.New("encrypted file has no final chunk")
+	}
+	chunks := (sealedSize + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	finalOffset := (chunks - 1) * (chunkSize + tagSize)
+	reader, err := newChunkReader(io.NewSectionReader(file, offset+finalOffset, sealedSize-finalOffset),
+		sealedSize-finalOffset, dataKey, noncePrefix, header)
+	if err != nil {
+		return err
+	}
+	reader.counter = uint32(chunks - 1)
+	return reader.open()
+}
+
+// chunkAAD returns the additional data a chunk is authenticated with: the stored header, so that
+// changing the encoding, flags or logical size on disk fails authentication. The logical size is
+// only known once the final chunk is sealed, the other chunks are bound to a size of 0. The wrapped
+// data key is left out, as rotating the master key rewrites it. Files of versions before
+// authenticatedVersion were sealed without additional data.
+func chunkAAD(header storedHeader, final bool) []byte {
+	if header.version < authenticatedVersion {
+		return nil
+	}
+	if !final {
+		header.size = 0
+	}
+	return encodeStoredHeader(header)
+}
+
+// plainSizeOf returns the length of the plaintext sealed into sealedSize bytes of chunks.
+func plainSizeOf(sealedSize int64) int64 {
+	chunks := (sealedSize + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	return sealedSize - chunks*tagSize
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// RotateMasterKey rewraps the data key of every encrypted file under root from oldKey to newKey:
+// the files of the root and of every namespace, and the pieces of staged uploads. File bodies are
+// left untouched, only the wrapped key in each header is rewritten.
+func RotateMasterKey(root string, oldKey *MasterKey, newKey *MasterKey) (int, error) {
+	stagingRoot := filepath.Join(root, stagingDir)
+	rotated := 0
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		// Pieces starting with a dot are left behind by interrupted stores, nothing reads them again.
+		if strings.HasPrefix(path, stagingRoot+string(filepath.Separator)) && strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		ok, err := rotateFileKey(path, oldKey, newKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if ok {
+			rotated++
+		}
+		return nil
+	})
+	return rotated, err
+}
+
+func rotateFileKey(path string, oldKey *MasterKey, newKey *MasterKey) (bool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer fsutil.LoggedClose(file)
+
+	stored, err := readStoredFile(file)
+	if err != nil {
+		return false, err
+	}
+	if !stored.encrypted() {
+		return false, nil
+	}
+
+	encryption := stored.encryption
+	if encryption.keyID == newKey.id {
+		return false, nil
+	}
+	dataKey, err := encryption.unwrap(oldKey)
+	if err != nil {
+		return false, err
+	}
+	if err = encryption.wrap(newKey, dataKey); err != nil {
+		return false, err
+	}
+
+	if _, err = file.WriteAt(encryption.encode(), storedHeaderSize); err != nil {
+		return false, err
+	}
+	return true, file.Sync()
+}
+
+const (
+	dataKeySize          = 32
+	keyIDSize            = 8
+	gcmNonceSize         = 12
+	wrapNonceSize        = gcmNonceSize
+	tagSize              = 16
+	wrappedKeySize       = dataKeySize + tagSize
+	noncePrefixSize      = gcmNonceSize - 5
+	encryptionHeaderSize = keyIDSize + wrapNonceSize + wrappedKeySize + noncePrefixSize
+
+	chunkSize = 64 * 1024
+	maxChunks = ^uint32(0)
+
+	flagEncrypted = 1 << 0
+)
@@ -0,0 +1,229 @@
+package files
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_should_SealAndOpenChunks_AtBoundaries(t *testing.T) {
+	dataKey := make([]byte, dataKeySize)
+	var noncePrefix [noncePrefixSize]byte
+	header := storedHeader{version: storedVersion, encoding: codec.Identity, flags: flagEncrypted}
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		payload := make([]byte, size)
+		if _, err := rand.Read(payload); err != nil {
+			t.Fatal(err)
+		}
+
+		var sealed bytes.Buffer
+		writer, err := newChunkWriter(&sealed, dataKey, noncePrefix, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+		writer.header.size = int64(size)
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := plainSizeOf(int64(sealed.Len())); got != int64(size) {
+			t.Fatalf("size %d: got plain size %d from %d sealed bytes", size, got, sealed.Len())
+		}
+
+		header.size = int64(size)
+		reader, err := newChunkReader(&sealed, int64(sealed.Len()), dataKey, noncePrefix, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, payload) {
+			t.Fatalf("size %d: opened payload differs from the original", size)
+		}
+	}
+}
+
+func Test_should_RejectTruncatedEncryptedFile(t *testing.T) {
+	masterKey := newTestMasterKey(t)
+	path := filepath.Join(t.TempDir(), "truncated")
+	writeStoredFile(t, path, bytes.Repeat([]byte{'x'}, 2*chunkSize), masterKey)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(path, stat.Size()-chunkSize-tagSize); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := openStoredFile(path, masterKey)
+	if err == nil {
+		defer fsutil.LoggedClose(stored)
+		_, err = io.ReadAll(stored)
+	}
+	if err == nil {
+		t.Fatal("expected truncated file to fail authentication")
+	}
+}
+
+func Test_should_RejectEncryptedFile_With_TamperedHeader(t *testing.T) {
+	masterKey := newTestMasterKey(t)
+	payload := bytes.Repeat([]byte{'x'}, 2*chunkSize+10)
+	tests := []struct {
+		name   string
+		offset int64
+		value  byte
+	}{
+		{name: "encryption flag cleared", offset: flagsOffset, value: 0},
+		{name: "encoding changed", offset: encodingOffset, value: byte(codec.Gzip)},
+		{name: "logical size shortened", offset: sizeOffset + uint64ByteSize - 1, value: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tampered")
+			writeStoredFile(t, path, payload, masterKey)
+			file, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = file.WriteAt([]byte{tt.value}, tt.offset); err != nil {
+				t.Fatal(err)
+			}
+			fsutil.LoggedClose(file)
+
+			stored, err := openStoredFile(path, masterKey)
+			if err == nil {
+				defer fsutil.LoggedClose(stored)
+				_, err = io.ReadAll(stored)
+			}
+			if err == nil {
+				t.Fatal("expected the tampered header to be rejected")
+			}
+		})
+	}
+}
+
+func Test_should_RotateMasterKey(t *testing.T) {
+	oldKey := newTestMasterKey(t)
+	newKey := newTestMasterKey(t)
+	root := t.TempDir()
+	payload := bytes.Repeat([]byte("rotate me "), 1024)
+
+	writeStoredFile(t, filepath.Join(root, "encrypted"), payload, oldKey)
+	if err := os.WriteFile(filepath.Join(root, "plain"), payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := RotateMasterKey(root, oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 1 {
+		t.Fatalf("got %d rotated files, want 1", rotated)
+	}
+
+	if _, err = openStoredFile(filepath.Join(root, "encrypted"), oldKey); err == nil {
+		t.Fatal("expected the old key to be rejected after rotation")
+	}
+	stored, err := openStoredFile(filepath.Join(root, "encrypted"), newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsutil.LoggedClose(stored)
+
+	out, err := io.ReadAll(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, payload) {
+		t.Fatal("rotated payload differs from the original")
+	}
+}
+
+func Test_should_RotateMasterKey_Of_NamespacesAndStagedPieces(t *testing.T) {
+	oldKey := newTestMasterKey(t)
+	newKey := newTestMasterKey(t)
+	service := newTestSyncService(t, oldKey)
+	payload := []byte("rotate me too")
+
+	if err := os.Mkdir(service.buildFilePath("bucket"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeStoredFile(t, service.buildFilePath("bucket/encrypted"), payload, oldKey)
+	staging := service.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = staging.Store(uploadID, "part-1", func(writer io.Writer) error {
+		_, err := writer.Write(payload)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := RotateMasterKey(service.root, oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 2 {
+		t.Fatalf("got %d rotated files, want 2", rotated)
+	}
+
+	rotatedService := newTestSyncServiceAt(t, service.root, newKey)
+	namespace, err := rotatedService.Namespace("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := openStoredFile(namespace.buildFilePath("encrypted"), newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(stored)
+	fsutil.LoggedClose(stored)
+	if err != nil || !bytes.Equal(out, payload) {
+		t.Fatalf("got %q, %v want %q from the namespace", out, err, payload)
+	}
+	piece, err := rotatedService.Staging().Open(uploadID, "part-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = io.ReadAll(piece)
+	fsutil.LoggedClose(piece)
+	if err != nil || !bytes.Equal(out, payload) {
+		t.Fatalf("got %q, %v want %q from the staged piece", out, err, payload)
+	}
+}
+
+func newTestMasterKey(t *testing.T) *MasterKey {
+	masterKey, err := GenerateMasterKey(filepath.Join(t.TempDir(), "master.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return masterKey
+}
+
+func writeStoredFile(t *testing.T, path string, payload []byte, masterKey *MasterKey) {
+	writer, err := createStoredFile(path, codec.Identity, masterKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,47 +1,26 @@
 package files
 
 import (
-	"github.com/mat-sik/file-server-go/internal/envs"
-	"io"
-	"log/slog"
 	"os"
-	"path/filepath"
+	"strings"
 )
 
-func BuildClientFilePath(filename string) string {
-	return filepath.Join(envs.ClientStoragePath, filename)
-}
-
-func buildServerFilePath(filename string) string {
-	return filepath.Join(envs.ServerStoragePath, filename)
-}
-
-func getServerStoredFilenames() []string {
-	return getAllFilenames(envs.ServerStoragePath)
-}
-
-func getAllFilenames(path string) []string {
+func getAllFilenames(path string) ([]string, error) {
 	entries, err := os.ReadDir(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	filenames := make([]string, len(entries))
+	filenames := make([]string, 0, len(entries))
 	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
 		filenames = append(filenames, entry.Name())
 	}
-	return filenames
+	return filenames, nil
 }
 
-func SizeOf(f *os.File) (int, error) {
-	stat, err := f.Stat()
-	if err != nil {
-		return 0, err
-	}
-	return int(stat.Size()), nil
-}
-
-func LoggedClose(f io.Closer) {
-	if err := f.Close(); err != nil {
-		slog.Error(err.Error())
-	}
+// ValidFilename reports whether filename names a file directly inside the storage directory.
+func ValidFilename(filename string) bool {
+	return filename != "" && filename != "." && filename != ".." && !strings.ContainsAny(filename, "/\\\x00")
 }
@@ -0,0 +1,427 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"os"
+)
+
+// Files start with a fixed header once compression or encryption is enabled, and so does content
+// that starts like one. Everything else is kept on disk byte for byte as it was uploaded:
+//
+//	magic [8] | version [1] | encoding [1] | flags [1] | logical size [8] | [encryption header] | body
+//
+// The body is the encoded content, sealed in chunks when the file is encrypted. Since version 2
+// the chunks are bound to the header.
+type storedHeader struct {
+	version  byte
+	encoding codec.Encoding
+	flags    byte
+	size     int64
+}
+
+func encodeStoredHeader(header storedHeader) []byte {
+	buffer := make([]byte, storedHeaderSize)
+	copy(buffer, storedMagic)
+	buffer[versionOffset] = header.version
+	buffer[encodingOffset] = byte(header.encoding)
+	buffer[flagsOffset] = header.flags
+	binary.BigEndian.PutUint64(buffer[sizeOffset:], uint64(header.size))
+	return buffer
+}
+
+func decodeStoredHeader(buffer []byte) (storedHeader, error) {
+	if buffer[versionOffset] == 0 || buffer[versionOffset] > storedVersion {
+		return storedHeader{}, fmt.Errorf("unsupported stored file version %d", buffer[versionOffset])
+	}
+	header := storedHeader{
+		version:  buffer[versionOffset],
+		encoding: codec.Encoding(buffer[encodingOffset]),
+		flags:    buffer[flagsOffset],
+		size:     int64(binary.BigEndian.Uint64(buffer[sizeOffset:])),
+	}
+	if !header.encoding.Supported() {
+		return storedHeader{}, fmt.Errorf("unsupported stored file encoding %v", header.encoding)
+	}
+	if header.flags&^flagEncrypted != 0 {
+		return storedHeader{}, fmt.Errorf("unsupported stored file flags %#x", header.flags)
+	}
+	return header, nil
+}
+
+type storedFile struct {
+	file       *os.File
+	header     storedHeader
+	encryption encryptionHeader
+	dataKey    []byte
+	bodyOffset int64
+	sealedSize int64
+	bodySize   int64
+	body       io.Reader
+	decoder    io.ReadCloser
+	position   int64
+	target     int64
+}
+
+func openStoredFile(path string, key *MasterKey) (*storedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := readStoredFile(file)
+	if err == nil {
+		err = stored.unseal(key)
+	}
+	if err != nil {
+		fsutil.LoggedClose(file)
+		return nil, err
+	}
+	return stored, nil
+}
+
+// readStoredFile parses the headers of file and leaves it positioned at the start of the body.
+func readStoredFile(file *os.File) (*storedFile, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, storedHeaderSize)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	if n < storedHeaderSize || !bytes.Equal(buffer[:len(storedMagic)], storedMagic) {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return &storedFile{
+			file:       file,
+			header:     storedHeader{encoding: codec.Identity, size: stat.Size()},
+			sealedSize: stat.Size(),
+			bodySize:   stat.Size(),
+			body:       file,
+		}, nil
+	}
+
+	header, err := decodeStoredHeader(buffer)
+	if err != nil {
+		return nil, err
+	}
+	stored := &storedFile{
+		file:       file,
+		header:     header,
+		bodyOffset: storedHeaderSize,
+		body:       file,
+	}
+	if stored.encrypted() {
+		if stored.encryption, err = readEncryptionHeader(file); err != nil {
+			return nil, err
+		}
+		stored.bodyOffset += encryptionHeaderSize
+	}
+	stored.sealedSize = stat.Size() - stored.bodyOffset
+	stored.bodySize = stored.sealedSize
+	// The body of such a file is the content, one whose encryption flag was cleared is not.
+	if header.encoding == codec.Identity && !stored.encrypted() && stored.bodySize != header.size {
+		return nil, fmt.Errorf("stored file has %d bytes where its header declares %d", stored.bodySize, header.size)
+	}
+	return stored, nil
+}
+
+func (f *storedFile) encrypted() bool {
+	return f.header.flags&flagEncrypted != 0
+}
+
+func (f *storedFile) unseal(key *MasterKey) error {
+	if !f.encrypted() {
+		return nil
+	}
+	dataKey, err := f.encryption.unwrap(key)
+	if err != nil {
+		return err
+	}
+	f.dataKey = dataKey
+	f.bodySize = plainSizeOf(f.sealedSize)
+	if f.header.version >= authenticatedVersion {
+		err = verifyFinalChunk(f.file, f.bodyOffset, f.sealedSize, f.dataKey, f.encryption.noncePrefix, f.header)
+		if err != nil {
+			return err
+		}
+	}
+	return f.openBody()
+}
+
+func (f *storedFile) openBody() error {
+	if !f.encrypted() {
+		f.body = f.file
+		return nil
+	}
+	reader, err := newChunkReader(f.file, f.sealedSize, f.dataKey, f.encryption.noncePrefix, f.header)
+	if err != nil {
+		return err
+	}
+	f.body = reader
+	return nil
+}
+
+func (f *storedFile) Read(p []byte) (int, error) {
+	if f.target != f.position {
+		if err := f.reposition(); err != nil {
+			return 0, err
+		}
+	}
+	if f.decoder == nil {
+		decoder, err := codec.NewReader(f.header.encoding, f.body)
+		if err != nil {
+			return 0, err
+		}
+		f.decoder = decoder
+	}
+	n, err := f.decoder.Read(p)
+	f.position += int64(n)
+	f.target = f.position
+	return n, err
+}
+
+// Seek only records the requested logical offset, the body is repositioned by the next Read.
+// Plain files seek directly, encrypted ones to the start of the chunk holding the offset. Encoded
+// ones are rewound when needed and skipped forward.
+func (f *storedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.target
+	case io.SeekEnd:
+		offset += f.header.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.target = offset
+	return offset, nil
+}
+
+func (f *storedFile) reposition() error {
+	if f.header.encoding == codec.Identity && !f.encrypted() {
+		if _, err := f.file.Seek(f.bodyOffset+f.target, io.SeekStart); err != nil {
+			return err
+		}
+		f.position = f.target
+		return nil
+	}
+
+	if f.header.encoding == codec.Identity {
+		if f.target < f.position || f.target/chunkSize != f.position/chunkSize {
+			if err := f.seekChunk(f.target / chunkSize); err != nil {
+				return err
+			}
+		}
+	} else if f.target < f.position {
+		if err := f.rewind(); err != nil {
+			return err
+		}
+	}
+	target := f.target
+	f.target = f.position
+	_, err := io.CopyN(io.Discard, f, target-f.position)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (f *storedFile) rewind() error {
+	if _, err := f.file.Seek(f.bodyOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if f.decoder != nil {
+		fsutil.LoggedClose(f.decoder)
+		f.decoder = nil
+	}
+	f.position = 0
+	return f.openBody()
+}
+
+// seekChunk positions the body of an encrypted file at the start of the chunk with the index
+// chunk, or at its end if there is no such chunk.
+func (f *storedFile) seekChunk(chunk int64) error {
+	sealedOffset := min(chunk*(chunkSize+tagSize), f.sealedSize)
+	if _, err := f.file.Seek(f.bodyOffset+sealedOffset, io.SeekStart); err != nil {
+		return err
+	}
+	reader, err := newChunkReader(f.file, f.sealedSize-sealedOffset, f.dataKey, f.encryption.noncePrefix, f.header)
+	if err != nil {
+		return err
+	}
+	reader.counter = uint32(sealedOffset / (chunkSize + tagSize))
+	if f.decoder != nil {
+		fsutil.LoggedClose(f.decoder)
+		f.decoder = nil
+	}
+	f.body = reader
+	f.position = min(chunk*chunkSize, f.bodySize)
+	return nil
+}
+
+func (f *storedFile) Close() error {
+	if f.decoder != nil {
+		fsutil.LoggedClose(f.decoder)
+	}
+	return f.file.Close()
+}
+
+type storedFileWriter struct {
+	file    *os.File
+	sealer  *chunkWriter
+	encoder io.WriteCloser
+	written int64
+}
+
+// createStoredFile creates the file at path for content stored with encoding, encrypted with key
+// unless it is nil. Framed files get a header also if they are neither encoded nor encrypted.
+func createStoredFile(path string, encoding codec.Encoding, key *MasterKey, framed bool) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == codec.Identity && key == nil && !framed {
+		return &plainWriter{file: file}, nil
+	}
+
+	writer, err := newStoredFileWriter(file, encoding, key)
+	if err != nil {
+		fsutil.LoggedClose(file)
+		return nil, err
+	}
+	return writer, nil
+}
+
+func newStoredFileWriter(file *os.File, encoding codec.Encoding, key *MasterKey) (*storedFileWriter, error) {
+	header := storedHeader{version: storedVersion, encoding: encoding}
+	if key != nil {
+		header.flags |= flagEncrypted
+	}
+	if _, err := file.Write(encodeStoredHeader(header)); err != nil {
+		return nil, err
+	}
+
+	writer := &storedFileWriter{file: file}
+	var body io.Writer = file
+	if key != nil {
+		encryption, dataKey, err := newEncryptionHeader(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = file.Write(encryption.encode()); err != nil {
+			return nil, err
+		}
+		sealer, err := newChunkWriter(file, dataKey, encryption.noncePrefix, header)
+		if err != nil {
+			return nil, err
+		}
+		writer.sealer = sealer
+		body = sealer
+	}
+
+	encoder, err := codec.NewWriter(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+	writer.encoder = encoder
+	return writer, nil
+}
+
+func (w *storedFileWriter) Write(p []byte) (int, error) {
+	n, err := w.encoder.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *storedFileWriter) Close() error {
+	if err := w.encoder.Close(); err != nil {
+		return errors.Join(err, w.file.Close())
+	}
+	if w.sealer != nil {
+		w.sealer.header.size = w.written
+		if err := w.sealer.Close(); err != nil {
+			return errors.Join(err, w.file.Close())
+		}
+	}
+
+	size := make([]byte, uint64ByteSize)
+	binary.BigEndian.PutUint64(size, uint64(w.written))
+	if _, err := w.file.WriteAt(size, sizeOffset); err != nil {
+		return errors.Join(err, w.file.Close())
+	}
+	return w.file.Close()
+}
+
+// plainWriter stores content as it is, unless it starts with storedMagic and would be taken for a
+// stored file when read back. Such content is stored with a header.
+type plainWriter struct {
+	file   *os.File
+	head   []byte
+	writer io.WriteCloser
+}
+
+func (w *plainWriter) Write(p []byte) (int, error) {
+	if w.writer != nil {
+		return w.writer.Write(p)
+	}
+	n := min(len(p), len(storedMagic)-len(w.head))
+	w.head = append(w.head, p[:n]...)
+	if len(w.head) < len(storedMagic) {
+		return n, nil
+	}
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	m, err := w.writer.Write(p[n:])
+	return n + m, err
+}
+
+func (w *plainWriter) Close() error {
+	if w.writer == nil {
+		if err := w.start(); err != nil {
+			return errors.Join(err, w.file.Close())
+		}
+	}
+	return w.writer.Close()
+}
+
+func (w *plainWriter) start() error {
+	w.writer = w.file
+	if bytes.Equal(w.head, storedMagic) {
+		writer, err := newStoredFileWriter(w.file, codec.Identity, nil)
+		if err != nil {
+			return err
+		}
+		w.writer = writer
+	}
+	_, err := w.writer.Write(w.head)
+	return err
+}
+
+var storedMagic = []byte("\x89FSG\r\n\x1a\n")
+
+const (
+	storedVersion = 2
+	// authenticatedVersion is the first version whose encrypted chunks are bound to the header.
+	authenticatedVersion = 2
+	uint64ByteSize       = 8
+
+	versionOffset    = 8
+	encodingOffset   = versionOffset + 1
+	flagsOffset      = encodingOffset + 1
+	sizeOffset       = flagsOffset + 1
+	storedHeaderSize = sizeOffset + uint64ByteSize
+)
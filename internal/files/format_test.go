@@ -0,0 +1,209 @@
+package files
+
+import (
+	"bytes"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_should_StoreAndOpen_WithEncoding(t *testing.T) {
+	payload := bytes.Repeat([]byte("stored at rest "), 8*1024)
+	masterKey := newTestMasterKey(t)
+
+	testCases := []struct {
+		name      string
+		encoding  codec.Encoding
+		masterKey *MasterKey
+	}{
+		{name: "identity", encoding: codec.Identity},
+		{name: "gzip", encoding: codec.Gzip},
+		{name: "zstd", encoding: codec.Zstd},
+		{name: "encrypted identity", encoding: codec.Identity, masterKey: masterKey},
+		{name: "encrypted zstd", encoding: codec.Zstd, masterKey: masterKey},
+	}
+
+	for _, tc := range testCases {
+		encoding := tc.encoding
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stored.txt")
+
+			writer, err := createStoredFile(path, encoding, tc.masterKey, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = writer.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err = writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoding != codec.Identity && stat.Size() >= int64(len(payload)) {
+				t.Fatalf("got %d bytes on disk, want less than %d", stat.Size(), len(payload))
+			}
+
+			stored, err := openStoredFile(path, tc.masterKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fsutil.LoggedClose(stored)
+
+			if stored.header.encoding != encoding || stored.header.size != int64(len(payload)) {
+				t.Fatalf("got header %+v, want %v of %d bytes", stored.header, encoding, len(payload))
+			}
+			out, err := io.ReadAll(stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, payload) {
+				t.Fatalf("stored payload differs from the original")
+			}
+		})
+	}
+}
+
+func Test_should_StoreContent_StartingWithMagic(t *testing.T) {
+	magicContent := append(bytes.Clone(storedMagic), []byte("\x01\x00\x01 looks like a header")...)
+	testCases := []struct {
+		name      string
+		payload   []byte
+		framed    bool
+		hasHeader bool
+	}{
+		{name: "plain", payload: []byte("plain content of some length"), hasHeader: false},
+		{name: "plain starting with magic", payload: magicContent, hasHeader: true},
+		{name: "framed", payload: []byte("plain content of some length"), framed: true, hasHeader: true},
+		{name: "framed starting with magic", payload: magicContent, framed: true, hasHeader: true},
+		{name: "shorter than magic", payload: storedMagic[:3], hasHeader: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stored")
+			writer, err := createStoredFile(path, codec.Identity, nil, tc.framed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Written byte by byte, the header is decided on before the magic is complete.
+			for _, b := range tc.payload {
+				if _, err = writer.Write([]byte{b}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			onDisk, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasHeader := len(onDisk) == storedHeaderSize+len(tc.payload); hasHeader != tc.hasHeader {
+				t.Fatalf("got %d bytes on disk for %d bytes of content, want a header %v", len(onDisk), len(tc.payload), tc.hasHeader)
+			}
+			stored, err := openStoredFile(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fsutil.LoggedClose(stored)
+			out, err := io.ReadAll(stored)
+			if err != nil || !bytes.Equal(out, tc.payload) {
+				t.Fatalf("got %q, %v want %q", out, err, tc.payload)
+			}
+		})
+	}
+}
+
+func Test_should_ParseCompressionPolicy(t *testing.T) {
+	policy, err := ParseCompressionPolicy("*.log=zstd, *.txt=gzip, *=identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		filename string
+		expected codec.Encoding
+	}{
+		{filename: "server.log", expected: codec.Zstd},
+		{filename: "notes.txt", expected: codec.Gzip},
+		{filename: "image.png", expected: codec.Identity},
+	}
+	for _, tc := range testCases {
+		if got := policy.EncodingFor(tc.filename); got != tc.expected {
+			t.Fatalf("%s: got %v want %v", tc.filename, got, tc.expected)
+		}
+	}
+
+	for _, spec := range []string{"*.log", "*.log=brotli", "[=gzip"} {
+		if _, err = ParseCompressionPolicy(spec); err == nil {
+			t.Fatalf("%q: expected an error", spec)
+		}
+	}
+}
+
+func Test_should_Seek_WithinStoredFile(t *testing.T) {
+	payload := make([]byte, 3*chunkSize+123)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	masterKey := newTestMasterKey(t)
+
+	testCases := []struct {
+		name      string
+		encoding  codec.Encoding
+		masterKey *MasterKey
+	}{
+		{name: "plain", encoding: codec.Identity},
+		{name: "zstd", encoding: codec.Zstd},
+		{name: "encrypted", encoding: codec.Identity, masterKey: masterKey},
+		{name: "encrypted gzip", encoding: codec.Gzip, masterKey: masterKey},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "seek")
+			writer, err := createStoredFile(path, tc.encoding, tc.masterKey, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = writer.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err = writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := openStoredFile(path, tc.masterKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fsutil.LoggedClose(stored)
+
+			end, err := stored.Seek(0, io.SeekEnd)
+			if err != nil || end != int64(len(payload)) {
+				t.Fatalf("got end %d (%v), want %d", end, err, len(payload))
+			}
+
+			for _, offset := range []int64{2 * chunkSize, 10, chunkSize + 7, chunkSize - 2, 3 * chunkSize, int64(len(payload)) - 5, 0} {
+				if _, err = stored.Seek(offset, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				out := make([]byte, 5)
+				if _, err = io.ReadFull(stored, out); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out, payload[offset:offset+5]) {
+					t.Fatalf("offset %d: got %v want %v", offset, out, payload[offset:offset+5])
+				}
+			}
+		})
+	}
+}
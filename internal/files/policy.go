@@ -0,0 +1,52 @@
+package files
+
+import (
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"path/filepath"
+	"strings"
+)
+
+// CompressionPolicy decides how files are encoded at rest. Rules are written as
+// comma separated glob=encoding pairs, e.g. "*.log=zstd,*.txt=gzip", and the first rule whose
+// glob matches the filename wins.
+type CompressionPolicy []compressionRule
+
+type compressionRule struct {
+	pattern  string
+	encoding codec.Encoding
+}
+
+func ParseCompressionPolicy(spec string) (CompressionPolicy, error) {
+	var policy CompressionPolicy
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		pattern, encodingName, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("compression rule %q is not in the pattern=encoding form", rule)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("compression rule %q: %w", rule, err)
+		}
+		encoding, err := codec.ParseEncoding(encodingName)
+		if err != nil {
+			return nil, fmt.Errorf("compression rule %q: %w", rule, err)
+		}
+
+		policy = append(policy, compressionRule{pattern: pattern, encoding: encoding})
+	}
+	return policy, nil
+}
+
+func (p CompressionPolicy) EncodingFor(filename string) codec.Encoding {
+	for _, rule := range p {
+		if ok, _ := filepath.Match(rule.pattern, filename); ok {
+			return rule.encoding
+		}
+	}
+	return codec.Identity
+}
@@ -0,0 +1,309 @@
+package files
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Staging keeps the pieces of uploads that are assembled into a file only once they are complete.
+// Every upload is a directory of named pieces, stored encrypted when a master key is configured.
+type Staging struct {
+	root      string
+	masterKey *MasterKey
+	service   *SyncService
+}
+
+// Staging returns the staging area of the storage root, which namespaces share so that one sweep
+// covers every upload. Files replaced through it are those of s.
+func (s *SyncService) Staging() *Staging {
+	return &Staging{
+		root:      s.stagingRoot,
+		masterKey: s.masterKey,
+		service:   s,
+	}
+}
+
+// Create starts a new upload and returns its id.
+func (st *Staging) Create() (string, error) {
+	id := make([]byte, uploadIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	if err := os.MkdirAll(filepath.Join(st.root, uploadID), 0700); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// Store replaces the piece name of the upload with whatever storeOP writes. The piece is replaced
+// only once storeOP succeeded, until then readers see its previous content.
+func (st *Staging) Store(uploadID string, name string, storeOP func(io.Writer) error) error {
+	path, err := st.buildPiecePath(uploadID, name)
+	if err != nil {
+		return err
+	}
+	tempPath, err := tempPiecePath(path)
+	if err != nil {
+		return err
+	}
+	if err = storePiece(tempPath, st.masterKey, st.service.framed(), storeOP); err != nil {
+		if removeErr := os.Remove(tempPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			err = errors.Join(err, removeErr)
+		}
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+func storePiece(path string, masterKey *MasterKey, framed bool, storeOP func(io.Writer) error) error {
+	writer, err := createStoredFile(path, codec.Identity, masterKey, framed)
+	if err != nil {
+		return err
+	}
+	if err = storeOP(writer); err != nil {
+		return errors.Join(err, writer.Close())
+	}
+	return writer.Close()
+}
+
+// tempPiecePath is a path next to the piece at path that no concurrent Store of it writes to. Its
+// name starts with a dot, which no piece name of the users of the staging area does.
+func tempPiecePath(path string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"-"+hex.EncodeToString(suffix)), nil
+}
+
+func (st *Staging) Open(uploadID string, name string) (io.ReadCloser, error) {
+	path, err := st.buildPiecePath(uploadID, name)
+	if err != nil {
+		return nil, err
+	}
+	return openStoredFile(path, st.masterKey)
+}
+
+// Pieces lists the names of the pieces stored for the upload.
+func (st *Staging) Pieces(uploadID string) ([]string, error) {
+	if !validUploadID(uploadID) {
+		return nil, os.ErrNotExist
+	}
+	dir := filepath.Join(st.root, uploadID)
+	// An upload removed while listing it fails with os.ErrNotExist like one removed before.
+	return getAllFilenames(dir)
+}
+
+// RemovePiece removes the piece name of the upload.
+func (st *Staging) RemovePiece(uploadID string, name string) error {
+	path, err := st.buildPiecePath(uploadID, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (st *Staging) Remove(uploadID string) error {
+	if !validUploadID(uploadID) {
+		return os.ErrNotExist
+	}
+	dir := filepath.Join(st.root, uploadID)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// RemoveStale removes the uploads nothing was stored into for longer than maxAge, those their
+// clients abandoned, and returns how many it removed.
+func (st *Staging) RemoveStale(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(st.root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !validUploadID(entry.Name()) {
+			continue
+		}
+		dir := filepath.Join(st.root, entry.Name())
+		modTime, err := lastModified(dir)
+		// Uploads may be completed or aborted while sweeping.
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if modTime.After(cutoff) {
+			continue
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// lastModified is the latest modification time of the upload directory dir and its pieces, which
+// storing a piece keeps recent.
+func lastModified(dir string) (time.Time, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Replace stores whatever storeOP writes as the content of the file filename, which is replaced
+// at once and only if storeOP succeeds. The new content is written into the upload while the file
+// is write locked, so that storeOP may read the pieces of the upload.
+func (st *Staging) Replace(ctx context.Context, uploadID string, filename string, storeOP func(io.Writer) error) error {
+	tempPath, err := st.buildPiecePath(uploadID, replacementPiece)
+	if err != nil {
+		return err
+	}
+
+	path := st.service.buildFilePath(filename)
+	newHandle := st.service.newFileHandle(path, filename)
+	value, loaded := st.service.files.LoadOrStore(path, newHandle)
+	fileHandle := value.(*FileHandle)
+
+	// A handle created here is dropped again if no file came of it. Both are decided under the
+	// write lock, as writers waiting for it may have loaded the handle before it was dropped.
+	return fileHandle.executeWriteOP(ctx, func(filename string) error {
+		err := fileHandle.replace(ctx, tempPath, filename, storeOP)
+		if err == nil {
+			st.service.files.LoadOrStore(path, fileHandle)
+			return nil
+		}
+		if _, statErr := os.Stat(filename); !loaded && errors.Is(statErr, os.ErrNotExist) {
+			st.service.files.CompareAndDelete(path, fileHandle)
+		}
+		return err
+	})
+}
+
+// ReplaceFile is Replace in an upload of its own, for content that arrives in one piece.
+func (s *SyncService) ReplaceFile(ctx context.Context, filename string, storeOP func(io.Writer) error) error {
+	staging := s.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := staging.Remove(uploadID); err != nil {
+			slog.Warn("Removing staged replacement failed", "uploadId", uploadID, "err", err)
+		}
+	}()
+	return staging.Replace(ctx, uploadID, filename, storeOP)
+}
+
+// Patch is Replace for new content derived from the current content of the file, which patchOP
+// reads from base while the file is write locked. It fails with os.ErrNotExist if there is no such
+// file.
+func (st *Staging) Patch(
+	ctx context.Context,
+	uploadID string,
+	filename string,
+	patchOP func(base io.ReadSeeker, writer io.Writer) error,
+) error {
+	tempPath, err := st.buildPiecePath(uploadID, replacementPiece)
+	if err != nil {
+		return err
+	}
+	fileHandle, ok := st.service.GetFile(filename)
+	if !ok {
+		return os.ErrNotExist
+	}
+	return fileHandle.executeReplaceOP(ctx, tempPath, func(writer io.Writer) error {
+		base, err := openStoredFile(fileHandle.filename, fileHandle.masterKey)
+		if err != nil {
+			return err
+		}
+		defer fsutil.LoggedClose(base)
+		if base.header.encoding == codec.Identity {
+			return patchOP(base, writer)
+		}
+
+		decoded, err := st.decodedCopy(uploadID, base)
+		if err != nil {
+			return err
+		}
+		defer fsutil.LoggedClose(decoded)
+		return patchOP(decoded, writer)
+	})
+}
+
+// decodedCopy stores the decoded content of base in the upload and opens it. Seeking back in an
+// encoded file decodes it again from its start, in the copy it is a seek to the position.
+func (st *Staging) decodedCopy(uploadID string, base io.Reader) (*storedFile, error) {
+	err := st.Store(uploadID, decodedPiece, func(writer io.Writer) error {
+		_, err := io.Copy(writer, base)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	path, err := st.buildPiecePath(uploadID, decodedPiece)
+	if err != nil {
+		return nil, err
+	}
+	return openStoredFile(path, st.masterKey)
+}
+
+func (st *Staging) buildPiecePath(uploadID string, name string) (string, error) {
+	if !validUploadID(uploadID) || !ValidFilename(name) {
+		return "", os.ErrNotExist
+	}
+	return filepath.Join(st.root, uploadID, name), nil
+}
+
+func validUploadID(uploadID string) bool {
+	id, err := hex.DecodeString(uploadID)
+	return err == nil && len(id) == uploadIDSize
+}
+
+const (
+	stagingDir   = ".staging"
+	uploadIDSize = 16
+	// replacementPiece is where Replace writes the new content of a file, which must not be the
+	// name of a piece stored by the users of the staging area.
+	replacementPiece = ".replacement"
+	// decodedPiece is where Patch decodes the content of an encoded file to.
+	decodedPiece = ".decoded"
+)
@@ -0,0 +1,246 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+func Test_should_ReplaceFile_Only_When_StoreSucceeds(t *testing.T) {
+	service := newTestSyncService(t, nil)
+	if err := os.WriteFile(service.buildFilePath("foo.txt"), []byte("old content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	service.AddFile("foo.txt")
+	staging := service.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errStore := errors.New("store failed")
+	err = staging.Replace(context.Background(), uploadID, "foo.txt", func(writer io.Writer) error {
+		if _, err := io.WriteString(writer, "half"); err != nil {
+			return err
+		}
+		return errStore
+	})
+	if !errors.Is(err, errStore) {
+		t.Fatalf("got %v want %v", err, errStore)
+	}
+	assertContent(t, service.buildFilePath("foo.txt"), "old content")
+	if pieces, _ := staging.Pieces(uploadID); len(pieces) != 0 {
+		t.Fatalf("failed replacement left %v", pieces)
+	}
+
+	err = staging.Replace(context.Background(), uploadID, "foo.txt", func(writer io.Writer) error {
+		_, err := io.WriteString(writer, "new content")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, service.buildFilePath("foo.txt"), "new content")
+
+	err = staging.Replace(context.Background(), uploadID, "bar.txt", func(io.Writer) error {
+		return errStore
+	})
+	if !errors.Is(err, errStore) {
+		t.Fatalf("got %v want %v", err, errStore)
+	}
+	if _, ok := service.GetFile("bar.txt"); ok {
+		t.Fatal("failed replacement registered bar.txt")
+	}
+}
+
+func Test_should_PatchFile_From_ItsContent(t *testing.T) {
+	service := newTestSyncService(t, nil)
+	if err := os.WriteFile(service.buildFilePath("foo.txt"), []byte("old content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	service.AddFile("foo.txt")
+	staging := service.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = staging.Patch(context.Background(), uploadID, "foo.txt", func(base io.ReadSeeker, writer io.Writer) error {
+		if _, err := base.Seek(4, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, "new "); err != nil {
+			return err
+		}
+		_, err := io.Copy(writer, base)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, service.buildFilePath("foo.txt"), "new content")
+
+	err = staging.Patch(context.Background(), uploadID, "bar.txt", func(io.ReadSeeker, io.Writer) error {
+		return nil
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v want %v", err, os.ErrNotExist)
+	}
+}
+
+func Test_should_PatchEncodedFile_From_DecodedCopy(t *testing.T) {
+	service := newTestSyncService(t, newTestMasterKey(t))
+	payload := make([]byte, 3*chunkSize)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	writer, err := createStoredFile(service.buildFilePath("foo.bin"), codec.Zstd, service.masterKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fileHandle := service.AddFile("foo.bin")
+	staging := service.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chunks of the base in reverse order.
+	err = staging.Patch(context.Background(), uploadID, "foo.bin", func(base io.ReadSeeker, writer io.Writer) error {
+		if stored, ok := base.(*storedFile); !ok || stored.header.encoding != codec.Identity {
+			return errors.New("base is not the decoded copy")
+		}
+		for chunk := int64(2); chunk >= 0; chunk-- {
+			if _, err := base.Seek(chunk*chunkSize, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(writer, base, chunkSize); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var patched bytes.Buffer
+	if err = fileHandle.ExecuteReadOP(func(filename string) error {
+		reader, err := openStoredFile(filename, service.masterKey)
+		if err != nil {
+			return err
+		}
+		defer fsutil.LoggedClose(reader)
+		_, err = patched.ReadFrom(reader)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Concat(payload[2*chunkSize:], payload[chunkSize:2*chunkSize], payload[:chunkSize])
+	if !bytes.Equal(patched.Bytes(), want) {
+		t.Fatalf("got %d bytes that differ from the %d reversed chunks", patched.Len(), len(want))
+	}
+}
+
+func assertContent(t *testing.T, path string, want string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Fatalf("got %q want %q", content, want)
+	}
+}
+
+func Test_should_StageNamespaceUploads_In_RootStaging(t *testing.T) {
+	service := newTestSyncService(t, nil)
+	if err := os.Mkdir(service.buildFilePath("bucket"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	namespace, err := service.Namespace("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging := namespace.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = service.Staging().Pieces(uploadID); err != nil {
+		t.Fatalf("upload is not staged in the root: %v", err)
+	}
+
+	err = staging.Replace(context.Background(), uploadID, "foo.txt", func(writer io.Writer) error {
+		_, err := io.WriteString(writer, "in bucket")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(namespace.buildFilePath("foo.txt")); err != nil || string(content) != "in bucket" {
+		t.Fatalf("got %q, %v want %q", content, err, "in bucket")
+	}
+	if _, ok := service.GetFile("foo.txt"); ok {
+		t.Fatalf("foo.txt is visible in the root")
+	}
+}
+
+func Test_should_KeepPiece_When_StoreFails(t *testing.T) {
+	service := newTestSyncService(t, newTestMasterKey(t))
+	staging := service.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = staging.Store(uploadID, "part-1", func(writer io.Writer) error {
+		_, err := io.WriteString(writer, "first content")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errStore := errors.New("store failed")
+	err = staging.Store(uploadID, "part-1", func(writer io.Writer) error {
+		if _, err := io.WriteString(writer, "half"); err != nil {
+			return err
+		}
+		return errStore
+	})
+	if !errors.Is(err, errStore) {
+		t.Fatalf("got %v want %v", err, errStore)
+	}
+
+	reader, err := staging.Open(uploadID, "part-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	fsutil.LoggedClose(reader)
+	if err != nil || string(content) != "first content" {
+		t.Fatalf("got %q, %v want %q", content, err, "first content")
+	}
+	if pieces, err := staging.Pieces(uploadID); err != nil || len(pieces) != 1 {
+		t.Fatalf("got %v, %v want only part-1", pieces, err)
+	}
+
+	if err = staging.RemovePiece(uploadID, "part-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = staging.Open(uploadID, "part-1"); !os.IsNotExist(err) {
+		t.Fatalf("got %v want %v", err, os.ErrNotExist)
+	}
+}
@@ -1,24 +1,58 @@
 package files
 
 import (
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"github.com/mat-sik/file-server-go/internal/tracing"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
 type SyncService struct {
-	files sync.Map
+	root string
+	// stagingRoot is the staging area of the storage root, which namespaces share.
+	stagingRoot string
+	files       sync.Map
+	namespaces  sync.Map
+	compression CompressionPolicy
+	masterKey   *MasterKey
+}
+
+func (s *SyncService) buildFilePath(filename string) string {
+	return filepath.Join(s.root, filename)
+}
+
+// framed reports whether files are stored with a header even when they are neither encoded nor
+// encrypted, as they are once compression or encryption is enabled. Files without one are those
+// stored before.
+func (s *SyncService) framed() bool {
+	return len(s.compression) > 0 || s.masterKey != nil
+}
+
+func (s *SyncService) newFileHandle(path string, filename string) *FileHandle {
+	fileHandle := NewFileHandle(path, s.compression.EncodingFor(filename), s.masterKey)
+	fileHandle.framed = s.framed()
+	return fileHandle
 }
 
 func (s *SyncService) AddFile(filename string) *FileHandle {
-	path := buildServerFilePath(filename)
-	fileHandler := NewFileHandle(path)
-	s.files.Store(path, fileHandler)
-	return fileHandler
+	path := s.buildFilePath(filename)
+	fileHandler := s.newFileHandle(path, filename)
+	actual, _ := s.files.LoadOrStore(path, fileHandler)
+	return actual.(*FileHandle)
 }
 
 func (s *SyncService) GetFile(filename string) (*FileHandle, bool) {
-	path := buildServerFilePath(filename)
+	path := s.buildFilePath(filename)
 	fileHandle, ok := s.files.Load(path)
 	if !ok {
 		return nil, false
@@ -27,7 +61,7 @@ func (s *SyncService) GetFile(filename string) (*FileHandle, bool) {
 }
 
 func (s *SyncService) RemoveFile(filename string) error {
-	path := buildServerFilePath(filename)
+	path := s.buildFilePath(filename)
 	value, ok := s.files.Load(path)
 	if !ok {
 		return os.ErrNotExist
@@ -40,6 +74,134 @@ func (s *SyncService) RemoveFile(filename string) error {
 	return nil
 }
 
+// ForgetIfMissing drops the handle of filename if it is not leased and no file exists for it, as
+// when a path was leased before anything was stored there.
+func (s *SyncService) ForgetIfMissing(filename string) {
+	path := s.buildFilePath(filename)
+	value, ok := s.files.Load(path)
+	if !ok {
+		return
+	}
+	fileHandle := value.(*FileHandle)
+	_ = fileHandle.ExecuteWriteOP(func(filename string) error {
+		if _, err := os.Stat(filename); !fileHandle.Leased() && errors.Is(err, os.ErrNotExist) {
+			s.files.CompareAndDelete(path, fileHandle)
+		}
+		return nil
+	})
+}
+
+// MoveFile renames from to to, replacing to if it exists. Both handles are write locked, in path
+// order so that concurrent moves cannot deadlock.
+func (s *SyncService) MoveFile(from string, to string) error {
+	fromPath := s.buildFilePath(from)
+	toPath := s.buildFilePath(to)
+	if fromPath == toPath {
+		return nil
+	}
+	value, ok := s.files.Load(fromPath)
+	if !ok {
+		return os.ErrNotExist
+	}
+	source := value.(*FileHandle)
+	newTarget := s.newFileHandle(toPath, to)
+	value, loaded := s.files.LoadOrStore(toPath, newTarget)
+	target := value.(*FileHandle)
+
+	first, second := source, target
+	if toPath < fromPath {
+		first, second = target, source
+	}
+	err := first.ExecuteWriteOP(func(string) error {
+		return second.ExecuteWriteOP(func(string) error {
+			return os.Rename(fromPath, toPath)
+		})
+	})
+	if err != nil {
+		if !loaded {
+			s.files.CompareAndDelete(toPath, newTarget)
+		}
+		return err
+	}
+	s.files.CompareAndDelete(fromPath, source)
+	return nil
+}
+
+// CopyFile copies the content of from to to, replacing to if it exists, and stores it as the
+// compression policy of to demands. The source is read and the target write locked, in path order
+// so that concurrent copies cannot deadlock.
+func (s *SyncService) CopyFile(ctx context.Context, from string, to string) error {
+	fromPath := s.buildFilePath(from)
+	toPath := s.buildFilePath(to)
+	value, ok := s.files.Load(fromPath)
+	if !ok {
+		return os.ErrNotExist
+	}
+	source := value.(*FileHandle)
+	if fromPath == toPath {
+		return nil
+	}
+	newTarget := s.newFileHandle(toPath, to)
+	value, loaded := s.files.LoadOrStore(toPath, newTarget)
+	target := value.(*FileHandle)
+
+	if fromPath < toPath {
+		source.rLock(ctx)
+		defer source.rwMutex.RUnlock()
+	}
+	err := target.executeWriteOP(ctx, func(string) error {
+		if toPath < fromPath {
+			source.rLock(ctx)
+			defer source.rwMutex.RUnlock()
+		}
+		return copyStoredFile(fromPath, toPath, target.encoding, s.masterKey, target.framed)
+	})
+	if err != nil && !loaded {
+		s.files.CompareAndDelete(toPath, newTarget)
+	}
+	return err
+}
+
+// copyStoredFile opens the source before creating the target, so that a missing source leaves
+// the target untouched.
+func copyStoredFile(fromPath string, toPath string, encoding codec.Encoding, key *MasterKey, framed bool) error {
+	source, err := openStoredFile(fromPath, key)
+	if err != nil {
+		return err
+	}
+	defer fsutil.LoggedClose(source)
+
+	target, err := createStoredFile(toPath, encoding, key, framed)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(target, source); err != nil {
+		return errors.Join(err, target.Close())
+	}
+	return target.Close()
+}
+
+// DiskUsage counts the regular files under the storage root and the bytes they take on disk.
+func (s *SyncService) DiskUsage() (int, int64, error) {
+	var count int
+	var size int64
+	err := filepath.WalkDir(s.root, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			var info fs.FileInfo
+			if info, err = entry.Info(); err == nil {
+				count++
+				size += info.Size()
+			}
+		}
+		// Files and staged uploads may be removed while walking.
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+	return count, size, err
+}
+
 func (s *SyncService) GetAllFilenames() []string {
 	var filenames []string
 	s.files.Range(func(key, value interface{}) bool {
@@ -51,71 +213,346 @@ func (s *SyncService) GetAllFilenames() []string {
 }
 
 func NewService() *SyncService {
-	fileService := SyncService{
-		files: sync.Map{},
+	compression, err := ParseCompressionPolicy(envs.ServerCompressionPolicy)
+	if err != nil {
+		panic(err)
+	}
+
+	var masterKey *MasterKey
+	if envs.ServerMasterKeyPath != "" {
+		if masterKey, err = LoadMasterKey(envs.ServerMasterKeyPath); err != nil {
+			panic(err)
+		}
+	}
+
+	fileService, err := newSyncService(envs.ServerStoragePath, compression, masterKey)
+	if err != nil {
+		panic(err)
 	}
+	return fileService
+}
 
-	filenames := getServerStoredFilenames()
+func newSyncService(root string, compression CompressionPolicy, masterKey *MasterKey) (*SyncService, error) {
+	filenames, err := getAllFilenames(root)
+	if err != nil {
+		return nil, err
+	}
+	fileService := &SyncService{
+		root:        root,
+		stagingRoot: filepath.Join(root, stagingDir),
+		compression: compression,
+		masterKey:   masterKey,
+	}
 	for _, filename := range filenames {
 		fileService.AddFile(filename)
 	}
+	return fileService, nil
+}
+
+// Namespace returns the service for the directory name inside the storage root. Namespaces share
+// the compression policy and master key of the root, files in them are not visible in the root.
+func (s *SyncService) Namespace(name string) (*SyncService, error) {
+	if !ValidNamespace(name) {
+		return nil, os.ErrNotExist
+	}
+	if value, ok := s.namespaces.Load(name); ok {
+		return value.(*SyncService), nil
+	}
 
-	return &fileService
+	root := s.buildFilePath(name)
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	namespace, err := newSyncService(root, s.compression, s.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	namespace.stagingRoot = s.stagingRoot
+	value, _ := s.namespaces.LoadOrStore(name, namespace)
+	return value.(*SyncService), nil
+}
+
+func (s *SyncService) Namespaces() ([]string, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && ValidNamespace(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// ValidNamespace reports whether name can name a namespace. Directories starting with a dot are
+// kept for the server's own use.
+func ValidNamespace(name string) bool {
+	return ValidFilename(name) && !strings.HasPrefix(name, ".")
 }
 
 type FileHandle struct {
-	rwMutex  sync.RWMutex
-	filename string
+	rwMutex    sync.RWMutex
+	filename   string
+	encoding   codec.Encoding
+	masterKey  *MasterKey
+	framed     bool
+	leaseMutex sync.Mutex
+	lease      lease
+}
+
+// lease is an advisory write lock held by a WebDAV client. It does not block the handle's own
+// operations, frontends that have no notion of lock tokens check Leased before modifying the file.
+type lease struct {
+	token   string
+	expires time.Time
+}
+
+// Lease marks the file as locked by token until expires, a zero expires never runs out.
+func (fh *FileHandle) Lease(token string, expires time.Time) {
+	fh.leaseMutex.Lock()
+	defer fh.leaseMutex.Unlock()
+	fh.lease = lease{token: token, expires: expires}
+}
+
+func (fh *FileHandle) Release(token string) {
+	fh.leaseMutex.Lock()
+	defer fh.leaseMutex.Unlock()
+	if fh.lease.token == token {
+		fh.lease = lease{}
+	}
+}
+
+func (fh *FileHandle) Leased() bool {
+	fh.leaseMutex.Lock()
+	defer fh.leaseMutex.Unlock()
+	if fh.lease.token == "" {
+		return false
+	}
+	return fh.lease.expires.IsZero() || time.Now().Before(fh.lease.expires)
 }
 
 func (fh *FileHandle) ExecuteReadOP(readOP func(string) error) error {
-	fh.rwMutex.RLock()
+	fh.rLock(context.Background())
 	defer fh.rwMutex.RUnlock()
 	return readOP(fh.filename)
 }
 
 func (fh *FileHandle) ExecuteWriteOP(writeOP func(string) error) error {
-	fh.rwMutex.Lock()
+	return fh.executeWriteOP(context.Background(), writeOP)
+}
+
+func (fh *FileHandle) executeWriteOP(ctx context.Context, writeOP func(string) error) error {
+	fh.lock(ctx)
 	defer fh.rwMutex.Unlock()
 	return writeOP(fh.filename)
 }
 
-func NewFileHandle(filename string) *FileHandle {
+// rLock and lock take the read and write lock, recording the time spent waiting for them.
+func (fh *FileHandle) rLock(ctx context.Context) {
+	_, span := tracing.StartChild(ctx, "file.lock_wait", tracing.String("mode", metrics.ReadLock))
+	start := time.Now()
+	fh.rwMutex.RLock()
+	metrics.ObserveLockWait(metrics.ReadLock, start)
+	span.End()
+}
+
+func (fh *FileHandle) lock(ctx context.Context) {
+	_, span := tracing.StartChild(ctx, "file.lock_wait", tracing.String("mode", metrics.WriteLock))
+	start := time.Now()
+	fh.rwMutex.Lock()
+	metrics.ObserveLockWait(metrics.WriteLock, start)
+	span.End()
+}
+
+// ExecuteStoreOP replaces the file content with whatever storeOP writes, encoding it at rest
+// according to the compression policy and encrypting it when a master key is configured.
+func (fh *FileHandle) ExecuteStoreOP(ctx context.Context, storeOP func(io.Writer) error) error {
+	return fh.executeWriteOP(ctx, func(filename string) error {
+		return fh.store(ctx, filename, storeOP)
+	})
+}
+
+// executeReplaceOP is ExecuteStoreOP writing to tempPath first, which is renamed over the file only
+// once storeOP succeeded. Neither a failure nor a crash leave the file partly written then.
+func (fh *FileHandle) executeReplaceOP(ctx context.Context, tempPath string, storeOP func(io.Writer) error) error {
+	return fh.executeWriteOP(ctx, func(filename string) error {
+		return fh.replace(ctx, tempPath, filename, storeOP)
+	})
+}
+
+// replace is executeReplaceOP for callers already holding the write lock.
+func (fh *FileHandle) replace(ctx context.Context, tempPath string, filename string, storeOP func(io.Writer) error) error {
+	if err := fh.store(ctx, tempPath, storeOP); err != nil {
+		if removeErr := os.Remove(tempPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			err = errors.Join(err, removeErr)
+		}
+		return err
+	}
+	return os.Rename(tempPath, filename)
+}
+
+func (fh *FileHandle) store(ctx context.Context, path string, storeOP func(io.Writer) error) (err error) {
+	_, span := tracing.StartChild(ctx, "file.store", tracing.String("encoding", fh.encoding.String()))
+	writer := &countingWriter{}
+	start := time.Now()
+	defer func() {
+		metrics.ObserveTransfer(metrics.In, start, writer.written)
+		span.SetAttributes(tracing.Int("bytes", int(writer.written)))
+		span.SetError(err)
+		span.End()
+	}()
+
+	if writer.WriteCloser, err = createStoredFile(path, fh.encoding, fh.masterKey, fh.framed); err != nil {
+		return err
+	}
+	if err = storeOP(writer); err != nil {
+		return errors.Join(err, writer.Close())
+	}
+	return writer.Close()
+}
+
+// SetModTime sets the modification time the file reports, so that a copy keeps that of its source.
+func (fh *FileHandle) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fh.executeWriteOP(ctx, func(filename string) error {
+		return os.Chtimes(filename, modTime, modTime)
+	})
+}
+
+func (fh *FileHandle) Stat() (FileInfo, error) {
+	var info FileInfo
+	err := fh.ExecuteReadOP(func(filename string) error {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer fsutil.LoggedClose(file)
+
+		stored, err := readStoredFile(file)
+		if err != nil {
+			return err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		info = FileInfo{
+			Name:    filepath.Base(filename),
+			Size:    int(stored.header.size),
+			ModTime: stat.ModTime(),
+		}
+		return nil
+	})
+	return info, err
+}
+
+type FileInfo struct {
+	Name    string
+	Size    int
+	ModTime time.Time
+}
+
+func NewFileHandle(filename string, encoding codec.Encoding, masterKey *MasterKey) *FileHandle {
 	return &FileHandle{
-		filename: filename,
-		rwMutex:  sync.RWMutex{},
+		filename:  filename,
+		encoding:  encoding,
+		masterKey: masterKey,
+		rwMutex:   sync.RWMutex{},
 	}
 }
 
-func (fh *FileHandle) NewReadLockedFile() (*ReadLockedFile, error) {
-	fh.rwMutex.RLock()
+// NewReadLockedFile opens the file for reading, the read span of ctx lasts until it is closed.
+func (fh *FileHandle) NewReadLockedFile(ctx context.Context) (*ReadLockedFile, error) {
+	fh.rLock(ctx)
 
-	file, err := os.Open(fh.filename)
+	_, span := tracing.StartChild(ctx, "file.read")
+	file, err := openStoredFile(fh.filename, fh.masterKey)
 	if err != nil {
 		fh.rwMutex.RUnlock()
+		span.SetError(err)
+		span.End()
 		return nil, err
 	}
 
 	return &ReadLockedFile{
 		rwMutex: &fh.rwMutex,
 		file:    file,
+		opened:  time.Now(),
+		span:    span,
 	}, nil
 }
 
+// ReadLockedFile reads the logical content of a stored file while holding its read lock.
 type ReadLockedFile struct {
 	rwMutex *sync.RWMutex
-	file    *os.File
+	file    *storedFile
+	opened  time.Time
+	read    int64
+	span    *tracing.Span
 }
 
 func (f *ReadLockedFile) Read(p []byte) (n int, err error) {
-	return f.file.Read(p)
+	n, err = f.file.Read(p)
+	f.read += int64(n)
+	return n, err
+}
+
+func (f *ReadLockedFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
 }
 
 func (f *ReadLockedFile) Size() (n int, err error) {
-	return SizeOf(f.file)
+	return int(f.file.header.size), nil
+}
+
+func (f *ReadLockedFile) ModTime() (time.Time, error) {
+	stat, err := f.file.file.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+
+func (f *ReadLockedFile) Encoding() codec.Encoding {
+	return f.file.header.encoding
+}
+
+// EncodedBody gives access to the bytes as they are stored on disk, so that they can be sent
+// to a peer that accepts the same encoding. It must not be mixed with Read.
+func (f *ReadLockedFile) EncodedBody() (io.Reader, int) {
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := f.file.body.Read(p)
+		f.read += int64(n)
+		return n, err
+	}), int(f.file.bodySize)
 }
 
 func (f *ReadLockedFile) Close() error {
 	defer f.rwMutex.RUnlock()
+	metrics.ObserveTransfer(metrics.Out, f.opened, f.read)
+	f.span.SetAttributes(tracing.Int("bytes", int(f.read)))
+	f.span.End()
 	return f.file.Close()
 }
+
+type readerFunc func(p []byte) (int, error)
+
+func (r readerFunc) Read(p []byte) (int, error) {
+	return r(p)
+}
+
+type countingWriter struct {
+	io.WriteCloser
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,287 @@
+package files
+
+import (
+	"context"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_should_MoveFile(t *testing.T) {
+	service := &SyncService{root: t.TempDir()}
+	payload := []byte("moved between handles")
+	if err := os.WriteFile(service.buildFilePath("from.txt"), payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	service.AddFile("from.txt")
+
+	if err := service.MoveFile("from.txt", "to.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := service.GetFile("from.txt"); ok {
+		t.Fatalf("from.txt is still registered")
+	}
+	fileHandle, ok := service.GetFile("to.txt")
+	if !ok {
+		t.Fatalf("to.txt is not registered")
+	}
+	info, err := fileHandle.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != len(payload) {
+		t.Fatalf("got %d bytes want %d", info.Size, len(payload))
+	}
+
+	if err = service.MoveFile("missing.txt", "other.txt"); !os.IsNotExist(err) {
+		t.Fatalf("got %v want %v", err, os.ErrNotExist)
+	}
+	if _, ok = service.GetFile("other.txt"); ok {
+		t.Fatalf("failed move left other.txt registered")
+	}
+}
+
+func Test_should_CopyFile(t *testing.T) {
+	service := &SyncService{root: t.TempDir()}
+	payload := []byte("copied between handles")
+	if err := os.WriteFile(service.buildFilePath("from.txt"), payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	service.AddFile("from.txt")
+
+	if err := service.CopyFile(context.Background(), "from.txt", "to.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range []string{"from.txt", "to.txt"} {
+		fileHandle, ok := service.GetFile(filename)
+		if !ok {
+			t.Fatalf("%s is not registered", filename)
+		}
+		file, err := fileHandle.NewReadLockedFile(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(file)
+		fsutil.LoggedClose(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != string(payload) {
+			t.Fatalf("%s contains %q want %q", filename, content, payload)
+		}
+	}
+
+	if err := service.CopyFile(context.Background(), "missing.txt", "other.txt"); !os.IsNotExist(err) {
+		t.Fatalf("got %v want %v", err, os.ErrNotExist)
+	}
+	if _, ok := service.GetFile("other.txt"); ok {
+		t.Fatalf("failed copy left other.txt registered")
+	}
+}
+
+func Test_should_ExpireAndReleaseLease(t *testing.T) {
+	fileHandle := NewFileHandle(filepath.Join(t.TempDir(), "leased.txt"), codec.Identity, nil)
+
+	fileHandle.Lease("expired", time.Now().Add(-time.Second))
+	if fileHandle.Leased() {
+		t.Fatalf("expired lease is still held")
+	}
+
+	fileHandle.Lease("token", time.Time{})
+	fileHandle.Release("other")
+	if !fileHandle.Leased() {
+		t.Fatalf("lease released by a foreign token")
+	}
+	fileHandle.Release("token")
+	if fileHandle.Leased() {
+		t.Fatalf("lease is still held after release")
+	}
+}
+
+func Test_should_ForgetLeasedPath_Only_When_NoFileWasStored(t *testing.T) {
+	service := newTestSyncService(t, nil)
+	if err := os.WriteFile(service.buildFilePath("stored.txt"), []byte("stored"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	service.AddFile("stored.txt")
+	service.AddFile("missing.txt").Lease("token", time.Time{})
+
+	service.ForgetIfMissing("missing.txt")
+	if _, ok := service.GetFile("missing.txt"); !ok {
+		t.Fatalf("leased path was forgotten")
+	}
+
+	fileHandle, _ := service.GetFile("missing.txt")
+	fileHandle.Release("token")
+	service.ForgetIfMissing("missing.txt")
+	service.ForgetIfMissing("stored.txt")
+	if _, ok := service.GetFile("missing.txt"); ok {
+		t.Fatalf("released path without a file is still registered")
+	}
+	if _, ok := service.GetFile("stored.txt"); !ok {
+		t.Fatalf("stored file was forgotten")
+	}
+}
+
+func Test_should_ServeNamespaces(t *testing.T) {
+	service := newTestSyncService(t, nil)
+	for _, dir := range []string{"bucket", ".staging"} {
+		if err := os.Mkdir(service.buildFilePath(dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(service.buildFilePath("bucket/inside.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := service.Namespaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "bucket" {
+		t.Fatalf("got %v want [bucket]", names)
+	}
+
+	namespace, err := service.Namespace("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := service.Namespace("bucket"); again != namespace {
+		t.Fatalf("namespace is not shared between lookups")
+	}
+	if _, ok := namespace.GetFile("inside.txt"); !ok {
+		t.Fatalf("inside.txt is not registered in the namespace")
+	}
+	if _, ok := service.GetFile("inside.txt"); ok {
+		t.Fatalf("inside.txt is visible in the root")
+	}
+	for _, name := range []string{".staging", "missing", "../bucket"} {
+		if _, err = service.Namespace(name); !os.IsNotExist(err) {
+			t.Fatalf("namespace %q: got %v want %v", name, err, os.ErrNotExist)
+		}
+	}
+}
+
+func Test_should_StageUploadPieces(t *testing.T) {
+	service := newTestSyncService(t, newTestMasterKey(t))
+	staging := service.Staging()
+
+	uploadID, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = staging.Store(uploadID, "part-1", func(writer io.Writer) error {
+		_, err := writer.Write([]byte("staged piece"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pieces, err := staging.Pieces(uploadID)
+	if err != nil || len(pieces) != 1 || pieces[0] != "part-1" {
+		t.Fatalf("got %v, %v want [part-1]", pieces, err)
+	}
+	reader, err := staging.Open(uploadID, "part-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	fsutil.LoggedClose(reader)
+	if err != nil || string(content) != "staged piece" {
+		t.Fatalf("got %q, %v want %q", content, err, "staged piece")
+	}
+
+	if err = staging.Remove(uploadID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = staging.Pieces(uploadID); !os.IsNotExist(err) {
+		t.Fatalf("got %v want %v", err, os.ErrNotExist)
+	}
+	if _, err = staging.Open("../../escape", "part-1"); !os.IsNotExist(err) {
+		t.Fatalf("got %v want %v", err, os.ErrNotExist)
+	}
+}
+
+func Test_should_RemoveStaleUploads_Only(t *testing.T) {
+	service := newTestSyncService(t, nil)
+	staging := service.Staging()
+	stale, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := staging.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uploadID := range []string{stale, active} {
+		err = staging.Store(uploadID, "part-1", func(writer io.Writer) error {
+			_, err := writer.Write([]byte("piece"))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{
+		filepath.Join(staging.root, stale, "part-1"),
+		filepath.Join(staging.root, stale),
+		filepath.Join(staging.root, active),
+	} {
+		if err = os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := staging.RemoveStale(time.Hour)
+
+	if err != nil || removed != 1 {
+		t.Fatalf("removed %d uploads, %v want 1", removed, err)
+	}
+	if _, err = staging.Pieces(stale); !os.IsNotExist(err) {
+		t.Fatalf("got %v want %v", err, os.ErrNotExist)
+	}
+	// The piece stored recently keeps the upload alive.
+	if pieces, err := staging.Pieces(active); err != nil || len(pieces) != 1 {
+		t.Fatalf("got %v, %v want [part-1]", pieces, err)
+	}
+}
+
+func newTestSyncService(t *testing.T, masterKey *MasterKey) *SyncService {
+	return newTestSyncServiceAt(t, t.TempDir(), masterKey)
+}
+
+func newTestSyncServiceAt(t *testing.T, root string, masterKey *MasterKey) *SyncService {
+	service, err := newSyncService(root, nil, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func Test_should_CountDiskUsage(t *testing.T) {
+	service := newTestSyncService(t, nil)
+	if err := os.Mkdir(service.buildFilePath("bucket"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"root.txt": "root", "bucket/inside.txt": "inside"} {
+		if err := os.WriteFile(service.buildFilePath(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, size, err := service.DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || size != int64(len("root")+len("inside")) {
+		t.Fatalf("got %d files with %d bytes want 2 files with 10 bytes", count, size)
+	}
+}
@@ -0,0 +1,21 @@
+package fsutil
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+func SizeOf(f *os.File) (int, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return int(stat.Size()), nil
+}
+
+func LoggedClose(f io.Closer) {
+	if err := f.Close(); err != nil {
+		slog.Error(err.Error())
+	}
+}
@@ -0,0 +1,58 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartialFile is written next to the file at path and takes its place only once it is committed,
+// so that an interrupted download neither leaves a partial file behind nor truncates the file.
+type PartialFile struct {
+	*os.File
+	path string
+}
+
+func CreatePartial(path string) (*PartialFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+partialInfix+"*")
+	if err != nil {
+		return nil, err
+	}
+	// Temporary files are private, downloads get the permissions os.Create usually results in.
+	if err = file.Chmod(0644); err != nil {
+		return nil, errors.Join(err, file.Close(), os.Remove(file.Name()))
+	}
+	return &PartialFile{File: file, path: path}, nil
+}
+
+// IsPartial reports whether name is that of a PartialFile, which is not a file of its own yet.
+func IsPartial(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, partialInfix)
+}
+
+// Commit closes the file and moves it to its path. If that fails the file is removed.
+func (f *PartialFile) Commit() error {
+	err := f.File.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		return errors.Join(err, removeIfExists(f.Name()))
+	}
+	return nil
+}
+
+// Abort closes and removes the file.
+func (f *PartialFile) Abort() error {
+	return errors.Join(f.File.Close(), removeIfExists(f.Name()))
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+const partialInfix = ".partial-"
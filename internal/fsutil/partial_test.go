@@ -0,0 +1,66 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_should_CommitPartialFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "partial.txt")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	partial, err := CreatePartial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = partial.WriteString("new"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "old" {
+		t.Fatalf("the file was replaced before the commit: %q", content)
+	}
+	if err = partial.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "new" {
+		t.Fatalf("got %q want %q", content, "new")
+	}
+	assertOnlyEntry(t, path)
+}
+
+func Test_should_AbortPartialFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "partial.txt")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	partial, err := CreatePartial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = partial.WriteString("ne"); err != nil {
+		t.Fatal(err)
+	}
+	if err = partial.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "old" {
+		t.Fatalf("got %q want %q", content, "old")
+	}
+	assertOnlyEntry(t, path)
+}
+
+func assertOnlyEntry(t *testing.T, path string) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(path) {
+		t.Fatalf("unexpected directory entries %v", entries)
+	}
+}
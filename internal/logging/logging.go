@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Setup installs the default logger configured by the LOG_* environment variables. The returned
+// closer closes the log file, if there is one.
+func Setup() (io.Closer, error) {
+	level, err := parseLevel(envs.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := parseInt(envs.LogMaxSize, defaultMaxSize, "LOG_MAX_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	maxBackups, err := parseInt(envs.LogMaxBackups, defaultMaxBackups, "LOG_MAX_BACKUPS")
+	if err != nil {
+		return nil, err
+	}
+
+	var writer io.WriteCloser = nopCloser{Writer: os.Stderr}
+	if envs.LogPath != "" {
+		if writer, err = NewRotatingFile(envs.LogPath, int64(maxSize), maxBackups); err != nil {
+			return nil, err
+		}
+	}
+
+	handler, err := newHandler(envs.LogFormat, writer, &slog.HandlerOptions{Level: level})
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(slog.New(handler))
+	return writer, nil
+}
+
+func newHandler(format string, writer io.Writer, options *slog.HandlerOptions) (slog.Handler, error) {
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(writer, options), nil
+	case "json":
+		return slog.NewJSONHandler(writer, options), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, use text or json", format)
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	return parsed, nil
+}
+
+func parseInt(value string, fallback int, name string) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non negative number, got %q", name, value)
+	}
+	return parsed, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+const (
+	defaultMaxSize    = 100 * 1024 * 1024
+	defaultMaxBackups = 5
+)
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile appends to the file at path and rotates it once writing would grow it beyond
+// maxSize bytes: path is renamed to path.1, path.1 to path.2 and so on, keeping maxBackups old
+// files. A maxSize of zero never rotates.
+type RotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Join(err, file.Close())
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// Write appends p even if rotating fails, the file then keeps growing and the error is returned
+// along with what was written.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	var rotateErr error
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		rotateErr = rf.rotate()
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, errors.Join(rotateErr, err)
+}
+
+// rotate moves the file aside before closing it, so that the file stays open for the writes that
+// follow if moving it or opening its successor fails.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.moveAside(); err != nil {
+		return err
+	}
+	previous := rf.file
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return previous.Close()
+}
+
+func (rf *RotatingFile) moveAside() error {
+	if rf.maxBackups == 0 {
+		if err := os.Remove(rf.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	// A previous rotation may have moved the file already and failed to open its successor.
+	if _, err := os.Stat(rf.path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(rf.backupPath(i), rf.backupPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(rf.path, rf.backupPath(1))
+}
+
+func (rf *RotatingFile) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	return rf.file.Close()
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_should_RotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	rf, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err = rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, expected := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("%s holds %q want %q", file, content, expected)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("kept more than 2 backups")
+	}
+}
+
+func Test_should_KeepWriting_When_RotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	rf, err := NewRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	// A directory in place of the backup cannot be replaced by the log file.
+	if err = os.MkdirAll(filepath.Join(path+".1", "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = rf.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := rf.Write([]byte("second\n")); err == nil || n != len("second\n") {
+		t.Fatalf("wrote %d bytes with error %v, want all of them and the rotation error", n, err)
+	}
+	if err = os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rf.Write([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, expected := range map[string]string{path: "third\n", path + ".1": "first\nsecond\n"} {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("%s holds %q want %q", file, content, expected)
+		}
+	}
+}
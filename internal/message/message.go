@@ -1,17 +1,44 @@
 package message
 
+import (
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/delta"
+	"time"
+)
+
 type GetFileRequest struct {
-	Filename string
+	Filename        string
+	AcceptEncodings []codec.Encoding
+	// Length above 0 asks for the Length bytes starting at Offset only, see Ranged.
+	Offset int
+	Length int
+}
+
+// Ranged reports whether req asks for a range of the file, which is answered with status 206 and
+// sent without encoding.
+func (req GetFileRequest) Ranged() bool {
+	return req.Length > 0
 }
 
 type GetFileResponse struct {
 	Status int
-	Size   int
+	// Size is the size of the content that follows, of the range only if req was Ranged.
+	Size        int
+	Encoding    codec.Encoding
+	EncodedSize int
+	ModTime     time.Time
 }
 
 type PutFileRequest struct {
-	Filename string
-	Size     int
+	Filename    string
+	Size        int
+	Encoding    codec.Encoding
+	EncodedSize int
+	// IdempotencyKey identifies retries of the request, the server answers them with the response
+	// of the first attempt instead of storing the file again.
+	IdempotencyKey string
+	// ModTime is given to the stored file unless it is zero, as when mirroring the file.
+	ModTime time.Time
 }
 
 type PutFileResponse struct {
@@ -19,7 +46,8 @@ type PutFileResponse struct {
 }
 
 type DeleteFileRequest struct {
-	Filename string
+	Filename       string
+	IdempotencyKey string
 }
 
 type DeleteFileResponse struct {
@@ -35,6 +63,115 @@ type GetFilenamesResponse struct {
 	Filenames []string
 }
 
+type StatFileRequest struct {
+	Filename string
+	// Checksum asks for the checksum of the file, which the server reads the whole file for.
+	Checksum bool
+}
+
+type StatFileResponse struct {
+	Status  int
+	Size    int
+	ModTime time.Time
+	// Checksum is the hex encoded SHA-256 of the content if the request asked for it.
+	Checksum string
+}
+
+type MoveFileRequest struct {
+	From string
+	To   string
+}
+
+type MoveFileResponse struct {
+	Status int
+}
+
+type CopyFileRequest struct {
+	From string
+	To   string
+}
+
+type CopyFileResponse struct {
+	Status int
+}
+
+// CreateUploadRequest starts an upload of a file of Size bytes sent in parts, possibly over several
+// sessions at once. The file is replaced only once CompleteUploadRequest assembled the parts.
+type CreateUploadRequest struct {
+	Filename string
+	Size     int
+}
+
+type CreateUploadResponse struct {
+	Status   int
+	UploadID string
+}
+
+// PutPartRequest is followed by the Size bytes of the file starting at Offset, putting a part at
+// the same Offset again replaces it.
+type PutPartRequest struct {
+	UploadID    string
+	Offset      int
+	Size        int
+	Encoding    codec.Encoding
+	EncodedSize int
+}
+
+type PutPartResponse struct {
+	Status int
+}
+
+// CompleteUploadRequest assembles the parts, which must cover the file without gaps or overlaps,
+// and replaces the file with them if their hex encoded SHA-256 matches Checksum.
+type CompleteUploadRequest struct {
+	UploadID       string
+	Checksum       string
+	IdempotencyKey string
+	ModTime        time.Time
+}
+
+type CompleteUploadResponse struct {
+	Status int
+}
+
+type AbortUploadRequest struct {
+	UploadID string
+}
+
+type AbortUploadResponse struct {
+	Status int
+}
+
+type GetSignatureRequest struct {
+	Filename string
+}
+
+// GetSignatureResponse describes the blocks of the file, a new version of which is uploaded as a
+// delta against them.
+type GetSignatureResponse struct {
+	Status    int
+	Size      int
+	BlockSize int
+	Blocks    []delta.Block
+}
+
+// PutDeltaRequest is followed by the DeltaSize bytes of instructions that rebuild a file of Size
+// bytes out of the stored one, whose blocks of BlockSize bytes they refer to. The file is replaced
+// only if the hex encoded SHA-256 of the rebuilt one matches Checksum.
+type PutDeltaRequest struct {
+	Filename       string
+	BlockSize      int
+	Size           int
+	DeltaSize      int
+	Checksum       string
+	IdempotencyKey string
+	ModTime        time.Time
+}
+
+type PutDeltaResponse struct {
+	Status int
+}
+
 type Message interface {
 	isMessage()
 }
@@ -63,6 +200,60 @@ func (_ GetFilenamesRequest) isMessage() {
 func (_ GetFilenamesResponse) isMessage() {
 }
 
+func (_ StatFileRequest) isMessage() {
+}
+
+func (_ StatFileResponse) isMessage() {
+}
+
+func (_ MoveFileRequest) isMessage() {
+}
+
+func (_ MoveFileResponse) isMessage() {
+}
+
+func (_ CopyFileRequest) isMessage() {
+}
+
+func (_ CopyFileResponse) isMessage() {
+}
+
+func (_ CreateUploadRequest) isMessage() {
+}
+
+func (_ CreateUploadResponse) isMessage() {
+}
+
+func (_ PutPartRequest) isMessage() {
+}
+
+func (_ PutPartResponse) isMessage() {
+}
+
+func (_ CompleteUploadRequest) isMessage() {
+}
+
+func (_ CompleteUploadResponse) isMessage() {
+}
+
+func (_ AbortUploadRequest) isMessage() {
+}
+
+func (_ AbortUploadResponse) isMessage() {
+}
+
+func (_ GetSignatureRequest) isMessage() {
+}
+
+func (_ GetSignatureResponse) isMessage() {
+}
+
+func (_ PutDeltaRequest) isMessage() {
+}
+
+func (_ PutDeltaResponse) isMessage() {
+}
+
 type Request interface {
 	isMessage()
 	isRequest()
@@ -80,6 +271,33 @@ func (_ DeleteFileRequest) isRequest() {
 func (_ GetFilenamesRequest) isRequest() {
 }
 
+func (_ StatFileRequest) isRequest() {
+}
+
+func (_ MoveFileRequest) isRequest() {
+}
+
+func (_ CopyFileRequest) isRequest() {
+}
+
+func (_ CreateUploadRequest) isRequest() {
+}
+
+func (_ PutPartRequest) isRequest() {
+}
+
+func (_ CompleteUploadRequest) isRequest() {
+}
+
+func (_ AbortUploadRequest) isRequest() {
+}
+
+func (_ GetSignatureRequest) isRequest() {
+}
+
+func (_ PutDeltaRequest) isRequest() {
+}
+
 type Response interface {
 	isMessage()
 	isResponse()
@@ -97,6 +315,33 @@ func (_ DeleteFileResponse) isResponse() {
 func (_ GetFilenamesResponse) isResponse() {
 }
 
+func (_ StatFileResponse) isResponse() {
+}
+
+func (_ MoveFileResponse) isResponse() {
+}
+
+func (_ CopyFileResponse) isResponse() {
+}
+
+func (_ CreateUploadResponse) isResponse() {
+}
+
+func (_ PutPartResponse) isResponse() {
+}
+
+func (_ CompleteUploadResponse) isResponse() {
+}
+
+func (_ AbortUploadResponse) isResponse() {
+}
+
+func (_ GetSignatureResponse) isResponse() {
+}
+
+func (_ PutDeltaResponse) isResponse() {
+}
+
 type FilenameGetter interface {
 	GetFilename() string
 }
@@ -112,3 +357,72 @@ func (req PutFileRequest) GetFilename() string {
 func (req DeleteFileRequest) GetFilename() string {
 	return req.Filename
 }
+
+func (req StatFileRequest) GetFilename() string {
+	return req.Filename
+}
+
+func (req MoveFileRequest) GetFilename() string {
+	return req.From
+}
+
+func (req CopyFileRequest) GetFilename() string {
+	return req.From
+}
+
+func (req CreateUploadRequest) GetFilename() string {
+	return req.Filename
+}
+
+func (req GetSignatureRequest) GetFilename() string {
+	return req.Filename
+}
+
+func (req PutDeltaRequest) GetFilename() string {
+	return req.Filename
+}
+
+type Transfer interface {
+	TransferEncoding() codec.Encoding
+	TransferSize() int
+}
+
+func (res GetFileResponse) TransferEncoding() codec.Encoding {
+	return res.Encoding
+}
+
+func (res GetFileResponse) TransferSize() int {
+	return transferSize(res.Encoding, res.Size, res.EncodedSize)
+}
+
+func (req PutFileRequest) TransferEncoding() codec.Encoding {
+	return req.Encoding
+}
+
+func (req PutFileRequest) TransferSize() int {
+	return transferSize(req.Encoding, req.Size, req.EncodedSize)
+}
+
+func (req PutPartRequest) TransferEncoding() codec.Encoding {
+	return req.Encoding
+}
+
+func (req PutPartRequest) TransferSize() int {
+	return transferSize(req.Encoding, req.Size, req.EncodedSize)
+}
+
+// The instructions of a delta are sent as they are.
+func (req PutDeltaRequest) TransferEncoding() codec.Encoding {
+	return codec.Identity
+}
+
+func (req PutDeltaRequest) TransferSize() int {
+	return req.DeltaSize
+}
+
+func transferSize(encoding codec.Encoding, size int, encodedSize int) int {
+	if encoding == codec.Identity {
+		return size
+	}
+	return encodedSize
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Requests handled by the shared request handler, by request type and response status.",
+	}, []string{"type", "status"})
+
+	transferredBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transferred_bytes_total",
+		Help:      "File content stored (in) and read (out) by all frontends.",
+	}, []string{"direction"})
+
+	transferDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transfer_duration_seconds",
+		Help:      "Time files were held open for storing (in) and reading (out).",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"direction"})
+
+	activeSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Open connections of the session based frontends.",
+	}, []string{"frontend"})
+
+	lockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lock_wait_seconds",
+		Help:      "Time spent waiting for the read or write lock of a file.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"mode"})
+
+	rejectedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_connections_total",
+		Help:      "Connections closed right after accepting them because a connection limit was reached.",
+	}, []string{"reason"})
+
+	storageFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_files",
+		Help:      "Files in the storage root, including namespaces and staged uploads.",
+	})
+
+	storageBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_bytes",
+		Help:      "Bytes the storage root takes on disk, after compression and encryption.",
+	})
+
+	registry = newRegistry()
+)
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		transferredBytes,
+		transferDuration,
+		activeSessions,
+		lockWait,
+		rejectedConnections,
+		storageFiles,
+		storageBytes,
+	)
+	return r
+}
+
+// Handler serves all metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func ObserveRequest(requestType string, status int, err error) {
+	label := strconv.Itoa(status)
+	if err != nil {
+		label = "error"
+	}
+	requests.WithLabelValues(requestType, label).Inc()
+}
+
+// ObserveTransfer records a transfer of size bytes in direction that started at start.
+func ObserveTransfer(direction string, start time.Time, size int64) {
+	transferredBytes.WithLabelValues(direction).Add(float64(size))
+	transferDuration.WithLabelValues(direction).Observe(time.Since(start).Seconds())
+}
+
+// SessionStarted counts an open session of frontend, the returned function ends it.
+func SessionStarted(frontend string) func() {
+	gauge := activeSessions.WithLabelValues(frontend)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+func ObserveLockWait(mode string, start time.Time) {
+	lockWait.WithLabelValues(mode).Observe(time.Since(start).Seconds())
+}
+
+func ConnectionRejected(reason string) {
+	rejectedConnections.WithLabelValues(reason).Inc()
+}
+
+// SetStorageUsage exports the number and on disk size of stored files as last counted. Counting
+// walks the whole storage root, so it is done periodically rather than on every scrape.
+func SetStorageUsage(files int, bytes int64) {
+	storageFiles.Set(float64(files))
+	storageBytes.Set(float64(bytes))
+}
+
+const (
+	namespace = "fileserver"
+
+	In  = "in"
+	Out = "out"
+
+	ReadLock  = "read"
+	WriteLock = "write"
+)
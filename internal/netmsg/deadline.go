@@ -0,0 +1,54 @@
+package netmsg
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+var ErrIdleTimeout = errors.New("session idle for too long")
+
+// deadlineConn moves the deadline of every read and write ioTimeout into the future, so that a
+// transfer only times out once the peer stops making progress, no matter how large it is. The
+// first read after the session became idle may take up to idleTimeout instead. A zero timeout
+// disables the respective deadline.
+type deadlineConn struct {
+	net.Conn
+	idleTimeout time.Duration
+	ioTimeout   time.Duration
+	idle        bool
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	idle := c.idle
+	c.idle = false
+
+	timeout := c.ioTimeout
+	if idle {
+		timeout = c.idleTimeout
+	}
+	if err := c.SetReadDeadline(deadlineAfter(timeout)); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(p)
+	if idle && n == 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+		return 0, ErrIdleTimeout
+	}
+	return n, err
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if err := c.SetWriteDeadline(deadlineAfter(c.ioTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
@@ -0,0 +1,93 @@
+package netmsg
+
+import (
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_should_FailIdleSession_With_ErrIdleTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	session := NewSessionWithTimeouts(conn, 20*time.Millisecond, time.Second)
+	session.Idle()
+
+	if _, _, err := session.ReceiveMessage(context.Background()); !errors.Is(err, ErrIdleTimeout) {
+		t.Fatalf("got %v want %v", err, ErrIdleTimeout)
+	}
+}
+
+func Test_should_FailStalledTransfer_After_IOTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	session := NewSessionWithTimeouts(conn, time.Second, 20*time.Millisecond)
+	go func() {
+		// The peer sends part of the payload and then stalls.
+		_, _ = peer.Write(make([]byte, 100))
+	}()
+
+	err := session.StreamFromNet(context.Background(), io.Discard, 1000)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func Test_should_ExtendDeadline_While_TransferProgresses(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	session := NewSessionWithTimeouts(conn, 0, 50*time.Millisecond)
+	go func() {
+		// Slower than the I/O timeout as a whole, but never stalled for that long.
+		for i := 0; i < 10; i++ {
+			time.Sleep(10 * time.Millisecond)
+			_, _ = peer.Write(make([]byte, 10))
+		}
+		peerSession := NewSession(peer)
+		_ = peerSession.SendMessage(context.Background(), message.DeleteFileRequest{Filename: "foo.txt"})
+	}()
+
+	if err := session.StreamFromNet(context.Background(), io.Discard, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := session.ReceiveMessage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_ReportHealth(t *testing.T) {
+	testCases := []struct {
+		name    string
+		peer    func(net.Conn)
+		healthy bool
+	}{
+		{name: "open", peer: func(net.Conn) {}, healthy: true},
+		{name: "closed by peer", peer: func(conn net.Conn) { _ = conn.Close() }, healthy: false},
+		{name: "unread data", peer: func(conn net.Conn) { _, _ = conn.Write([]byte("x")) }, healthy: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go tc.peer(server)
+			time.Sleep(10 * time.Millisecond)
+
+			session := NewSessionWithTimeouts(client, time.Minute, time.Minute)
+			if got := session.Healthy(); got != tc.healthy {
+				t.Fatalf("got %v want %v", got, tc.healthy)
+			}
+		})
+	}
+}
@@ -7,13 +7,21 @@ import (
 
 type header struct {
 	payloadSize uint32
+	compressed  bool
 }
 
 func encodeHeader(header header, buffer []byte) error {
 	if err := validateHeaderBufferSize(buffer, uint32ByteSize); err != nil {
 		return err
 	}
-	binary.BigEndian.PutUint32(buffer, header.payloadSize)
+	if header.payloadSize&compressedFlag != 0 {
+		return errors.New("payload too large to encode in header")
+	}
+	value := header.payloadSize
+	if header.compressed {
+		value |= compressedFlag
+	}
+	binary.BigEndian.PutUint32(buffer, value)
 	return nil
 }
 
@@ -22,10 +30,11 @@ func decodeHeader(buffer []byte) (header, error) {
 		return header{}, errors.New("buffer has not enough bytes to decode header")
 	}
 	uint32ByteSlice := buffer[:uint32ByteSize]
-	payloadSize := binary.BigEndian.Uint32(uint32ByteSlice)
+	value := binary.BigEndian.Uint32(uint32ByteSlice)
 
 	return header{
-		payloadSize: payloadSize,
+		payloadSize: value &^ compressedFlag,
+		compressed:  value&compressedFlag != 0,
 	}, nil
 }
 
@@ -39,4 +48,6 @@ func validateHeaderBufferSize(headerBuffer []byte, size int) error {
 const (
 	uint32ByteSize = 4
 	headerSize     = uint32ByteSize
+
+	compressedFlag = uint32(1) << 31
 )
@@ -1,24 +1,51 @@
 package netmsg
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/delta"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
 	"github.com/mat-sik/file-server-go/internal/generated/netmsgpb"
 	"github.com/mat-sik/file-server-go/internal/message"
 	"google.golang.org/protobuf/proto"
 	"io"
+	"slices"
+	"time"
 )
 
-func sendMessage(msg message.Message, buffer []byte, writer io.Writer) error {
+// envelope is what the sender of a message tells along with it.
+type envelope struct {
+	traceparent string
+	// acceptsCompression is whether the sender can decode compressed messages.
+	acceptsCompression bool
+}
+
+// sendMessage compresses msg if it is large and compress is set, which it may only be once the
+// peer told that it accepts compressed messages.
+func sendMessage(msg message.Message, traceparent string, compress bool, buffer []byte, writer io.Writer) error {
 	wrapperMsg := toProto(msg)
+	if traceparent != "" {
+		wrapperMsg.Traceparent = &traceparent
+	}
+	wrapperMsg.AcceptMessageEncoding = []netmsgpb.Encoding{netmsgpb.Encoding(messageEncoding)}
 
 	msgBytes, err := proto.Marshal(&wrapperMsg)
 	if err != nil {
 		return err
 	}
 
+	compressed := compress && len(msgBytes) > compressionThreshold
+	if compressed {
+		if msgBytes, err = compressPayload(msgBytes); err != nil {
+			return err
+		}
+	}
+
 	msgSize := uint32(len(msgBytes))
 	msgHeader := header{
 		payloadSize: msgSize,
+		compressed:  compressed,
 	}
 	if err = encodeHeader(msgHeader, buffer); err != nil {
 		return err
@@ -33,58 +60,132 @@ func sendMessage(msg message.Message, buffer []byte, writer io.Writer) error {
 	return nil
 }
 
-func receiveMessage(reader io.Reader, buffer []byte) (message.Message, error) {
-	limitedReader := io.LimitReader(reader, int64(headerSize))
-	if _, err := limitedReader.Read(buffer); err != nil {
-		return nil, err
+func receiveMessage(reader io.Reader, buffer []byte) (message.Message, envelope, error) {
+	if _, err := io.ReadFull(reader, buffer[:headerSize]); err != nil {
+		return nil, envelope{}, err
 	}
 
 	msgHeader, err := decodeHeader(buffer)
 	if err != nil {
-		return nil, err
+		return nil, envelope{}, err
+	}
+	if msgHeader.payloadSize > maxPayloadSize {
+		return nil, envelope{}, fmt.Errorf("message payload of %d bytes exceeds limit", msgHeader.payloadSize)
 	}
 
-	limitedReader = io.LimitReader(reader, int64(msgHeader.payloadSize))
-	if _, err = limitedReader.Read(buffer); err != nil {
-		return nil, err
+	payload := buffer
+	if int(msgHeader.payloadSize) > len(payload) {
+		payload = make([]byte, msgHeader.payloadSize)
+	}
+	payload = payload[:msgHeader.payloadSize]
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		return nil, envelope{}, err
+	}
+
+	if msgHeader.compressed {
+		if payload, err = decompressPayload(payload); err != nil {
+			return nil, envelope{}, err
+		}
 	}
 
 	msg := &netmsgpb.MessageWrapper{}
-	if err = proto.Unmarshal(buffer[:msgHeader.payloadSize], msg); err != nil {
+	if err = proto.Unmarshal(payload, msg); err != nil {
+		return nil, envelope{}, err
+	}
+
+	return fromProto(msg), envelope{
+		traceparent:        msg.GetTraceparent(),
+		acceptsCompression: slices.Contains(msg.GetAcceptMessageEncoding(), netmsgpb.Encoding(messageEncoding)),
+	}, nil
+}
+
+func compressPayload(payload []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	encoder, err := codec.NewWriter(messageEncoding, &compressed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = encoder.Write(payload); err != nil {
+		return nil, err
+	}
+	if err = encoder.Close(); err != nil {
 		return nil, err
 	}
+	return compressed.Bytes(), nil
+}
+
+func decompressPayload(payload []byte) ([]byte, error) {
+	decoder, err := codec.NewReader(messageEncoding, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.LoggedClose(decoder)
+	return io.ReadAll(io.LimitReader(decoder, maxPayloadSize))
+}
 
-	return fromProto(msg), nil
+const (
+	compressionThreshold = 4 * 1024
+	maxPayloadSize       = 64 * 1024 * 1024
+	messageEncoding      = codec.Gzip
+)
+
+// ToProto and FromProto convert between messages and their protobuf form for transports that
+// carry netmsgpb messages without the length-prefixed framing.
+func ToProto(msg message.Message) *netmsgpb.MessageWrapper {
+	wrapper := toProto(msg)
+	return &wrapper
+}
+
+func FromProto(wrapper *netmsgpb.MessageWrapper) message.Message {
+	return fromProto(wrapper)
 }
 
 func toProto(msg message.Message) netmsgpb.MessageWrapper {
 	switch msg := msg.(type) {
 	case message.GetFileRequest:
+		offset := int64(msg.Offset)
+		length := int64(msg.Length)
 		return netmsgpb.MessageWrapper{
 			Message: &netmsgpb.MessageWrapper_GetFileRequest{
 				GetFileRequest: &netmsgpb.GetFileRequest{
-					Filename: &msg.Filename,
+					Filename:       &msg.Filename,
+					AcceptEncoding: encodingsToProto(msg.AcceptEncodings),
+					Offset:         &offset,
+					Length:         &length,
 				},
 			},
 		}
 	case message.GetFileResponse:
 		status := int32(msg.Status)
 		size := int64(msg.Size)
+		encoding := netmsgpb.Encoding(msg.Encoding)
+		encodedSize := int64(msg.EncodedSize)
+		modTime := modTimeToProto(msg.ModTime)
 		return netmsgpb.MessageWrapper{
 			Message: &netmsgpb.MessageWrapper_GetFileResponse{
 				GetFileResponse: &netmsgpb.GetFileResponse{
-					Status: &status,
-					Size:   &size,
+					Status:      &status,
+					Size:        &size,
+					Encoding:    &encoding,
+					EncodedSize: &encodedSize,
+					ModTime:     &modTime,
 				},
 			},
 		}
 	case message.PutFileRequest:
 		size := int64(msg.Size)
+		encoding := netmsgpb.Encoding(msg.Encoding)
+		encodedSize := int64(msg.EncodedSize)
+		modTime := modTimeToProto(msg.ModTime)
 		return netmsgpb.MessageWrapper{
 			Message: &netmsgpb.MessageWrapper_PutFileRequest{
 				PutFileRequest: &netmsgpb.PutFileRequest{
-					Filename: &msg.Filename,
-					Size:     &size,
+					Filename:       &msg.Filename,
+					Size:           &size,
+					Encoding:       &encoding,
+					EncodedSize:    &encodedSize,
+					IdempotencyKey: &msg.IdempotencyKey,
+					ModTime:        &modTime,
 				},
 			},
 		}
@@ -101,7 +202,8 @@ func toProto(msg message.Message) netmsgpb.MessageWrapper {
 		return netmsgpb.MessageWrapper{
 			Message: &netmsgpb.MessageWrapper_DeleteFileRequest{
 				DeleteFileRequest: &netmsgpb.DeleteFileRequest{
-					Filename: &msg.Filename,
+					Filename:       &msg.Filename,
+					IdempotencyKey: &msg.IdempotencyKey,
 				},
 			},
 		}
@@ -132,6 +234,204 @@ func toProto(msg message.Message) netmsgpb.MessageWrapper {
 				},
 			},
 		}
+	case message.StatFileRequest:
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_StatFileRequest{
+				StatFileRequest: &netmsgpb.StatFileRequest{
+					Filename: &msg.Filename,
+					Checksum: &msg.Checksum,
+				},
+			},
+		}
+	case message.StatFileResponse:
+		status := int32(msg.Status)
+		size := int64(msg.Size)
+		modTime := modTimeToProto(msg.ModTime)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_StatFileResponse{
+				StatFileResponse: &netmsgpb.StatFileResponse{
+					Status:   &status,
+					Size:     &size,
+					ModTime:  &modTime,
+					Checksum: &msg.Checksum,
+				},
+			},
+		}
+	case message.MoveFileRequest:
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_MoveFileRequest{
+				MoveFileRequest: &netmsgpb.MoveFileRequest{
+					From: &msg.From,
+					To:   &msg.To,
+				},
+			},
+		}
+	case message.MoveFileResponse:
+		status := int32(msg.Status)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_MoveFileResponse{
+				MoveFileResponse: &netmsgpb.MoveFileResponse{
+					Status: &status,
+				},
+			},
+		}
+	case message.CopyFileRequest:
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_CopyFileRequest{
+				CopyFileRequest: &netmsgpb.CopyFileRequest{
+					From: &msg.From,
+					To:   &msg.To,
+				},
+			},
+		}
+	case message.CopyFileResponse:
+		status := int32(msg.Status)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_CopyFileResponse{
+				CopyFileResponse: &netmsgpb.CopyFileResponse{
+					Status: &status,
+				},
+			},
+		}
+	case message.CreateUploadRequest:
+		size := int64(msg.Size)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_CreateUploadRequest{
+				CreateUploadRequest: &netmsgpb.CreateUploadRequest{
+					Filename: &msg.Filename,
+					Size:     &size,
+				},
+			},
+		}
+	case message.CreateUploadResponse:
+		status := int32(msg.Status)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_CreateUploadResponse{
+				CreateUploadResponse: &netmsgpb.CreateUploadResponse{
+					Status:   &status,
+					UploadId: &msg.UploadID,
+				},
+			},
+		}
+	case message.PutPartRequest:
+		offset := int64(msg.Offset)
+		size := int64(msg.Size)
+		encoding := netmsgpb.Encoding(msg.Encoding)
+		encodedSize := int64(msg.EncodedSize)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_PutPartRequest{
+				PutPartRequest: &netmsgpb.PutPartRequest{
+					UploadId:    &msg.UploadID,
+					Offset:      &offset,
+					Size:        &size,
+					Encoding:    &encoding,
+					EncodedSize: &encodedSize,
+				},
+			},
+		}
+	case message.PutPartResponse:
+		status := int32(msg.Status)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_PutPartResponse{
+				PutPartResponse: &netmsgpb.PutPartResponse{
+					Status: &status,
+				},
+			},
+		}
+	case message.CompleteUploadRequest:
+		modTime := modTimeToProto(msg.ModTime)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_CompleteUploadRequest{
+				CompleteUploadRequest: &netmsgpb.CompleteUploadRequest{
+					UploadId:       &msg.UploadID,
+					Checksum:       &msg.Checksum,
+					IdempotencyKey: &msg.IdempotencyKey,
+					ModTime:        &modTime,
+				},
+			},
+		}
+	case message.CompleteUploadResponse:
+		status := int32(msg.Status)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_CompleteUploadResponse{
+				CompleteUploadResponse: &netmsgpb.CompleteUploadResponse{
+					Status: &status,
+				},
+			},
+		}
+	case message.AbortUploadRequest:
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_AbortUploadRequest{
+				AbortUploadRequest: &netmsgpb.AbortUploadRequest{
+					UploadId: &msg.UploadID,
+				},
+			},
+		}
+	case message.AbortUploadResponse:
+		status := int32(msg.Status)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_AbortUploadResponse{
+				AbortUploadResponse: &netmsgpb.AbortUploadResponse{
+					Status: &status,
+				},
+			},
+		}
+	case message.GetSignatureRequest:
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_GetSignatureRequest{
+				GetSignatureRequest: &netmsgpb.GetSignatureRequest{
+					Filename: &msg.Filename,
+				},
+			},
+		}
+	case message.GetSignatureResponse:
+		status := int32(msg.Status)
+		size := int64(msg.Size)
+		blockSize := int64(msg.BlockSize)
+		weakSums := make([]uint32, len(msg.Blocks))
+		strongSums := make([][]byte, len(msg.Blocks))
+		for i, block := range msg.Blocks {
+			weakSums[i] = block.Weak
+			strongSums[i] = block.Strong[:]
+		}
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_GetSignatureResponse{
+				GetSignatureResponse: &netmsgpb.GetSignatureResponse{
+					Status:     &status,
+					Size:       &size,
+					BlockSize:  &blockSize,
+					WeakSums:   weakSums,
+					StrongSums: strongSums,
+				},
+			},
+		}
+	case message.PutDeltaRequest:
+		blockSize := int64(msg.BlockSize)
+		size := int64(msg.Size)
+		deltaSize := int64(msg.DeltaSize)
+		modTime := modTimeToProto(msg.ModTime)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_PutDeltaRequest{
+				PutDeltaRequest: &netmsgpb.PutDeltaRequest{
+					Filename:       &msg.Filename,
+					BlockSize:      &blockSize,
+					Size:           &size,
+					DeltaSize:      &deltaSize,
+					Checksum:       &msg.Checksum,
+					IdempotencyKey: &msg.IdempotencyKey,
+					ModTime:        &modTime,
+				},
+			},
+		}
+	case message.PutDeltaResponse:
+		status := int32(msg.Status)
+		return netmsgpb.MessageWrapper{
+			Message: &netmsgpb.MessageWrapper_PutDeltaResponse{
+				PutDeltaResponse: &netmsgpb.PutDeltaResponse{
+					Status: &status,
+				},
+			},
+		}
 	default:
 		panic(fmt.Sprintf("unexpected message type %T", msg))
 	}
@@ -142,19 +442,29 @@ func fromProto(wrapper *netmsgpb.MessageWrapper) message.Message {
 	case *netmsgpb.MessageWrapper_GetFileRequest:
 		req := msg.GetFileRequest
 		return message.GetFileRequest{
-			Filename: req.GetFilename(),
+			Filename:        req.GetFilename(),
+			AcceptEncodings: encodingsFromProto(req.GetAcceptEncoding()),
+			Offset:          int(req.GetOffset()),
+			Length:          int(req.GetLength()),
 		}
 	case *netmsgpb.MessageWrapper_GetFileResponse:
 		req := msg.GetFileResponse
 		return message.GetFileResponse{
-			Status: int(req.GetStatus()),
-			Size:   int(req.GetSize()),
+			Status:      int(req.GetStatus()),
+			Size:        int(req.GetSize()),
+			Encoding:    codec.Encoding(req.GetEncoding()),
+			EncodedSize: int(req.GetEncodedSize()),
+			ModTime:     modTimeFromProto(req.GetModTime()),
 		}
 	case *netmsgpb.MessageWrapper_PutFileRequest:
 		req := msg.PutFileRequest
 		return message.PutFileRequest{
-			Filename: req.GetFilename(),
-			Size:     int(req.GetSize()),
+			Filename:       req.GetFilename(),
+			Size:           int(req.GetSize()),
+			Encoding:       codec.Encoding(req.GetEncoding()),
+			EncodedSize:    int(req.GetEncodedSize()),
+			IdempotencyKey: req.GetIdempotencyKey(),
+			ModTime:        modTimeFromProto(req.GetModTime()),
 		}
 	case *netmsgpb.MessageWrapper_PutFileResponse:
 		req := msg.PutFileResponse
@@ -164,7 +474,8 @@ func fromProto(wrapper *netmsgpb.MessageWrapper) message.Message {
 	case *netmsgpb.MessageWrapper_DeleteFileRequest:
 		req := msg.DeleteFileRequest
 		return message.DeleteFileRequest{
-			Filename: req.GetFilename(),
+			Filename:       req.GetFilename(),
+			IdempotencyKey: req.GetIdempotencyKey(),
 		}
 	case *netmsgpb.MessageWrapper_DeleteFileResponse:
 		req := msg.DeleteFileResponse
@@ -182,7 +493,160 @@ func fromProto(wrapper *netmsgpb.MessageWrapper) message.Message {
 			Status:    int(req.GetStatus()),
 			Filenames: req.GetFilename(),
 		}
+	case *netmsgpb.MessageWrapper_StatFileRequest:
+		req := msg.StatFileRequest
+		return message.StatFileRequest{
+			Filename: req.GetFilename(),
+			Checksum: req.GetChecksum(),
+		}
+	case *netmsgpb.MessageWrapper_StatFileResponse:
+		res := msg.StatFileResponse
+		return message.StatFileResponse{
+			Status:   int(res.GetStatus()),
+			Size:     int(res.GetSize()),
+			ModTime:  modTimeFromProto(res.GetModTime()),
+			Checksum: res.GetChecksum(),
+		}
+	case *netmsgpb.MessageWrapper_MoveFileRequest:
+		req := msg.MoveFileRequest
+		return message.MoveFileRequest{
+			From: req.GetFrom(),
+			To:   req.GetTo(),
+		}
+	case *netmsgpb.MessageWrapper_MoveFileResponse:
+		return message.MoveFileResponse{
+			Status: int(msg.MoveFileResponse.GetStatus()),
+		}
+	case *netmsgpb.MessageWrapper_CopyFileRequest:
+		req := msg.CopyFileRequest
+		return message.CopyFileRequest{
+			From: req.GetFrom(),
+			To:   req.GetTo(),
+		}
+	case *netmsgpb.MessageWrapper_CopyFileResponse:
+		return message.CopyFileResponse{
+			Status: int(msg.CopyFileResponse.GetStatus()),
+		}
+	case *netmsgpb.MessageWrapper_CreateUploadRequest:
+		req := msg.CreateUploadRequest
+		return message.CreateUploadRequest{
+			Filename: req.GetFilename(),
+			Size:     int(req.GetSize()),
+		}
+	case *netmsgpb.MessageWrapper_CreateUploadResponse:
+		res := msg.CreateUploadResponse
+		return message.CreateUploadResponse{
+			Status:   int(res.GetStatus()),
+			UploadID: res.GetUploadId(),
+		}
+	case *netmsgpb.MessageWrapper_PutPartRequest:
+		req := msg.PutPartRequest
+		return message.PutPartRequest{
+			UploadID:    req.GetUploadId(),
+			Offset:      int(req.GetOffset()),
+			Size:        int(req.GetSize()),
+			Encoding:    codec.Encoding(req.GetEncoding()),
+			EncodedSize: int(req.GetEncodedSize()),
+		}
+	case *netmsgpb.MessageWrapper_PutPartResponse:
+		return message.PutPartResponse{
+			Status: int(msg.PutPartResponse.GetStatus()),
+		}
+	case *netmsgpb.MessageWrapper_CompleteUploadRequest:
+		req := msg.CompleteUploadRequest
+		return message.CompleteUploadRequest{
+			UploadID:       req.GetUploadId(),
+			Checksum:       req.GetChecksum(),
+			IdempotencyKey: req.GetIdempotencyKey(),
+			ModTime:        modTimeFromProto(req.GetModTime()),
+		}
+	case *netmsgpb.MessageWrapper_CompleteUploadResponse:
+		return message.CompleteUploadResponse{
+			Status: int(msg.CompleteUploadResponse.GetStatus()),
+		}
+	case *netmsgpb.MessageWrapper_AbortUploadRequest:
+		return message.AbortUploadRequest{
+			UploadID: msg.AbortUploadRequest.GetUploadId(),
+		}
+	case *netmsgpb.MessageWrapper_AbortUploadResponse:
+		return message.AbortUploadResponse{
+			Status: int(msg.AbortUploadResponse.GetStatus()),
+		}
+	case *netmsgpb.MessageWrapper_GetSignatureRequest:
+		return message.GetSignatureRequest{
+			Filename: msg.GetSignatureRequest.GetFilename(),
+		}
+	case *netmsgpb.MessageWrapper_GetSignatureResponse:
+		res := msg.GetSignatureResponse
+		return message.GetSignatureResponse{
+			Status:    int(res.GetStatus()),
+			Size:      int(res.GetSize()),
+			BlockSize: int(res.GetBlockSize()),
+			Blocks:    blocksFromProto(res.GetWeakSums(), res.GetStrongSums()),
+		}
+	case *netmsgpb.MessageWrapper_PutDeltaRequest:
+		req := msg.PutDeltaRequest
+		return message.PutDeltaRequest{
+			Filename:       req.GetFilename(),
+			BlockSize:      int(req.GetBlockSize()),
+			Size:           int(req.GetSize()),
+			DeltaSize:      int(req.GetDeltaSize()),
+			Checksum:       req.GetChecksum(),
+			IdempotencyKey: req.GetIdempotencyKey(),
+			ModTime:        modTimeFromProto(req.GetModTime()),
+		}
+	case *netmsgpb.MessageWrapper_PutDeltaResponse:
+		return message.PutDeltaResponse{
+			Status: int(msg.PutDeltaResponse.GetStatus()),
+		}
 	default:
 		panic(fmt.Sprintf("unexpected message type %T", msg))
 	}
 }
+
+// blocksFromProto pairs the checksums of the blocks, a block missing either one is left out.
+func blocksFromProto(weakSums []uint32, strongSums [][]byte) []delta.Block {
+	blocks := make([]delta.Block, min(len(weakSums), len(strongSums)))
+	for i := range blocks {
+		blocks[i].Weak = weakSums[i]
+		copy(blocks[i].Strong[:], strongSums[i])
+	}
+	return blocks
+}
+
+// modTimeToProto and modTimeFromProto keep the zero time apart from the Unix epoch.
+func modTimeToProto(modTime time.Time) int64 {
+	if modTime.IsZero() {
+		return 0
+	}
+	return modTime.UnixNano()
+}
+
+func modTimeFromProto(modTime int64) time.Time {
+	if modTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, modTime)
+}
+
+func encodingsToProto(encodings []codec.Encoding) []netmsgpb.Encoding {
+	if len(encodings) == 0 {
+		return nil
+	}
+	out := make([]netmsgpb.Encoding, len(encodings))
+	for i, encoding := range encodings {
+		out[i] = netmsgpb.Encoding(encoding)
+	}
+	return out
+}
+
+func encodingsFromProto(encodings []netmsgpb.Encoding) []codec.Encoding {
+	if len(encodings) == 0 {
+		return nil
+	}
+	out := make([]codec.Encoding, len(encodings))
+	for i, encoding := range encodings {
+		out[i] = codec.Encoding(encoding)
+	}
+	return out
+}
@@ -2,48 +2,174 @@ package netmsg
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
 	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"github.com/mat-sik/file-server-go/internal/tracing"
 	"io"
 	"net"
+	"os"
+	"sync/atomic"
+	"time"
 )
 
 type Session struct {
 	conn   io.ReadWriteCloser
 	buffer []byte
+	// peerAcceptsCompression is set once the peer told that it decodes compressed messages.
+	peerAcceptsCompression *atomic.Bool
 }
 
-func (s Session) SendMessage(msg message.Message) error {
-	return sendMessage(msg, s.buffer, s.conn)
+// SendMessage sends msg along with the trace context of the span in ctx, if there is one. Large
+// messages are compressed once the peer sent a message telling that it accepts them so.
+func (s Session) SendMessage(ctx context.Context, msg message.Message) error {
+	compress := s.peerAcceptsCompression != nil && s.peerAcceptsCompression.Load()
+	return sendMessage(msg, tracing.SpanContextFromContext(ctx).Traceparent(), compress, s.buffer, s.conn)
 }
 
-func (s Session) ReceiveMessage() (message.Message, error) {
-	return receiveMessage(s.conn, s.buffer)
+// ReceiveMessage receives the next message. The returned context carries the trace context the
+// peer sent with it, spans started from it continue the peer's trace.
+func (s Session) ReceiveMessage(ctx context.Context) (context.Context, message.Message, error) {
+	msg, envelope, err := receiveMessage(s.conn, s.buffer)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if envelope.acceptsCompression && s.peerAcceptsCompression != nil {
+		s.peerAcceptsCompression.Store(true)
+	}
+	if sc, ok := tracing.ParseTraceparent(envelope.traceparent); ok {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	return ctx, msg, nil
 }
 
-func (s Session) StreamToNet(ctx context.Context, reader io.Reader, toTransfer int) error {
-	if err := ctx.Err(); err != nil {
+// StreamToNet, StreamFromNet and DecodeFromNet transfer no faster than the throttle.Limiter of
+// ctx allows, if it has one.
+func (s Session) StreamToNet(ctx context.Context, reader io.Reader, toTransfer int) (err error) {
+	if err = ctx.Err(); err != nil {
 		return err
 	}
+	_, span := tracing.StartChild(ctx, "net.stream_out", tracing.Int("bytes", toTransfer))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	limitedReader := io.LimitReader(reader, int64(toTransfer))
-	_, err := io.CopyBuffer(s.conn, limitedReader, s.buffer)
+	_, err = io.CopyBuffer(throttle.FromContext(ctx).Writer(ctx, s.conn), limitedReader, s.buffer)
 	return err
 }
 
-func (s Session) StreamFromNet(ctx context.Context, writer io.Writer, toTransfer int) error {
-	if err := ctx.Err(); err != nil {
+func (s Session) StreamFromNet(ctx context.Context, writer io.Writer, toTransfer int) (err error) {
+	if err = ctx.Err(); err != nil {
 		return err
 	}
-	limitedReader := io.LimitReader(s.conn, int64(toTransfer))
-	_, err := io.CopyBuffer(writer, limitedReader, s.buffer)
+	_, span := tracing.StartChild(ctx, "net.stream_in", tracing.Int("bytes", toTransfer))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	limitedReader := io.LimitReader(throttle.FromContext(ctx).Reader(ctx, s.conn), int64(toTransfer))
+	_, err = io.CopyBuffer(writer, limitedReader, s.buffer)
 	return err
 }
 
+// DecodeFromNet reads toTransfer encoded bytes from the connection and writes them decoded
+// into writer, returning the decoded length. The whole encoded payload is always consumed so
+// that the next message can be read from the connection. Decoding fails with codec.ErrTooLarge
+// once it yields more than size bytes.
+func (s Session) DecodeFromNet(
+	ctx context.Context,
+	writer io.Writer,
+	encoding codec.Encoding,
+	toTransfer int,
+	size int,
+) (written int, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	_, span := tracing.StartChild(ctx, "net.stream_in", tracing.Int("bytes", toTransfer), tracing.String("encoding", encoding.String()))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	limitedReader := io.LimitReader(throttle.FromContext(ctx).Reader(ctx, s.conn), int64(toTransfer))
+	decoder, err := codec.NewReader(encoding, limitedReader)
+	if err != nil {
+		return 0, err
+	}
+	defer fsutil.LoggedClose(decoder)
+
+	decoded, err := io.CopyBuffer(writer, io.LimitReader(decoder, int64(size)+1), s.buffer)
+	if err == nil && decoded > int64(size) {
+		err = fmt.Errorf("%w: more than %d bytes", codec.ErrTooLarge, size)
+	}
+	if err != nil {
+		return int(decoded), err
+	}
+	_, err = io.Copy(io.Discard, limitedReader)
+	return int(decoded), err
+}
+
+func (s Session) Close() error {
+	return s.conn.Close()
+}
+
 func NewSession(conn net.Conn) Session {
 	buffer := make([]byte, bufferSize)
 	return Session{
-		conn:   conn,
-		buffer: buffer,
+		conn:                   conn,
+		buffer:                 buffer,
+		peerAcceptsCompression: &atomic.Bool{},
+	}
+}
+
+// NewSessionWithTimeouts returns a session that fails once a read or write on conn makes no
+// progress for ioTimeout, or once its peer sends nothing for idleTimeout after Idle was called.
+func NewSessionWithTimeouts(conn net.Conn, idleTimeout time.Duration, ioTimeout time.Duration) Session {
+	return NewSession(&deadlineConn{
+		Conn:        conn,
+		idleTimeout: idleTimeout,
+		ioTimeout:   ioTimeout,
+	})
+}
+
+// Idle marks the session as waiting for the next message of its peer, receiving the first bytes
+// of it may take up to the idle timeout. Reading from a Session is not safe for concurrent use,
+// neither is calling Idle.
+func (s Session) Idle() {
+	if conn, ok := s.conn.(*deadlineConn); ok {
+		conn.idle = true
 	}
 }
 
-const bufferSize = 4 * 1024
+// Healthy reports whether the connection is still open and holds no unread data, as it does in
+// between requests. It waits up to healthCheckWait for the close of the peer to show.
+func (s Session) Healthy() bool {
+	var conn net.Conn
+	switch c := s.conn.(type) {
+	case *deadlineConn:
+		conn = c.Conn
+	case net.Conn:
+		conn = c
+	default:
+		return true
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(healthCheckWait)); err != nil {
+		return false
+	}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
+
+const (
+	bufferSize      = 4 * 1024
+	healthCheckWait = time.Millisecond
+)
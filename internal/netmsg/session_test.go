@@ -2,9 +2,17 @@ package netmsg
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/delta"
 	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/tracing"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_should_SendMessage_And_ReceiveIt(t *testing.T) {
@@ -18,6 +26,113 @@ func Test_should_SendMessage_And_ReceiveIt(t *testing.T) {
 		{name: "GET File Response", message: message.GetFileResponse{Status: 200, Size: 404}},
 		{name: "DELETE File Request", message: message.DeleteFileRequest{Filename: "foo.txt"}},
 		{name: "DELETE File Response", message: message.DeleteFileResponse{Status: 200}},
+		{
+			name: "GET File Request with encodings",
+			message: message.GetFileRequest{
+				Filename:        "foo.txt",
+				AcceptEncodings: []codec.Encoding{codec.Zstd, codec.Gzip},
+			},
+		},
+		{
+			name: "GET File Response with encoding",
+			message: message.GetFileResponse{
+				Status:      200,
+				Size:        404,
+				Encoding:    codec.Zstd,
+				EncodedSize: 42,
+			},
+		},
+		{
+			name: "PUT File Request with encoding",
+			message: message.PutFileRequest{
+				Filename:    "foo.txt",
+				Size:        404,
+				Encoding:    codec.Gzip,
+				EncodedSize: 42,
+			},
+		},
+		{
+			name:    "PUT File Request with idempotency key",
+			message: message.PutFileRequest{Filename: "foo.txt", Size: 404, IdempotencyKey: "4f1c"},
+		},
+		{
+			name:    "DELETE File Request with idempotency key",
+			message: message.DeleteFileRequest{Filename: "foo.txt", IdempotencyKey: "4f1c"},
+		},
+		{name: "STAT File Request", message: message.StatFileRequest{Filename: "foo.txt"}},
+		{name: "STAT File Request with checksum", message: message.StatFileRequest{Filename: "foo.txt", Checksum: true}},
+		{
+			name: "STAT File Response with checksum",
+			message: message.StatFileResponse{
+				Status:   200,
+				Size:     404,
+				ModTime:  time.Unix(0, 1700000000123456789),
+				Checksum: "e3b0c442",
+			},
+		},
+		{
+			name:    "PUT File Request with modification time",
+			message: message.PutFileRequest{Filename: "foo.txt", Size: 404, ModTime: time.Unix(0, 1700000000123456789)},
+		},
+		{
+			name:    "STAT File Response",
+			message: message.StatFileResponse{Status: 200, Size: 404, ModTime: time.Unix(0, 1700000000123456789)},
+		},
+		{name: "MOVE File Request", message: message.MoveFileRequest{From: "foo.txt", To: "bar.txt"}},
+		{name: "MOVE File Response", message: message.MoveFileResponse{Status: 409}},
+		{name: "COPY File Request", message: message.CopyFileRequest{From: "foo.txt", To: "bar.txt"}},
+		{name: "COPY File Response", message: message.CopyFileResponse{Status: 201}},
+		{
+			name:    "Ranged GET File Request",
+			message: message.GetFileRequest{Filename: "foo.txt", Offset: 1 << 33, Length: 8 << 20},
+		},
+		{
+			name:    "Ranged GET File Response",
+			message: message.GetFileResponse{Status: 206, Size: 404, ModTime: time.Unix(0, 1700000000123456789)},
+		},
+		{name: "CREATE Upload Request", message: message.CreateUploadRequest{Filename: "foo.txt", Size: 1 << 34}},
+		{name: "CREATE Upload Response", message: message.CreateUploadResponse{Status: 200, UploadID: "9a0e"}},
+		{name: "PUT Part Request", message: message.PutPartRequest{UploadID: "9a0e", Offset: 1 << 33, Size: 404}},
+		{name: "PUT Part Response", message: message.PutPartResponse{Status: 200}},
+		{
+			name: "COMPLETE Upload Request",
+			message: message.CompleteUploadRequest{
+				UploadID:       "9a0e",
+				Checksum:       "e3b0c442",
+				IdempotencyKey: "4f1c",
+				ModTime:        time.Unix(0, 1700000000123456789),
+			},
+		},
+		{name: "COMPLETE Upload Response", message: message.CompleteUploadResponse{Status: 422}},
+		{name: "ABORT Upload Request", message: message.AbortUploadRequest{UploadID: "9a0e"}},
+		{name: "ABORT Upload Response", message: message.AbortUploadResponse{Status: 404}},
+		{name: "GET Signature Request", message: message.GetSignatureRequest{Filename: "foo.txt"}},
+		{
+			name: "GET Signature Response",
+			message: message.GetSignatureResponse{
+				Status:    200,
+				Size:      3000,
+				BlockSize: 2048,
+				Blocks:    []delta.Block{{Weak: 0x1234abcd, Strong: [32]byte{1, 2, 3}}, {Weak: 7, Strong: [32]byte{31: 9}}},
+			},
+		},
+		{
+			name: "PUT Delta Request",
+			message: message.PutDeltaRequest{
+				Filename:       "foo.txt",
+				BlockSize:      2048,
+				Size:           4000,
+				DeltaSize:      120,
+				Checksum:       "e3b0c442",
+				IdempotencyKey: "4f1c",
+				ModTime:        time.Unix(0, 1700000000123456789),
+			},
+		},
+		{name: "PUT Delta Response", message: message.PutDeltaResponse{Status: 409}},
+		{
+			name:    "Large GET Filenames Response",
+			message: message.GetFilenamesResponse{Status: 200, Filenames: manyFilenames(1000)},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -30,11 +145,11 @@ func Test_should_SendMessage_And_ReceiveIt(t *testing.T) {
 				buffer: buffer,
 			}
 
-			if err := session.SendMessage(tc.message); err != nil {
+			if err := session.SendMessage(context.Background(), tc.message); err != nil {
 				t.Fatal(err)
 			}
 
-			out, err := session.ReceiveMessage()
+			_, out, err := session.ReceiveMessage(context.Background())
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -46,6 +161,132 @@ func Test_should_SendMessage_And_ReceiveIt(t *testing.T) {
 	}
 }
 
+func Test_should_DecodeFromNet(t *testing.T) {
+	payload := bytes.Repeat([]byte("xyz"), 10*1024)
+	trailer := message.DeleteFileRequest{Filename: "after.txt"}
+
+	spooled, err := codec.Spool(codec.Zstd, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spooled.Close()
+
+	readWriteCloser := &mockReadWriteCloser{Buffer: *bytes.NewBuffer(make([]byte, 0, 1024))}
+	session := Session{
+		conn:   readWriteCloser,
+		buffer: make([]byte, 1024),
+	}
+
+	ctx := context.Background()
+	if err = session.StreamToNet(ctx, spooled, spooled.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.SendMessage(ctx, trailer); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	written, err := session.DecodeFromNet(ctx, &out, codec.Zstd, spooled.Size(), len(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != len(payload) || !bytes.Equal(out.Bytes(), payload) {
+		t.Fatalf("got %d decoded bytes, want %d", written, len(payload))
+	}
+
+	_, msg, err := session.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, trailer) {
+		t.Fatalf("got %v want %v", msg, trailer)
+	}
+}
+
+func Test_should_CompressLargeMessages_Only_When_PeerAcceptsThem(t *testing.T) {
+	conn := &mockReadWriteCloser{Buffer: *bytes.NewBuffer(make([]byte, 0, 1024))}
+	session := Session{conn: conn, buffer: make([]byte, 1024), peerAcceptsCompression: &atomic.Bool{}}
+	large := message.GetFilenamesResponse{Status: 200, Filenames: manyFilenames(1000)}
+	ctx := context.Background()
+
+	for _, wantCompressed := range []bool{false, true} {
+		if err := session.SendMessage(ctx, large); err != nil {
+			t.Fatal(err)
+		}
+		msgHeader, err := decodeHeader(conn.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgHeader.compressed != wantCompressed {
+			t.Fatalf("sent compressed %v want %v", msgHeader.compressed, wantCompressed)
+		}
+		// Receiving its own message, the session learns that its peer accepts compression.
+		_, out, err := session.ReceiveMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, large) {
+			t.Fatalf("got %T want %T", out, large)
+		}
+	}
+}
+
+func Test_should_StopDecoding_When_PayloadExpandsPastDeclaredSize(t *testing.T) {
+	payload := make([]byte, 1024*1024)
+	spooled, err := codec.Spool(codec.Gzip, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spooled.Close()
+
+	session := Session{
+		conn:   &mockReadWriteCloser{Buffer: *bytes.NewBuffer(make([]byte, 0, 1024))},
+		buffer: make([]byte, 1024),
+	}
+	ctx := context.Background()
+	if err = session.StreamToNet(ctx, spooled, spooled.Size()); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	written, err := session.DecodeFromNet(ctx, &out, codec.Gzip, spooled.Size(), 1000)
+	if !errors.Is(err, codec.ErrTooLarge) {
+		t.Fatalf("got %v want %v", err, codec.ErrTooLarge)
+	}
+	if written > 1001 || out.Len() > 1001 {
+		t.Fatalf("decoded %d bytes past the declared 1000", written)
+	}
+}
+
+func Test_should_PropagateTraceContext(t *testing.T) {
+	session := Session{
+		conn:   &mockReadWriteCloser{Buffer: *bytes.NewBuffer(make([]byte, 0, 1024))},
+		buffer: make([]byte, 1024),
+	}
+	ctx, span := tracing.Start(context.Background(), "test")
+	defer span.End()
+
+	if err := session.SendMessage(ctx, message.DeleteFileRequest{Filename: "foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	received, _, err := session.ReceiveMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tracing.SpanContextFromContext(received), tracing.SpanContextFromContext(ctx); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func manyFilenames(n int) []string {
+	filenames := make([]string, n)
+	for i := range filenames {
+		filenames[i] = fmt.Sprintf("file-%04d.txt", i)
+	}
+	return filenames
+}
+
 type mockReadWriteCloser struct {
 	bytes.Buffer
 }
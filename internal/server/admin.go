@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// serveAdmin refuses to serve without a token, the admin API changes limits every client is
+// subject to.
+func serveAdmin(ctx context.Context, listener net.Listener, h handler) error {
+	if envs.ServerAdminToken == "" {
+		return errors.New("the admin frontend needs a token, set SERVER_ADMIN_TOKEN")
+	}
+	return serveHTTPHandler(ctx, listener, newAdminHandler(h.throttler, envs.ServerAdminToken), h.limits)
+}
+
+// newAdminHandler serves the operations that change a running server. Requests must carry token
+// as a bearer token.
+func newAdminHandler(throttler *throttle.Throttler, token string) http.Handler {
+	ah := adminHandler{throttler: throttler}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /throttle", ah.getThrottle)
+	mux.HandleFunc("PUT /throttle", ah.putThrottle)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			writeStatus(w, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+type adminHandler struct {
+	throttler *throttle.Throttler
+}
+
+func (ah adminHandler) getThrottle(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, ah.throttler.Limits())
+}
+
+// putThrottle replaces all transfer rate limits, transfers in progress slow down or speed up
+// right away.
+func (ah adminHandler) putThrottle(w http.ResponseWriter, r *http.Request) {
+	var limits throttle.Limits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := limits.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ah.throttler.SetLimits(limits)
+	slog.Info("Changed throttle limits", "global", limits.Global, "perPrincipal", limits.PerPrincipal, "classes", limits.Classes)
+	writeJSON(w, limits)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/delta"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// A delta upload sends a new version of a file as instructions that copy blocks of the stored
+// version, which the client found with the signature of the stored version, and literal data in
+// between. The instructions are staged first, so that the file is write locked only while it is
+// rebuilt and not while they arrive.
+
+func (h handler) handleGetSignatureRequest(
+	ctx context.Context,
+	req message.GetSignatureRequest,
+) (res message.GetSignatureResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("get_signature", res.Status, err)
+	}()
+
+	if !files.ValidFilename(req.Filename) {
+		return message.GetSignatureResponse{
+			Status: http.StatusBadRequest,
+		}, nil
+	}
+	fileHandle, ok := h.syncService.GetFile(req.Filename)
+	if !ok {
+		return message.GetSignatureResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+
+	file, err := fileHandle.NewReadLockedFile(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return message.GetSignatureResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		return message.GetSignatureResponse{}, err
+	}
+	defer fsutil.LoggedClose(file)
+
+	size, err := file.Size()
+	if err != nil {
+		return message.GetSignatureResponse{}, err
+	}
+	sig, err := delta.Sign(file, size, delta.BlockSize(size))
+	if err != nil {
+		return message.GetSignatureResponse{}, err
+	}
+	return message.GetSignatureResponse{
+		Status:    http.StatusOK,
+		Size:      sig.Size,
+		BlockSize: sig.BlockSize,
+		Blocks:    sig.Blocks,
+	}, nil
+}
+
+// handlePutDeltaRequest rebuilds the file out of its stored version and the instructions that
+// follow the request. Instructions referring to blocks the stored version does not have are
+// answered with 409 and a rebuilt file that does not match the checksum with 422, both mean that the
+// file changed since the client read its signature. The file is left as it was in either case.
+func (h handler) handlePutDeltaRequest(
+	ctx context.Context,
+	receiver payloadReceiver,
+	req message.PutDeltaRequest,
+) (res message.PutDeltaResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("put_delta", res.Status, err)
+	}()
+
+	status, replay, finish, err := h.idempotency.begin(ctx, "put_delta", req.Filename, req.IdempotencyKey)
+	if err != nil {
+		return message.PutDeltaResponse{}, err
+	}
+	if replay {
+		if err = receiver.StreamFromNet(ctx, io.Discard, req.TransferSize()); err != nil {
+			return message.PutDeltaResponse{}, err
+		}
+		return message.PutDeltaResponse{Status: status}, nil
+	}
+	defer func() {
+		finish(res.Status, err)
+	}()
+
+	status = validatePutDeltaRequest(req)
+	if status == http.StatusOK && h.leased(req.Filename) {
+		status = http.StatusLocked
+	}
+	if _, ok := h.syncService.GetFile(req.Filename); status == http.StatusOK && !ok {
+		status = http.StatusNotFound
+	}
+	if status != http.StatusOK {
+		if err := receiver.StreamFromNet(ctx, io.Discard, req.TransferSize()); err != nil {
+			return message.PutDeltaResponse{}, err
+		}
+		return message.PutDeltaResponse{
+			Status: status,
+		}, nil
+	}
+
+	staging := h.syncService.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		return message.PutDeltaResponse{}, err
+	}
+	defer func() {
+		if err := staging.Remove(uploadID); err != nil {
+			slog.Warn("Removing staged delta failed", "uploadId", uploadID, "err", err)
+		}
+	}()
+
+	err = staging.Store(uploadID, deltaPiece, func(writer io.Writer) error {
+		return receiver.StreamFromNet(ctx, writer, req.TransferSize())
+	})
+	if err != nil {
+		return message.PutDeltaResponse{}, err
+	}
+	err = staging.Patch(ctx, uploadID, req.Filename, func(base io.ReadSeeker, writer io.Writer) error {
+		return rebuild(writer, base, staging, uploadID, req)
+	})
+	if status = deltaFailureStatus(err); status != 0 {
+		return message.PutDeltaResponse{
+			Status: status,
+		}, nil
+	}
+	if err != nil {
+		return message.PutDeltaResponse{}, err
+	}
+	if !req.ModTime.IsZero() {
+		if err = h.syncService.AddFile(req.Filename).SetModTime(ctx, req.ModTime); err != nil {
+			return message.PutDeltaResponse{}, err
+		}
+	}
+	return message.PutDeltaResponse{
+		Status: http.StatusCreated,
+	}, nil
+}
+
+func validatePutDeltaRequest(req message.PutDeltaRequest) int {
+	if !files.ValidFilename(req.Filename) || req.BlockSize <= 0 || req.Size < 0 || req.DeltaSize < 0 {
+		return http.StatusBadRequest
+	}
+	if !validChecksum(req.Checksum) {
+		return http.StatusBadRequest
+	}
+	return http.StatusOK
+}
+
+// rebuild writes the file the staged instructions rebuild out of base, which must match the
+// checksum of the request.
+func rebuild(writer io.Writer, base io.ReadSeeker, staging *files.Staging, uploadID string, req message.PutDeltaRequest) error {
+	instructions, err := staging.Open(uploadID, deltaPiece)
+	if err != nil {
+		return err
+	}
+	defer fsutil.LoggedClose(instructions)
+
+	digest := sha256.New()
+	written, err := delta.Apply(io.MultiWriter(writer, digest), base, req.BlockSize, instructions, int64(req.Size))
+	if err != nil {
+		return err
+	}
+	if written != int64(req.Size) {
+		return fmt.Errorf("%w: rebuilt %d bytes for a file of %d bytes", errChecksumMismatch, written, req.Size)
+	}
+	if hex.EncodeToString(digest.Sum(nil)) != strings.ToLower(req.Checksum) {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// deltaFailureStatus returns the status of a delta that could not be applied, 0 for other errors.
+func deltaFailureStatus(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, delta.ErrMalformed):
+		return http.StatusBadRequest
+	case errors.Is(err, delta.ErrUnknownBlock):
+		return http.StatusConflict
+	case errors.Is(err, errChecksumMismatch), errors.Is(err, delta.ErrTooLong):
+		return http.StatusUnprocessableEntity
+	}
+	return 0
+}
+
+const deltaPiece = "delta"
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/mat-sik/file-server-go/internal/files"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Frontends that present the store as a file system show it as a single directory at "/".
+
+func statStoredFile(syncService *files.SyncService, filename string) (os.FileInfo, error) {
+	fileHandle, ok := syncService.GetFile(filename)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	info, err := fileHandle.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return storedFileInfo{FileInfo: info}, nil
+}
+
+func isRootPath(name string) bool {
+	return name == "" || name == "/"
+}
+
+// rootFilename maps a path of a frontend with directories onto a stored filename, only direct children of the root exist.
+func rootFilename(name string) (string, bool) {
+	filename := strings.TrimPrefix(path.Clean(name), "/")
+	return filename, files.ValidFilename(filename)
+}
+
+type storedFileInfo struct {
+	files.FileInfo
+	dir bool
+}
+
+var rootInfo = storedFileInfo{FileInfo: files.FileInfo{Name: "/"}, dir: true}
+
+func (fi storedFileInfo) Name() string {
+	return fi.FileInfo.Name
+}
+
+func (fi storedFileInfo) Size() int64 {
+	return int64(fi.FileInfo.Size)
+}
+
+func (fi storedFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi storedFileInfo) ModTime() time.Time {
+	return fi.FileInfo.ModTime
+}
+
+func (fi storedFileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi storedFileInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/auth"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// frontend is an additional listener serving the same storage through a protocol other than
+// the native one. Its serve function must return once ctx is done.
+type frontend struct {
+	name     string
+	listener net.Listener
+	serve    func(ctx context.Context, listener net.Listener, h handler) error
+}
+
+// listenFrontends opens the listeners of all configured frontends, limiting their connections
+// with limiter. Metrics and admin are exempt, so that an overloaded server can still be observed
+// and reconfigured.
+func listenFrontends(limiter *connLimiter) ([]frontend, error) {
+	configs := []struct {
+		name      string
+		addr      string
+		serve     func(ctx context.Context, listener net.Listener, h handler) error
+		unlimited bool
+	}{
+		{name: "http", addr: envs.ServerHTTPAddr, serve: serveHTTP},
+		{name: "grpc", addr: envs.ServerGRPCAddr, serve: serveGRPC},
+		{name: "webdav", addr: envs.ServerWebDAVAddr, serve: serveWebDAV},
+		{name: "s3", addr: envs.ServerS3Addr, serve: serveS3},
+		{name: "sftp", addr: envs.ServerSFTPAddr, serve: serveSFTP},
+		{name: "websocket", addr: envs.ServerWebSocketAddr, serve: serveWebSocket},
+		{name: "metrics", addr: envs.ServerMetricsAddr, serve: serveMetrics, unlimited: true},
+		{name: "admin", addr: envs.ServerAdminAddr, serve: serveAdmin, unlimited: true},
+	}
+
+	var frontends []frontend
+	for _, config := range configs {
+		if config.addr == "" {
+			continue
+		}
+		listener, err := net.Listen("tcp4", config.addr)
+		if err != nil {
+			closeFrontends(frontends)
+			return nil, err
+		}
+		if !config.unlimited {
+			listener = limiter.limit(listener)
+		}
+		frontends = append(frontends, frontend{
+			name:     config.name,
+			listener: listener,
+			serve:    config.serve,
+		})
+	}
+	return frontends, nil
+}
+
+func closeFrontends(frontends []frontend) {
+	for _, f := range frontends {
+		fsutil.LoggedClose(f.listener)
+	}
+}
+
+// loadUserStore loads the users of frontends that authenticate their clients.
+func loadUserStore(frontendName string) (*auth.Store, error) {
+	if envs.ServerUsersPath == "" {
+		return nil, fmt.Errorf("the %s frontend needs a user store, set SERVER_USERS_PATH", frontendName)
+	}
+	return auth.LoadStore(envs.ServerUsersPath)
+}
+
+func serveFrontend(ctx context.Context, wg *sync.WaitGroup, f frontend, h handler, errCh chan<- error) {
+	defer wg.Done()
+
+	slog.Info("Serving frontend", "name", f.name, "addr", f.listener.Addr().String())
+	if err := f.serve(ctx, f.listener, h); err != nil {
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/generated/netmsgpb"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+func serveGRPC(ctx context.Context, listener net.Listener, h handler) error {
+	grpcServer := grpc.NewServer(
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle: h.limits.idleTimeout,
+		}),
+		grpc.StreamInterceptor(progressDeadlinesInterceptor(h.limits.ioTimeout)),
+	)
+	netmsgpb.RegisterFileServiceServer(grpcServer, grpcHandler{handler: h})
+
+	stop := context.AfterFunc(ctx, grpcServer.Stop)
+	defer stop()
+
+	return grpcServer.Serve(listener)
+}
+
+// grpcHandler maps the FileService defined in netmsg.proto onto the request handler methods.
+type grpcHandler struct {
+	netmsgpb.UnimplementedFileServiceServer
+	handler handler
+}
+
+func (gh grpcHandler) GetFile(pbReq *netmsgpb.GetFileRequest, stream netmsgpb.FileService_GetFileServer) error {
+	req := fromProto[message.GetFileRequest](&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_GetFileRequest{GetFileRequest: pbReq},
+	})
+
+	res, err := gh.handler.handleGetFileRequest(stream.Context(), req)
+	if err != nil {
+		return internalError(err)
+	}
+	if res.Body != nil {
+		defer fsutil.LoggedClose(res.Body)
+	}
+
+	if err = stream.Send(&netmsgpb.GetFileStreamResponse{
+		Part: &netmsgpb.GetFileStreamResponse_Response{
+			Response: netmsg.ToProto(res.GetFileResponse).GetGetFileResponse(),
+		},
+	}); err != nil {
+		return err
+	}
+	if res.Body == nil {
+		return nil
+	}
+
+	limiter := gh.handler.throttler.For(grpcPrincipal(stream.Context()), "get")
+	body := limiter.Reader(stream.Context(), io.LimitReader(res.Body, int64(res.TransferSize())))
+	for {
+		// gRPC may keep a reference to a sent message, so every chunk gets its own buffer.
+		buffer := make([]byte, grpcChunkSize)
+		n, err := body.Read(buffer)
+		if n > 0 {
+			chunk := &netmsgpb.GetFileStreamResponse{
+				Part: &netmsgpb.GetFileStreamResponse_Chunk{Chunk: buffer[:n]},
+			}
+			if sendErr := stream.Send(chunk); sendErr != nil {
+				return sendErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return internalError(err)
+		}
+	}
+}
+
+func (gh grpcHandler) PutFile(stream netmsgpb.FileService_PutFileServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	pbReq := first.GetRequest()
+	if pbReq == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry the put file request")
+	}
+	req := fromProto[message.PutFileRequest](&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_PutFileRequest{PutFileRequest: pbReq},
+	})
+
+	limiter := gh.handler.throttler.For(grpcPrincipal(stream.Context()), "put")
+	receiver := streamReceiver{reader: limiter.Reader(stream.Context(), &chunkStreamReader{stream: stream})}
+	res, err := gh.handler.handlePutFileRequest(stream.Context(), receiver, req)
+	if err != nil {
+		return internalError(err)
+	}
+	return stream.SendAndClose(netmsg.ToProto(res).GetPutFileResponse())
+}
+
+func (gh grpcHandler) DeleteFile(ctx context.Context, pbReq *netmsgpb.DeleteFileRequest) (*netmsgpb.DeleteFileResponse, error) {
+	req := fromProto[message.DeleteFileRequest](&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_DeleteFileRequest{DeleteFileRequest: pbReq},
+	})
+
+	res, err := gh.handler.handleDeleteFileRequest(ctx, req)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return netmsg.ToProto(res).GetDeleteFileResponse(), nil
+}
+
+func (gh grpcHandler) GetFilenames(_ context.Context, pbReq *netmsgpb.GetFilenamesRequest) (*netmsgpb.GetFilenamesResponse, error) {
+	req := fromProto[message.GetFilenamesRequest](&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_GetFilenamesRequest{GetFilenamesRequest: pbReq},
+	})
+
+	res, err := gh.handler.handleGetFilenamesRequest(req)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return netmsg.ToProto(res).GetGetFilenamesResponse(), nil
+}
+
+func (gh grpcHandler) StatFile(ctx context.Context, pbReq *netmsgpb.StatFileRequest) (*netmsgpb.StatFileResponse, error) {
+	req := fromProto[message.StatFileRequest](&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_StatFileRequest{StatFileRequest: pbReq},
+	})
+
+	res, err := gh.handler.handleStatFileRequest(ctx, req)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return netmsg.ToProto(res).GetStatFileResponse(), nil
+}
+
+func (gh grpcHandler) MoveFile(_ context.Context, pbReq *netmsgpb.MoveFileRequest) (*netmsgpb.MoveFileResponse, error) {
+	req := fromProto[message.MoveFileRequest](&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_MoveFileRequest{MoveFileRequest: pbReq},
+	})
+
+	res, err := gh.handler.handleMoveFileRequest(req)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return netmsg.ToProto(res).GetMoveFileResponse(), nil
+}
+
+func (gh grpcHandler) CopyFile(ctx context.Context, pbReq *netmsgpb.CopyFileRequest) (*netmsgpb.CopyFileResponse, error) {
+	req := fromProto[message.CopyFileRequest](&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_CopyFileRequest{CopyFileRequest: pbReq},
+	})
+
+	res, err := gh.handler.handleCopyFileRequest(ctx, req)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return netmsg.ToProto(res).GetCopyFileResponse(), nil
+}
+
+// chunkStreamReader reads the file content that follows the request in a PutFile stream.
+type chunkStreamReader struct {
+	stream netmsgpb.FileService_PutFileServer
+	chunk  []byte
+}
+
+func (r *chunkStreamReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		msg, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		chunk, ok := msg.GetPart().(*netmsgpb.PutFileStreamRequest_Chunk)
+		if !ok {
+			return 0, errors.New("expected file content chunk, received different message")
+		}
+		r.chunk = chunk.Chunk
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// progressDeadlinesInterceptor fails the receives and sends of a stream once they make no progress
+// for ioTimeout, so that a stalled client does not hold the file it transfers. A zero timeout
+// disables them.
+func progressDeadlinesInterceptor(ioTimeout time.Duration) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if ioTimeout <= 0 {
+			return handler(srv, stream)
+		}
+		return handler(srv, &progressStream{ServerStream: stream, ioTimeout: ioTimeout})
+	}
+}
+
+// progressStream gives up on a receive or send that makes no progress for ioTimeout. gRPC cannot
+// abort it, it is left behind until the handler returned and the stream is closed, which also
+// ends it. The stream fails every later call then.
+type progressStream struct {
+	grpc.ServerStream
+	ioTimeout time.Duration
+	stalled   bool
+}
+
+func (s *progressStream) RecvMsg(m any) error {
+	return s.await(func() error {
+		return s.ServerStream.RecvMsg(m)
+	})
+}
+
+func (s *progressStream) SendMsg(m any) error {
+	return s.await(func() error {
+		return s.ServerStream.SendMsg(m)
+	})
+}
+
+func (s *progressStream) await(op func() error) error {
+	if s.stalled {
+		return errStreamStalled
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+
+	timer := time.NewTimer(s.ioTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		s.stalled = true
+		return errStreamStalled
+	}
+}
+
+var errStreamStalled = status.Error(codes.DeadlineExceeded, "stream made no progress")
+
+// grpcPrincipal is the principal of the client of a call, the gRPC frontend does not authenticate
+// its clients.
+func grpcPrincipal(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return addrPrincipal(p.Addr.String())
+}
+
+func fromProto[T message.Message](wrapper *netmsgpb.MessageWrapper) T {
+	return netmsg.FromProto(wrapper).(T)
+}
+
+// internalError logs err and answers with a generic message, the details of a failure, such as
+// paths of the storage directory, are not for clients.
+func internalError(err error) error {
+	slog.Error("gRPC request failed", "err", err)
+	return status.Error(codes.Internal, http.StatusText(http.StatusInternalServerError))
+}
+
+const grpcChunkSize = 64 * 1024
@@ -2,86 +2,256 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
-	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
 	"github.com/mat-sik/file-server-go/internal/message"
 	"github.com/mat-sik/file-server-go/internal/netmsg"
-	"net/http"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"github.com/mat-sik/file-server-go/internal/tracing"
+	"log/slog"
+	"net"
 	"time"
 )
 
 type sessionHandler struct {
 	session netmsg.Session
 	handler handler
+	// principal is who the transfers of the session are throttled as.
+	principal string
+	accessLog *slog.Logger
 }
 
 func (sh sessionHandler) handleRequest(ctx context.Context) error {
-	req, err := sh.receiveRequest()
+	sh.session.Idle()
+	ctx, req, err := sh.receiveRequest(ctx)
 	if err != nil {
 		return err
 	}
 
+	start := time.Now()
+	ctx = sh.handler.throttled(ctx, sh.principal, throttleClass(req))
+	ctx, span := tracing.Start(ctx, "server.handle",
+		tracing.String("type", requestType(req)),
+		tracing.String("filename", requestFilename(req)),
+	)
 	res, err := sh.routeRequest(ctx, req)
-	if err != nil {
-		return err
+	if err == nil {
+		err = sh.deliverResponse(ctx, res)
 	}
-
-	return sh.deliverResponse(ctx, res)
+	span.SetAttributes(tracing.Int("status", responseStatus(res)))
+	span.SetError(err)
+	span.End()
+	sh.logAccess(ctx, req, res, err, time.Since(start))
+	return err
 }
 
-func (sh sessionHandler) receiveRequest() (message.Request, error) {
-	msg, err := sh.session.ReceiveMessage()
+// receiveRequest returns the request and ctx joined to the trace of the client, if it sent one.
+func (sh sessionHandler) receiveRequest(ctx context.Context) (context.Context, message.Request, error) {
+	ctx, msg, err := sh.session.ReceiveMessage(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	req, ok := msg.(message.Request)
 	if !ok {
-		return nil, errors.New("expected request, received different type")
+		return nil, nil, errors.New("expected request, received different type")
 	}
-	return req, nil
+	return ctx, req, nil
 }
 
+// routeRequest and deliverResponse rely on the deadlines of the session to bound how long a peer
+// that stopped reading or writing can hold a file.
 func (sh sessionHandler) routeRequest(ctx context.Context, req message.Request) (message.Response, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeForRequest)
-	defer cancel()
-
 	switch req := req.(type) {
 	case message.GetFileRequest:
-		return sh.handler.handleGetFileRequest(req)
+		return sh.handler.handleGetFileRequest(ctx, req)
 	case message.PutFileRequest:
 		return sh.handler.handlePutFileRequest(ctx, sh.session, req)
 	case message.DeleteFileRequest:
-		return sh.handler.handleDeleteFileRequest(req)
+		return sh.handler.handleDeleteFileRequest(ctx, req)
 	case message.GetFilenamesRequest:
 		return sh.handler.handleGetFilenamesRequest(req)
+	case message.StatFileRequest:
+		return sh.handler.handleStatFileRequest(ctx, req)
+	case message.MoveFileRequest:
+		return sh.handler.handleMoveFileRequest(req)
+	case message.CopyFileRequest:
+		return sh.handler.handleCopyFileRequest(ctx, req)
+	case message.CreateUploadRequest:
+		return sh.handler.handleCreateUploadRequest(req)
+	case message.PutPartRequest:
+		return sh.handler.handlePutPartRequest(ctx, sh.session, req)
+	case message.CompleteUploadRequest:
+		return sh.handler.handleCompleteUploadRequest(ctx, req)
+	case message.AbortUploadRequest:
+		return sh.handler.handleAbortUploadRequest(req)
+	case message.GetSignatureRequest:
+		return sh.handler.handleGetSignatureRequest(ctx, req)
+	case message.PutDeltaRequest:
+		return sh.handler.handlePutDeltaRequest(ctx, sh.session, req)
 	default:
 		return nil, errors.New("unexpected request type")
 	}
 }
 
 func (sh sessionHandler) deliverResponse(ctx context.Context, res message.Response) error {
-	ctx, cancel := context.WithTimeout(ctx, timeForRequest)
-	defer cancel()
-
 	switch res := res.(type) {
 	case getFileResponse:
 		return sh.streamFileResponse(ctx, res)
 	default:
-		return sh.session.SendMessage(res)
+		return sh.session.SendMessage(ctx, res)
 	}
 }
 
 func (sh sessionHandler) streamFileResponse(ctx context.Context, res getFileResponse) error {
-	if res.Status != http.StatusOK {
-		return sh.session.SendMessage(res.GetFileResponse)
+	if res.Body == nil {
+		return sh.session.SendMessage(ctx, res.GetFileResponse)
 	}
 
-	defer files.LoggedClose(res.ReadLockedFile)
-	if err := sh.session.SendMessage(res.GetFileResponse); err != nil {
+	defer fsutil.LoggedClose(res.Body)
+	if err := sh.session.SendMessage(ctx, res.GetFileResponse); err != nil {
 		return err
 	}
-	return sh.session.StreamToNet(ctx, res.ReadLockedFile, res.Size)
+	return sh.session.StreamToNet(ctx, res.Body, res.TransferSize())
+}
+
+// newAccessLog returns the logger of a session's access log entries, every entry carries a random
+// session ID so that the requests of one connection can be told apart from those of others.
+func newAccessLog(remoteAddr string, principal string) *slog.Logger {
+	id := make([]byte, sessionIDSize)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return slog.With(
+		slog.String("session", hex.EncodeToString(id)),
+		slog.String("remote", remoteAddr),
+		slog.String("principal", principal),
+	)
+}
+
+// throttled returns ctx carrying the limiter of a transfer of principal in the request class.
+func (h handler) throttled(ctx context.Context, principal string, class string) context.Context {
+	return throttle.NewContext(ctx, h.throttler.For(principal, class))
+}
+
+// addrPrincipal is the host of remoteAddr, the principal of a client that frontends without
+// authentication know only by its address. Connection limits count connections by it as well.
+func addrPrincipal(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func (sh sessionHandler) logAccess(ctx context.Context, req message.Request, res message.Response, err error, duration time.Duration) {
+	attrs := []slog.Attr{
+		slog.String("type", requestType(req)),
+		slog.String("filename", requestFilename(req)),
+		slog.Int("status", responseStatus(res)),
+		slog.Int("bytes", transferredBytes(req, res)),
+		slog.Duration("duration", duration),
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Any("err", err))
+	}
+	sh.accessLog.LogAttrs(ctx, level, "Access", attrs...)
+}
+
+func requestType(req message.Request) string {
+	switch req.(type) {
+	case message.GetFileRequest:
+		return "get"
+	case message.PutFileRequest:
+		return "put"
+	case message.DeleteFileRequest:
+		return "delete"
+	case message.GetFilenamesRequest:
+		return "list"
+	case message.StatFileRequest:
+		return "stat"
+	case message.MoveFileRequest:
+		return "move"
+	case message.CopyFileRequest:
+		return "copy"
+	case message.CreateUploadRequest:
+		return "create_upload"
+	case message.PutPartRequest:
+		return "put_part"
+	case message.CompleteUploadRequest:
+		return "complete_upload"
+	case message.AbortUploadRequest:
+		return "abort_upload"
+	case message.GetSignatureRequest:
+		return "get_signature"
+	case message.PutDeltaRequest:
+		return "put_delta"
+	}
+	return "unknown"
+}
+
+// throttleClass is the request type, except that parts of uploads and deltas share the class of
+// whole uploads.
+func throttleClass(req message.Request) string {
+	switch req.(type) {
+	case message.PutPartRequest, message.PutDeltaRequest:
+		return "put"
+	}
+	return requestType(req)
+}
+
+func requestFilename(req message.Request) string {
+	if req, ok := req.(message.FilenameGetter); ok {
+		return req.GetFilename()
+	}
+	return ""
+}
+
+func responseStatus(res message.Response) int {
+	switch res := res.(type) {
+	case getFileResponse:
+		return res.Status
+	case message.PutFileResponse:
+		return res.Status
+	case message.DeleteFileResponse:
+		return res.Status
+	case message.GetFilenamesResponse:
+		return res.Status
+	case message.StatFileResponse:
+		return res.Status
+	case message.MoveFileResponse:
+		return res.Status
+	case message.CopyFileResponse:
+		return res.Status
+	case message.CreateUploadResponse:
+		return res.Status
+	case message.PutPartResponse:
+		return res.Status
+	case message.CompleteUploadResponse:
+		return res.Status
+	case message.AbortUploadResponse:
+		return res.Status
+	case message.GetSignatureResponse:
+		return res.Status
+	case message.PutDeltaResponse:
+		return res.Status
+	}
+	return 0
+}
+
+// transferredBytes is the size of the file payload that followed the request or response.
+func transferredBytes(req message.Request, res message.Response) int {
+	if req, ok := req.(message.Transfer); ok {
+		return req.TransferSize()
+	}
+	if res, ok := res.(getFileResponse); ok && res.Body != nil {
+		return res.TransferSize()
+	}
+	return 0
 }
 
-const timeForRequest = 5 * time.Second
+const sessionIDSize = 8
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+//go:embed openapi.yaml
+var openAPIDescription []byte
+
+// uiFiles is the web UI served under /ui/. It is a client of the routes below like any other, so
+// it can do exactly what they allow.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+func serveHTTP(ctx context.Context, listener net.Listener, h handler) error {
+	// The REST gateway does not authenticate its clients.
+	httpHandler := throttleHTTP(newHTTPHandler(h), h.throttler, func(r *http.Request) string {
+		return addrPrincipal(r.RemoteAddr)
+	})
+	return serveHTTPHandler(ctx, listener, httpHandler, h.limits)
+}
+
+func serveHTTPHandler(ctx context.Context, listener net.Listener, httpHandler http.Handler, l limits) error {
+	httpServer := &http.Server{
+		Handler:           progressDeadlines(httpHandler, l.ioTimeout),
+		ReadHeaderTimeout: l.ioTimeout,
+		IdleTimeout:       l.idleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	stop := context.AfterFunc(ctx, func() {
+		fsutil.LoggedClose(httpServer)
+	})
+	defer stop()
+
+	if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// progressDeadlines fails the reads of a request body and the writes of its response once they
+// make no progress for ioTimeout, like the sessions of the native protocol do, so that a stalled
+// peer does not hold the file it transfers. A zero timeout disables them.
+func progressDeadlines(httpHandler http.Handler, ioTimeout time.Duration) http.Handler {
+	if ioTimeout <= 0 {
+		return httpHandler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadlines := deadlineResponseWriter{
+			ResponseWriter: w,
+			controller:     http.NewResponseController(w),
+			ioTimeout:      ioTimeout,
+		}
+		// The response is written out once the handler returned, and hijacked connections set
+		// deadlines of their own.
+		defer deadlines.extendWrite()
+		if err := deadlines.extendWrite(); err != nil {
+			slog.Warn("Setting the write deadline failed", "err", err)
+		}
+		r.Body = deadlineBody{ReadCloser: r.Body, deadlines: deadlines}
+		httpHandler.ServeHTTP(deadlines, r)
+	})
+}
+
+type deadlineResponseWriter struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	ioTimeout  time.Duration
+}
+
+func (w deadlineResponseWriter) Write(p []byte) (int, error) {
+	if err := w.extendWrite(); err != nil {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w deadlineResponseWriter) extendWrite() error {
+	return w.controller.SetWriteDeadline(time.Now().Add(w.ioTimeout))
+}
+
+// Hijack hands over the connection to handlers that assert http.Hijacker, as WebSocket does.
+func (w deadlineResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.controller.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the flushing and deadlines of the connection.
+func (w deadlineResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type deadlineBody struct {
+	io.ReadCloser
+	deadlines deadlineResponseWriter
+}
+
+func (b deadlineBody) Read(p []byte) (int, error) {
+	if err := b.deadlines.controller.SetReadDeadline(time.Now().Add(b.deadlines.ioTimeout)); err != nil {
+		return 0, err
+	}
+	return b.ReadCloser.Read(p)
+}
+
+func serveMetrics(ctx context.Context, listener net.Listener, h handler) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	return serveHTTPHandler(ctx, listener, mux, h.limits)
+}
+
+// httpHandler maps the REST gateway onto the same handler methods the native protocol uses.
+type httpHandler struct {
+	handler handler
+}
+
+func newHTTPHandler(h handler) http.Handler {
+	hh := httpHandler{handler: h}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /files", hh.getFilenames)
+	mux.HandleFunc("GET /files/{filename}", hh.getFile)
+	mux.HandleFunc("PUT /files/{filename}", hh.putFile)
+	mux.HandleFunc("DELETE /files/{filename}", hh.deleteFile)
+	mux.HandleFunc("GET /openapi.yaml", serveOpenAPIDescription)
+	mux.Handle("GET /ui/", http.FileServerFS(uiFiles))
+	mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	return mux
+}
+
+func (hh httpHandler) getFile(w http.ResponseWriter, r *http.Request) {
+	req := message.GetFileRequest{Filename: r.PathValue("filename")}
+	res, err := hh.handler.handleGetFileRequest(r.Context(), req)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if res.Status != http.StatusOK {
+		writeStatus(w, res.Status)
+		return
+	}
+	defer fsutil.LoggedClose(res.Body)
+
+	content, ok := res.Body.(io.ReadSeeker)
+	if !ok {
+		writeInternalError(w, r, fmt.Errorf("body of %s is not seekable", req.Filename))
+		return
+	}
+	w.Header().Set("ETag", etag(res.ModTime, res.Size))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", res.ModTime, content)
+}
+
+func (hh httpHandler) putFile(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength < 0 {
+		writeStatus(w, http.StatusLengthRequired)
+		return
+	}
+	encoding, err := codec.ParseEncoding(r.Header.Get("Content-Encoding"))
+	if err != nil || encoding != codec.Identity {
+		writeStatus(w, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	req := message.PutFileRequest{
+		Filename: r.PathValue("filename"),
+		Size:     int(r.ContentLength),
+	}
+	res, err := hh.handler.handlePutFileRequest(r.Context(), streamReceiver{reader: r.Body}, req)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if fileHandle, ok := hh.handler.syncService.GetFile(req.Filename); ok && res.Status == http.StatusCreated {
+		if info, err := fileHandle.Stat(); err == nil {
+			w.Header().Set("ETag", etag(info.ModTime, info.Size))
+		}
+	}
+	writeStatus(w, res.Status)
+}
+
+func (hh httpHandler) deleteFile(w http.ResponseWriter, r *http.Request) {
+	req := message.DeleteFileRequest{Filename: r.PathValue("filename")}
+	res, err := hh.handler.handleDeleteFileRequest(r.Context(), req)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeStatus(w, res.Status)
+}
+
+func (hh httpHandler) getFilenames(w http.ResponseWriter, r *http.Request) {
+	req := message.GetFilenamesRequest{MatchRegex: r.URL.Query().Get("match")}
+	res, err := hh.handler.handleGetFilenamesRequest(req)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if res.Status != http.StatusOK {
+		writeStatus(w, res.Status)
+		return
+	}
+
+	filenames := res.Filenames
+	if filenames == nil {
+		filenames = []string{}
+	}
+	slices.Sort(filenames)
+	writeJSON(w, filenamesBody{Filenames: filenames})
+}
+
+type filenamesBody struct {
+	Filenames []string `json:"filenames"`
+}
+
+func serveOpenAPIDescription(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(openAPIDescription); err != nil {
+		slog.Warn("Writing OpenAPI description failed", "err", err)
+	}
+}
+
+func etag(modTime time.Time, size int) string {
+	return `"` + strconv.FormatInt(modTime.UnixNano(), 16) + "-" + strconv.FormatInt(int64(size), 16) + `"`
+}
+
+func writeStatus(w http.ResponseWriter, status int) {
+	if status < http.StatusBadRequest {
+		w.WriteHeader(status)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Writing JSON response failed", "err", err)
+	}
+}
+
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.Error("HTTP request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	writeStatus(w, http.StatusInternalServerError)
+}
+
+// throttleHTTP throttles the request and response bodies of httpHandler as transfers of the
+// principal that principalOf returns for the request.
+func throttleHTTP(httpHandler http.Handler, throttler *throttle.Throttler, principalOf func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r = throttleExchange(w, r, throttler.For(principalOf(r), methodClass(r.Method)))
+		httpHandler.ServeHTTP(w, r)
+	})
+}
+
+// throttleExchange returns w and r with their bodies throttled by limiter, and r carrying it in
+// its context like the requests of sessions do.
+func throttleExchange(w http.ResponseWriter, r *http.Request, limiter *throttle.Limiter) (http.ResponseWriter, *http.Request) {
+	ctx := throttle.NewContext(r.Context(), limiter)
+	r = r.WithContext(ctx)
+	r.Body = throttledBody{Reader: limiter.Reader(ctx, r.Body), Closer: r.Body}
+	return throttledResponseWriter{ResponseWriter: w, body: limiter.Writer(ctx, w)}, r
+}
+
+type throttledBody struct {
+	io.Reader
+	io.Closer
+}
+
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	body io.Writer
+}
+
+func (w throttledResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the flushing and deadlines of the connection.
+func (w throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// methodClass is the throttle class of requests of method, named like the native request types
+// that transfer the same way. All other methods share one class, clients choose the method.
+func methodClass(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "get"
+	case http.MethodPut, http.MethodPost:
+		return "put"
+	}
+	return "other"
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idempotencyCache remembers the responses of mutating requests by their idempotency key, so that
+// a client retrying a request whose response it never received does not have it run twice.
+type idempotencyCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotentResult
+	// order holds the keys of entries oldest first, every entry lives for ttl.
+	order []string
+}
+
+type idempotentResult struct {
+	done    chan struct{}
+	status  int
+	failed  bool
+	expires time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]*idempotentResult),
+	}
+}
+
+// begin returns the status of the request identified by kind, filename and key if it ran before,
+// waiting for it while it still runs. Otherwise the request is about to run and finish records
+// its status, or forgets the request if it failed so that a retry runs it again.
+func (c *idempotencyCache) begin(
+	ctx context.Context,
+	kind string,
+	filename string,
+	key string,
+) (status int, replay bool, finish func(status int, err error), err error) {
+	if c == nil || c.ttl == 0 || key == "" {
+		return 0, false, func(int, error) {}, nil
+	}
+	scoped := kind + "\x00" + filename + "\x00" + key
+
+	for {
+		c.mu.Lock()
+		c.expire(time.Now())
+		result, ok := c.entries[scoped]
+		if !ok {
+			result = &idempotentResult{done: make(chan struct{}), expires: time.Now().Add(c.ttl)}
+			c.entries[scoped] = result
+			c.order = append(c.order, scoped)
+			c.mu.Unlock()
+			return 0, false, func(status int, err error) {
+				c.finish(scoped, result, status, err)
+			}, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-result.done:
+		case <-ctx.Done():
+			return 0, false, nil, ctx.Err()
+		}
+		if !result.failed {
+			return result.status, true, nil, nil
+		}
+	}
+}
+
+func (c *idempotencyCache) finish(scoped string, result *idempotentResult, status int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result.status, result.failed = status, err != nil
+	if result.failed && c.entries[scoped] == result {
+		delete(c.entries, scoped)
+	}
+	close(result.done)
+}
+
+func (c *idempotencyCache) expire(now time.Time) {
+	expired := 0
+	for _, scoped := range c.order {
+		result, ok := c.entries[scoped]
+		if ok && now.Before(result.expires) {
+			break
+		}
+		if ok {
+			delete(c.entries, scoped)
+		}
+		expired++
+	}
+	c.order = c.order[expired:]
+}
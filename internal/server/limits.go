@@ -0,0 +1,212 @@
+package server
+
+import (
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limits bound the connections of all listeners, how long session based frontends wait for
+// their peers and how fast they transfer files initially. Zero values disable a limit.
+type limits struct {
+	maxConnections      int
+	maxConnectionsPerIP int
+	idleTimeout         time.Duration
+	ioTimeout           time.Duration
+	rates               throttle.Limits
+	// idempotencyTTL is how long responses are kept for retries of mutating requests.
+	idempotencyTTL time.Duration
+	// uploadTTL is how long staged uploads nothing is stored into are kept.
+	uploadTTL time.Duration
+}
+
+func loadLimits() (limits, error) {
+	var l limits
+	var err error
+	if l.maxConnections, err = parseCount(envs.ServerMaxConnections, "SERVER_MAX_CONNECTIONS"); err != nil {
+		return limits{}, err
+	}
+	if l.maxConnectionsPerIP, err = parseCount(envs.ServerMaxConnectionsPerIP, "SERVER_MAX_CONNECTIONS_PER_IP"); err != nil {
+		return limits{}, err
+	}
+	if l.idleTimeout, err = parseTimeout(envs.ServerIdleTimeout, defaultIdleTimeout, "SERVER_IDLE_TIMEOUT"); err != nil {
+		return limits{}, err
+	}
+	if l.ioTimeout, err = parseTimeout(envs.ServerIOTimeout, defaultIOTimeout, "SERVER_IO_TIMEOUT"); err != nil {
+		return limits{}, err
+	}
+	if l.rates.Global, err = parseRate(envs.ServerThrottleGlobal, "SERVER_THROTTLE_GLOBAL"); err != nil {
+		return limits{}, err
+	}
+	if l.rates.PerPrincipal, err = parseRate(envs.ServerThrottlePerPrincipal, "SERVER_THROTTLE_PER_PRINCIPAL"); err != nil {
+		return limits{}, err
+	}
+	if l.rates.Classes, err = parseClassRates(envs.ServerThrottleClasses); err != nil {
+		return limits{}, err
+	}
+	if l.idempotencyTTL, err = parseTimeout(envs.ServerIdempotencyTTL, defaultIdempotencyTTL, "SERVER_IDEMPOTENCY_TTL"); err != nil {
+		return limits{}, err
+	}
+	if l.uploadTTL, err = parseTimeout(envs.ServerUploadTTL, defaultUploadTTL, "SERVER_UPLOAD_TTL"); err != nil {
+		return limits{}, err
+	}
+	return l, nil
+}
+
+func parseCount(value string, name string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non negative number, got %q", name, value)
+	}
+	return parsed, nil
+}
+
+// parseRate parses a rate in bytes per second.
+func parseRate(value string, name string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non negative number of bytes per second, got %q", name, value)
+	}
+	return parsed, nil
+}
+
+// parseClassRates parses comma separated class=rate pairs such as get=1048576,put=524288.
+func parseClassRates(value string) (map[string]int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	rates := make(map[string]int64)
+	for _, pair := range strings.Split(value, ",") {
+		class, rate, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || class == "" {
+			return nil, fmt.Errorf("SERVER_THROTTLE_CLASSES must consist of class=rate pairs, got %q", pair)
+		}
+		parsed, err := parseRate(rate, "SERVER_THROTTLE_CLASSES rate of "+class)
+		if err != nil {
+			return nil, err
+		}
+		rates[class] = parsed
+	}
+	return rates, nil
+}
+
+func parseTimeout(value string, fallback time.Duration, name string) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non negative duration such as 30s, got %q", name, value)
+	}
+	return parsed, nil
+}
+
+// connLimiter counts the open connections of all listeners it limits, overall and by remote IP.
+type connLimiter struct {
+	mutex               sync.Mutex
+	maxConnections      int
+	maxConnectionsPerIP int
+	connections         int
+	connectionsPerIP    map[string]int
+}
+
+func newConnLimiter(l limits) *connLimiter {
+	return &connLimiter{
+		maxConnections:      l.maxConnections,
+		maxConnectionsPerIP: l.maxConnectionsPerIP,
+		connectionsPerIP:    make(map[string]int),
+	}
+}
+
+// acquire admits a connection from addr, the returned function must be called once it is closed.
+func (cl *connLimiter) acquire(addr net.Addr) (func(), string, bool) {
+	ip := addrPrincipal(addr.String())
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	if cl.maxConnections > 0 && cl.connections >= cl.maxConnections {
+		return nil, rejectedMaxConnections, false
+	}
+	if cl.maxConnectionsPerIP > 0 && cl.connectionsPerIP[ip] >= cl.maxConnectionsPerIP {
+		return nil, rejectedMaxConnectionsPerIP, false
+	}
+	cl.connections++
+	cl.connectionsPerIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cl.release(ip)
+		})
+	}, "", true
+}
+
+func (cl *connLimiter) release(ip string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	cl.connections--
+	if cl.connectionsPerIP[ip]--; cl.connectionsPerIP[ip] == 0 {
+		delete(cl.connectionsPerIP, ip)
+	}
+}
+
+func (cl *connLimiter) limit(listener net.Listener) net.Listener {
+	return limitedListener{Listener: listener, limiter: cl}
+}
+
+// limitedListener closes the connections its limiter rejects right after accepting them.
+type limitedListener struct {
+	net.Listener
+	limiter *connLimiter
+}
+
+func (ll limitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ll.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		release, reason, ok := ll.limiter.acquire(conn.RemoteAddr())
+		if ok {
+			return limitedConn{Conn: conn, release: release}, nil
+		}
+		slog.Warn("Rejected connection", "addr", conn.RemoteAddr().String(), "reason", reason)
+		metrics.ConnectionRejected(reason)
+		if err = conn.Close(); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	release func()
+}
+
+func (lc limitedConn) Close() error {
+	lc.release()
+	return lc.Conn.Close()
+}
+
+const (
+	defaultIdleTimeout    = 2 * time.Minute
+	defaultIOTimeout      = 30 * time.Second
+	defaultIdempotencyTTL = 10 * time.Minute
+	defaultUploadTTL      = 24 * time.Hour
+
+	rejectedMaxConnections      = "max_connections"
+	rejectedMaxConnectionsPerIP = "max_connections_per_ip"
+)
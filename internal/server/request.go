@@ -2,24 +2,51 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/codec"
 	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
 	"github.com/mat-sik/file-server-go/internal/message"
-	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"io"
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
+	"time"
 )
 
 type handler struct {
 	syncService *files.SyncService
+	// limits are the timeouts frontends apply to their connections.
+	limits limits
+	// throttler limits the transfer rate of every frontend, per principal of each.
+	throttler *throttle.Throttler
+	// idempotency answers retried requests that carry an idempotency key.
+	idempotency *idempotencyCache
 }
 
 func newHandler(fileService *files.SyncService) handler {
 	return handler{syncService: fileService}
 }
 
-func (h handler) handleGetFileRequest(req message.GetFileRequest) (getFileResponse, error) {
+func (h handler) handleGetFileRequest(ctx context.Context, req message.GetFileRequest) (res getFileResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("get", res.Status, err)
+	}()
+
+	if !files.ValidFilename(req.Filename) {
+		return getFileResponse{
+			GetFileResponse: message.GetFileResponse{
+				Status: http.StatusBadRequest,
+			},
+		}, nil
+	}
+
 	fileHandle, ok := h.syncService.GetFile(req.Filename)
 	if !ok {
 		return getFileResponse{
@@ -30,7 +57,7 @@ func (h handler) handleGetFileRequest(req message.GetFileRequest) (getFileRespon
 		}, nil
 	}
 
-	readLockedFile, err := fileHandle.NewReadLockedFile()
+	readLockedFile, err := fileHandle.NewReadLockedFile(ctx)
 	if errors.Is(err, os.ErrNotExist) {
 		return getFileResponse{
 			GetFileResponse: message.GetFileResponse{
@@ -44,51 +71,249 @@ func (h handler) handleGetFileRequest(req message.GetFileRequest) (getFileRespon
 
 	fileSize, err := readLockedFile.Size()
 	if err != nil {
-		files.LoggedClose(readLockedFile)
+		fsutil.LoggedClose(readLockedFile)
+		return getFileResponse{}, err
+	}
+	modTime, err := readLockedFile.ModTime()
+	if err != nil {
+		fsutil.LoggedClose(readLockedFile)
+		return getFileResponse{}, err
+	}
+
+	if req.Ranged() {
+		return rangeOf(readLockedFile, req, fileSize, modTime)
+	}
+
+	if stored := readLockedFile.Encoding(); stored != codec.Identity && slices.Contains(req.AcceptEncodings, stored) {
+		body, bodySize := readLockedFile.EncodedBody()
+		return getFileResponse{
+			GetFileResponse: message.GetFileResponse{
+				Status:      http.StatusOK,
+				Size:        fileSize,
+				Encoding:    stored,
+				EncodedSize: bodySize,
+				ModTime:     modTime,
+			},
+			Body: readCloser{Reader: body, Closer: readLockedFile},
+		}, nil
+	}
+
+	encoding := codec.Negotiate(req.AcceptEncodings)
+	if encoding == codec.Identity || fileSize < minEncodedSize {
+		return getFileResponse{
+			GetFileResponse: message.GetFileResponse{
+				Status:  http.StatusOK,
+				Size:    fileSize,
+				ModTime: modTime,
+			},
+			Body: readLockedFile,
+		}, nil
+	}
+
+	spooled, err := codec.Spool(encoding, readLockedFile)
+	fsutil.LoggedClose(readLockedFile)
+	if err != nil {
 		return getFileResponse{}, err
 	}
 
 	return getFileResponse{
 		GetFileResponse: message.GetFileResponse{
-			Status: http.StatusOK,
-			Size:   fileSize,
+			Status:      http.StatusOK,
+			Size:        fileSize,
+			Encoding:    encoding,
+			EncodedSize: spooled.Size(),
+			ModTime:     modTime,
+		},
+		Body: spooled,
+	}, nil
+}
+
+// rangeOf answers a ranged request with the part of the file it asks for, which ends early at the
+// end of the file.
+func rangeOf(file *files.ReadLockedFile, req message.GetFileRequest, fileSize int, modTime time.Time) (getFileResponse, error) {
+	status := http.StatusPartialContent
+	if req.Offset < 0 {
+		status = http.StatusBadRequest
+	} else if req.Offset >= fileSize {
+		status = http.StatusRequestedRangeNotSatisfiable
+	}
+	if status != http.StatusPartialContent {
+		fsutil.LoggedClose(file)
+		return getFileResponse{GetFileResponse: message.GetFileResponse{Status: status}}, nil
+	}
+
+	if _, err := file.Seek(int64(req.Offset), io.SeekStart); err != nil {
+		fsutil.LoggedClose(file)
+		return getFileResponse{}, err
+	}
+	size := min(req.Length, fileSize-req.Offset)
+	return getFileResponse{
+		GetFileResponse: message.GetFileResponse{
+			Status:  http.StatusPartialContent,
+			Size:    size,
+			ModTime: modTime,
 		},
-		ReadLockedFile: readLockedFile,
+		Body: readCloser{Reader: io.LimitReader(file, int64(size)), Closer: file},
 	}, nil
 }
 
 type getFileResponse struct {
 	message.GetFileResponse
-	ReadLockedFile *files.ReadLockedFile
+	Body io.ReadCloser
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+const minEncodedSize = 1024
+
+// payloadReceiver is where uploaded file content is read from: the session for the native
+// protocol, a streamReceiver for the other frontends.
+type payloadReceiver interface {
+	StreamFromNet(ctx context.Context, writer io.Writer, toTransfer int) error
+	DecodeFromNet(ctx context.Context, writer io.Writer, encoding codec.Encoding, toTransfer int, size int) (int, error)
+}
+
+// streamReceiver receives an uploaded payload from a plain reader, such as an HTTP request body.
+type streamReceiver struct {
+	reader io.Reader
+}
+
+func (r streamReceiver) StreamFromNet(ctx context.Context, writer io.Writer, toTransfer int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := io.CopyN(writer, r.reader, int64(toTransfer))
+	return err
+}
+
+func (r streamReceiver) DecodeFromNet(
+	ctx context.Context,
+	writer io.Writer,
+	encoding codec.Encoding,
+	toTransfer int,
+	size int,
+) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	decoder, err := codec.NewReader(encoding, io.LimitReader(r.reader, int64(toTransfer)))
+	if err != nil {
+		return 0, err
+	}
+	defer fsutil.LoggedClose(decoder)
+
+	written, err := io.Copy(writer, io.LimitReader(decoder, int64(size)+1))
+	if err == nil && written > int64(size) {
+		err = fmt.Errorf("%w: more than %d bytes", codec.ErrTooLarge, size)
+	}
+	return int(written), err
 }
 
 func (h handler) handlePutFileRequest(
 	ctx context.Context,
-	session netmsg.Session,
+	receiver payloadReceiver,
 	req message.PutFileRequest,
-) (message.PutFileResponse, error) {
-	saveFileFromNet := func(filename string) error {
-		file, err := os.Create(filename)
+) (res message.PutFileResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("put", res.Status, err)
+	}()
+
+	status, replay, finish, err := h.idempotency.begin(ctx, "put", req.Filename, req.IdempotencyKey)
+	if err != nil {
+		return message.PutFileResponse{}, err
+	}
+	if replay {
+		if err = receiver.StreamFromNet(ctx, io.Discard, req.TransferSize()); err != nil {
+			return message.PutFileResponse{}, err
+		}
+		return message.PutFileResponse{Status: status}, nil
+	}
+	defer func() {
+		finish(res.Status, err)
+	}()
+
+	status = validatePutFileRequest(req)
+	if status == http.StatusOK && h.leased(req.Filename) {
+		status = http.StatusLocked
+	}
+	if status != http.StatusOK {
+		if err := receiver.StreamFromNet(ctx, io.Discard, req.TransferSize()); err != nil {
+			return message.PutFileResponse{}, err
+		}
+		return message.PutFileResponse{
+			Status: status,
+		}, nil
+	}
+
+	saveFileFromNet := func(writer io.Writer) error {
+		written, err := receiver.DecodeFromNet(ctx, writer, req.Encoding, req.TransferSize(), req.Size)
 		if err != nil {
 			return err
 		}
-		defer files.LoggedClose(file)
-
-		return session.StreamFromNet(ctx, file, req.Size)
+		if written != req.Size {
+			return fmt.Errorf("declared size of %d bytes, received %d bytes", req.Size, written)
+		}
+		return nil
 	}
 
-	fileHandle := h.syncService.AddFile(req.Filename)
-	if err := fileHandle.ExecuteWriteOP(saveFileFromNet); err != nil {
+	if err := h.syncService.ReplaceFile(ctx, req.Filename, saveFileFromNet); err != nil {
 		return message.PutFileResponse{}, err
 	}
+	if !req.ModTime.IsZero() {
+		if err := h.syncService.AddFile(req.Filename).SetModTime(ctx, req.ModTime); err != nil {
+			return message.PutFileResponse{}, err
+		}
+	}
 
 	return message.PutFileResponse{
 		Status: http.StatusCreated,
 	}, nil
 }
 
-func (h handler) handleDeleteFileRequest(req message.DeleteFileRequest) (message.DeleteFileResponse, error) {
-	err := h.syncService.RemoveFile(req.Filename)
+func validatePutFileRequest(req message.PutFileRequest) int {
+	if !files.ValidFilename(req.Filename) {
+		return http.StatusBadRequest
+	}
+	if !req.Encoding.Supported() {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusOK
+}
+
+func (h handler) handleDeleteFileRequest(
+	ctx context.Context,
+	req message.DeleteFileRequest,
+) (res message.DeleteFileResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("delete", res.Status, err)
+	}()
+
+	status, replay, finish, err := h.idempotency.begin(ctx, "delete", req.Filename, req.IdempotencyKey)
+	if err != nil {
+		return message.DeleteFileResponse{}, err
+	}
+	if replay {
+		return message.DeleteFileResponse{Status: status}, nil
+	}
+	defer func() {
+		finish(res.Status, err)
+	}()
+
+	if !files.ValidFilename(req.Filename) {
+		return message.DeleteFileResponse{
+			Status: http.StatusBadRequest,
+		}, nil
+	}
+	if h.leased(req.Filename) {
+		return message.DeleteFileResponse{
+			Status: http.StatusLocked,
+		}, nil
+	}
+
+	err = h.syncService.RemoveFile(req.Filename)
 	if errors.Is(err, os.ErrNotExist) {
 		return message.DeleteFileResponse{
 			Status: http.StatusNotFound,
@@ -102,7 +327,18 @@ func (h handler) handleDeleteFileRequest(req message.DeleteFileRequest) (message
 	}, nil
 }
 
-func (h handler) handleGetFilenamesRequest(req message.GetFilenamesRequest) (message.GetFilenamesResponse, error) {
+// leased reports whether a WebDAV client holds a lock on filename. Frontends without lock tokens
+// must not modify such a file.
+func (h handler) leased(filename string) bool {
+	fileHandle, ok := h.syncService.GetFile(filename)
+	return ok && fileHandle.Leased()
+}
+
+func (h handler) handleGetFilenamesRequest(req message.GetFilenamesRequest) (res message.GetFilenamesResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("list", res.Status, err)
+	}()
+
 	pattern, err := regexp.Compile(req.MatchRegex)
 	if err != nil {
 		return message.GetFilenamesResponse{
@@ -122,3 +358,135 @@ func (h handler) handleGetFilenamesRequest(req message.GetFilenamesRequest) (mes
 		Filenames: filteredFilenames,
 	}, nil
 }
+
+func (h handler) handleStatFileRequest(ctx context.Context, req message.StatFileRequest) (res message.StatFileResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("stat", res.Status, err)
+	}()
+
+	if !files.ValidFilename(req.Filename) {
+		return message.StatFileResponse{
+			Status: http.StatusBadRequest,
+		}, nil
+	}
+	fileHandle, ok := h.syncService.GetFile(req.Filename)
+	if !ok {
+		return message.StatFileResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+
+	if req.Checksum {
+		res, err = statWithChecksum(ctx, fileHandle)
+	} else {
+		res, err = stat(fileHandle)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return message.StatFileResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+	return res, err
+}
+
+func stat(fileHandle *files.FileHandle) (message.StatFileResponse, error) {
+	info, err := fileHandle.Stat()
+	if err != nil {
+		return message.StatFileResponse{}, err
+	}
+	return message.StatFileResponse{
+		Status:  http.StatusOK,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+	}, nil
+}
+
+// statWithChecksum reads the whole file under one read lock, so that the checksum is that of the
+// content the size and modification time describe.
+func statWithChecksum(ctx context.Context, fileHandle *files.FileHandle) (message.StatFileResponse, error) {
+	file, err := fileHandle.NewReadLockedFile(ctx)
+	if err != nil {
+		return message.StatFileResponse{}, err
+	}
+	defer fsutil.LoggedClose(file)
+
+	size, err := file.Size()
+	if err != nil {
+		return message.StatFileResponse{}, err
+	}
+	modTime, err := file.ModTime()
+	if err != nil {
+		return message.StatFileResponse{}, err
+	}
+	digest := sha256.New()
+	if _, err = io.Copy(digest, file); err != nil {
+		return message.StatFileResponse{}, err
+	}
+	return message.StatFileResponse{
+		Status:   http.StatusOK,
+		Size:     size,
+		ModTime:  modTime,
+		Checksum: hex.EncodeToString(digest.Sum(nil)),
+	}, nil
+}
+
+func (h handler) handleMoveFileRequest(req message.MoveFileRequest) (res message.MoveFileResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("move", res.Status, err)
+	}()
+
+	if status := h.validateTransferBetween(req.From, req.To); status != http.StatusOK {
+		return message.MoveFileResponse{
+			Status: status,
+		}, nil
+	}
+
+	err = h.syncService.MoveFile(req.From, req.To)
+	if errors.Is(err, os.ErrNotExist) {
+		return message.MoveFileResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		return message.MoveFileResponse{}, err
+	}
+	return message.MoveFileResponse{
+		Status: http.StatusOK,
+	}, nil
+}
+
+func (h handler) handleCopyFileRequest(ctx context.Context, req message.CopyFileRequest) (res message.CopyFileResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("copy", res.Status, err)
+	}()
+
+	if status := h.validateTransferBetween(req.From, req.To); status != http.StatusOK {
+		return message.CopyFileResponse{
+			Status: status,
+		}, nil
+	}
+
+	err = h.syncService.CopyFile(ctx, req.From, req.To)
+	if errors.Is(err, os.ErrNotExist) {
+		return message.CopyFileResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		return message.CopyFileResponse{}, err
+	}
+	return message.CopyFileResponse{
+		Status: http.StatusCreated,
+	}, nil
+}
+
+// validateTransferBetween checks the names of a move or copy, neither file may be leased.
+func (h handler) validateTransferBetween(from string, to string) int {
+	if !files.ValidFilename(from) || !files.ValidFilename(to) {
+		return http.StatusBadRequest
+	}
+	if h.leased(from) || h.leased(to) {
+		return http.StatusLocked
+	}
+	return http.StatusOK
+}
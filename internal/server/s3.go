@@ -0,0 +1,650 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/auth"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/sigv4"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func serveS3(ctx context.Context, listener net.Listener, h handler) error {
+	users, err := loadUserStore("S3")
+	if err != nil {
+		return err
+	}
+	return serveHTTPHandler(ctx, listener, newS3Handler(h.syncService, users, h.throttler), h.limits)
+}
+
+// s3Handler serves a path style subset of the S3 API. The root bucket is the store the other
+// frontends serve, every other bucket is a namespace directory inside it. Object keys may contain
+// slashes, they are kept in filenames with '%' and '/' percent encoded.
+type s3Handler struct {
+	syncService *files.SyncService
+	users       *auth.Store
+	// throttler limits transfers per user of the access key that signed a request.
+	throttler  *throttle.Throttler
+	rootBucket string
+}
+
+func newS3Handler(syncService *files.SyncService, users *auth.Store, throttler *throttle.Throttler) http.Handler {
+	rootBucket := envs.ServerS3RootBucket
+	if rootBucket == "" {
+		rootBucket = defaultRootBucket
+	}
+	return s3Handler{
+		syncService: syncService,
+		users:       users,
+		throttler:   throttler,
+		rootBucket:  rootBucket,
+	}
+}
+
+// s3Request is an authenticated request split into its bucket and object key.
+type s3Request struct {
+	*http.Request
+	signature *sigv4.Signature
+	bucket    string
+	key       string
+}
+
+func (sh s3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	signature, err := sigv4.Verify(r, sh.secretOf, time.Now())
+	if err != nil {
+		writeS3Error(w, r, authenticationError(err))
+		return
+	}
+	_, user, _ := sh.users.AccessKey(signature.AccessKeyID)
+	w, r = throttleExchange(w, r, sh.throttler.For(user, methodClass(r.Method)))
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	req := s3Request{Request: r, signature: signature, bucket: bucket, key: key}
+
+	query := r.URL.Query()
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		sh.listBuckets(w, req)
+	case bucket == "":
+		writeS3Error(w, r, errS3MethodNotAllowed)
+	case key == "" && r.Method == http.MethodHead:
+		sh.headBucket(w, req)
+	case key == "" && r.Method == http.MethodGet && query.Has("location"):
+		sh.getBucketLocation(w, req)
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		sh.listObjects(w, req)
+	case key == "":
+		writeS3Error(w, r, errS3NotImplemented)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		sh.createMultipartUpload(w, req)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		sh.completeMultipartUpload(w, req)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		sh.uploadPart(w, req)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		sh.abortMultipartUpload(w, req)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") == "":
+		sh.putObject(w, req)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		sh.getObject(w, req)
+	case r.Method == http.MethodDelete:
+		sh.deleteObject(w, req)
+	default:
+		writeS3Error(w, r, errS3NotImplemented)
+	}
+}
+
+func (sh s3Handler) secretOf(accessKeyID string) (string, bool) {
+	secret, _, ok := sh.users.AccessKey(accessKeyID)
+	return secret, ok
+}
+
+func (sh s3Handler) namespace(bucket string) (*files.SyncService, *s3Error) {
+	if bucket == sh.rootBucket {
+		return sh.syncService, nil
+	}
+	namespace, err := sh.syncService.Namespace(bucket)
+	if err != nil {
+		return nil, errS3NoSuchBucket
+	}
+	return namespace, nil
+}
+
+// object resolves the namespace and filename the request addresses.
+func (sh s3Handler) object(req s3Request) (*files.SyncService, string, *s3Error) {
+	namespace, s3Err := sh.namespace(req.bucket)
+	if s3Err != nil {
+		return nil, "", s3Err
+	}
+	filename, ok := keyFilename(req.key)
+	if !ok {
+		return nil, "", errS3InvalidKey
+	}
+	return namespace, filename, nil
+}
+
+func (sh s3Handler) putObject(w http.ResponseWriter, req s3Request) {
+	namespace, filename, s3Err := sh.object(req)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	body, s3Err := payloadOf(req)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+
+	putReq := message.PutFileRequest{Filename: filename, Size: body.size}
+	res, err := newHandler(namespace).handlePutFileRequest(req.Context(), streamReceiver{reader: body.reader}, putReq)
+	if err != nil {
+		writeS3Error(w, req.Request, payloadError(err))
+		return
+	}
+	if res.Status != http.StatusCreated {
+		writeS3Error(w, req.Request, statusError(res.Status))
+		return
+	}
+
+	if fileHandle, ok := namespace.GetFile(filename); ok {
+		if info, err := fileHandle.Stat(); err == nil {
+			w.Header().Set("ETag", etag(info.ModTime, info.Size))
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (sh s3Handler) getObject(w http.ResponseWriter, req s3Request) {
+	namespace, filename, s3Err := sh.object(req)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+
+	res, err := newHandler(namespace).handleGetFileRequest(req.Context(), message.GetFileRequest{Filename: filename})
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	if res.Status != http.StatusOK {
+		writeS3Error(w, req.Request, statusError(res.Status))
+		return
+	}
+	defer fsutil.LoggedClose(res.Body)
+
+	content, ok := res.Body.(io.ReadSeeker)
+	if !ok {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, fmt.Errorf("body of %s is not seekable", filename)))
+		return
+	}
+	w.Header().Set("ETag", etag(res.ModTime, res.Size))
+	w.Header().Set("Content-Type", contentTypeOf(req.key))
+	http.ServeContent(w, req.Request, "", res.ModTime, content)
+}
+
+func (sh s3Handler) deleteObject(w http.ResponseWriter, req s3Request) {
+	namespace, filename, s3Err := sh.object(req)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+
+	res, err := newHandler(namespace).handleDeleteFileRequest(req.Context(), message.DeleteFileRequest{Filename: filename})
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	if res.Status != http.StatusOK && res.Status != http.StatusNotFound {
+		writeS3Error(w, req.Request, statusError(res.Status))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sh s3Handler) headBucket(w http.ResponseWriter, req s3Request) {
+	if _, s3Err := sh.namespace(req.bucket); s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (sh s3Handler) getBucketLocation(w http.ResponseWriter, req s3Request) {
+	if _, s3Err := sh.namespace(req.bucket); s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	writeXML(w, http.StatusOK, locationConstraint{})
+}
+
+func (sh s3Handler) listBuckets(w http.ResponseWriter, req s3Request) {
+	names, err := sh.syncService.Namespaces()
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return name == sh.rootBucket
+	})
+	names = append(names, sh.rootBucket)
+	slices.Sort(names)
+
+	_, user, _ := sh.users.AccessKey(req.signature.AccessKeyID)
+	result := listAllMyBucketsResult{Owner: owner{ID: user, DisplayName: user}}
+	for _, name := range names {
+		result.Buckets = append(result.Buckets, bucketEntry{Name: name, CreationDate: s3Time(time.Time{})})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (sh s3Handler) listObjects(w http.ResponseWriter, req s3Request) {
+	namespace, s3Err := sh.namespace(req.bucket)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+
+	query := req.URL.Query()
+	result := listBucketResult{
+		Name:              req.bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		MaxKeys:           maxListKeys,
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		parsed, err := strconv.Atoi(maxKeys)
+		if err != nil || parsed < 0 {
+			writeS3Error(w, req.Request, errS3InvalidArgument)
+			return
+		}
+		result.MaxKeys = min(parsed, maxListKeys)
+	}
+	marker := result.StartAfter
+	if result.ContinuationToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			writeS3Error(w, req.Request, errS3InvalidArgument)
+			return
+		}
+		marker = max(marker, string(decoded))
+	}
+
+	keys := make([]string, 0)
+	for _, filename := range namespace.GetAllFilenames() {
+		keys = append(keys, filenameKey(filename))
+	}
+	slices.Sort(keys)
+
+	last := ""
+	for _, key := range keys {
+		if key <= marker || !strings.HasPrefix(key, result.Prefix) {
+			continue
+		}
+		if result.Delimiter != "" && strings.HasSuffix(marker, result.Delimiter) && strings.HasPrefix(key, marker) {
+			continue
+		}
+
+		commonPrefix := ""
+		if result.Delimiter != "" {
+			if i := strings.Index(key[len(result.Prefix):], result.Delimiter); i >= 0 {
+				commonPrefix = key[:len(result.Prefix)+i+len(result.Delimiter)]
+			}
+		}
+		if commonPrefix != "" && commonPrefix == last {
+			continue
+		}
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefixEntry{Prefix: commonPrefix})
+			last = commonPrefix
+			result.KeyCount++
+			continue
+		}
+		fileHandle, ok := namespace.GetFile(keyFilenameUnchecked(key))
+		if !ok {
+			continue
+		}
+		info, err := fileHandle.Stat()
+		if err != nil {
+			continue
+		}
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          key,
+			LastModified: s3Time(info.ModTime),
+			ETag:         etag(info.ModTime, info.Size),
+			Size:         info.Size,
+			StorageClass: "STANDARD",
+		})
+		last = key
+		result.KeyCount++
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// s3Payload is the decoded body of an upload, checked against the signed payload hash as it is read.
+type s3Payload struct {
+	reader io.Reader
+	size   int
+}
+
+func payloadOf(req s3Request) (s3Payload, *s3Error) {
+	switch req.signature.PayloadHash {
+	case sigv4.StreamingSignedPayload, sigv4.StreamingUnsignedTrail:
+		size, err := strconv.Atoi(req.Header.Get("X-Amz-Decoded-Content-Length"))
+		if err != nil || size < 0 {
+			return s3Payload{}, errS3MissingContentLength
+		}
+		var signature *sigv4.Signature
+		if req.signature.PayloadHash == sigv4.StreamingSignedPayload {
+			signature = req.signature
+		}
+		return s3Payload{reader: newDecodedLengthReader(sigv4.NewChunkedReader(req.Body, signature), size), size: size}, nil
+	}
+
+	if req.ContentLength < 0 {
+		return s3Payload{}, errS3MissingContentLength
+	}
+	size := int(req.ContentLength)
+	if req.signature.PayloadHash == sigv4.UnsignedPayload {
+		return s3Payload{reader: req.Body, size: size}, nil
+	}
+	expected, err := hex.DecodeString(req.signature.PayloadHash)
+	if err != nil || len(expected) != sha256.Size {
+		return s3Payload{}, errS3InvalidArgument
+	}
+	return s3Payload{reader: newHashCheckingReader(req.Body, expected, size), size: size}, nil
+}
+
+var (
+	errContentSHA256Mismatch = errors.New("payload does not match x-amz-content-sha256")
+	errPayloadTooLong        = errors.New("payload is longer than x-amz-decoded-content-length")
+)
+
+// hashCheckingReader fails the read that completes the declared size when the content does not
+// hash to the signed value, the store discards the upload then.
+type hashCheckingReader struct {
+	reader    io.Reader
+	hash      hash.Hash
+	expected  []byte
+	remaining int
+}
+
+func newHashCheckingReader(reader io.Reader, expected []byte, size int) *hashCheckingReader {
+	return &hashCheckingReader{
+		reader:    reader,
+		hash:      sha256.New(),
+		expected:  expected,
+		remaining: size,
+	}
+}
+
+func (r *hashCheckingReader) Read(p []byte) (int, error) {
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	if len(p) == 0 {
+		return 0, io.EOF
+	}
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.remaining -= n
+	if r.remaining == 0 && !bytes.Equal(r.hash.Sum(nil), r.expected) {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// decodedLengthReader ends a chunked payload after its declared decoded length. The read that
+// completes it also reads the terminating chunk, whose signature the chunked reader verifies, and
+// fails when it is missing or the payload is longer.
+type decodedLengthReader struct {
+	reader    io.Reader
+	remaining int
+}
+
+func newDecodedLengthReader(reader io.Reader, size int) *decodedLengthReader {
+	return &decodedLengthReader{
+		reader:    reader,
+		remaining: size,
+	}
+}
+
+func (r *decodedLengthReader) Read(p []byte) (int, error) {
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	if len(p) == 0 {
+		return 0, r.finish()
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= n
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && r.remaining == 0 {
+		err = r.finish()
+	}
+	return n, err
+}
+
+func (r *decodedLengthReader) finish() error {
+	n, err := r.reader.Read(make([]byte, 1))
+	if n > 0 {
+		return errPayloadTooLong
+	}
+	return err
+}
+
+var keyEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+var keyUnescaper = strings.NewReplacer("%2F", "/", "%25", "%")
+
+func keyFilename(key string) (string, bool) {
+	filename := keyFilenameUnchecked(key)
+	return filename, len(key) <= maxKeyLength && files.ValidFilename(filename)
+}
+
+func keyFilenameUnchecked(key string) string {
+	return keyEscaper.Replace(key)
+}
+
+func filenameKey(filename string) string {
+	return keyUnescaper.Replace(filename)
+}
+
+func contentTypeOf(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+var (
+	errS3AccessDenied           = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errS3InvalidAccessKeyID     = &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key does not exist"}
+	errS3SignatureMismatch      = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match"}
+	errS3TimeSkewed             = &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The request time is too far from the server time"}
+	errS3Expired                = &s3Error{http.StatusForbidden, "AccessDenied", "Request has expired"}
+	errS3AuthorizationMalformed = &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization is malformed"}
+	errS3ContentSHA256Mismatch  = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The payload does not match x-amz-content-sha256"}
+	errS3IncompleteBody         = &s3Error{http.StatusBadRequest, "IncompleteBody", "The body is shorter than declared"}
+	errS3InvalidArgument        = &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errS3InvalidKey             = &s3Error{http.StatusBadRequest, "InvalidArgument", "The key cannot be stored"}
+	errS3MalformedXML           = &s3Error{http.StatusBadRequest, "MalformedXML", "The XML is not well formed"}
+	errS3InvalidPart            = &s3Error{http.StatusBadRequest, "InvalidPart", "A part was not uploaded or its ETag does not match"}
+	errS3InvalidPartOrder       = &s3Error{http.StatusBadRequest, "InvalidPartOrder", "Parts must be listed in ascending order"}
+	errS3NoSuchBucket           = &s3Error{http.StatusNotFound, "NoSuchBucket", "The bucket does not exist"}
+	errS3NoSuchKey              = &s3Error{http.StatusNotFound, "NoSuchKey", "The key does not exist"}
+	errS3NoSuchUpload           = &s3Error{http.StatusNotFound, "NoSuchUpload", "The upload does not exist"}
+	errS3MethodNotAllowed       = &s3Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not allowed"}
+	errS3Locked                 = &s3Error{http.StatusConflict, "OperationAborted", "The object is locked"}
+	errS3MissingContentLength   = &s3Error{http.StatusLengthRequired, "MissingContentLength", "The content length is required"}
+	errS3Internal               = &s3Error{http.StatusInternalServerError, "InternalError", "Internal error"}
+	errS3NotImplemented         = &s3Error{http.StatusNotImplemented, "NotImplemented", "The operation is not implemented"}
+)
+
+func authenticationError(err error) *s3Error {
+	switch {
+	case errors.Is(err, sigv4.ErrMissing):
+		return errS3AccessDenied
+	case errors.Is(err, sigv4.ErrUnknownAccessKey):
+		return errS3InvalidAccessKeyID
+	case errors.Is(err, sigv4.ErrSignatureMismatch):
+		return errS3SignatureMismatch
+	case errors.Is(err, sigv4.ErrTimeSkewed):
+		return errS3TimeSkewed
+	case errors.Is(err, sigv4.ErrExpired):
+		return errS3Expired
+	default:
+		return errS3AuthorizationMalformed
+	}
+}
+
+// payloadError maps a failure to receive an upload.
+func payloadError(err error) *s3Error {
+	switch {
+	case errors.Is(err, errContentSHA256Mismatch):
+		return errS3ContentSHA256Mismatch
+	case errors.Is(err, sigv4.ErrSignatureMismatch):
+		return errS3SignatureMismatch
+	case errors.Is(err, sigv4.ErrMalformed), errors.Is(err, errPayloadTooLong), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errS3IncompleteBody
+	default:
+		slog.Error("S3 upload failed", "err", err)
+		return errS3Internal
+	}
+}
+
+// statusError maps the status of a handler response.
+func statusError(status int) *s3Error {
+	switch status {
+	case http.StatusNotFound:
+		return errS3NoSuchKey
+	case http.StatusLocked:
+		return errS3Locked
+	case http.StatusBadRequest:
+		return errS3InvalidKey
+	default:
+		return errS3Internal
+	}
+}
+
+func internalS3Error(r *http.Request, err error) *s3Error {
+	slog.Error("S3 request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	return errS3Internal
+}
+
+type errorBody struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, s3Err *s3Error) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(s3Err.status)
+		return
+	}
+	writeXML(w, s3Err.status, errorBody{Code: s3Err.code, Message: s3Err.message, Resource: r.URL.Path})
+}
+
+func writeXML(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		slog.Warn("Writing XML response failed", "err", err)
+		return
+	}
+	if err := xml.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Writing XML response failed", "err", err)
+	}
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name            `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string              `xml:"Name"`
+	Prefix                string              `xml:"Prefix"`
+	Delimiter             string              `xml:"Delimiter,omitempty"`
+	MaxKeys               int                 `xml:"MaxKeys"`
+	KeyCount              int                 `xml:"KeyCount"`
+	IsTruncated           bool                `xml:"IsTruncated"`
+	StartAfter            string              `xml:"StartAfter,omitempty"`
+	ContinuationToken     string              `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string              `xml:"NextContinuationToken,omitempty"`
+	Contents              []objectEntry       `xml:"Contents"`
+	CommonPrefixes        []commonPrefixEntry `xml:"CommonPrefixes"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefixEntry struct {
+	Prefix string `xml:"Prefix"`
+}
+
+const (
+	defaultRootBucket = "files"
+	maxListKeys       = 1000
+	maxKeyLength      = 1024
+)
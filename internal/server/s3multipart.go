@@ -0,0 +1,264 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Multipart uploads live in the staging area until they are completed. Every upload holds an
+// s3Upload naming its object, and per part the content and the hex MD5 handed out as its ETag.
+type s3Upload struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+func (sh s3Handler) createMultipartUpload(w http.ResponseWriter, req s3Request) {
+	if _, _, s3Err := sh.object(req); s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+
+	staging := sh.syncService.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	err = staging.Store(uploadID, uploadPiece, func(writer io.Writer) error {
+		return json.NewEncoder(writer).Encode(s3Upload{Bucket: req.bucket, Key: req.key})
+	})
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{Bucket: req.bucket, Key: req.key, UploadID: uploadID})
+}
+
+func (sh s3Handler) uploadPart(w http.ResponseWriter, req s3Request) {
+	uploadID := req.URL.Query().Get("uploadId")
+	if s3Err := sh.checkUpload(req, uploadID); s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	partNumber, err := strconv.Atoi(req.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		writeS3Error(w, req.Request, errS3InvalidArgument)
+		return
+	}
+	body, s3Err := payloadOf(req)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+
+	staging := sh.syncService.Staging()
+	// A part uploaded again keeps no ETag until its new content is stored, so that a completion
+	// naming the previous one cannot assemble the upload in between.
+	if err = staging.RemovePiece(uploadID, partPiece(partNumber)+etagSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	digest := md5.New()
+	err = staging.Store(uploadID, partPiece(partNumber), func(writer io.Writer) error {
+		_, err := io.CopyN(io.MultiWriter(writer, digest), body.reader, int64(body.size))
+		return err
+	})
+	if err != nil {
+		writeS3Error(w, req.Request, payloadError(err))
+		return
+	}
+	partETag := hex.EncodeToString(digest.Sum(nil))
+	err = staging.Store(uploadID, partPiece(partNumber)+etagSuffix, func(writer io.Writer) error {
+		_, err := io.WriteString(writer, partETag)
+		return err
+	})
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+
+	w.Header().Set("ETag", `"`+partETag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (sh s3Handler) completeMultipartUpload(w http.ResponseWriter, req s3Request) {
+	uploadID := req.URL.Query().Get("uploadId")
+	if s3Err := sh.checkUpload(req, uploadID); s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	namespace, filename, s3Err := sh.object(req)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	body, s3Err := payloadOf(req)
+	if s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+
+	var completion completeMultipartUpload
+	if err := xml.NewDecoder(io.LimitReader(body.reader, maxCompletionSize)).Decode(&completion); err != nil {
+		writeS3Error(w, req.Request, errS3MalformedXML)
+		return
+	}
+	staging := namespace.Staging()
+	if s3Err = checkParts(staging, uploadID, completion.Parts); s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	if newHandler(namespace).leased(filename) {
+		writeS3Error(w, req.Request, errS3Locked)
+		return
+	}
+
+	err := staging.Replace(req.Context(), uploadID, filename, func(writer io.Writer) error {
+		for _, part := range completion.Parts {
+			if err := copyPiece(writer, staging, uploadID, partPiece(part.PartNumber)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// The upload was aborted while it was being assembled.
+	if errors.Is(err, os.ErrNotExist) {
+		writeS3Error(w, req.Request, errS3NoSuchUpload)
+		return
+	}
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	if err = staging.Remove(uploadID); err != nil {
+		slog.Warn("Removing completed upload failed", "uploadId", uploadID, "err", err)
+	}
+
+	fileHandle, ok := namespace.GetFile(filename)
+	if !ok {
+		writeS3Error(w, req.Request, errS3NoSuchKey)
+		return
+	}
+	info, err := fileHandle.Stat()
+	if err != nil {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: req.URL.Path,
+		Bucket:   req.bucket,
+		Key:      req.key,
+		ETag:     etag(info.ModTime, info.Size),
+	})
+}
+
+func (sh s3Handler) abortMultipartUpload(w http.ResponseWriter, req s3Request) {
+	uploadID := req.URL.Query().Get("uploadId")
+	if s3Err := sh.checkUpload(req, uploadID); s3Err != nil {
+		writeS3Error(w, req.Request, s3Err)
+		return
+	}
+	if err := sh.syncService.Staging().Remove(uploadID); err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeS3Error(w, req.Request, internalS3Error(req.Request, err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkUpload verifies that the upload exists and was created for the addressed object.
+func (sh s3Handler) checkUpload(req s3Request, uploadID string) *s3Error {
+	reader, err := sh.syncService.Staging().Open(uploadID, uploadPiece)
+	if err != nil {
+		return errS3NoSuchUpload
+	}
+	defer fsutil.LoggedClose(reader)
+
+	var upload s3Upload
+	if err = json.NewDecoder(reader).Decode(&upload); err != nil {
+		return internalS3Error(req.Request, err)
+	}
+	if upload.Bucket != req.bucket || upload.Key != req.key {
+		return errS3NoSuchUpload
+	}
+	return nil
+}
+
+func checkParts(staging *files.Staging, uploadID string, parts []completedPart) *s3Error {
+	if len(parts) == 0 {
+		return errS3MalformedXML
+	}
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return errS3InvalidPartOrder
+		}
+		reader, err := staging.Open(uploadID, partPiece(part.PartNumber)+etagSuffix)
+		if err != nil {
+			return errS3InvalidPart
+		}
+		partETag, err := io.ReadAll(reader)
+		fsutil.LoggedClose(reader)
+		if err != nil || string(partETag) != strings.Trim(part.ETag, `"`) {
+			return errS3InvalidPart
+		}
+	}
+	return nil
+}
+
+func copyPiece(writer io.Writer, staging *files.Staging, uploadID string, name string) error {
+	reader, err := staging.Open(uploadID, name)
+	if err != nil {
+		return err
+	}
+	defer fsutil.LoggedClose(reader)
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+func partPiece(partNumber int) string {
+	return fmt.Sprintf("part-%05d", partNumber)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+const (
+	uploadPiece       = "upload"
+	etagSuffix        = ".etag"
+	maxPartNumber     = 10000
+	maxCompletionSize = 1024 * 1024
+)
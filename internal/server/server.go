@@ -4,87 +4,213 @@ import (
 	"context"
 	"errors"
 	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/metrics"
 	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"github.com/mat-sik/file-server-go/internal/throttle"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"time"
 )
 
 func Run(ctx context.Context, addr string) error {
+	l, err := loadLimits()
+	if err != nil {
+		return err
+	}
+	limiter := newConnLimiter(l)
+
 	listener, err := net.Listen("tcp4", addr)
 	if err != nil {
 		return err
 	}
-	defer files.LoggedClose(listener)
+	defer fsutil.LoggedClose(listener)
 
-	return run(ctx, listener)
+	frontends, err := listenFrontends(limiter)
+	if err != nil {
+		return err
+	}
+
+	return run(ctx, limiter.limit(listener), frontends, l)
 }
 
 func RunWithWaitGroup(ctx context.Context, wg *sync.WaitGroup, addr string) error {
+	l, err := loadLimits()
+	if err != nil {
+		return err
+	}
+	limiter := newConnLimiter(l)
+
 	listener, err := net.Listen("tcp4", addr)
 	if err != nil {
 		return err
 	}
-	defer files.LoggedClose(listener)
+	defer fsutil.LoggedClose(listener)
+
+	frontends, err := listenFrontends(limiter)
+	if err != nil {
+		return err
+	}
 	wg.Done()
 
-	return run(ctx, listener)
+	return run(ctx, limiter.limit(listener), frontends, l)
 }
 
-func run(ctx context.Context, listener net.Listener) error {
+func run(ctx context.Context, listener net.Listener, frontends []frontend, l limits) error {
+	frontendsWg := &sync.WaitGroup{}
+	defer frontendsWg.Wait()
+	sessionsWg := &sync.WaitGroup{}
+	defer sessionsWg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	syncService := files.NewService()
+	refreshStorageUsage(syncService)
+	requestHandler := newHandler(syncService)
+	requestHandler.limits = l
+	requestHandler.throttler = throttle.NewThrottler(l.rates)
+	requestHandler.idempotency = newIdempotencyCache(l.idempotencyTTL)
+
 	connCh := make(chan net.Conn)
 	errCh := make(chan error)
 
-	go acceptConnections(listener, connCh, errCh)
+	for _, f := range frontends {
+		frontendsWg.Add(1)
+		go serveFrontend(ctx, frontendsWg, f, requestHandler, errCh)
+	}
+	frontendsWg.Add(2)
+	go func() {
+		defer frontendsWg.Done()
+		sweepStaging(ctx, syncService.Staging(), l.uploadTTL)
+	}()
+	go func() {
+		defer frontendsWg.Done()
+		refreshStorageUsagePeriodically(ctx, syncService)
+	}()
+
+	go acceptConnections(ctx, listener, connCh, errCh)
+
+	return connectionLoop(ctx, sessionsWg, connCh, errCh, requestHandler)
+}
 
-	return connectionLoop(ctx, connCh, errCh)
+// refreshStorageUsagePeriodically counts the stored files every storageUsageInterval until ctx is
+// done, scrapes export the figures of the last count.
+func refreshStorageUsagePeriodically(ctx context.Context, syncService *files.SyncService) {
+	ticker := time.NewTicker(storageUsageInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			refreshStorageUsage(syncService)
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-func acceptConnections(listener net.Listener, connCh chan<- net.Conn, errCh chan<- error) {
+// refreshStorageUsage keeps the figures of the previous count if counting fails.
+func refreshStorageUsage(syncService *files.SyncService) {
+	count, size, err := syncService.DiskUsage()
+	if err != nil {
+		slog.Warn("Counting stored files failed", "err", err)
+		return
+	}
+	metrics.SetStorageUsage(count, size)
+}
+
+func acceptConnections(ctx context.Context, listener net.Listener, connCh chan<- net.Conn, errCh chan<- error) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			errCh <- err
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+			return
+		}
+		select {
+		case connCh <- conn:
+		case <-ctx.Done():
+			fsutil.LoggedClose(conn)
 			return
 		}
-		connCh <- conn
 	}
 }
 
-func connectionLoop(ctx context.Context, connCh <-chan net.Conn, errCh chan error) error {
+// connectionLoop serves every accepted connection in its own goroutine until accepting or a
+// frontend fails, or ctx is done. A failing session only ends its own connection.
+func connectionLoop(
+	ctx context.Context,
+	sessionsWg *sync.WaitGroup,
+	connCh <-chan net.Conn,
+	errCh <-chan error,
+	requestHandler handler,
+) error {
 	for {
 		select {
 		case conn := <-connCh:
-			go handleRequest(ctx, conn, errCh)
+			sessionsWg.Add(1)
+			go func() {
+				defer sessionsWg.Done()
+				handleConnection(ctx, conn, requestHandler)
+			}()
 		case err := <-errCh:
-			if !errors.Is(err, io.EOF) {
-				return err
-			}
-			slog.Info("Connection closed from client")
+			return err
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
-func handleRequest(ctx context.Context, conn net.Conn, errCh chan<- error) {
-	defer files.LoggedClose(conn)
+func handleConnection(ctx context.Context, conn net.Conn, requestHandler handler) {
+	// Closing the connection on shutdown interrupts the session wherever it blocks.
+	stop := context.AfterFunc(ctx, func() {
+		fsutil.LoggedClose(conn)
+	})
+	defer func() {
+		if stop() {
+			fsutil.LoggedClose(conn)
+		}
+	}()
+	defer metrics.SessionStarted("native")()
 
-	session := netmsg.NewSession(conn)
+	session := netmsg.NewSessionWithTimeouts(conn, requestHandler.limits.idleTimeout, requestHandler.limits.ioTimeout)
+	err := serveSession(ctx, session, conn.RemoteAddr().String(), requestHandler)
+	logSessionEnd(ctx, "native", conn.RemoteAddr().String(), err)
+}
 
-	fileService := files.NewService()
-	requestHandler := newHandler(fileService)
+// logSessionEnd logs why a session ended, unless the peer closed it or the server is shutting down.
+func logSessionEnd(ctx context.Context, frontendName string, remoteAddr string, err error) {
+	switch {
+	case ctx.Err() != nil, errors.Is(err, io.EOF):
+		slog.Debug("Session closed", "frontend", frontendName, "addr", remoteAddr)
+	case errors.Is(err, netmsg.ErrIdleTimeout):
+		slog.Info("Closed idle session", "frontend", frontendName, "addr", remoteAddr)
+	default:
+		slog.Warn("Session failed", "frontend", frontendName, "addr", remoteAddr, "err", err)
+	}
+}
 
+// serveSession handles the requests of a session until receiving or answering one fails.
+func serveSession(ctx context.Context, session netmsg.Session, remoteAddr string, requestHandler handler) error {
+	// The native protocol does not authenticate its clients.
+	principal := addrPrincipal(remoteAddr)
 	sh := sessionHandler{
-		session: session,
-		handler: requestHandler,
+		session:   session,
+		handler:   requestHandler,
+		principal: principal,
+		accessLog: newAccessLog(remoteAddr, principal),
 	}
 
 	var err error
 	for err == nil {
 		err = sh.handleRequest(ctx)
 	}
-
-	errCh <- err
+	return err
 }
+
+// storageUsageInterval is how often the stored files are counted for the storage metrics.
+const storageUsageInterval = 30 * time.Second
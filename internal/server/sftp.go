@@ -0,0 +1,548 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/auth"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+func serveSFTP(ctx context.Context, listener net.Listener, h handler) error {
+	users, err := loadUserStore("SFTP")
+	if err != nil {
+		return err
+	}
+	config, err := newSSHServerConfig(users)
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		fsutil.LoggedClose(listener)
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveSSHConn(ctx, conn, config, h)
+		}()
+	}
+}
+
+func newSSHServerConfig(users *auth.Store) (*ssh.ServerConfig, error) {
+	hostKey, err := loadHostKey(envs.ServerSFTPHostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if !users.CheckPassword(meta.User(), string(password)) {
+				return nil, fmt.Errorf("password rejected for %s", meta.User())
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !users.CheckPublicKey(meta.User(), key) {
+				return nil, fmt.Errorf("public key rejected for %s", meta.User())
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	return config, nil
+}
+
+// loadHostKey reads the PEM encoded host key at path, generating an ed25519 key there if the file
+// does not exist yet, so that clients see the same host key across restarts.
+func loadHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		return nil, errors.New("the SFTP frontend needs a host key, set SERVER_SFTP_HOST_KEY_PATH")
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateHostKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(content)
+}
+
+func generateHostKey(path string) (ssh.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "file-server-go")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	slog.Info("Generated SFTP host key", "path", path)
+	return ssh.NewSignerFromKey(key)
+}
+
+func serveSSHConn(ctx context.Context, conn net.Conn, config *ssh.ServerConfig, h handler) {
+	stop := context.AfterFunc(ctx, func() {
+		fsutil.LoggedClose(conn)
+	})
+	defer stop()
+	defer fsutil.LoggedClose(conn)
+
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		slog.Warn("SSH handshake failed", "addr", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	slog.Info("SFTP session started", "user", serverConn.User(), "addr", conn.RemoteAddr().String())
+	go ssh.DiscardRequests(requests)
+	defer metrics.SessionStarted("sftp")()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			if err = newChannel.Reject(ssh.UnknownChannelType, "only session channels are served"); err != nil {
+				slog.Warn("Rejecting SSH channel failed", "err", err)
+			}
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			slog.Warn("Accepting SSH channel failed", "err", err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveSFTPSession(channel, channelRequests, h, serverConn.User())
+		}()
+	}
+}
+
+// serveSFTPSession serves the sftp subsystem on the channel, shells and commands are refused.
+// Transfers are throttled as those of user.
+func serveSFTPSession(channel ssh.Channel, requests <-chan *ssh.Request, h handler, user string) {
+	defer fsutil.LoggedClose(channel)
+
+	for req := range requests {
+		var subsystem struct{ Name string }
+		ok := req.Type == "subsystem" && ssh.Unmarshal(req.Payload, &subsystem) == nil && subsystem.Name == "sftp"
+		if err := req.Reply(ok, nil); err != nil {
+			slog.Warn("Replying to SSH request failed", "err", err)
+			return
+		}
+		if !ok {
+			continue
+		}
+
+		go ssh.DiscardRequests(requests)
+		sftpServer := sftp.NewRequestServer(channel, newSFTPHandlers(h, user))
+		if err := sftpServer.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			slog.Warn("SFTP session failed", "err", err)
+		}
+		fsutil.LoggedClose(sftpServer)
+		return
+	}
+}
+
+// sftpHandlers serve the store as a single directory, like the WebDAV frontend does. Files are
+// written through the same FileHandle locks as native uploads and are visible once closed.
+type sftpHandlers struct {
+	handler handler
+	user    string
+}
+
+func newSFTPHandlers(h handler, user string) sftp.Handlers {
+	sh := sftpHandlers{handler: h, user: user}
+	return sftp.Handlers{FileGet: sh, FilePut: sh, FileCmd: sh, FileList: sh}
+}
+
+func (sh sftpHandlers) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	filename, ok := rootFilename(req.Filepath)
+	if !ok {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	fileHandle, ok := sh.handler.syncService.GetFile(filename)
+	if !ok {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	file, err := fileHandle.NewReadLockedFile(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	return newSFTPReadFile(req.Context(), file, sh.handler.throttler.For(sh.user, "get"), sh.handler.limits.idleTimeout), nil
+}
+
+func (sh sftpHandlers) Filewrite(req *sftp.Request) (io.WriterAt, error) {
+	filename, ok := rootFilename(req.Filepath)
+	if !ok {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	// Uploads replace files as a whole, appending to or patching a file is not possible.
+	if flags := req.Pflags(); flags.Append || !flags.Trunc && sh.exists(filename) {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	if sh.handler.leased(filename) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	limiter := sh.handler.throttler.For(sh.user, "put")
+	return newSFTPWriteFile(req.Context(), sh.handler.syncService, filename, limiter, sh.handler.limits.idleTimeout), nil
+}
+
+func (sh sftpHandlers) exists(filename string) bool {
+	_, ok := sh.handler.syncService.GetFile(filename)
+	return ok
+}
+
+func (sh sftpHandlers) Filecmd(req *sftp.Request) error {
+	switch req.Method {
+	case "Setstat":
+		// Clients set times and permissions after uploads, the store keeps neither.
+		return nil
+	case "Remove":
+		filename, ok := rootFilename(req.Filepath)
+		if !ok {
+			return sftp.ErrSSHFxNoSuchFile
+		}
+		if sh.handler.leased(filename) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return sh.handler.syncService.RemoveFile(filename)
+	case "Rename", "PosixRename":
+		from, ok := rootFilename(req.Filepath)
+		if !ok {
+			return sftp.ErrSSHFxNoSuchFile
+		}
+		to, ok := rootFilename(req.Target)
+		if !ok {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		if sh.handler.leased(from) || sh.handler.leased(to) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return sh.handler.syncService.MoveFile(from, to)
+	case "Mkdir", "Rmdir", "Link", "Symlink":
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (sh sftpHandlers) Filelist(req *sftp.Request) (sftp.ListerAt, error) {
+	syncService := sh.handler.syncService
+	switch req.Method {
+	case "List":
+		if !isRootPath(req.Filepath) {
+			return nil, sftp.ErrSSHFxNoSuchFile
+		}
+		filenames := syncService.GetAllFilenames()
+		slices.Sort(filenames)
+		infos := make(sftpListing, 0, len(filenames))
+		for _, filename := range filenames {
+			info, err := statStoredFile(syncService, filename)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+	case "Stat":
+		if isRootPath(req.Filepath) {
+			return sftpListing{rootInfo}, nil
+		}
+		filename, ok := rootFilename(req.Filepath)
+		if !ok {
+			return nil, sftp.ErrSSHFxNoSuchFile
+		}
+		info, err := statStoredFile(syncService, filename)
+		if err != nil {
+			return nil, err
+		}
+		return sftpListing{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type sftpListing []fs.FileInfo
+
+func (l sftpListing) ListAt(infos []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if offset+int64(n) == int64(len(l)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpReadFile holds the read lock of the file until the client closes it, or leaves it unused
+// for the idle timeout. Stored files are only seekable as a stream, so concurrent reads are
+// serialized.
+type sftpReadFile struct {
+	ctx     context.Context
+	limiter *throttle.Limiter
+	mutex   sync.Mutex
+	// file is nil once the handle expired.
+	file *files.ReadLockedFile
+	idle *idleDeadline
+}
+
+func newSFTPReadFile(ctx context.Context, file *files.ReadLockedFile, limiter *throttle.Limiter, idleTimeout time.Duration) *sftpReadFile {
+	f := &sftpReadFile{ctx: ctx, limiter: limiter, file: file}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.idle = newIdleDeadline(idleTimeout, f.expire)
+	return f
+}
+
+func (f *sftpReadFile) ReadAt(p []byte, offset int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, errIdleHandle
+	}
+	// Reads waiting for the throttle count as use until they return.
+	defer f.idle.touch()
+	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.limiter.Reader(f.ctx, f.file), p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// expire releases the read lock of a file the client left unused for the idle timeout.
+func (f *sftpReadFile) expire() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil || !f.idle.expired() {
+		return
+	}
+	fsutil.LoggedClose(f.file)
+	f.file = nil
+}
+
+func (f *sftpReadFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.idle.stop()
+	if f.file == nil {
+		return nil
+	}
+	file := f.file
+	f.file = nil
+	return file.Close()
+}
+
+// sftpWriteFile streams an upload into a staged replacement of the file, which takes its place
+// only once the client closes it without the upload having failed. Clients pipeline their writes,
+// so the request server may hand them over out of order; writes ahead of the stream are held back
+// until the gap before them is filled. An upload left without writes for the idle timeout fails,
+// so that it does not hold the write lock of the file forever.
+type sftpWriteFile struct {
+	mutex       sync.Mutex
+	writer      *io.PipeWriter
+	done        chan error
+	offset      int64
+	pending     map[int64][]byte
+	pendingSize int
+	err         error
+	idle        *idleDeadline
+}
+
+func newSFTPWriteFile(
+	ctx context.Context,
+	syncService *files.SyncService,
+	filename string,
+	limiter *throttle.Limiter,
+	idleTimeout time.Duration,
+) *sftpWriteFile {
+	reader, writer := io.Pipe()
+	f := &sftpWriteFile{
+		writer:  writer,
+		done:    make(chan error, 1),
+		pending: make(map[int64][]byte),
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.idle = newIdleDeadline(idleTimeout, f.expire)
+
+	go func() {
+		err := syncService.ReplaceFile(context.Background(), filename, func(w io.Writer) error {
+			_, err := io.Copy(w, limiter.Reader(ctx, reader))
+			return err
+		})
+		reader.CloseWithError(err)
+		f.done <- err
+	}()
+	return f
+}
+
+func (f *sftpWriteFile) WriteAt(p []byte, offset int64) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+	defer f.idle.touch()
+	switch {
+	case offset < f.offset:
+		f.abort(fmt.Errorf("rewriting offset %d: %w", offset, errors.ErrUnsupported))
+		return 0, f.err
+	case offset > f.offset:
+		if f.pendingSize+len(p) > maxPendingWriteSize {
+			f.abort(fmt.Errorf("too many writes ahead of offset %d", f.offset))
+			return 0, f.err
+		}
+		// The request server reuses p once WriteAt returns.
+		f.pending[offset] = slices.Clone(p)
+		f.pendingSize += len(p)
+		return len(p), nil
+	}
+
+	if err := f.write(p); err != nil {
+		return 0, err
+	}
+	for {
+		chunk, ok := f.pending[f.offset]
+		if !ok {
+			break
+		}
+		delete(f.pending, f.offset)
+		f.pendingSize -= len(chunk)
+		if err := f.write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (f *sftpWriteFile) write(p []byte) error {
+	n, err := f.writer.Write(p)
+	f.offset += int64(n)
+	if err != nil {
+		f.abort(err)
+	}
+	return err
+}
+
+func (f *sftpWriteFile) abort(err error) {
+	f.err = err
+	f.idle.stop()
+	f.writer.CloseWithError(err)
+}
+
+// expire fails an upload the client left without writes for the idle timeout.
+func (f *sftpWriteFile) expire() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err == nil && f.idle.expired() {
+		f.abort(errIdleHandle)
+	}
+}
+
+// TransferError is called when the session ends with the file still open, the upload then fails
+// the same way an interrupted native upload does instead of passing as complete.
+func (f *sftpWriteFile) TransferError(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err == nil {
+		f.abort(err)
+	}
+}
+
+func (f *sftpWriteFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err == nil && len(f.pending) > 0 {
+		f.abort(fmt.Errorf("upload has a hole at offset %d", f.offset))
+	}
+	if f.err == nil {
+		f.idle.stop()
+		return errors.Join(f.writer.Close(), <-f.done)
+	}
+	<-f.done
+	return f.err
+}
+
+// idleDeadline calls expire once its handle was not touched for timeout. Touching it and asking
+// whether it expired must be done under the lock expire takes, so that a handle used just as the
+// deadline passes is not expired. A zero timeout never expires.
+type idleDeadline struct {
+	timeout time.Duration
+	timer   *time.Timer
+	used    time.Time
+}
+
+func newIdleDeadline(timeout time.Duration, expire func()) *idleDeadline {
+	d := &idleDeadline{timeout: timeout, used: time.Now()}
+	if timeout > 0 {
+		d.timer = time.AfterFunc(timeout, expire)
+	}
+	return d
+}
+
+func (d *idleDeadline) touch() {
+	d.used = time.Now()
+	if d.timer != nil {
+		d.timer.Reset(d.timeout)
+	}
+}
+
+func (d *idleDeadline) expired() bool {
+	return d.timer != nil && time.Since(d.used) >= d.timeout
+}
+
+func (d *idleDeadline) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+var errIdleHandle = errors.New("file handle was left idle for too long")
+
+const maxPendingWriteSize = 32 * 1024 * 1024
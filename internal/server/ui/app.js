@@ -0,0 +1,122 @@
+"use strict";
+
+// The UI only uses the REST gateway under /files, so it is subject to the same rules as every
+// other client of the server.
+
+const filesBody = document.getElementById("files");
+const statusLine = document.getElementById("status");
+const searchMatch = document.getElementById("search-match");
+
+function fileURL(name) {
+    return "/files/" + encodeURIComponent(name);
+}
+
+function showStatus(text, isError) {
+    statusLine.textContent = text;
+    statusLine.classList.toggle("error", Boolean(isError));
+}
+
+async function failure(res) {
+    const text = (await res.text()).trim();
+    return `${res.status} ${text || res.statusText}`;
+}
+
+async function listFiles() {
+    const match = searchMatch.value;
+    const res = await fetch("/files" + (match ? "?match=" + encodeURIComponent(match) : ""));
+    if (!res.ok) {
+        showStatus("Listing failed: " + await failure(res), true);
+        return;
+    }
+    const {filenames} = await res.json();
+    filesBody.replaceChildren(...filenames.map(fileRow));
+    showStatus(filenames.length === 1 ? "1 file" : `${filenames.length} files`);
+}
+
+function fileRow(name) {
+    const row = document.createElement("tr");
+
+    const nameCell = document.createElement("td");
+    const nameLink = document.createElement("a");
+    nameLink.className = "name";
+    nameLink.textContent = name;
+    nameLink.addEventListener("click", () => showDetails(name, row));
+    nameCell.append(nameLink);
+
+    const actionsCell = document.createElement("td");
+    const download = document.createElement("a");
+    download.href = fileURL(name);
+    download.download = name;
+    download.textContent = "Download";
+    const remove = document.createElement("button");
+    remove.type = "button";
+    remove.textContent = "Delete";
+    remove.addEventListener("click", () => deleteFile(name));
+    actionsCell.append(download, " ", remove);
+
+    row.append(nameCell, actionsCell);
+    return row;
+}
+
+async function showDetails(name, row) {
+    const res = await fetch(fileURL(name), {method: "HEAD"});
+    if (!res.ok) {
+        showStatus(`Reading ${name} failed: ${res.status} ${res.statusText}`, true);
+        return;
+    }
+    for (const selected of filesBody.querySelectorAll("tr.selected")) {
+        selected.classList.remove("selected");
+    }
+    row.classList.add("selected");
+
+    document.getElementById("details-name").textContent = name;
+    document.getElementById("details-size").textContent = formatSize(Number(res.headers.get("Content-Length")));
+    document.getElementById("details-modified").textContent = new Date(res.headers.get("Last-Modified")).toLocaleString();
+    document.getElementById("details-etag").textContent = res.headers.get("ETag");
+    document.getElementById("details").hidden = false;
+}
+
+function formatSize(size) {
+    const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+    let unit = 0;
+    while (size >= 1024 && unit < units.length - 1) {
+        size /= 1024;
+        unit++;
+    }
+    return `${unit === 0 ? size : size.toFixed(1)} ${units[unit]}`;
+}
+
+async function deleteFile(name) {
+    if (!confirm(`Delete ${name}?`)) {
+        return;
+    }
+    const res = await fetch(fileURL(name), {method: "DELETE"});
+    if (!res.ok) {
+        showStatus(`Deleting ${name} failed: ` + await failure(res), true);
+        return;
+    }
+    document.getElementById("details").hidden = true;
+    await listFiles();
+}
+
+async function uploadFiles(event) {
+    event.preventDefault();
+    const input = document.getElementById("upload-files");
+    for (const file of input.files) {
+        showStatus(`Uploading ${file.name}...`);
+        const res = await fetch(fileURL(file.name), {method: "PUT", body: file});
+        if (!res.ok) {
+            showStatus(`Uploading ${file.name} failed: ` + await failure(res), true);
+            return;
+        }
+    }
+    input.value = "";
+    await listFiles();
+}
+
+document.getElementById("upload").addEventListener("submit", uploadFiles);
+document.getElementById("search").addEventListener("submit", event => {
+    event.preventDefault();
+    listFiles();
+});
+listFiles();
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uploads in parts live in the staging area until they are completed. Every upload holds a
+// partUpload naming its file, and per part the content of the file starting at the offset in the
+// name of the piece.
+type partUpload struct {
+	Filename string `json:"filename"`
+	Size     int    `json:"size"`
+}
+
+func (h handler) handleCreateUploadRequest(req message.CreateUploadRequest) (res message.CreateUploadResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("create_upload", res.Status, err)
+	}()
+
+	if !files.ValidFilename(req.Filename) || req.Size < 0 {
+		return message.CreateUploadResponse{
+			Status: http.StatusBadRequest,
+		}, nil
+	}
+	if h.leased(req.Filename) {
+		return message.CreateUploadResponse{
+			Status: http.StatusLocked,
+		}, nil
+	}
+
+	staging := h.syncService.Staging()
+	uploadID, err := staging.Create()
+	if err != nil {
+		return message.CreateUploadResponse{}, err
+	}
+	err = staging.Store(uploadID, partUploadPiece, func(writer io.Writer) error {
+		return json.NewEncoder(writer).Encode(partUpload{Filename: req.Filename, Size: req.Size})
+	})
+	if err != nil {
+		return message.CreateUploadResponse{}, err
+	}
+	return message.CreateUploadResponse{
+		Status:   http.StatusOK,
+		UploadID: uploadID,
+	}, nil
+}
+
+func (h handler) handlePutPartRequest(
+	ctx context.Context,
+	receiver payloadReceiver,
+	req message.PutPartRequest,
+) (res message.PutPartResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("put_part", res.Status, err)
+	}()
+
+	upload, status, err := h.openUpload(req.UploadID)
+	if err != nil {
+		return message.PutPartResponse{}, err
+	}
+	if status == http.StatusOK {
+		status = validatePutPartRequest(req, upload)
+	}
+	if status != http.StatusOK {
+		if err := receiver.StreamFromNet(ctx, io.Discard, req.TransferSize()); err != nil {
+			return message.PutPartResponse{}, err
+		}
+		return message.PutPartResponse{
+			Status: status,
+		}, nil
+	}
+
+	err = h.syncService.Staging().Store(req.UploadID, rangePiece(req.Offset), func(writer io.Writer) error {
+		written, err := receiver.DecodeFromNet(ctx, writer, req.Encoding, req.TransferSize(), req.Size)
+		if err != nil {
+			return err
+		}
+		if written != req.Size {
+			return fmt.Errorf("declared size of %d bytes, received %d bytes", req.Size, written)
+		}
+		return nil
+	})
+	if err != nil {
+		return message.PutPartResponse{}, err
+	}
+	return message.PutPartResponse{
+		Status: http.StatusOK,
+	}, nil
+}
+
+func validatePutPartRequest(req message.PutPartRequest, upload partUpload) int {
+	if req.Offset < 0 || req.Size < 0 || req.Offset+req.Size > upload.Size {
+		return http.StatusBadRequest
+	}
+	if !req.Encoding.Supported() {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusOK
+}
+
+// handleCompleteUploadRequest replaces the file of the upload with its parts. Parts that do not
+// cover the file are answered with 409, a checksum that does not match them with 422, the upload
+// is kept in both cases so that the client may send the missing parts or abort it.
+func (h handler) handleCompleteUploadRequest(
+	ctx context.Context,
+	req message.CompleteUploadRequest,
+) (res message.CompleteUploadResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("complete_upload", res.Status, err)
+	}()
+
+	// Retries are told apart by the upload, which no longer exists once a request completed it.
+	status, replay, finish, err := h.idempotency.begin(ctx, "complete_upload", req.UploadID, req.IdempotencyKey)
+	if err != nil {
+		return message.CompleteUploadResponse{}, err
+	}
+	if replay {
+		return message.CompleteUploadResponse{Status: status}, nil
+	}
+	defer func() {
+		finish(res.Status, err)
+	}()
+
+	upload, status, err := h.openUpload(req.UploadID)
+	if err != nil {
+		return message.CompleteUploadResponse{}, err
+	}
+	if status == http.StatusOK && !validChecksum(req.Checksum) {
+		status = http.StatusBadRequest
+	}
+	if status == http.StatusOK && h.leased(upload.Filename) {
+		status = http.StatusLocked
+	}
+	if status != http.StatusOK {
+		return message.CompleteUploadResponse{
+			Status: status,
+		}, nil
+	}
+
+	staging := h.syncService.Staging()
+	err = staging.Replace(ctx, req.UploadID, upload.Filename, func(writer io.Writer) error {
+		return assembleParts(writer, staging, req.UploadID, upload.Size, req.Checksum)
+	})
+	if errors.Is(err, errPartsIncomplete) {
+		return message.CompleteUploadResponse{
+			Status: http.StatusConflict,
+		}, nil
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		return message.CompleteUploadResponse{
+			Status: http.StatusUnprocessableEntity,
+		}, nil
+	}
+	// The upload was aborted while it was being assembled.
+	if errors.Is(err, os.ErrNotExist) {
+		return message.CompleteUploadResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		return message.CompleteUploadResponse{}, err
+	}
+	if !req.ModTime.IsZero() {
+		if err = h.syncService.AddFile(upload.Filename).SetModTime(ctx, req.ModTime); err != nil {
+			return message.CompleteUploadResponse{}, err
+		}
+	}
+	if err = staging.Remove(req.UploadID); err != nil {
+		slog.Warn("Removing completed upload failed", "uploadId", req.UploadID, "err", err)
+	}
+	return message.CompleteUploadResponse{
+		Status: http.StatusCreated,
+	}, nil
+}
+
+func (h handler) handleAbortUploadRequest(req message.AbortUploadRequest) (res message.AbortUploadResponse, err error) {
+	defer func() {
+		metrics.ObserveRequest("abort_upload", res.Status, err)
+	}()
+
+	_, status, err := h.openUpload(req.UploadID)
+	if err != nil || status != http.StatusOK {
+		return message.AbortUploadResponse{
+			Status: status,
+		}, err
+	}
+	err = h.syncService.Staging().Remove(req.UploadID)
+	if errors.Is(err, os.ErrNotExist) {
+		return message.AbortUploadResponse{
+			Status: http.StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		return message.AbortUploadResponse{}, err
+	}
+	return message.AbortUploadResponse{
+		Status: http.StatusOK,
+	}, nil
+}
+
+// sweepStaging removes the uploads nothing was stored into for ttl, first right away and then
+// periodically until ctx is done. A zero ttl keeps abandoned uploads.
+func sweepStaging(ctx context.Context, staging *files.Staging, ttl time.Duration) {
+	if ttl == 0 {
+		return
+	}
+	ticker := time.NewTicker(min(ttl, maxStagingSweepInterval))
+	defer ticker.Stop()
+	for {
+		removed, err := staging.RemoveStale(ttl)
+		if err != nil {
+			slog.Warn("Removing abandoned uploads failed", "err", err)
+		} else if removed > 0 {
+			slog.Info("Removed abandoned uploads", "count", removed)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// openUpload reads the description of the upload, its status is 404 if there is no such upload.
+func (h handler) openUpload(uploadID string) (partUpload, int, error) {
+	reader, err := h.syncService.Staging().Open(uploadID, partUploadPiece)
+	if errors.Is(err, os.ErrNotExist) {
+		return partUpload{}, http.StatusNotFound, nil
+	}
+	if err != nil {
+		return partUpload{}, 0, err
+	}
+	defer fsutil.LoggedClose(reader)
+
+	var upload partUpload
+	if err = json.NewDecoder(reader).Decode(&upload); err != nil {
+		return partUpload{}, 0, err
+	}
+	return upload, http.StatusOK, nil
+}
+
+// assembleParts writes the parts of the upload in the order of their offsets, each of which must
+// start where the previous one ended.
+func assembleParts(writer io.Writer, staging *files.Staging, uploadID string, size int, checksum string) error {
+	pieces, err := staging.Pieces(uploadID)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.New()
+	writer = io.MultiWriter(writer, digest)
+	written := 0
+	for _, piece := range pieces {
+		offset, ok := rangePieceOffset(piece)
+		if !ok {
+			continue
+		}
+		if offset != written {
+			return fmt.Errorf("%w: part at %d where %d was expected", errPartsIncomplete, offset, written)
+		}
+		n, err := copyPieceCounted(writer, staging, uploadID, piece)
+		if err != nil {
+			return err
+		}
+		written += n
+	}
+	if written != size {
+		return fmt.Errorf("%w: parts of %d bytes for a file of %d bytes", errPartsIncomplete, written, size)
+	}
+	if hex.EncodeToString(digest.Sum(nil)) != strings.ToLower(checksum) {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+func copyPieceCounted(writer io.Writer, staging *files.Staging, uploadID string, name string) (int, error) {
+	reader, err := staging.Open(uploadID, name)
+	if err != nil {
+		return 0, err
+	}
+	defer fsutil.LoggedClose(reader)
+
+	n, err := io.Copy(writer, reader)
+	return int(n), err
+}
+
+func validChecksum(checksum string) bool {
+	decoded, err := hex.DecodeString(checksum)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// rangePiece names the piece of the part at offset, padded so that pieces list in offset order.
+func rangePiece(offset int) string {
+	return fmt.Sprintf("%s%019d", rangePrefix, offset)
+}
+
+func rangePieceOffset(piece string) (int, bool) {
+	digits, ok := strings.CutPrefix(piece, rangePrefix)
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(digits)
+	return offset, err == nil
+}
+
+var (
+	errPartsIncomplete  = errors.New("parts do not cover the file")
+	errChecksumMismatch = errors.New("checksum does not match the parts")
+)
+
+const (
+	partUploadPiece = "native-upload"
+	rangePrefix     = "range-"
+	// maxStagingSweepInterval is how often abandoned uploads are looked for at most.
+	maxStagingSweepInterval = time.Hour
+)
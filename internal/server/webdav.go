@@ -0,0 +1,460 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/files"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+func serveWebDAV(ctx context.Context, listener net.Listener, h handler) error {
+	// Like the REST gateway, WebDAV does not authenticate its clients.
+	davHandler := throttleHTTP(newWebDAVHandler(h.syncService), h.throttler, func(r *http.Request) string {
+		return addrPrincipal(r.RemoteAddr)
+	})
+	return serveHTTPHandler(ctx, listener, davHandler, h.limits)
+}
+
+func newWebDAVHandler(syncService *files.SyncService) http.Handler {
+	davHandler := &webdav.Handler{
+		FileSystem: davFileSystem{syncService: syncService},
+		LockSystem: newLeaseLockSystem(syncService),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				slog.Warn("WebDAV request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			body := &davBody{ReadCloser: r.Body}
+			r = r.WithContext(context.WithValue(r.Context(), davBodyKey{}, body))
+			r.Body = body
+		}
+		davHandler.ServeHTTP(w, r)
+	})
+}
+
+// davBody records whether the body of a PUT request was read to its end. The WebDAV handler
+// commits what it wrote to a file even if reading the body failed, so the file checks this first.
+type davBody struct {
+	io.ReadCloser
+	complete bool
+}
+
+func (b *davBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.complete = true
+	}
+	return n, err
+}
+
+type davBodyKey struct{}
+
+// davFileSystem exposes the flat file store as a single WebDAV collection at "/".
+type davFileSystem struct {
+	syncService *files.SyncService
+}
+
+func (dfs davFileSystem) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	if isRootPath(name) {
+		return os.ErrExist
+	}
+	return os.ErrPermission
+}
+
+func (dfs davFileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if isRootPath(name) {
+		if writing {
+			return nil, os.ErrPermission
+		}
+		return &davDir{syncService: dfs.syncService}, nil
+	}
+
+	filename, ok := rootFilename(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if writing {
+		if flag&os.O_TRUNC == 0 {
+			return nil, errors.ErrUnsupported
+		}
+		body, _ := ctx.Value(davBodyKey{}).(*davBody)
+		return newDavWriteFile(dfs.syncService, filename, body), nil
+	}
+
+	fileHandle, ok := dfs.syncService.GetFile(filename)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &davReadFile{fileHandle: fileHandle, filename: filename}, nil
+}
+
+func (dfs davFileSystem) RemoveAll(_ context.Context, name string) error {
+	if isRootPath(name) {
+		return os.ErrPermission
+	}
+	filename, ok := rootFilename(name)
+	if !ok {
+		return os.ErrNotExist
+	}
+	return dfs.syncService.RemoveFile(filename)
+}
+
+func (dfs davFileSystem) Rename(_ context.Context, oldName string, newName string) error {
+	from, ok := rootFilename(oldName)
+	if !ok {
+		return os.ErrNotExist
+	}
+	to, ok := rootFilename(newName)
+	if !ok {
+		return os.ErrPermission
+	}
+	return dfs.syncService.MoveFile(from, to)
+}
+
+func (dfs davFileSystem) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	if isRootPath(name) {
+		return rootInfo, nil
+	}
+	filename, ok := rootFilename(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return statStoredFile(dfs.syncService, filename)
+}
+
+// ETag matches the ETag the HTTP gateway sends for the same file.
+func (fi storedFileInfo) ETag(context.Context) (string, error) {
+	if fi.dir {
+		return "", webdav.ErrNotImplemented
+	}
+	return etag(fi.FileInfo.ModTime, fi.FileInfo.Size), nil
+}
+
+func (fi storedFileInfo) ContentType(context.Context) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(fi.FileInfo.Name)); contentType != "" {
+		return contentType, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// davDir lists the store, it is the only collection there is.
+type davDir struct {
+	syncService *files.SyncService
+	listed      bool
+}
+
+func (d *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if d.listed {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	d.listed = true
+
+	filenames := d.syncService.GetAllFilenames()
+	slices.Sort(filenames)
+	infos := make([]fs.FileInfo, 0, len(filenames))
+	for _, filename := range filenames {
+		info, err := statStoredFile(d.syncService, filename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (d *davDir) Stat() (fs.FileInfo, error) {
+	return rootInfo, nil
+}
+
+func (d *davDir) Read([]byte) (int, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *davDir) Seek(int64, int) (int64, error) {
+	return 0, nil
+}
+
+func (d *davDir) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *davDir) Close() error {
+	return nil
+}
+
+// davReadFile takes the read lock of the file on the first Read or Seek and holds it until it is
+// closed. PROPFIND opens every file it lists without reading them, so those never lock.
+type davReadFile struct {
+	fileHandle *files.FileHandle
+	filename   string
+	file       *files.ReadLockedFile
+}
+
+func (f *davReadFile) open() error {
+	if f.file != nil {
+		return nil
+	}
+	file, err := f.fileHandle.NewReadLockedFile(context.Background())
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+func (f *davReadFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Read(p)
+}
+
+func (f *davReadFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Seek(offset, whence)
+}
+
+func (f *davReadFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *davReadFile) Stat() (fs.FileInfo, error) {
+	if f.file == nil {
+		info, err := f.fileHandle.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return storedFileInfo{FileInfo: info}, nil
+	}
+
+	size, err := f.file.Size()
+	if err != nil {
+		return nil, err
+	}
+	modTime, err := f.file.ModTime()
+	if err != nil {
+		return nil, err
+	}
+	return storedFileInfo{FileInfo: files.FileInfo{Name: f.filename, Size: size, ModTime: modTime}}, nil
+}
+
+func (f *davReadFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *davReadFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// davWriteFile streams everything written to it into a staged replacement of the file, holding
+// the write lock of the file until the content is committed by Stat or Close, the same way a native
+// upload does. The file is replaced only if the body of the request, if any, was read completely.
+type davWriteFile struct {
+	syncService *files.SyncService
+	filename    string
+	body        *davBody
+	writer      *io.PipeWriter
+	done        chan error
+	commitOnce  sync.Once
+	err         error
+}
+
+func newDavWriteFile(syncService *files.SyncService, filename string, body *davBody) *davWriteFile {
+	reader, writer := io.Pipe()
+	f := &davWriteFile{
+		syncService: syncService,
+		filename:    filename,
+		body:        body,
+		writer:      writer,
+		done:        make(chan error, 1),
+	}
+	go func() {
+		err := syncService.ReplaceFile(context.Background(), filename, func(w io.Writer) error {
+			_, err := io.Copy(w, reader)
+			return err
+		})
+		reader.CloseWithError(err)
+		f.done <- err
+	}()
+	return f
+}
+
+func (f *davWriteFile) Write(p []byte) (int, error) {
+	return f.writer.Write(p)
+}
+
+func (f *davWriteFile) commit() error {
+	f.commitOnce.Do(func() {
+		if f.body != nil && !f.body.complete {
+			f.writer.CloseWithError(errIncompleteBody)
+			<-f.done
+			f.err = errIncompleteBody
+			return
+		}
+		f.err = errors.Join(f.writer.Close(), <-f.done)
+	})
+	return f.err
+}
+
+// Stat commits the content, the WebDAV handler asks for it only once it is done writing.
+func (f *davWriteFile) Stat() (fs.FileInfo, error) {
+	if err := f.commit(); err != nil {
+		return nil, err
+	}
+	fileHandle, ok := f.syncService.GetFile(f.filename)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	info, err := fileHandle.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return storedFileInfo{FileInfo: info}, nil
+}
+
+func (f *davWriteFile) Close() error {
+	return f.commit()
+}
+
+func (f *davWriteFile) Read([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *davWriteFile) Seek(int64, int) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (f *davWriteFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+var errIncompleteBody = errors.New("request body was not read completely")
+
+// leaseLockSystem keeps WebDAV locks in memory and mirrors every lock on a file onto a lease of
+// its FileHandle, so that the native protocol and the other frontends refuse to modify it.
+// Locks on the root collection only hold between WebDAV clients.
+type leaseLockSystem struct {
+	webdav.LockSystem
+	syncService *files.SyncService
+	mutex       sync.Mutex
+	leases      map[string]string
+}
+
+func newLeaseLockSystem(syncService *files.SyncService) *leaseLockSystem {
+	return &leaseLockSystem{
+		LockSystem:  webdav.NewMemLS(),
+		syncService: syncService,
+		leases:      make(map[string]string),
+	}
+}
+
+func (ls *leaseLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	token, err := ls.LockSystem.Create(now, details)
+	if err != nil {
+		return "", err
+	}
+	if filename, ok := rootFilename(details.Root); ok {
+		// Locking a path that does not exist yet reserves it, its handle is dropped again once
+		// the lock is gone if no file was stored there.
+		ls.syncService.AddFile(filename).Lease(token, leaseExpiry(now, details.Duration))
+
+		ls.mutex.Lock()
+		expired := ls.pruneExpired()
+		ls.leases[token] = filename
+		ls.mutex.Unlock()
+		for _, filename := range expired {
+			ls.syncService.ForgetIfMissing(filename)
+		}
+	}
+	return token, nil
+}
+
+// pruneExpired forgets locks that ran out without being unlocked and returns their files. It must
+// be called with mutex held.
+func (ls *leaseLockSystem) pruneExpired() []string {
+	var expired []string
+	for token, filename := range ls.leases {
+		if fileHandle, ok := ls.syncService.GetFile(filename); !ok || !fileHandle.Leased() {
+			delete(ls.leases, token)
+			expired = append(expired, filename)
+		}
+	}
+	return expired
+}
+
+func (ls *leaseLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	if err != nil {
+		ls.release(token)
+		return webdav.LockDetails{}, err
+	}
+	if fileHandle, ok := ls.leasedFile(token); ok {
+		fileHandle.Lease(token, leaseExpiry(now, duration))
+	}
+	return details, nil
+}
+
+func (ls *leaseLockSystem) Unlock(now time.Time, token string) error {
+	err := ls.LockSystem.Unlock(now, token)
+	if err == nil || errors.Is(err, webdav.ErrNoSuchLock) {
+		ls.release(token)
+	}
+	return err
+}
+
+func (ls *leaseLockSystem) leasedFile(token string) (*files.FileHandle, bool) {
+	ls.mutex.Lock()
+	filename, ok := ls.leases[token]
+	ls.mutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return ls.syncService.GetFile(filename)
+}
+
+func (ls *leaseLockSystem) release(token string) {
+	ls.mutex.Lock()
+	filename, ok := ls.leases[token]
+	delete(ls.leases, token)
+	ls.mutex.Unlock()
+	if !ok {
+		return
+	}
+	if fileHandle, ok := ls.syncService.GetFile(filename); ok {
+		fileHandle.Release(token)
+	}
+	ls.syncService.ForgetIfMissing(filename)
+}
+
+func leaseExpiry(now time.Time, duration time.Duration) time.Time {
+	if duration < 0 {
+		return time.Time{}
+	}
+	return now.Add(duration)
+}
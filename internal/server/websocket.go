@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
+	"github.com/mat-sik/file-server-go/internal/metrics"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"golang.org/x/net/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+func serveWebSocket(ctx context.Context, listener net.Listener, h handler) error {
+	origins := strings.FieldsFunc(envs.ServerWebSocketOrigins, func(r rune) bool {
+		return r == ','
+	})
+	return serveHTTPHandler(ctx, listener, newWebSocketHandler(ctx, h, origins), h.limits)
+}
+
+// newWebSocketHandler serves native protocol sessions over WebSocket connections. The binary
+// messages of a connection form one byte stream carrying exactly what a TCP connection would,
+// headers, MessageWrapper frames and file payloads, so how the stream is split into messages
+// does not matter.
+func newWebSocketHandler(ctx context.Context, h handler, allowedOrigins []string) http.Handler {
+	return websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			origin, err := websocket.Origin(config, req)
+			if err != nil {
+				return err
+			}
+			return checkOrigin(origin, req.Host, allowedOrigins)
+		},
+		Handler: func(conn *websocket.Conn) {
+			conn.PayloadType = websocket.BinaryFrame
+			// Hijacked connections outlive the HTTP server, close them on shutdown.
+			stop := context.AfterFunc(ctx, func() {
+				fsutil.LoggedClose(conn)
+			})
+			defer stop()
+			defer metrics.SessionStarted("websocket")()
+
+			session := netmsg.NewSessionWithTimeouts(conn, h.limits.idleTimeout, h.limits.ioTimeout)
+			err := serveSession(ctx, session, conn.Request().RemoteAddr, h)
+			logSessionEnd(ctx, "websocket", conn.Request().RemoteAddr, err)
+		},
+	}
+}
+
+// checkOrigin refuses connections opened by pages of foreign origins. A browser lets any page it
+// shows connect to any WebSocket server it can reach, and the native protocol has no credentials
+// to tell the user's own tools apart, so only pages served by the server itself or from one of the
+// allowed origins, given as scheme://host[:port], may connect. Clients other than browsers send no
+// origin.
+func checkOrigin(origin *url.URL, host string, allowedOrigins []string) error {
+	if origin == nil || strings.EqualFold(origin.Host, host) {
+		return nil
+	}
+	if slices.ContainsFunc(allowedOrigins, func(allowed string) bool {
+		return strings.EqualFold(strings.TrimSpace(allowed), origin.Scheme+"://"+origin.Host)
+	}) {
+		return nil
+	}
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
@@ -0,0 +1,128 @@
+package sigv4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NewChunkedReader decodes an aws-chunked payload:
+//
+//	hex size [;chunk-signature=sig] CRLF data CRLF ... 0 [;chunk-signature=sig] CRLF [trailers] CRLF
+//
+// Chunk signatures are verified against signature, which must be nil for unsigned payloads.
+// Trailers are read and discarded.
+func NewChunkedReader(body io.Reader, signature *Signature) io.Reader {
+	reader := &chunkedReader{
+		reader:    bufio.NewReader(body),
+		signature: signature,
+	}
+	if signature != nil {
+		reader.previous = signature.value
+	}
+	return reader
+}
+
+type chunkedReader struct {
+	reader    *bufio.Reader
+	signature *Signature
+	previous  string
+	buffer    []byte
+	chunk     []byte
+	done      bool
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			// The payload must end with the terminating chunk.
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *chunkedReader) readChunk() error {
+	line, err := r.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return fmt.Errorf("%w: chunk size %q", ErrMalformed, sizeHex)
+	}
+
+	if int64(cap(r.buffer)) < size {
+		r.buffer = make([]byte, size)
+	}
+	data := r.buffer[:size]
+	if _, err = io.ReadFull(r.reader, data); err != nil {
+		return err
+	}
+
+	if r.signature != nil {
+		chunkSignature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok {
+			return fmt.Errorf("%w: chunk without a signature", ErrMalformed)
+		}
+		expected := r.signature.sign(stringToSign(
+			chunkAlgorithm, r.signature.amzDate, r.signature.scope, r.previous, hashHex(nil), hashHex(data),
+		))
+		if !hmac.Equal([]byte(expected), []byte(chunkSignature)) {
+			return ErrSignatureMismatch
+		}
+		r.previous = expected
+	}
+
+	if size == 0 {
+		r.done = true
+		return r.skipTrailers()
+	}
+	if line, err = r.readLine(); err != nil {
+		return err
+	}
+	if line != "" {
+		return fmt.Errorf("%w: chunk is longer than declared", ErrMalformed)
+	}
+	r.chunk = data
+	return nil
+}
+
+func (r *chunkedReader) skipTrailers() error {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+	}
+}
+
+func (r *chunkedReader) readLine() (string, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", fmt.Errorf("%w: chunk header too long", ErrMalformed)
+		}
+		return "", err
+	}
+	return string(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))), nil
+}
+
+// maxChunkSize bounds the memory needed to verify a chunk before handing out its data.
+const maxChunkSize = 16 * 1024 * 1024
@@ -0,0 +1,346 @@
+// Package sigv4 verifies and creates AWS Signature Version 4 signatures for S3 requests.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissing           = errors.New("request is not signed")
+	ErrMalformed         = errors.New("malformed signature")
+	ErrUnknownAccessKey  = errors.New("unknown access key")
+	ErrSignatureMismatch = errors.New("signature does not match")
+	ErrTimeSkewed        = errors.New("request time is too far from the server time")
+	ErrExpired           = errors.New("presigned request has expired")
+)
+
+// Lookup returns the secret of an access key id.
+type Lookup func(accessKeyID string) (secret string, ok bool)
+
+// Signature is a verified request signature. It is the seed for the chunk signatures of a
+// streaming upload.
+type Signature struct {
+	AccessKeyID string
+	PayloadHash string
+	amzDate     string
+	scope       string
+	signingKey  []byte
+	value       string
+}
+
+// Verify checks the signature of r, carried either by the Authorization header or by the query of
+// a presigned URL.
+func Verify(r *http.Request, lookup Lookup, now time.Time) (*Signature, error) {
+	var (
+		parsed parsedSignature
+		err    error
+	)
+	query := r.URL.Query()
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		parsed, err = parseAuthorization(authorization, r.Header)
+	} else if query.Has("X-Amz-Signature") {
+		parsed, err = parsePresigned(query, now)
+		query.Del("X-Amz-Signature")
+	} else {
+		return nil, ErrMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	date, err := time.Parse(amzDateFormat, parsed.amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	if !parsed.presigned && (date.Before(now.Add(-maxSkew)) || date.After(now.Add(maxSkew))) {
+		return nil, ErrTimeSkewed
+	}
+
+	secret, ok := lookup(parsed.accessKeyID)
+	if !ok {
+		return nil, ErrUnknownAccessKey
+	}
+
+	signature := &Signature{
+		AccessKeyID: parsed.accessKeyID,
+		PayloadHash: parsed.payloadHash,
+		amzDate:     parsed.amzDate,
+		scope:       parsed.scope,
+		signingKey:  signingKey(secret, parsed.scope),
+	}
+	canonical := canonicalRequest(r, query, parsed.signedHeaders, parsed.payloadHash)
+	signature.value = signature.sign(stringToSign(algorithm, parsed.amzDate, parsed.scope, hashHex([]byte(canonical))))
+	if !hmac.Equal([]byte(signature.value), []byte(parsed.signature)) {
+		return nil, ErrSignatureMismatch
+	}
+	return signature, nil
+}
+
+// Sign adds an Authorization header to r. The payload hash is taken from the x-amz-content-sha256
+// header, which is set to UNSIGNED-PAYLOAD when missing.
+func Sign(r *http.Request, accessKeyID string, secret string, region string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	scope := buildScope(amzDate, region)
+	r.Header.Set("X-Amz-Date", amzDate)
+	if r.Header.Get("X-Amz-Content-Sha256") == "" {
+		r.Header.Set("X-Amz-Content-Sha256", UnsignedPayload)
+	}
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+
+	signedHeaders := []string{"host"}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || name == "content-md5" || name == "range" || strings.HasPrefix(name, "x-amz-") {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	slices.Sort(signedHeaders)
+
+	signature := Signature{amzDate: amzDate, scope: scope, signingKey: signingKey(secret, scope)}
+	canonical := canonicalRequest(r, r.URL.Query(), signedHeaders, r.Header.Get("X-Amz-Content-Sha256"))
+	value := signature.sign(stringToSign(algorithm, amzDate, scope, hashHex([]byte(canonical))))
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, accessKeyID, scope, strings.Join(signedHeaders, ";"), value,
+	))
+}
+
+// Presign adds the query of a presigned URL valid for expires to r.
+func Presign(r *http.Request, accessKeyID string, secret string, region string, now time.Time, expires time.Duration) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	scope := buildScope(amzDate, region)
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+
+	query := r.URL.Query()
+	query.Set("X-Amz-Algorithm", algorithm)
+	query.Set("X-Amz-Credential", accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	signature := Signature{amzDate: amzDate, scope: scope, signingKey: signingKey(secret, scope)}
+	canonical := canonicalRequest(r, query, []string{"host"}, UnsignedPayload)
+	query.Set("X-Amz-Signature", signature.sign(stringToSign(algorithm, amzDate, scope, hashHex([]byte(canonical)))))
+	r.URL.RawQuery = query.Encode()
+}
+
+func (s *Signature) sign(toSign string) string {
+	return hex.EncodeToString(hmacSHA256(s.signingKey, toSign))
+}
+
+type parsedSignature struct {
+	accessKeyID   string
+	scope         string
+	amzDate       string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+}
+
+func parseAuthorization(authorization string, header http.Header) (parsedSignature, error) {
+	fields, ok := strings.CutPrefix(authorization, algorithm+" ")
+	if !ok {
+		return parsedSignature{}, fmt.Errorf("%w: unsupported algorithm", ErrMalformed)
+	}
+
+	values := make(map[string]string)
+	for _, field := range strings.Split(fields, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return parsedSignature{}, fmt.Errorf("%w: field %q", ErrMalformed, field)
+		}
+		values[key] = value
+	}
+
+	parsed := parsedSignature{
+		amzDate:     header.Get("X-Amz-Date"),
+		signature:   values["Signature"],
+		payloadHash: header.Get("X-Amz-Content-Sha256"),
+	}
+	if parsed.payloadHash == "" {
+		return parsedSignature{}, fmt.Errorf("%w: missing x-amz-content-sha256", ErrMalformed)
+	}
+	if err := parsed.parseCommon(values["Credential"], values["SignedHeaders"]); err != nil {
+		return parsedSignature{}, err
+	}
+	return parsed, nil
+}
+
+func parsePresigned(query url.Values, now time.Time) (parsedSignature, error) {
+	if query.Get("X-Amz-Algorithm") != algorithm {
+		return parsedSignature{}, fmt.Errorf("%w: unsupported algorithm", ErrMalformed)
+	}
+	parsed := parsedSignature{
+		amzDate:     query.Get("X-Amz-Date"),
+		signature:   query.Get("X-Amz-Signature"),
+		payloadHash: UnsignedPayload,
+		presigned:   true,
+	}
+	if err := parsed.parseCommon(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders")); err != nil {
+		return parsedSignature{}, err
+	}
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || expires > maxPresignedExpiry {
+		return parsedSignature{}, fmt.Errorf("%w: X-Amz-Expires", ErrMalformed)
+	}
+	date, err := time.Parse(amzDateFormat, parsed.amzDate)
+	if err != nil {
+		return parsedSignature{}, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	if now.After(date.Add(time.Duration(expires)*time.Second)) || date.After(now.Add(maxSkew)) {
+		return parsedSignature{}, ErrExpired
+	}
+	return parsed, nil
+}
+
+// parseCommon reads the credential, AKID/yyyymmdd/region/s3/aws4_request, and the signed headers.
+func (p *parsedSignature) parseCommon(credential string, signedHeaders string) error {
+	accessKeyID, scope, ok := strings.Cut(credential, "/")
+	parts := strings.Split(scope, "/")
+	if !ok || len(parts) != 4 || parts[2] != service || parts[3] != terminator {
+		return fmt.Errorf("%w: credential %q", ErrMalformed, credential)
+	}
+	if !strings.HasPrefix(p.amzDate, parts[0]) {
+		return fmt.Errorf("%w: credential date does not match the request date", ErrMalformed)
+	}
+	if p.signature == "" || signedHeaders == "" {
+		return fmt.Errorf("%w: missing signature", ErrMalformed)
+	}
+
+	p.accessKeyID = accessKeyID
+	p.scope = scope
+	p.signedHeaders = strings.Split(signedHeaders, ";")
+	if !slices.Contains(p.signedHeaders, "host") {
+		return fmt.Errorf("%w: host is not signed", ErrMalformed)
+	}
+	return nil
+}
+
+func canonicalRequest(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) string {
+	var builder strings.Builder
+	builder.WriteString(r.Method)
+	builder.WriteByte('\n')
+	builder.WriteString(canonicalURI(r.URL.Path))
+	builder.WriteByte('\n')
+	builder.WriteString(canonicalQuery(query))
+	builder.WriteByte('\n')
+	for _, name := range signedHeaders {
+		builder.WriteString(name)
+		builder.WriteByte(':')
+		builder.WriteString(canonicalHeaderValue(r, name))
+		builder.WriteByte('\n')
+	}
+	builder.WriteByte('\n')
+	builder.WriteString(strings.Join(signedHeaders, ";"))
+	builder.WriteByte('\n')
+	builder.WriteString(payloadHash)
+	return builder.String()
+}
+
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return uriEncode(path, false)
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.Header.Get("Content-Length") == "" {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	}
+	var values []string
+	for _, value := range r.Header.Values(name) {
+		values = append(values, strings.Join(strings.Fields(value), " "))
+	}
+	return strings.Join(values, ",")
+}
+
+// uriEncode escapes everything but the unreserved characters, as S3 expects. Slashes are kept
+// unless encodeSlash is set.
+func uriEncode(value string, encodeSlash bool) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			builder.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			builder.WriteByte(b)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+func stringToSign(algorithm string, amzDate string, scope string, lines ...string) string {
+	return strings.Join(append([]string{algorithm, amzDate, scope}, lines...), "\n")
+}
+
+func buildScope(amzDate string, region string) string {
+	return strings.Join([]string{amzDate[:8], region, service, terminator}, "/")
+}
+
+func signingKey(secret string, scope string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	UnsignedPayload        = "UNSIGNED-PAYLOAD"
+	StreamingSignedPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingUnsignedTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	algorithm          = "AWS4-HMAC-SHA256"
+	chunkAlgorithm     = "AWS4-HMAC-SHA256-PAYLOAD"
+	service            = "s3"
+	terminator         = "aws4_request"
+	amzDateFormat      = "20060102T150405Z"
+	maxSkew            = 15 * time.Minute
+	maxPresignedExpiry = 7 * 24 * 60 * 60
+)
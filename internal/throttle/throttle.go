@@ -0,0 +1,215 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/time/rate"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limits are transfer rates in bytes per second, zero means unlimited. Global is shared by all
+// transfers, every principal gets its own PerPrincipal bucket, and Classes maps a request class,
+// such as get or put, to a bucket shared by all transfers of the class.
+type Limits struct {
+	Global       int64            `json:"global"`
+	PerPrincipal int64            `json:"perPrincipal"`
+	Classes      map[string]int64 `json:"classes,omitempty"`
+}
+
+func (l Limits) Validate() error {
+	if l.Global < 0 || l.PerPrincipal < 0 {
+		return fmt.Errorf("rates must not be negative")
+	}
+	for class, limit := range l.Classes {
+		if limit < 0 {
+			return fmt.Errorf("rate of class %s must not be negative", class)
+		}
+	}
+	return nil
+}
+
+// Throttler hands out the token buckets transfers wait on. Changing its limits takes effect
+// immediately, also for transfers in progress.
+type Throttler struct {
+	mutex      sync.Mutex
+	limits     Limits
+	global     *rate.Limiter
+	principals map[string]*rate.Limiter
+	classes    map[string]*rate.Limiter
+	// swept is when idle principal buckets were last evicted.
+	swept time.Time
+}
+
+func NewThrottler(limits Limits) *Throttler {
+	t := &Throttler{
+		global:     newBucket(0),
+		principals: make(map[string]*rate.Limiter),
+		classes:    make(map[string]*rate.Limiter),
+	}
+	t.SetLimits(limits)
+	return t
+}
+
+func (t *Throttler) Limits() Limits {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.limits
+}
+
+func (t *Throttler) SetLimits(limits Limits) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.limits = limits
+	setRate(t.global, limits.Global)
+	for _, bucket := range t.principals {
+		setRate(bucket, limits.PerPrincipal)
+	}
+	for class, bucket := range t.classes {
+		setRate(bucket, limits.Classes[class])
+	}
+}
+
+// For returns the limiter of a transfer of principal in the request class.
+func (t *Throttler) For(principal string, class string) *Limiter {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if now := time.Now(); now.Sub(t.swept) >= sweepInterval {
+		t.evictIdle(now)
+		t.swept = now
+	}
+	return &Limiter{buckets: []*rate.Limiter{
+		t.global,
+		bucketOf(t.principals, principal, t.limits.PerPrincipal),
+		bucketOf(t.classes, class, t.limits.Classes[class]),
+	}}
+}
+
+// evictIdle forgets the buckets of principals that are full at now, those no transfer drew from
+// for a while. A principal gets a full bucket again with its next transfer, so only one still in
+// progress that drew from nothing since keeps an evicted bucket.
+func (t *Throttler) evictIdle(now time.Time) {
+	for principal, bucket := range t.principals {
+		if bucket.Limit() == rate.Inf || bucket.TokensAt(now) >= float64(bucket.Burst()) {
+			delete(t.principals, principal)
+		}
+	}
+}
+
+func bucketOf(buckets map[string]*rate.Limiter, key string, limit int64) *rate.Limiter {
+	bucket, ok := buckets[key]
+	if !ok {
+		bucket = newBucket(limit)
+		buckets[key] = bucket
+	}
+	return bucket
+}
+
+func newBucket(limit int64) *rate.Limiter {
+	bucket := rate.NewLimiter(rate.Inf, 0)
+	setRate(bucket, limit)
+	return bucket
+}
+
+// setRate allows bursts of a tenth of a second of transfer, but never less than a chunk.
+func setRate(bucket *rate.Limiter, limit int64) {
+	if limit == 0 {
+		bucket.SetLimit(rate.Inf)
+		return
+	}
+	bucket.SetLimit(rate.Limit(limit))
+	bucket.SetBurst(max(int(limit/10), minBurst))
+}
+
+// Limiter delays a transfer until all of its buckets allow it. A nil Limiter does not delay.
+type Limiter struct {
+	buckets []*rate.Limiter
+}
+
+// Wait blocks until n bytes may be transferred or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	for _, bucket := range l.buckets {
+		for remaining := n; remaining > 0; {
+			// Waiting for more than a burst fails, larger chunks are waited for in parts.
+			chunk := remaining
+			if bucket.Limit() != rate.Inf {
+				chunk = min(remaining, bucket.Burst())
+			}
+			if err := bucket.WaitN(ctx, chunk); err != nil {
+				return err
+			}
+			remaining -= chunk
+		}
+	}
+	return nil
+}
+
+// Reader throttles reads from reader, the bytes read are paid for after reading them.
+func (l *Limiter) Reader(ctx context.Context, reader io.Reader) io.Reader {
+	if l == nil {
+		return reader
+	}
+	return &throttledReader{ctx: ctx, limiter: l, reader: reader}
+}
+
+// Writer throttles writes to writer, the bytes are paid for before writing them.
+func (l *Limiter) Writer(ctx context.Context, writer io.Writer) io.Writer {
+	if l == nil {
+		return writer
+	}
+	return &throttledWriter{ctx: ctx, limiter: l, writer: writer}
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	limiter *Limiter
+	reader  io.Reader
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.reader.Read(p)
+	if waitErr := tr.limiter.Wait(tr.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	ctx     context.Context
+	limiter *Limiter
+	writer  io.Writer
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	if err := tw.limiter.Wait(tw.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return tw.writer.Write(p)
+}
+
+type limiterKey struct{}
+
+func NewContext(ctx context.Context, l *Limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+// FromContext returns the limiter of ctx, or nil if transfers within ctx are not throttled.
+func FromContext(ctx context.Context) *Limiter {
+	l, _ := ctx.Value(limiterKey{}).(*Limiter)
+	return l
+}
+
+const (
+	minBurst = 32 * 1024
+	// sweepInterval is how often For evicts idle principal buckets.
+	sweepInterval = time.Minute
+)
@@ -0,0 +1,82 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func Test_should_LimitTransferRate(t *testing.T) {
+	throttler := NewThrottler(Limits{Classes: map[string]int64{"get": 320 * 1024}})
+	limiter := throttler.For("anonymous", "get")
+
+	start := time.Now()
+	if _, err := io.Copy(limiter.Writer(context.Background(), io.Discard), bytes.NewReader(make([]byte, 96*1024))); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("transferred 96 KiB at 320 KiB/s in %v", elapsed)
+	}
+}
+
+func Test_should_ApplyChangedLimits_To_ExistingLimiters(t *testing.T) {
+	throttler := NewThrottler(Limits{Global: 1024})
+	limiter := throttler.For("anonymous", "put")
+
+	throttler.SetLimits(Limits{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_ShareBuckets_Of_SamePrincipal(t *testing.T) {
+	throttler := NewThrottler(Limits{PerPrincipal: 320 * 1024})
+	first := throttler.For("alice", "get")
+	second := throttler.For("alice", "put")
+	other := throttler.For("bob", "get")
+
+	if first.buckets[1] != second.buckets[1] {
+		t.Fatal("requests of one principal use different buckets")
+	}
+	if first.buckets[1] == other.buckets[1] {
+		t.Fatal("requests of different principals share a bucket")
+	}
+}
+
+func Test_should_NotDelay_Without_Limiter(t *testing.T) {
+	var limiter *Limiter
+	ctx := NewContext(context.Background(), limiter)
+
+	reader := bytes.NewReader(nil)
+	if FromContext(ctx).Reader(ctx, reader) != reader {
+		t.Fatal("nil limiter wrapped the reader")
+	}
+	if err := FromContext(context.Background()).Wait(ctx, 1<<30); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_EvictIdleBuckets_Of_Principals(t *testing.T) {
+	throttler := NewThrottler(Limits{PerPrincipal: 320 * 1024})
+	busy := throttler.For("alice", "get")
+	throttler.For("bob", "get")
+	if err := busy.Wait(context.Background(), minBurst); err != nil {
+		t.Fatal(err)
+	}
+
+	throttler.mutex.Lock()
+	throttler.evictIdle(time.Now())
+	_, aliceKept := throttler.principals["alice"]
+	_, bobKept := throttler.principals["bob"]
+	throttler.mutex.Unlock()
+
+	if !aliceKept || bobKept {
+		t.Fatalf("got alice kept %v and bob kept %v, want only the bucket drawn from kept", aliceKept, bobKept)
+	}
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanData is a finished span as it is handed to exporters.
+type SpanData struct {
+	Name         string         `json:"name"`
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+type Exporter interface {
+	ExportSpan(span SpanData)
+}
+
+var exporter atomic.Pointer[Exporter]
+
+// SetExporter sends all spans ended from now on to e, a nil e stops exporting.
+func SetExporter(e Exporter) {
+	if e == nil {
+		exporter.Store(nil)
+		return
+	}
+	exporter.Store(&e)
+}
+
+// WriterExporter writes every span as a line of JSON.
+type WriterExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriterExporter(writer io.Writer) *WriterExporter {
+	return &WriterExporter{encoder: json.NewEncoder(writer)}
+}
+
+func (we *WriterExporter) ExportSpan(span SpanData) {
+	we.mutex.Lock()
+	defer we.mutex.Unlock()
+	if err := we.encoder.Encode(span); err != nil {
+		slog.Warn("Exporting span failed", "name", span.Name, "err", err)
+	}
+}
+
+// Setup installs the exporter named by name, as TRACE_EXPORTER configures it: none (the default),
+// stdout, or file, which appends to path. The returned closer closes the trace file, if there is
+// one.
+func Setup(name string, path string) (io.Closer, error) {
+	switch name {
+	case "", "none":
+		return io.NopCloser(nil), nil
+	case "stdout":
+		SetExporter(NewWriterExporter(os.Stdout))
+		return io.NopCloser(nil), nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("the file trace exporter needs TRACE_PATH")
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		SetExporter(NewWriterExporter(file))
+		return file, nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q, use none, stdout or file", name)
+}
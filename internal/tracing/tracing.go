@@ -0,0 +1,218 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID and SpanID follow the W3C trace context format, so that traces can be joined with
+// those of other OpenTelemetry instrumented systems.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent encodes the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	if !sc.Valid() {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, sc.TraceID, sc.SpanID, traceparentSampled)
+}
+
+func ParseTraceparent(traceparent string) (SpanContext, bool) {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != traceparentVersion {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if !decodeID(sc.TraceID[:], parts[1]) || !decodeID(sc.SpanID[:], parts[2]) {
+		return SpanContext{}, false
+	}
+	return sc, sc.Valid()
+}
+
+func decodeID(id []byte, encoded string) bool {
+	n, err := hex.Decode(id, []byte(encoded))
+	return err == nil && n == len(id) && len(encoded) == 2*len(id)
+}
+
+// Span is a timed operation of a trace. All methods of a nil Span do nothing, StartChild returns
+// one when there is no trace to add to.
+type Span struct {
+	mutex       sync.Mutex
+	name        string
+	spanContext SpanContext
+	parent      SpanID
+	start       time.Time
+	attributes  []Attribute
+	err         error
+	ended       bool
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// Start begins a span named name, as a child of the span or remote span context in ctx, or as the
+// root of a new trace.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	span := &Span{
+		name:       name,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	if parent := SpanContextFromContext(ctx); parent.Valid() {
+		span.spanContext.TraceID = parent.TraceID
+		span.parent = parent.SpanID
+	} else {
+		span.spanContext.TraceID = newTraceID()
+	}
+	span.spanContext.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartChild begins a span only if ctx belongs to a trace, otherwise it returns ctx and a nil span.
+func StartChild(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	if !SpanContextFromContext(ctx).Valid() {
+		return ctx, nil
+	}
+	return Start(ctx, name, attributes...)
+}
+
+// ContextWithRemoteSpanContext makes spans started from the returned context children of a span
+// of another process.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span.spanContext
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// SetError marks the span as failed, a nil err leaves it as it is.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+// End finishes the span and hands it to the exporter, only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	data := s.data(time.Now())
+	s.mutex.Unlock()
+
+	if e := exporter.Load(); e != nil {
+		(*e).ExportSpan(data)
+	}
+}
+
+func (s *Span) data(end time.Time) SpanData {
+	data := SpanData{
+		Name:       s.name,
+		TraceID:    s.spanContext.TraceID.String(),
+		SpanID:     s.spanContext.SpanID.String(),
+		Start:      s.start,
+		End:        end,
+		Attributes: make(map[string]any, len(s.attributes)),
+	}
+	if s.parent != (SpanID{}) {
+		data.ParentSpanID = s.parent.String()
+	}
+	for _, attribute := range s.attributes {
+		data.Attributes[attribute.Key] = attribute.Value
+	}
+	if s.err != nil {
+		data.Error = s.err.Error()
+	}
+	return data
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		putUint64(id[:8], rand.Uint64())
+		putUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		putUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+}
+
+const (
+	traceparentVersion = "00"
+	traceparentSampled = "01"
+)
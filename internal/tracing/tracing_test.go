@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_should_ParseTraceparent_Of_SpanContext(t *testing.T) {
+	_, span := Start(context.Background(), "test")
+	sc := span.spanContext
+
+	parsed, ok := ParseTraceparent(sc.Traceparent())
+	if !ok || parsed != sc {
+		t.Fatalf("got %v %t want %v", parsed, ok, sc)
+	}
+}
+
+func Test_should_RejectInvalidTraceparent(t *testing.T) {
+	for _, traceparent := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-zzad6b7169203331-01",
+	} {
+		if sc, ok := ParseTraceparent(traceparent); ok {
+			t.Errorf("parsed %q as %v", traceparent, sc)
+		}
+	}
+}
+
+func Test_should_StartChild_Only_Within_Trace(t *testing.T) {
+	if _, span := StartChild(context.Background(), "orphan"); span != nil {
+		t.Fatal("started a span outside of a trace")
+	}
+
+	ctx, root := Start(context.Background(), "root")
+	_, child := StartChild(ctx, "child")
+	if child == nil || child.spanContext.TraceID != root.spanContext.TraceID || child.parent != root.spanContext.SpanID {
+		t.Fatalf("child %v does not belong to root %v", child, root)
+	}
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func Test_shouldWriteAccessLogEntryPerRequest(t *testing.T) {
+	// given
+	filename := "accessLogTest.txt"
+	createFile(filepath.Join(testServerStoragePath, filename), 8*1024)
+
+	logs := &lockedBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	defer slog.SetDefault(previous)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	webClient := getClient()
+	res, err := webClient.Run(message.GetFileRequest{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateGetFileRes(t, res)
+	if _, err = webClient.Run(message.DeleteFileRequest{Filename: "missing.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	var entries []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(logs.Bytes()))
+	for scanner.Scan() {
+		var entry map[string]any
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["msg"] == "Access" {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d access log entries want 2", len(entries))
+	}
+	get, del := entries[0], entries[1]
+	if get["type"] != "get" || get["filename"] != filename || get["status"] != float64(200) || get["bytes"] != float64(8*1024) {
+		t.Fatalf("unexpected entry %v", get)
+	}
+	if del["type"] != "delete" || del["status"] != float64(404) || del["bytes"] != float64(0) {
+		t.Fatalf("unexpected entry %v", del)
+	}
+	if get["session"] == "" || get["session"] != del["session"] || get["remote"] == "" || get["principal"] != "127.0.0.1" {
+		t.Fatalf("entries do not identify the session: %v %v", get, del)
+	}
+}
+
+// lockedBuffer collects the log lines the server goroutines write concurrently.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return bytes.Clone(b.buffer.Bytes())
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_shouldAbortDownload_And_RemovePartialFile_When_DeadlineExceeded(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerThrottleClasses, "get=65536")()
+
+	filename := "cancelDownloadTest.txt"
+	createFile(filepath.Join(testServerStoragePath, filename), 1024*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	webClient := getClient()
+	ctx, cancelRequest := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelRequest()
+
+	// when
+	start := time.Now()
+	_, err := webClient.RunContext(ctx, message.GetFileRequest{Filename: filename})
+	elapsed := time.Since(start)
+
+	// then
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("aborting took %v", elapsed)
+	}
+	entries, err := os.ReadDir(testClientStoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), filename) {
+			t.Fatalf("download left %s behind", entry.Name())
+		}
+	}
+	if res, err := webClient.Stat(context.Background(), filename); err != nil || res.Status != 200 {
+		t.Fatalf("client did not replace the aborted session: %v %v", res, err)
+	}
+}
+
+func Test_shouldAbortUpload_When_Cancelled(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	filename := "cancelUploadTest.txt"
+	reader, writer := io.Pipe()
+	go func() {
+		for {
+			if _, err := writer.Write(make([]byte, 16)); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	defer reader.Close()
+
+	ctx, cancelRequest := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancelRequest)
+
+	// when
+	start := time.Now()
+	_, err := getClient().Put(ctx, filename, reader, 1024*1024)
+	elapsed := time.Since(start)
+
+	// then
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v want %v", err, context.Canceled)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("aborting took %v", elapsed)
+	}
+	if _, err = getClient().Stat(context.Background(), filename); err != nil {
+		t.Fatalf("server did not survive the aborted upload: %v", err)
+	}
+}
+
+func Test_shouldKeepSession_When_CancelledBeforeRequest(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	webClient := getClient()
+	ctx, cancelRequest := context.WithCancel(context.Background())
+	cancelRequest()
+
+	// when
+	_, err := webClient.List(ctx, ".*")
+
+	// then
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v want %v", err, context.Canceled)
+	}
+	if res, err := webClient.List(context.Background(), ".*"); err != nil || res.Status != 200 {
+		t.Fatalf("session unusable after a request that never started: %v %v", res, err)
+	}
+}
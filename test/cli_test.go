@@ -0,0 +1,121 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/cli"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_shouldManageFiles_With_CLI(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	localDir := t.TempDir()
+	content := bytes.Repeat([]byte("cli"), 4096)
+	localPath := filepath.Join(localDir, "cliTest.txt")
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	runCLI(t, cli.ExitOK, nil, "put", localPath)
+	runCLI(t, cli.ExitOK, nil, "cp", "cliTest.txt", "cliTestCopy.txt")
+	runCLI(t, cli.ExitOK, nil, "mv", "cliTestCopy.txt", "cliTestMoved.txt")
+	downloaded, _ := runCLI(t, cli.ExitOK, nil, "get", "-o", "-", "cliTestMoved.txt")
+	stat, _ := runCLI(t, cli.ExitOK, nil, "-json", "stat", "cliTest.txt")
+	listed, _ := runCLI(t, cli.ExitOK, nil, "ls", "^cliTest")
+	runCLI(t, cli.ExitOK, nil, "rm", "cliTest.txt")
+	_, missing := runCLI(t, cli.ExitNotFound, nil, "stat", "cliTest.txt")
+
+	// then
+	if !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded %d bytes want %d", len(downloaded), len(content))
+	}
+	var statResult struct {
+		Status int `json:"status"`
+		Size   int `json:"size"`
+	}
+	if err := json.Unmarshal(stat, &statResult); err != nil {
+		t.Fatal(err)
+	}
+	if statResult.Status != 200 || statResult.Size != len(content) {
+		t.Fatalf("unexpected stat result %s", stat)
+	}
+	if got, want := string(listed), "cliTest.txt\ncliTestMoved.txt\n"; got != want {
+		t.Fatalf("listed %q want %q", got, want)
+	}
+	if !strings.Contains(string(missing), "404") {
+		t.Fatalf("missing file reported as %q", missing)
+	}
+}
+
+func Test_shouldUploadStdin_And_TransferRecursively_With_CLI(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	uploadDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(uploadDir, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"cliTreeA.txt", filepath.Join("nested", "cliTreeB.txt")} {
+		createFile(filepath.Join(uploadDir, path), 1024)
+	}
+	downloadDir := t.TempDir()
+
+	// when
+	runCLI(t, cli.ExitOK, []byte("from stdin"), "put", "-name", "cliStdin.txt", "-")
+	runCLI(t, cli.ExitOK, nil, "put", "-r", uploadDir)
+	runCLI(t, cli.ExitOK, nil, "get", "-r", "-dir", downloadDir, "^cliTree", "^cliStdin")
+	runCLI(t, cli.ExitOK, nil, "rm", "-r", "^cliTree")
+	listed, _ := runCLI(t, cli.ExitOK, nil, "ls", "^cliTree")
+
+	// then
+	for _, name := range []string{"cliTreeA.txt", "cliTreeB.txt", "cliStdin.txt"} {
+		if !fileExists(filepath.Join(downloadDir, name)) {
+			t.Errorf("%s was not downloaded", name)
+		}
+	}
+	stdin, err := os.ReadFile(filepath.Join(downloadDir, "cliStdin.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdin) != "from stdin" {
+		t.Fatalf("got %q want %q", stdin, "from stdin")
+	}
+	if len(listed) != 0 {
+		t.Fatalf("recursive rm left %q", listed)
+	}
+}
+
+func Test_shouldNotLeaveFile_When_CLIDownloadIsNotFound(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+	downloadDir := t.TempDir()
+
+	// when
+	runCLI(t, cli.ExitNotFound, nil, "get", "-dir", downloadDir, "missing.txt")
+
+	// then
+	if fileExists(filepath.Join(downloadDir, "missing.txt")) {
+		t.Fatal("download of a missing file left a local file")
+	}
+}
+
+func runCLI(t *testing.T, wantCode int, stdin []byte, args ...string) ([]byte, []byte) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-addr", fmt.Sprintf(":%d", port)}, args...)
+	code := cli.Run(context.Background(), args, bytes.NewReader(stdin), &stdout, &stderr)
+	if code != wantCode {
+		t.Fatalf("%v exited with %d want %d: %s", args, code, wantCode, stderr.String())
+	}
+	return stdout.Bytes(), stderr.Bytes()
+}
@@ -0,0 +1,190 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/mat-sik/file-server-go/internal/cli"
+	"github.com/mat-sik/file-server-go/internal/delta"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_shouldSendOnlyDifferences_With_SDKUpdate(t *testing.T) {
+	// given
+	logs := &lockedBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	defer slog.SetDefault(previous)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t)
+	content := randomContent(512 * 1024)
+	if err := sdkClient.PutFile(ctx, "deltaTest.bin", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	modified := append(bytes.Clone(content[:200_000]), []byte("inserted")...)
+	modified = append(modified, content[200_000:]...)
+	copy(modified[1000:], "overwritten")
+
+	// when
+	if err := sdkClient.UpdateFile(ctx, "deltaTest.bin", bytes.NewReader(modified), int64(len(modified))); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	var stored bytes.Buffer
+	if _, err := sdkClient.GetFile(ctx, "deltaTest.bin", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored.Bytes(), modified) {
+		t.Fatalf("the rebuilt file of %d bytes differs from the %d uploaded", stored.Len(), len(modified))
+	}
+	sent := -1
+	scanner := bufio.NewScanner(bytes.NewReader(logs.Bytes()))
+	for scanner.Scan() {
+		var entry struct {
+			Msg    string `json:"msg"`
+			Type   string `json:"type"`
+			Status int    `json:"status"`
+			Bytes  int    `json:"bytes"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil && entry.Msg == "Access" && entry.Type == "put_delta" {
+			if entry.Status != 201 {
+				t.Errorf("the delta was answered with %d", entry.Status)
+			}
+			sent = entry.Bytes
+		}
+	}
+	if sent < 0 || sent > len(modified)/10 {
+		t.Errorf("sent a delta of %d bytes for a file of %d bytes", sent, len(modified))
+	}
+	assertNoStagedUploads(t)
+}
+
+func Test_shouldRebuildFileFromDelta_And_RejectStaleDeltas(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	webClient := getClient()
+	defer webClient.Close()
+	base := bytes.Repeat([]byte("0123456789"), 1000)
+	if _, err := webClient.Put(ctx, "deltaRawTest.txt", bytes.NewReader(base), len(base)); err != nil {
+		t.Fatal(err)
+	}
+	session := netmsg.NewSession(dialServer(t))
+	defer session.Close()
+
+	if err := session.SendMessage(ctx, message.GetSignatureRequest{Filename: "deltaRawTest.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := session.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, ok := msg.(message.GetSignatureResponse)
+	if !ok || signature.Status != 200 || signature.Size != len(base) || len(signature.Blocks) != 5 {
+		t.Fatalf("got signature %T with status %d of %d bytes", msg, signature.Status, signature.Size)
+	}
+	putDelta := func(filename string, instructions []byte, size int, checksum string, key string) int {
+		req := message.PutDeltaRequest{
+			Filename:       filename,
+			BlockSize:      signature.BlockSize,
+			Size:           size,
+			DeltaSize:      len(instructions),
+			Checksum:       checksum,
+			IdempotencyKey: key,
+		}
+		if err := session.SendMessage(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if err := session.StreamToNet(ctx, bytes.NewReader(instructions), len(instructions)); err != nil {
+			t.Fatal(err)
+		}
+		return receiveStatus(t, session)
+	}
+	newVersion := append([]byte("head"), base[2048:]...)
+	ops, err := delta.Diff(bytes.NewReader(newVersion), delta.Signature{
+		BlockSize: signature.BlockSize,
+		Size:      signature.Size,
+		Blocks:    signature.Blocks,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var instructions bytes.Buffer
+	if _, err = instructions.ReadFrom(delta.NewReader(ops, bytes.NewReader(newVersion))); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(newVersion)
+	checksum := hex.EncodeToString(sum[:])
+
+	// when
+	missing := putDelta("deltaMissingTest.txt", instructions.Bytes(), len(newVersion), checksum, "")
+	unknownBlock := putDelta("deltaRawTest.txt", []byte{'c', 9, 1}, signature.BlockSize, checksum, "")
+	malformed := putDelta("deltaRawTest.txt", []byte{'?'}, 0, checksum, "")
+	wrongChecksum := putDelta("deltaRawTest.txt", instructions.Bytes(), len(newVersion), hex.EncodeToString(make([]byte, 32)), "")
+	tooLong := putDelta("deltaRawTest.txt", []byte{'c', 0, 1, 'c', 0, 1}, signature.BlockSize, checksum, "")
+	rebuilt := putDelta("deltaRawTest.txt", instructions.Bytes(), len(newVersion), checksum, "delta-key")
+	retried := putDelta("deltaRawTest.txt", instructions.Bytes(), len(newVersion), checksum, "delta-key")
+
+	// then
+	if missing != 404 || unknownBlock != 409 || malformed != 400 || wrongChecksum != 422 || tooLong != 422 {
+		t.Errorf("got %d for a missing file, %d for an unknown block, %d for a malformed delta, %d for a wrong checksum, %d for a delta too long",
+			missing, unknownBlock, malformed, wrongChecksum, tooLong)
+	}
+	if rebuilt != 201 || retried != 201 {
+		t.Errorf("rebuilding returned %d then %d", rebuilt, retried)
+	}
+	var stored bytes.Buffer
+	if _, err = webClient.Get(ctx, "deltaRawTest.txt", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored.Bytes(), newVersion) {
+		t.Errorf("got %d bytes want the %d of the new version", stored.Len(), len(newVersion))
+	}
+	assertNoStagedUploads(t)
+}
+
+func Test_shouldUpdateFiles_With_CLIPutDelta(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	localPath := filepath.Join(t.TempDir(), "deltaCLITest.bin")
+	content := randomContent(256 * 1024)
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runCLI(t, cli.ExitOK, nil, "put", "-delta", localPath)
+	copy(content[100_000:], "changed")
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	runCLI(t, cli.ExitOK, nil, "put", "-delta", localPath)
+
+	// then
+	webClient := getClient()
+	defer webClient.Close()
+	var stored bytes.Buffer
+	if _, err := webClient.Get(context.Background(), "deltaCLITest.bin", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored.Bytes(), content) {
+		t.Fatalf("the updated file of %d bytes differs from the local one", stored.Len())
+	}
+}
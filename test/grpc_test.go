@@ -0,0 +1,156 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/generated/netmsgpb"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_shouldServeFilesOverGRPC(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerGRPCAddr, fmt.Sprintf(":%d", grpcPort))()
+
+	filename := "grpcGetTest.txt"
+	createFile(filepath.Join(testServerStoragePath, filename), 200*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	conn, err := grpc.NewClient(fmt.Sprintf("localhost:%d", grpcPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	grpcClient := netmsgpb.NewFileServiceClient(conn)
+	ctx := context.Background()
+
+	// when
+	getStream, err := grpcClient.GetFile(ctx, &netmsgpb.GetFileRequest{Filename: proto.String(filename)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getRes, content := receiveGRPCFile(t, getStream)
+
+	// then
+	if getRes.GetStatus() != http.StatusOK || getRes.GetSize() != 200*1024 {
+		t.Fatalf("got %v", getRes)
+	}
+	if !bytes.Equal(content, bytes.Repeat([]byte{'x'}, 200*1024)) {
+		t.Fatalf("file not equal")
+	}
+
+	// and when
+	uploaded := bytes.Repeat([]byte("grpc"), 50*1024)
+	putStream, err := grpcClient.PutFile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putReq := &netmsgpb.PutFileRequest{Filename: proto.String("grpcPutTest.txt"), Size: proto.Int64(int64(len(uploaded)))}
+	if err = putStream.Send(&netmsgpb.PutFileStreamRequest{Part: &netmsgpb.PutFileStreamRequest_Request{Request: putReq}}); err != nil {
+		t.Fatal(err)
+	}
+	for chunk := range slices.Chunk(uploaded, 64*1024) {
+		if err = putStream.Send(&netmsgpb.PutFileStreamRequest{Part: &netmsgpb.PutFileStreamRequest_Chunk{Chunk: chunk}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putRes, err := putStream.CloseAndRecv()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if putRes.GetStatus() != http.StatusCreated {
+		t.Fatalf("got %v", putRes)
+	}
+
+	// and when
+	namesRes, err := grpcClient.GetFilenames(ctx, &netmsgpb.GetFilenamesRequest{MatchRegex: proto.String("^grpc")})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := netmsg.FromProto(&netmsgpb.MessageWrapper{
+		Message: &netmsgpb.MessageWrapper_GetFilenamesResponse{GetFilenamesResponse: namesRes},
+	})
+	validateGetFilenamesRes(t, res.(message.Response), http.StatusOK, []string{filename, "grpcPutTest.txt"})
+
+	// and when
+	delRes, err := grpcClient.DeleteFile(ctx, &netmsgpb.DeleteFileRequest{Filename: proto.String("grpcPutTest.txt")})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delRes.GetStatus() != http.StatusOK {
+		t.Fatalf("got %v", delRes)
+	}
+
+	// and when
+	getStream, err = grpcClient.GetFile(ctx, &netmsgpb.GetFileRequest{Filename: proto.String("grpcPutTest.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getRes, _ = receiveGRPCFile(t, getStream)
+
+	// then
+	if getRes.GetStatus() != http.StatusNotFound {
+		t.Fatalf("got %v", getRes)
+	}
+
+	// and when
+	copyRes, err := grpcClient.CopyFile(ctx, &netmsgpb.CopyFileRequest{From: proto.String(filename), To: proto.String("grpcCopyTest.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moveRes, err := grpcClient.MoveFile(ctx, &netmsgpb.MoveFileRequest{From: proto.String("grpcCopyTest.txt"), To: proto.String("grpcMoveTest.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statRes, err := grpcClient.StatFile(ctx, &netmsgpb.StatFileRequest{Filename: proto.String("grpcMoveTest.txt")})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copyRes.GetStatus() != http.StatusCreated || moveRes.GetStatus() != http.StatusOK {
+		t.Fatalf("got %v and %v", copyRes, moveRes)
+	}
+	if statRes.GetStatus() != http.StatusOK || statRes.GetSize() != 200*1024 || statRes.GetModTime() == 0 {
+		t.Fatalf("got %v", statRes)
+	}
+}
+
+func receiveGRPCFile(t *testing.T, stream netmsgpb.FileService_GetFileClient) (*netmsgpb.GetFileResponse, []byte) {
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content bytes.Buffer
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return first.GetResponse(), content.Bytes()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content.Write(msg.GetChunk())
+	}
+}
+
+const grpcPort = 33305
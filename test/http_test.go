@@ -0,0 +1,226 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_shouldServeFilesOverHTTP(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerHTTPAddr, fmt.Sprintf(":%d", httpPort))()
+
+	filename := "httpGatewayTest.txt"
+	serverFilePath := filepath.Join(testServerStoragePath, filename)
+	createFile(serverFilePath, 64*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	res := doHTTP(t, http.MethodGet, "/files/"+filename, nil, nil)
+
+	// then
+	body := readBody(t, res)
+	if res.StatusCode != http.StatusOK || len(body) != 64*1024 {
+		t.Fatalf("got %d with %d bytes, want 200 with %d bytes", res.StatusCode, len(body), 64*1024)
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("missing ETag")
+	}
+
+	// and when
+	res = doHTTP(t, http.MethodGet, "/files/"+filename, nil, map[string]string{"Range": "bytes=10-19"})
+
+	// then
+	body = readBody(t, res)
+	if res.StatusCode != http.StatusPartialContent || !bytes.Equal(body, bytes.Repeat([]byte{'x'}, 10)) {
+		t.Fatalf("got %d with %q, want 206 with 10 bytes", res.StatusCode, body)
+	}
+
+	// and when
+	res = doHTTP(t, http.MethodGet, "/files/"+filename, nil, map[string]string{"If-None-Match": etag})
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusNotModified {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusNotModified)
+	}
+
+	// and when
+	uploaded := []byte("uploaded over http")
+	res = doHTTP(t, http.MethodPut, "/files/httpUpload.txt", uploaded, nil)
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusCreated || res.Header.Get("ETag") == "" {
+		t.Fatalf("got %d with ETag %q, want 201 with an ETag", res.StatusCode, res.Header.Get("ETag"))
+	}
+	res = doHTTP(t, http.MethodGet, "/files/httpUpload.txt", nil, nil)
+	if body = readBody(t, res); !bytes.Equal(body, uploaded) {
+		t.Fatalf("got %q want %q", body, uploaded)
+	}
+
+	// and when
+	res = doHTTP(t, http.MethodGet, "/files?match=^http", nil, nil)
+
+	// then
+	var filenames struct {
+		Filenames []string `json:"filenames"`
+	}
+	if err := json.Unmarshal(readBody(t, res), &filenames); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"httpGatewayTest.txt", "httpUpload.txt"}
+	if !reflect.DeepEqual(filenames.Filenames, expected) {
+		t.Fatalf("got %v want %v", filenames.Filenames, expected)
+	}
+
+	// and when
+	res = doHTTP(t, http.MethodDelete, "/files/"+filename, nil, nil)
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusOK || fileExists(serverFilePath) {
+		t.Fatalf("got %d, want the file deleted", res.StatusCode)
+	}
+
+	// and when
+	res = doHTTP(t, http.MethodGet, "/files/..%2Fescape", nil, nil)
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusBadRequest)
+	}
+
+	// and when
+	res = doHTTP(t, http.MethodGet, "/openapi.yaml", nil, nil)
+
+	// then
+	if body = readBody(t, res); res.StatusCode != http.StatusOK || !bytes.HasPrefix(body, []byte("openapi:")) {
+		t.Fatalf("got %d, want the OpenAPI description", res.StatusCode)
+	}
+
+	// and when
+	res = doHTTP(t, http.MethodGet, "/", nil, nil)
+
+	// then
+	if body = readBody(t, res); res.StatusCode != http.StatusOK || !bytes.Contains(body, []byte(`<script src="app.js">`)) {
+		t.Fatalf("got %d, want the web UI", res.StatusCode)
+	}
+	if res.Request.URL.Path != "/ui/" {
+		t.Fatalf("got %s, want to be redirected to /ui/", res.Request.URL.Path)
+	}
+	res = doHTTP(t, http.MethodGet, "/ui/app.js", nil, nil)
+	if readBody(t, res); res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Fatalf("got %d with %s, want the UI script", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+}
+
+func Test_shouldReleaseFile_When_HTTPUploadStalls(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerHTTPAddr, fmt.Sprintf(":%d", httpPort))()
+	defer setEnv(&envs.ServerIOTimeout, "200ms")()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	filename := "stalledHTTPTest.txt"
+	conn, err := net.Dial("tcp4", fmt.Sprintf(":%d", httpPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stalled := "PUT /files/" + filename + " HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100\r\n\r\nonly the start"
+	if _, err = io.WriteString(conn, stalled); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// when
+	content := []byte("after the stalled upload")
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/files/%s", httpPort, filename), bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := (&http.Client{Timeout: 2 * time.Second}).Do(req)
+
+	// then
+	if err != nil {
+		t.Fatalf("the stalled upload still holds the file: %v", err)
+	}
+	readBody(t, res)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusCreated)
+	}
+	res = doHTTP(t, http.MethodGet, "/files/"+filename, nil, nil)
+	if body := readBody(t, res); !bytes.Equal(body, content) {
+		t.Fatalf("got %q want %q", body, content)
+	}
+}
+
+func Test_shouldServeContent_StartingWithStoredFileMagic(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerHTTPAddr, fmt.Sprintf(":%d", httpPort))()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	content := []byte("\x89FSG\r\n\x1a\n\x02\x01\x00uploaded content that starts like a stored file")
+
+	// when
+	res := doHTTP(t, http.MethodPut, "/files/magicTest.bin", content, nil)
+	readBody(t, res)
+
+	// then
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusCreated)
+	}
+	res = doHTTP(t, http.MethodGet, "/files/magicTest.bin", nil, nil)
+	if body := readBody(t, res); res.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("got %d with %q, want %q", res.StatusCode, body, content)
+	}
+}
+
+func doHTTP(t *testing.T, method string, path string, body []byte, headers map[string]string) *http.Response {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d%s", httpPort, path), reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	// Connections must not outlive the server of a test, another one listens on the port next.
+	req.Close = true
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func readBody(t *testing.T, res *http.Response) []byte {
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+const httpPort = 33304
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_shouldRejectConnectionsOverPerIPLimit(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerMaxConnectionsPerIP, "1")()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	first := dialServer(t)
+	defer first.Close()
+
+	// when
+	second := dialServer(t)
+	defer second.Close()
+
+	// then
+	if !closedByServer(t, second) {
+		t.Fatal("connection over the limit was not closed")
+	}
+	if closedByServer(t, first) {
+		t.Fatal("connection within the limit was closed")
+	}
+}
+
+func Test_shouldCloseIdleSessions(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerIdleTimeout, "100ms")()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	conn := dialServer(t)
+	defer conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	// then
+	if !closedByServer(t, conn) {
+		t.Fatal("idle session was not closed")
+	}
+}
+
+func Test_shouldKeepServing_When_SessionFails(t *testing.T) {
+	// given
+	filename := "failingSessionTest.txt"
+	createFile(filepath.Join(testServerStoragePath, filename), 1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	conn := dialServer(t)
+	defer conn.Close()
+	if _, err := conn.Write([]byte("not a message header")); err != nil {
+		t.Fatal(err)
+	}
+	if !closedByServer(t, conn) {
+		t.Fatal("session sending garbage was not closed")
+	}
+
+	// when
+	webClient := getClient()
+	res, err := webClient.Run(message.GetFileRequest{Filename: filename})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateGetFileRes(t, res)
+}
+
+func dialServer(t *testing.T) net.Conn {
+	conn, err := net.Dial("tcp4", fmt.Sprintf(":%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// closedByServer reports whether the server closed conn within a short time.
+func closedByServer(t *testing.T, conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func setEnv(env *string, value string) func() {
+	previous := *env
+	*env = value
+	return func() {
+		*env = previous
+	}
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_shouldExposeMetrics(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerMetricsAddr, fmt.Sprintf(":%d", metricsPort))()
+
+	filename := "metricsTest.txt"
+	createFile(filepath.Join(testClientStoragePath, filename), 32*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	webClient := getClient()
+	res, err := webClient.Run(message.PutFileRequest{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatePutFileRes(t, res)
+
+	// when
+	scraped, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", metricsPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	body := string(readBody(t, scraped))
+	if scraped.StatusCode != http.StatusOK {
+		t.Fatalf("got %d want %d", scraped.StatusCode, http.StatusOK)
+	}
+	for _, expected := range []string{
+		`fileserver_requests_total{status="201",type="put"}`,
+		`fileserver_transferred_bytes_total{direction="in"}`,
+		`fileserver_transfer_duration_seconds_count{direction="in"}`,
+		`fileserver_lock_wait_seconds_count{mode="write"}`,
+		`fileserver_active_sessions{frontend="native"}`,
+		"fileserver_storage_files ",
+		"fileserver_storage_bytes ",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metrics do not contain %s", expected)
+		}
+	}
+}
+
+const metricsPort = 33310
@@ -0,0 +1,257 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/mat-sik/file-server-go/fileserver"
+	"github.com/mat-sik/file-server-go/internal/cli"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_shouldTransferLargeFilesInParallel_With_SDK(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t, fileserver.WithPartSize(64*1024), fileserver.WithMaxConnections(4))
+	content := randomContent(1024*1024 + 123)
+	downloadPath := filepath.Join(t.TempDir(), "parallelTest.bin")
+	download, err := os.Create(downloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer download.Close()
+
+	// when
+	if err = sdkClient.UploadFile(ctx, "parallelTest.bin", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	size, err := sdkClient.DownloadFile(ctx, "parallelTest.bin", download)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	downloaded, err := os.ReadFile(downloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(content)) || !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded %d bytes, %d in the file, want %d", size, len(downloaded), len(content))
+	}
+	var stored bytes.Buffer
+	if _, err = sdkClient.GetFile(ctx, "parallelTest.bin", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored.Bytes(), content) {
+		t.Fatal("the assembled file differs from the uploaded one")
+	}
+	assertNoStagedUploads(t)
+}
+
+func Test_shouldKeepFile_When_ParallelUploadDoesNotMatchChecksum(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t, fileserver.WithPartSize(64*1024))
+	if err := sdkClient.PutFile(ctx, "parallelKeptTest.txt", bytes.NewReader([]byte("old")), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	err := sdkClient.UploadFile(ctx, "parallelKeptTest.txt", &changingReader{}, 256*1024)
+
+	// then
+	if !errors.Is(err, fileserver.ErrChecksum) {
+		t.Fatalf("got %v want %v", err, fileserver.ErrChecksum)
+	}
+	var stored bytes.Buffer
+	if _, err = sdkClient.GetFile(ctx, "parallelKeptTest.txt", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.String() != "old" {
+		t.Fatalf("the failed upload replaced the file with %d bytes", stored.Len())
+	}
+	assertNoStagedUploads(t)
+}
+
+func Test_shouldAssembleUploadedParts_And_ServeRanges(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	session := netmsg.NewSession(dialServer(t))
+	defer session.Close()
+
+	content := []byte("abcdefghij")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	uploadID := createUpload(t, session, "partsTest.txt", len(content))
+	putPart := func(offset int, part string) int {
+		req := message.PutPartRequest{UploadID: uploadID, Offset: offset, Size: len(part)}
+		if err := session.SendMessage(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if err := session.StreamToNet(ctx, bytes.NewReader([]byte(part)), len(part)); err != nil {
+			t.Fatal(err)
+		}
+		return receiveStatus(t, session)
+	}
+	complete := func(checksum string, key string) int {
+		req := message.CompleteUploadRequest{UploadID: uploadID, Checksum: checksum, IdempotencyKey: key}
+		if err := session.SendMessage(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		return receiveStatus(t, session)
+	}
+
+	// when
+	first, beyondEnd := putPart(0, "abcd"), putPart(8, "ijk")
+	missingPart := complete(checksum, "first-key")
+	second := putPart(4, "efghij")
+	wrongChecksum := complete(hex.EncodeToString(make([]byte, sha256.Size)), "second-key")
+	completed, retried := complete(checksum, "third-key"), complete(checksum, "third-key")
+
+	// then
+	if first != 200 || second != 200 || beyondEnd != 400 {
+		t.Errorf("parts returned %d, %d and %d beyond the end", first, second, beyondEnd)
+	}
+	if missingPart != 409 || wrongChecksum != 422 {
+		t.Errorf("completing returned %d with a missing part and %d with a wrong checksum", missingPart, wrongChecksum)
+	}
+	if completed != 201 || retried != 201 {
+		t.Errorf("completing returned %d then %d", completed, retried)
+	}
+
+	ranged, body := getRange(t, session, "partsTest.txt", 2, 3)
+	if ranged.Status != 206 || string(body) != "cde" || ranged.ModTime.IsZero() {
+		t.Errorf("got %d %q modified %v want 206 cde", ranged.Status, body, ranged.ModTime)
+	}
+	ranged, body = getRange(t, session, "partsTest.txt", 8, 5)
+	if ranged.Status != 206 || string(body) != "ij" {
+		t.Errorf("got %d %q want 206 ij", ranged.Status, body)
+	}
+	if ranged, _ = getRange(t, session, "partsTest.txt", 10, 1); ranged.Status != 416 {
+		t.Errorf("got %d for a range past the end want 416", ranged.Status)
+	}
+	if err := session.SendMessage(ctx, message.AbortUploadRequest{UploadID: uploadID}); err != nil {
+		t.Fatal(err)
+	}
+	if status := receiveStatus(t, session); status != 404 {
+		t.Errorf("aborting a completed upload returned %d want 404", status)
+	}
+	assertNoStagedUploads(t)
+}
+
+func Test_shouldTransferLargeFilesInParallel_With_CLI(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	localDir, downloadDir := t.TempDir(), t.TempDir()
+	content := randomContent(9*1024*1024 + 1)
+	localPath := filepath.Join(localDir, "parallelCLITest.bin")
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	runCLI(t, cli.ExitOK, nil, "-parallel", "3", "put", localPath)
+	runCLI(t, cli.ExitOK, nil, "-parallel", "3", "get", "-dir", downloadDir, "parallelCLITest.bin")
+	runCLI(t, cli.ExitUsage, nil, "-parallel", "0", "ls")
+
+	// then
+	downloaded, err := os.ReadFile(filepath.Join(downloadDir, "parallelCLITest.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded %d bytes differing from the %d uploaded", len(downloaded), len(content))
+	}
+}
+
+func createUpload(t *testing.T, session netmsg.Session, filename string, size int) string {
+	ctx := context.Background()
+	if err := session.SendMessage(ctx, message.CreateUploadRequest{Filename: filename, Size: size}); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := session.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, ok := msg.(message.CreateUploadResponse)
+	if !ok || res.Status != 200 {
+		t.Fatalf("creating the upload returned %+v", msg)
+	}
+	return res.UploadID
+}
+
+func getRange(t *testing.T, session netmsg.Session, filename string, offset int, length int) (message.GetFileResponse, []byte) {
+	ctx := context.Background()
+	if err := session.SendMessage(ctx, message.GetFileRequest{Filename: filename, Offset: offset, Length: length}); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := session.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, ok := msg.(message.GetFileResponse)
+	if !ok {
+		t.Fatalf("unexpected response %T", msg)
+	}
+	if res.Status != 206 {
+		return res, nil
+	}
+	var body bytes.Buffer
+	if _, err = session.DecodeFromNet(ctx, &body, res.Encoding, res.TransferSize(), res.Size); err != nil {
+		t.Fatal(err)
+	}
+	return res, body.Bytes()
+}
+
+func assertNoStagedUploads(t *testing.T) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(testServerStoragePath, ".staging"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("%d uploads left in the staging area", len(entries))
+	}
+}
+
+func randomContent(size int) []byte {
+	random := rand.New(rand.NewPCG(1, 2))
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(random.Uint32())
+	}
+	return content
+}
+
+// changingReader returns different content every time it is read, as a file being written to
+// might.
+type changingReader struct {
+	reads atomic.Uint32
+}
+
+func (r *changingReader) ReadAt(p []byte, offset int64) (int, error) {
+	reads := byte(r.reads.Add(1))
+	for i := range p {
+		p[i] = byte(offset) + byte(i) + reads
+	}
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/fileserver"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_shouldTransferConcurrently_Over_SharedClient(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t, fileserver.WithMaxConnections(3))
+
+	// when
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("poolTest%02d.txt", i)
+			content := bytes.Repeat([]byte{byte('a' + i)}, 32*1024+i)
+			if err := sdkClient.PutFile(ctx, name, bytes.NewReader(content), int64(len(content))); err != nil {
+				errs <- err
+				return
+			}
+			var downloaded bytes.Buffer
+			if _, err := sdkClient.GetFile(ctx, name, &downloaded); err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(downloaded.Bytes(), content) {
+				errs <- fmt.Errorf("%s: downloaded %d bytes want %d", name, downloaded.Len(), len(content))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// then
+	for err := range errs {
+		t.Error(err)
+	}
+	names, err := sdkClient.List(ctx, "^poolTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 16 {
+		t.Fatalf("listed %d files want 16", len(names))
+	}
+}
+
+func Test_shouldReplaceConnection_When_ServerClosedIt(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerIdleTimeout, "100ms")()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t)
+	if _, err := sdkClient.List(ctx, ".*"); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	time.Sleep(1200 * time.Millisecond)
+	_, err := sdkClient.List(ctx, ".*")
+
+	// then
+	if err != nil {
+		t.Fatalf("the connection closed by the server was reused: %v", err)
+	}
+}
+
+func Test_shouldFailRequests_When_Closed(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	sdkClient, err := fileserver.Dial(context.Background(), fmt.Sprintf(":%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	if err = sdkClient.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sdkClient.List(context.Background(), ".*")
+
+	// then
+	if !errors.Is(err, fileserver.ErrClosed) {
+		t.Fatalf("got %v want %v", err, fileserver.ErrClosed)
+	}
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"github.com/mat-sik/file-server-go/fileserver"
+	"github.com/mat-sik/file-server-go/internal/cli"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_shouldReportTransferProgress(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerThrottleGlobal, "2097152")()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	sdkClient := dialSDK(t, fileserver.WithCompression(false))
+	content := bytes.Repeat([]byte("progress"), 64*1024)
+	var reports []fileserver.Progress
+	ctx := fileserver.ContextWithProgress(context.Background(), func(p fileserver.Progress) {
+		reports = append(reports, p)
+	})
+
+	// when
+	if err := sdkClient.PutFile(ctx, "progressTest.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	uploads := reports
+	reports = nil
+	if _, err := sdkClient.GetFile(ctx, "progressTest.txt", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	downloads := reports
+
+	// then
+	if len(downloads) < 3 {
+		t.Fatalf("got %d reports of a download taking 250ms", len(downloads))
+	}
+	for _, transfer := range [][]fileserver.Progress{uploads, downloads} {
+		for i, p := range transfer {
+			if p.Name != "progressTest.txt" || p.Total != int64(len(content)) || i > 0 && p.Done < transfer[i-1].Done {
+				t.Fatalf("unexpected report %+v", p)
+			}
+		}
+		if last := transfer[len(transfer)-1]; last.Done != last.Total {
+			t.Fatalf("the last report %+v is not complete", last)
+		}
+	}
+	if !uploads[0].Upload || downloads[0].Upload {
+		t.Fatal("the direction of the transfers was not reported")
+	}
+}
+
+func Test_shouldDrawProgressBars_With_CLI(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	localPath := filepath.Join(t.TempDir(), "progressbarTest.txt")
+	if err := os.WriteFile(localPath, bytes.Repeat([]byte("bar"), 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	_, withBar := runCLI(t, cli.ExitOK, nil, "-progress", "always", "put", localPath)
+	_, withoutBar := runCLI(t, cli.ExitOK, nil, "get", "-o", "-", "progressbarTest.txt")
+
+	// then
+	if !strings.Contains(string(withBar), "progressbarTest.txt [") || !strings.Contains(string(withBar), "100%") {
+		t.Errorf("no progress bar drawn: %q", withBar)
+	}
+	if bytes.Contains(withoutBar, []byte("\r")) {
+		t.Errorf("drew a progress bar on a non terminal: %q", withoutBar)
+	}
+	runCLI(t, cli.ExitUsage, nil, "-progress", "sometimes", "ls")
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"github.com/mat-sik/file-server-go/fileserver"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"testing"
+	"time"
+)
+
+func Test_shouldRetry_When_ServerRestarted(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer func() {
+		cancel()
+	}()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t)
+	content := []byte("survives restarts")
+	if err := sdkClient.PutFile(ctx, "retryTest.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	cancel()
+	cancel = runServerBlockTillListening()
+	var downloaded bytes.Buffer
+	_, err := sdkClient.GetFile(ctx, "retryTest.txt", &downloaded)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded.Bytes(), content) {
+		t.Fatalf("got %q want %q", downloaded.Bytes(), content)
+	}
+}
+
+func Test_shouldRetryWithBackoff_While_ServerIsDown(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	ctx := context.Background()
+	sdkClient := dialSDK(t, fileserver.WithRetries(10, 50*time.Millisecond, 200*time.Millisecond))
+	cancel()
+
+	restarted := make(chan context.CancelFunc, 1)
+	time.AfterFunc(300*time.Millisecond, func() {
+		restarted <- runServerBlockTillListening()
+	})
+	defer func() {
+		(<-restarted)()
+	}()
+
+	// when
+	start := time.Now()
+	_, err := sdkClient.List(ctx, ".*")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("listed after %v, before the server was back", elapsed)
+	}
+}
+
+func Test_shouldNotRetry_When_DisabledOrNotIdempotent(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer func() {
+		cancel()
+	}()
+
+	ctx := context.Background()
+	noRetries := dialSDK(t, fileserver.WithRetries(1, 0, 0))
+	retries := dialSDK(t)
+	cancel()
+	cancel = runServerBlockTillListening()
+
+	// when
+	_, listErr := noRetries.List(ctx, ".*")
+	moveErr := retries.Move(ctx, "retryMoveTest.txt", "retryMovedTest.txt")
+
+	// then
+	if listErr == nil {
+		t.Error("list was retried with retries disabled")
+	}
+	if moveErr == nil {
+		t.Error("move was retried")
+	}
+}
+
+func Test_shouldReplayResponse_When_IdempotencyKeyReused(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	session := netmsg.NewSession(dialServer(t))
+	defer session.Close()
+
+	put := func(content string) int {
+		req := message.PutFileRequest{Filename: "idempotencyTest.txt", Size: len(content), IdempotencyKey: "put-key"}
+		if err := session.SendMessage(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if err := session.StreamToNet(ctx, bytes.NewReader([]byte(content)), len(content)); err != nil {
+			t.Fatal(err)
+		}
+		return receiveStatus(t, session)
+	}
+	deleteFile := func(key string) int {
+		if err := session.SendMessage(ctx, message.DeleteFileRequest{Filename: "idempotencyTest.txt", IdempotencyKey: key}); err != nil {
+			t.Fatal(err)
+		}
+		return receiveStatus(t, session)
+	}
+
+	// when
+	firstPut, retriedPut := put("first"), put("retried")
+	var stored bytes.Buffer
+	if _, err := getClient().Get(ctx, "idempotencyTest.txt", &stored); err != nil {
+		t.Fatal(err)
+	}
+	firstDelete, retriedDelete, newDelete := deleteFile("delete-key"), deleteFile("delete-key"), deleteFile("other-key")
+
+	// then
+	if firstPut != 201 || retriedPut != 201 {
+		t.Errorf("put returned %d then %d", firstPut, retriedPut)
+	}
+	if stored.String() != "first" {
+		t.Errorf("the retried put was stored again: %q", stored.String())
+	}
+	if firstDelete != 200 || retriedDelete != 200 || newDelete != 404 {
+		t.Errorf("delete returned %d, %d then %d", firstDelete, retriedDelete, newDelete)
+	}
+}
+
+func receiveStatus(t *testing.T, session netmsg.Session) int {
+	_, msg, err := session.ReceiveMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch res := msg.(type) {
+	case message.PutFileResponse:
+		return res.Status
+	case message.DeleteFileResponse:
+		return res.Status
+	case message.PutPartResponse:
+		return res.Status
+	case message.CompleteUploadResponse:
+		return res.Status
+	case message.AbortUploadResponse:
+		return res.Status
+	case message.PutDeltaResponse:
+		return res.Status
+	}
+	t.Fatalf("unexpected response %T", msg)
+	return 0
+}
@@ -0,0 +1,312 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/sigv4"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_shouldServeFilesOverS3(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerS3Addr, fmt.Sprintf(":%d", s3Port))()
+	defer setEnv(&envs.ServerUsersPath, writeUserStore(t))()
+
+	bucketPath := filepath.Join(testServerStoragePath, "s3-bucket")
+	if err := os.Mkdir(bucketPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	content := []byte("hello over s3, with a signed payload")
+	res := doS3(t, http.MethodPut, "/files/s3/dir/object.txt", content, map[string]string{
+		"X-Amz-Content-Sha256": sha256Hex(content),
+	})
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") == "" {
+		t.Fatalf("got %d with ETag %q, want 200 with an ETag", res.StatusCode, res.Header.Get("ETag"))
+	}
+	if !fileExists(filepath.Join(testServerStoragePath, "s3%2Fdir%2Fobject.txt")) {
+		t.Fatalf("object is not stored in the root bucket")
+	}
+
+	// and when
+	res = doS3(t, http.MethodGet, "/files/s3/dir/object.txt", nil, map[string]string{"Range": "bytes=0-4"})
+
+	// then
+	if body := readBody(t, res); res.StatusCode != http.StatusPartialContent || string(body) != "hello" {
+		t.Fatalf("got %d with %q, want 206 with %q", res.StatusCode, body, "hello")
+	}
+
+	// and when
+	res = doS3(t, http.MethodGet, "/files?list-type=2&prefix=s3/&delimiter=/", nil, nil)
+
+	// then
+	var listing struct {
+		Contents []struct {
+			Key  string
+			Size int
+		}
+		CommonPrefixes []struct {
+			Prefix string
+		}
+	}
+	decodeXML(t, readBody(t, res), &listing)
+	if len(listing.Contents) != 0 || len(listing.CommonPrefixes) != 1 || listing.CommonPrefixes[0].Prefix != "s3/dir/" {
+		t.Fatalf("got %+v, want the common prefix s3/dir/", listing)
+	}
+	res = doS3(t, http.MethodGet, "/files?list-type=2&prefix=s3/dir/", nil, nil)
+	listing.CommonPrefixes = nil
+	decodeXML(t, readBody(t, res), &listing)
+	if len(listing.Contents) != 1 || listing.Contents[0].Key != "s3/dir/object.txt" || listing.Contents[0].Size != len(content) {
+		t.Fatalf("got %+v, want s3/dir/object.txt", listing)
+	}
+
+	// and when
+	res = doS3(t, http.MethodPut, "/files/tampered.txt", []byte("tampered"), map[string]string{
+		"X-Amz-Content-Sha256": sha256Hex([]byte("original")),
+	})
+
+	// then
+	if body := readBody(t, res); res.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("XAmzContentSHA256Mismatch")) {
+		t.Fatalf("got %d with %s, want XAmzContentSHA256Mismatch", res.StatusCode, body)
+	}
+	if fileExists(filepath.Join(testServerStoragePath, "tampered.txt")) {
+		t.Fatalf("tampered upload was kept")
+	}
+
+	// and when
+	res = doS3(t, http.MethodPut, "/files/s3/dir/object.txt", []byte("tampered"), map[string]string{
+		"X-Amz-Content-Sha256": sha256Hex([]byte("original")),
+	})
+	readBody(t, res)
+
+	// then
+	res = doS3(t, http.MethodGet, "/files/s3/dir/object.txt", nil, nil)
+	if body := readBody(t, res); res.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("got %d with %q, want the object as it was before the failed overwrite", res.StatusCode, body)
+	}
+
+	// and when
+	req := newS3Request(t, http.MethodGet, "/files/s3/dir/object.txt", nil)
+	sigv4.Sign(req, s3AccessKeyID, "wrong secret", "us-east-1", time.Now())
+	res = sendS3(t, req)
+
+	// then
+	if body := readBody(t, res); res.StatusCode != http.StatusForbidden || !bytes.Contains(body, []byte("SignatureDoesNotMatch")) {
+		t.Fatalf("got %d with %s, want SignatureDoesNotMatch", res.StatusCode, body)
+	}
+
+	// and when
+	req = newS3Request(t, http.MethodGet, "/files/s3/dir/object.txt", nil)
+	sigv4.Presign(req, s3AccessKeyID, s3Secret, "us-east-1", time.Now(), time.Minute)
+	res = sendS3(t, req)
+
+	// then
+	if body := readBody(t, res); res.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("got %d with %q, want the object through a presigned URL", res.StatusCode, body)
+	}
+
+	// and when
+	chunked := "b\r\nchunked ove\r\n6\r\nr s3..\r\n0\r\nx-amz-checksum-crc32:AAAAAA==\r\n\r\n"
+	res = doS3(t, http.MethodPut, "/s3-bucket/chunked.txt", []byte(chunked), map[string]string{
+		"X-Amz-Content-Sha256":         sigv4.StreamingUnsignedTrail,
+		"X-Amz-Decoded-Content-Length": "17",
+		"Content-Encoding":             "aws-chunked",
+	})
+
+	// then
+	readBody(t, res)
+	res = doS3(t, http.MethodGet, "/s3-bucket/chunked.txt", nil, nil)
+	if body := readBody(t, res); res.StatusCode != http.StatusOK || string(body) != "chunked over s3.." {
+		t.Fatalf("got %d with %q, want the decoded chunks", res.StatusCode, body)
+	}
+
+	// and when
+	res = doS3(t, http.MethodPut, "/s3-bucket/chunked.txt", []byte("b\r\nunterminate\r\n6\r\nd body\r\n"), map[string]string{
+		"X-Amz-Content-Sha256":         sigv4.StreamingUnsignedTrail,
+		"X-Amz-Decoded-Content-Length": "17",
+		"Content-Encoding":             "aws-chunked",
+	})
+
+	// then
+	if body := readBody(t, res); res.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("IncompleteBody")) {
+		t.Fatalf("got %d with %s, want IncompleteBody", res.StatusCode, body)
+	}
+	res = doS3(t, http.MethodGet, "/s3-bucket/chunked.txt", nil, nil)
+	if body := readBody(t, res); string(body) != "chunked over s3.." {
+		t.Fatalf("got %q, want the object kept without the terminating chunk", body)
+	}
+
+	// and when
+	res = doS3(t, http.MethodPost, "/s3-bucket/multi/part.bin?uploads", nil, nil)
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	decodeXML(t, readBody(t, res), &initiated)
+
+	parts := [][]byte{bytes.Repeat([]byte{'a'}, 64*1024), []byte("last part")}
+	var completion strings.Builder
+	completion.WriteString("<CompleteMultipartUpload>")
+	for i, part := range parts {
+		res = doS3(t, http.MethodPut, fmt.Sprintf("/s3-bucket/multi/part.bin?partNumber=%d&uploadId=%s", i+1, initiated.UploadID), part, nil)
+		readBody(t, res)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("part %d: got %d want %d", i+1, res.StatusCode, http.StatusOK)
+		}
+		fmt.Fprintf(&completion, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, res.Header.Get("ETag"))
+	}
+	completion.WriteString("</CompleteMultipartUpload>")
+	res = doS3(t, http.MethodPost, "/s3-bucket/multi/part.bin?uploadId="+initiated.UploadID, []byte(completion.String()), nil)
+
+	// then
+	if body := readBody(t, res); res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d with %s, want the upload completed", res.StatusCode, body)
+	}
+	res = doS3(t, http.MethodGet, "/s3-bucket/multi/part.bin", nil, nil)
+	if body := readBody(t, res); !bytes.Equal(body, bytes.Join(parts, nil)) {
+		t.Fatalf("got %d bytes want %d", len(body), len(parts[0])+len(parts[1]))
+	}
+	if !fileExists(filepath.Join(bucketPath, "multi%2Fpart.bin")) {
+		t.Fatalf("object is not stored in the bucket namespace")
+	}
+
+	// and when
+	res = doS3(t, http.MethodDelete, "/files/s3/dir/object.txt", nil, nil)
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusNoContent)
+	}
+	res = doS3(t, http.MethodGet, "/files/s3/dir/object.txt", nil, nil)
+	if body := readBody(t, res); res.StatusCode != http.StatusNotFound || !bytes.Contains(body, []byte("NoSuchKey")) {
+		t.Fatalf("got %d with %s, want NoSuchKey", res.StatusCode, body)
+	}
+	res = doS3(t, http.MethodGet, "/missing-bucket/key", nil, nil)
+	if body := readBody(t, res); res.StatusCode != http.StatusNotFound || !bytes.Contains(body, []byte("NoSuchBucket")) {
+		t.Fatalf("got %d with %s, want NoSuchBucket", res.StatusCode, body)
+	}
+}
+
+func Test_shouldKeepObject_When_MultipartCompletionFails(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerS3Addr, fmt.Sprintf(":%d", s3Port))()
+	defer setEnv(&envs.ServerUsersPath, writeUserStore(t))()
+
+	bucketPath := filepath.Join(testServerStoragePath, "s3-failing")
+	if err := os.Mkdir(bucketPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := []byte("previous object")
+	if err := os.WriteFile(filepath.Join(bucketPath, "object.bin"), old, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	res := doS3(t, http.MethodPost, "/s3-failing/object.bin?uploads", nil, nil)
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	decodeXML(t, readBody(t, res), &initiated)
+
+	var completion strings.Builder
+	completion.WriteString("<CompleteMultipartUpload>")
+	for i, part := range [][]byte{[]byte("first part"), []byte("second part")} {
+		res = doS3(t, http.MethodPut, fmt.Sprintf("/s3-failing/object.bin?partNumber=%d&uploadId=%s", i+1, initiated.UploadID), part, nil)
+		readBody(t, res)
+		fmt.Fprintf(&completion, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, res.Header.Get("ETag"))
+	}
+	completion.WriteString("</CompleteMultipartUpload>")
+	// The second part goes missing after its ETag was checked, once the first was assembled.
+	secondPart := filepath.Join(testServerStoragePath, ".staging", initiated.UploadID, "part-00002")
+	if err := os.Remove(secondPart); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	res = doS3(t, http.MethodPost, "/s3-failing/object.bin?uploadId="+initiated.UploadID, []byte(completion.String()), nil)
+
+	// then
+	if body := readBody(t, res); res.StatusCode == http.StatusOK {
+		t.Fatalf("got %d with %s, want the completion to fail", res.StatusCode, body)
+	}
+	res = doS3(t, http.MethodGet, "/s3-failing/object.bin", nil, nil)
+	if body := readBody(t, res); res.StatusCode != http.StatusOK || !bytes.Equal(body, old) {
+		t.Fatalf("got %d with %q, want the previous object %q", res.StatusCode, body, old)
+	}
+	res = doS3(t, http.MethodDelete, "/s3-failing/object.bin?uploadId="+initiated.UploadID, nil, nil)
+	readBody(t, res)
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("got %d want the failed upload to be aborted", res.StatusCode)
+	}
+}
+
+func doS3(t *testing.T, method string, path string, body []byte, headers map[string]string) *http.Response {
+	req := newS3Request(t, method, path, body)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	sigv4.Sign(req, s3AccessKeyID, s3Secret, "us-east-1", time.Now())
+	return sendS3(t, req)
+}
+
+func newS3Request(t *testing.T, method string, path string, body []byte) *http.Request {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d%s", s3Port, path), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Connections must not outlive the server of a test, another one listens on the port next.
+	req.Close = true
+	return req
+}
+
+func sendS3(t *testing.T, req *http.Request) *http.Response {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func decodeXML(t *testing.T, body []byte, target any) {
+	if err := xml.Unmarshal(body, target); err != nil {
+		t.Fatalf("%v in %s", err, body)
+	}
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeUserStore(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "users.json")
+	store := fmt.Sprintf(`{"users": [{"name": "pipeline", "accessKeys": [{"id": %q, "secret": %q}]}]}`, s3AccessKeyID, s3Secret)
+	if err := os.WriteFile(path, []byte(store), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const (
+	s3Port        = 33307
+	s3AccessKeyID = "AKIDPIPELINE"
+	s3Secret      = "pipeline-secret"
+)
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mat-sik/file-server-go/fileserver"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_shouldManageFiles_With_SDK(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t, fileserver.WithIOTimeout(5*time.Second))
+	content := bytes.Repeat([]byte("sdk"), 64*1024)
+
+	// when
+	if err := sdkClient.PutFile(ctx, "sdkTest.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdkClient.Copy(ctx, "sdkTest.txt", "sdkTestCopy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	var downloaded bytes.Buffer
+	size, err := sdkClient.GetFile(ctx, "sdkTestCopy.txt", &downloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := sdkClient.Stat(ctx, "sdkTest.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sdkClient.Delete(ctx, "sdkTestCopy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := sdkClient.List(ctx, "^sdkTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	if size != int64(len(content)) || !bytes.Equal(downloaded.Bytes(), content) {
+		t.Fatalf("downloaded %d bytes want %d", downloaded.Len(), len(content))
+	}
+	if info.Size != int64(len(content)) || info.ModTime.IsZero() {
+		t.Fatalf("unexpected file info %+v", info)
+	}
+	if !slices.Equal(names, []string{"sdkTest.txt"}) {
+		t.Fatalf("listed %v", names)
+	}
+}
+
+func Test_shouldReturnTypedErrors_With_SDK(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	ctx := context.Background()
+	sdkClient := dialSDK(t, fileserver.WithCompression(false))
+
+	// when
+	_, getErr := sdkClient.GetFile(ctx, "missingSDKTest.txt", &bytes.Buffer{})
+	putErr := sdkClient.PutFile(ctx, "../escape.txt", bytes.NewReader(nil), 0)
+	_, listErr := sdkClient.List(ctx, "(")
+
+	// then
+	var statusErr *fileserver.StatusError
+	if !errors.As(getErr, &statusErr) || statusErr.Status != 404 || !errors.Is(getErr, fileserver.ErrNotFound) {
+		t.Errorf("got %v for a missing file", getErr)
+	}
+	if !errors.Is(putErr, fileserver.ErrInvalid) {
+		t.Errorf("got %v for an invalid name", putErr)
+	}
+	if !errors.Is(listErr, fileserver.ErrInvalid) {
+		t.Errorf("got %v for an invalid pattern", listErr)
+	}
+	if _, err := sdkClient.Stat(ctx, "missingSDKTest.txt"); !errors.Is(err, fileserver.ErrNotFound) {
+		t.Errorf("the session did not survive the rejected requests: %v", err)
+	}
+}
+
+func dialSDK(t *testing.T, opts ...fileserver.Option) *fileserver.Client {
+	sdkClient, err := fileserver.Dial(context.Background(), fmt.Sprintf(":%d", port), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := sdkClient.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return sdkClient
+}
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mat-sik/file-server-go/internal/client"
+	"github.com/mat-sik/file-server-go/internal/codec"
 	"github.com/mat-sik/file-server-go/internal/envs"
 	"github.com/mat-sik/file-server-go/internal/files"
+	"github.com/mat-sik/file-server-go/internal/fsutil"
 	"github.com/mat-sik/file-server-go/internal/message"
 	"github.com/mat-sik/file-server-go/internal/server"
 	"io/fs"
@@ -311,6 +313,183 @@ func Test_shouldPutFileToServer(t *testing.T) {
 	}
 }
 
+func Test_shouldTransferCompressedFiles(t *testing.T) {
+	// given
+	encodings := []codec.Encoding{codec.Gzip, codec.Zstd}
+	for _, encoding := range encodings {
+		createFile(filepath.Join(testServerStoragePath, compressedGetFilename(encoding)), 1024*1024)
+		createFile(filepath.Join(testClientStoragePath, compressedPutFilename(encoding)), 1024*1024)
+	}
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	webClient := getClient()
+
+	for _, encoding := range encodings {
+		t.Run(encoding.String(), func(t *testing.T) {
+			getFilename := compressedGetFilename(encoding)
+			putFilename := compressedPutFilename(encoding)
+
+			// when
+			getFileReq := message.GetFileRequest{
+				Filename:        getFilename,
+				AcceptEncodings: []codec.Encoding{encoding},
+			}
+			res, err := webClient.Run(getFileReq)
+
+			// then
+			if err != nil {
+				t.Fatal(err)
+			}
+			validateGetFileRes(t, res)
+			if res := res.(message.GetFileResponse); res.Encoding != encoding || res.EncodedSize >= res.Size {
+				t.Fatalf("got encoding %v of %d bytes, want compressed %v", res.Encoding, res.EncodedSize, encoding)
+			}
+			serverFilePath := filepath.Join(testServerStoragePath, getFilename)
+			if !filesEqual(filepath.Join(testClientStoragePath, getFilename), serverFilePath) {
+				t.Fatalf("file not equal")
+			}
+
+			// and when
+			putFileReq := message.PutFileRequest{Filename: putFilename, Encoding: encoding}
+			res, err = webClient.Run(putFileReq)
+
+			// then
+			if err != nil {
+				t.Fatal(err)
+			}
+			validatePutFileRes(t, res)
+			clientFilePath := filepath.Join(testClientStoragePath, putFilename)
+			if !filesEqual(filepath.Join(testServerStoragePath, putFilename), clientFilePath) {
+				t.Fatalf("file not equal")
+			}
+		})
+	}
+}
+
+func Test_shouldStoreFilesCompressedAtRest(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerCompressionPolicy, "*.log=zstd")()
+
+	filename := "compressedAtRest.log"
+	clientFilePath := filepath.Join(testClientStoragePath, filename)
+	createFile(clientFilePath, 1024*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	webClient := getClient()
+
+	// when
+	res, err := webClient.Run(message.PutFileRequest{Filename: filename})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatePutFileRes(t, res)
+	serverFilePath := filepath.Join(testServerStoragePath, filename)
+	stat, err := os.Stat(serverFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() >= 1024*1024 {
+		t.Fatalf("got %d bytes on disk, want the file stored compressed", stat.Size())
+	}
+
+	// and when
+	webClient = getClient()
+	res, err = webClient.Run(message.GetFileRequest{Filename: filename})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateGetFileRes(t, res)
+	if res := res.(message.GetFileResponse); res.Size != 1024*1024 || res.Encoding != codec.Identity {
+		t.Fatalf("got %d bytes encoded with %v, want the logical size decoded", res.Size, res.Encoding)
+	}
+	if !contentEqual(clientFilePath, 1024*1024) {
+		t.Fatalf("file not equal")
+	}
+
+	// and when
+	res, err = webClient.Run(message.GetFileRequest{Filename: filename, AcceptEncodings: []codec.Encoding{codec.Zstd}})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateGetFileRes(t, res)
+	if res := res.(message.GetFileResponse); res.Encoding != codec.Zstd || int64(res.EncodedSize) >= stat.Size() {
+		t.Fatalf("got %d bytes encoded with %v, want the stored body served as is", res.EncodedSize, res.Encoding)
+	}
+	if !contentEqual(clientFilePath, 1024*1024) {
+		t.Fatalf("file not equal")
+	}
+}
+
+func Test_shouldStoreFilesEncryptedAtRest(t *testing.T) {
+	// given
+	keyPath := filepath.Join(pathToTest, "master.key")
+	if _, err := files.GenerateMasterKey(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyPath)
+	defer setEnv(&envs.ServerMasterKeyPath, keyPath)()
+
+	filename := "encryptedAtRest.txt"
+	clientFilePath := filepath.Join(testClientStoragePath, filename)
+	createFile(clientFilePath, 1024*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	res, err := getClient().Run(message.PutFileRequest{Filename: filename})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatePutFileRes(t, res)
+	stored, err := os.ReadFile(filepath.Join(testServerStoragePath, filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("xxxxxxxx")) {
+		t.Fatalf("file stored in plaintext")
+	}
+
+	// and when
+	res, err = getClient().Run(message.GetFileRequest{Filename: filename})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateGetFileRes(t, res)
+	if !contentEqual(clientFilePath, 1024*1024) {
+		t.Fatalf("file not equal")
+	}
+}
+
+func contentEqual(path string, size int) bool {
+	expectedPath := filepath.Join(pathToTest, "expected")
+	createFile(expectedPath, size)
+	defer os.Remove(expectedPath)
+	return filesEqual(path, expectedPath)
+}
+
+func compressedGetFilename(encoding codec.Encoding) string {
+	return "compressedGetTest-" + encoding.String()
+}
+
+func compressedPutFilename(encoding codec.Encoding) string {
+	return "compressedPutTest-" + encoding.String()
+}
+
 func Test_shouldDeleteFileFromServer(t *testing.T) {
 	// given
 	filename := "deleteFileTest.txt"
@@ -372,13 +551,18 @@ func runServerBlockTillListening() context.CancelFunc {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
-	go runServer(ctx, wg)
+	done := make(chan struct{})
+	go runServer(ctx, wg, done)
 	wg.Wait()
 
-	return cancel
+	return func() {
+		cancel()
+		<-done
+	}
 }
 
-func runServer(ctx context.Context, wg *sync.WaitGroup) {
+func runServer(ctx context.Context, wg *sync.WaitGroup, done chan<- struct{}) {
+	defer close(done)
 	addr := fmt.Sprintf(":%d", port)
 
 	if err := server.RunWithWaitGroup(ctx, wg, addr); err != nil {
@@ -394,7 +578,7 @@ func getClient() client.Client {
 		panic(err)
 	}
 
-	return webClient
+	return webClient.WithStorageDir(envs.ClientStoragePath)
 }
 
 func fileExists(filename string) bool {
@@ -413,12 +597,12 @@ func filesEqual(firstPath string, secondPath string) bool {
 	if err != nil {
 		panic(err)
 	}
-	defer files.LoggedClose(firstFile)
+	defer fsutil.LoggedClose(firstFile)
 	secondFile, err := os.Open(secondPath)
 	if err != nil {
 		panic(err)
 	}
-	defer files.LoggedClose(secondFile)
+	defer fsutil.LoggedClose(secondFile)
 
 	return fileLengthEqual(firstFile, secondFile) && fileContentsEqual(firstFile, secondFile)
 }
@@ -445,11 +629,11 @@ func fileContentsEqual(firstFile *os.File, secondFile *os.File) bool {
 }
 
 func fileLengthEqual(firstFile *os.File, secondFile *os.File) bool {
-	firstSize, err := files.SizeOf(firstFile)
+	firstSize, err := fsutil.SizeOf(firstFile)
 	if err != nil {
 		panic(err)
 	}
-	secondSize, err := files.SizeOf(secondFile)
+	secondSize, err := fsutil.SizeOf(secondFile)
 	if err != nil {
 		panic(err)
 	}
@@ -462,7 +646,7 @@ func createFile(path string, size int) {
 	if err != nil {
 		panic(err)
 	}
-	defer files.LoggedClose(file)
+	defer fsutil.LoggedClose(file)
 
 	for i := 0; i < min(size, len(copyBuffer)); i++ {
 		copyBuffer[i] = 'x'
@@ -0,0 +1,271 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/auth"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_shouldServeFilesOverSFTP(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerSFTPAddr, fmt.Sprintf(":%d", sftpPort))()
+	defer setEnv(&envs.ServerSFTPHostKeyPath, filepath.Join(t.TempDir(), "host_key"))()
+
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setEnv(&envs.ServerUsersPath, writeSFTPUserStore(t, signer.PublicKey()))()
+
+	filename := "sftpTest.txt"
+	serverFilePath := filepath.Join(testServerStoragePath, filename)
+	createFile(serverFilePath, 64*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	_, err = dialSFTP(ssh.Password("wrong password"))
+
+	// then
+	if err == nil {
+		t.Fatalf("logged in with a wrong password")
+	}
+
+	// and when
+	sftpClient, err := dialSFTP(ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sftpClient.Close()
+
+	infos, err := sftpClient.ReadDir("/")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(infos, func(info os.FileInfo) bool { return info.Name() == filename && info.Size() == 64*1024 }) {
+		t.Fatalf("listing does not contain %s", filename)
+	}
+
+	// and when
+	file, err := sftpClient.Open("/" + filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := io.ReadAll(file)
+	file.Close()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := os.ReadFile(serverFilePath); !bytes.Equal(downloaded, expected) {
+		t.Fatalf("got %d bytes want %d", len(downloaded), len(expected))
+	}
+
+	// and when
+	uploadName := "sftpUpload.txt"
+	content := bytes.Repeat([]byte("dropped over sftp "), 32*1024)
+	file, err = sftpClient.Create("/" + uploadName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.ReadFrom(bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	res, err := getClient().Run(message.GetFileRequest{Filename: uploadName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateGetFileRes(t, res)
+	if received, _ := os.ReadFile(filepath.Join(testClientStoragePath, uploadName)); !bytes.Equal(received, content) {
+		t.Fatalf("native client got %d bytes want %d", len(received), len(content))
+	}
+
+	// and when
+	err = sftpClient.Rename("/"+uploadName, "/sftpRenamed.txt")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join(testServerStoragePath, uploadName)) || !fileExists(filepath.Join(testServerStoragePath, "sftpRenamed.txt")) {
+		t.Fatalf("file was not renamed")
+	}
+
+	// and when
+	err = sftpClient.Remove("/sftpRenamed.txt")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sftpClient.Stat("/sftpRenamed.txt"); !os.IsNotExist(err) {
+		t.Fatalf("got %v want a missing file", err)
+	}
+	if err = sftpClient.Mkdir("/dir"); err == nil {
+		t.Fatalf("created a directory in the flat store")
+	}
+}
+
+func Test_shouldReleaseIdleSFTPHandles_And_KeepFiles_When_UploadsFail(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerSFTPAddr, fmt.Sprintf(":%d", sftpPort))()
+	defer setEnv(&envs.ServerSFTPHostKeyPath, filepath.Join(t.TempDir(), "host_key"))()
+	defer setEnv(&envs.ServerIdleTimeout, "300ms")()
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setEnv(&envs.ServerUsersPath, writeSFTPUserStore(t, signer.PublicKey()))()
+
+	idleName, keptName := "sftpIdleTest.txt", "sftpKeptTest.txt"
+	createFile(filepath.Join(testServerStoragePath, idleName), 64*1024)
+	keptPath := filepath.Join(testServerStoragePath, keptName)
+	createFile(keptPath, 64*1024)
+	kept, err := os.ReadFile(keptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	sftpClient, err := dialSFTP(ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sftpClient.Close()
+
+	// when
+	file, err := sftpClient.Open("/" + idleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(600 * time.Millisecond)
+
+	// then
+	deleted := make(chan int, 1)
+	go func() {
+		res, err := getClient().Run(message.DeleteFileRequest{Filename: idleName})
+		if err != nil {
+			t.Error(err)
+		}
+		deleted <- res.(message.DeleteFileResponse).Status
+	}()
+	select {
+	case status := <-deleted:
+		if status != 200 {
+			t.Fatalf("got %d want 200", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the idle handle still holds the read lock")
+	}
+	if _, err = file.ReadAt(make([]byte, 10), 0); err == nil {
+		t.Fatalf("read from an expired handle")
+	}
+
+	// and when
+	abortingClient, err := dialSFTP(ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := abortingClient.Create("/" + keptName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.Write([]byte("partial upload")); err != nil {
+		t.Fatal(err)
+	}
+	abortingClient.Close()
+
+	// then
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		entries, _ := os.ReadDir(filepath.Join(testServerStoragePath, ".staging"))
+		if len(entries) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the aborted upload is still staged")
+		}
+	}
+	if stored, _ := os.ReadFile(keptPath); !bytes.Equal(stored, kept) {
+		t.Fatalf("an aborted upload replaced the %d bytes of the file with %d", len(kept), len(stored))
+	}
+}
+
+func dialSFTP(authMethod ssh.AuthMethod) (*sftp.Client, error) {
+	sshClient, err := ssh.Dial("tcp", fmt.Sprintf("localhost:%d", sftpPort), &ssh.ClientConfig{
+		User:            sftpUser,
+		Auth:            []ssh.AuthMethod{authMethod},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	return sftpClient, nil
+}
+
+func writeSFTPUserStore(t *testing.T, publicKey ssh.PublicKey) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(sftpPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := json.Marshal(map[string][]auth.User{"users": {{
+		Name:           sftpUser,
+		PasswordHash:   string(hash),
+		AuthorizedKeys: []string{string(ssh.MarshalAuthorizedKey(publicKey))},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err = os.WriteFile(path, store, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const (
+	sftpPort     = 33308
+	sftpUser     = "legacy"
+	sftpPassword = "legacy-password"
+)
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/cli"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_shouldRunScript_In_Shell(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	localPath := filepath.Join(t.TempDir(), "shellTest.txt")
+	if err := os.WriteFile(localPath, []byte("from the shell"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := strings.Join([]string{
+		fmt.Sprintf("put '%s'", localPath),
+		"cp shellTest.txt shellTestCopy.txt",
+		"ls ^shellTest",
+		"get -o - shellTestCopy.txt",
+		"stat missingShellTest.txt",
+		"cd /",
+		"cd nested",
+		"pwd",
+		"rm -r ^shellTest",
+		"history",
+		"exit",
+		"ls",
+	}, "\n")
+
+	// when
+	stdout, stderr := runCLI(t, cli.ExitOK, []byte(script), "shell")
+
+	// then
+	for _, expected := range []string{
+		"shellTest.txt\nshellTestCopy.txt\n",
+		"from the shell/\n",
+		"   9  rm -r ^shellTest\n  10  history\n",
+	} {
+		if !strings.Contains(string(stdout), expected) {
+			t.Errorf("output %q does not contain %q", stdout, expected)
+		}
+	}
+	for _, expected := range []string{"404", "cd nested: no such directory"} {
+		if !strings.Contains(string(stderr), expected) {
+			t.Errorf("errors %q do not contain %q", stderr, expected)
+		}
+	}
+	if strings.Contains(string(stdout), "  11  ") {
+		t.Errorf("commands after exit were run: %q", stdout)
+	}
+}
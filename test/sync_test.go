@@ -0,0 +1,133 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"github.com/mat-sik/file-server-go/internal/cli"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_shouldMirrorDirectories_With_CLISync(t *testing.T) {
+	// given
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	localDir := t.TempDir()
+	writeLocal(t, localDir, "syncTest1.txt", "one")
+	writeLocal(t, localDir, "syncTest2.txt", "two")
+	if err := os.Mkdir(filepath.Join(localDir, "syncTestDir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	runCLI(t, cli.ExitOK, []byte("old"), "put", "-name", "syncTestOld.txt", "-")
+	sync := func(args ...string) string {
+		stdout, _ := runCLI(t, cli.ExitOK, nil, append([]string{"sync"}, args...)...)
+		return string(stdout)
+	}
+
+	// when
+	planned := sync("push", "-n", "-delete", "-match", "^syncTest", localDir)
+	pushed := sync("push", "-delete", "-match", "^syncTest", localDir)
+
+	// then
+	if !strings.Contains(planned, "would put "+filepath.Join(localDir, "syncTest1.txt")+" -> syncTest1.txt (new)") ||
+		!strings.Contains(planned, "would rm syncTestOld.txt (extraneous)") ||
+		!strings.HasSuffix(planned, "sync push: 2 to transfer, 1 to delete, 0 unchanged\n") {
+		t.Errorf("dry run printed %q", planned)
+	}
+	if !strings.HasSuffix(pushed, "sync push: 2 transferred, 1 deleted, 0 unchanged\n") {
+		t.Errorf("push printed %q", pushed)
+	}
+	assertRemote(t, "syncTest1.txt", "one")
+	assertSameModTime(t, filepath.Join(localDir, "syncTest1.txt"), "syncTest1.txt")
+	if stdout, _ := runCLI(t, cli.ExitOK, nil, "ls", "^syncTest"); string(stdout) != "syncTest1.txt\nsyncTest2.txt\n" {
+		t.Errorf("the server has %q after the push", stdout)
+	}
+
+	// when
+	writeLocal(t, localDir, "syncTest1.txt", "uno")
+	touched := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(localDir, "syncTest2.txt"), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	pushed = sync("push", "-match", "^syncTest", localDir)
+
+	// then
+	if !strings.Contains(pushed, "syncTest1.txt (3 bytes)") ||
+		!strings.HasSuffix(pushed, "sync push: 1 transferred, 0 deleted, 1 unchanged\n") {
+		t.Errorf("push of a modified file printed %q", pushed)
+	}
+	assertRemote(t, "syncTest1.txt", "uno")
+	assertSameModTime(t, filepath.Join(localDir, "syncTest2.txt"), "syncTest2.txt")
+	if pushed = sync("push", "-match", "^syncTest", localDir); !strings.HasSuffix(pushed, "sync push: 0 transferred, 0 deleted, 2 unchanged\n") {
+		t.Errorf("push of unchanged files printed %q", pushed)
+	}
+
+	// when
+	pullDir := filepath.Join(t.TempDir(), "pulled")
+	pulled := sync("pull", "-match", "^syncTest", pullDir)
+	writeLocal(t, pullDir, "syncTestLocal.txt", "local")
+	pulledAgain := sync("pull", "-delete", "-match", "^syncTest", pullDir)
+
+	// then
+	if !strings.HasSuffix(pulled, "sync pull: 2 transferred, 0 deleted, 0 unchanged\n") {
+		t.Errorf("pull printed %q", pulled)
+	}
+	if !strings.Contains(pulledAgain, "rm "+filepath.Join(pullDir, "syncTestLocal.txt")+"\n") ||
+		!strings.HasSuffix(pulledAgain, "sync pull: 0 transferred, 1 deleted, 2 unchanged\n") {
+		t.Errorf("second pull printed %q", pulledAgain)
+	}
+	for name, content := range map[string]string{"syncTest1.txt": "uno", "syncTest2.txt": "two"} {
+		path := filepath.Join(pullDir, name)
+		if got, err := os.ReadFile(path); err != nil || string(got) != content {
+			t.Errorf("pulled %s holds %q, %v want %q", name, got, err, content)
+		}
+		assertSameModTime(t, path, name)
+	}
+	if _, err := os.Stat(filepath.Join(pullDir, "syncTestLocal.txt")); !os.IsNotExist(err) {
+		t.Errorf("the extraneous local file was not deleted: %v", err)
+	}
+
+	runCLI(t, cli.ExitOK, nil, "rm", "-r", "^syncTest")
+	runCLI(t, cli.ExitUsage, nil, "sync", "both", localDir)
+}
+
+func writeLocal(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertRemote(t *testing.T, name string, content string) {
+	t.Helper()
+	webClient := getClient()
+	defer webClient.Close()
+	var stored bytes.Buffer
+	if _, err := webClient.Get(context.Background(), name, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.String() != content {
+		t.Errorf("%s holds %q on the server want %q", name, stored.String(), content)
+	}
+}
+
+func assertSameModTime(t *testing.T, path string, name string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webClient := getClient()
+	defer webClient.Close()
+	res, err := webClient.Stat(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.ModTime.Equal(info.ModTime()) {
+		t.Errorf("%s was modified at %v on the server and at %v locally", name, res.ModTime, info.ModTime())
+	}
+}
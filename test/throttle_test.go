@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/server"
+	"github.com/mat-sik/file-server-go/internal/throttle"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_shouldThrottleTransfers_With_LimitsSetThroughAdmin(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerAdminAddr, fmt.Sprintf(":%d", adminPort))()
+	defer setEnv(&envs.ServerAdminToken, "secret")()
+
+	filename := "throttleTest.txt"
+	createFile(filepath.Join(testServerStoragePath, filename), 96*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	limits := throttle.Limits{Classes: map[string]int64{"get": 320 * 1024}}
+	if status := putThrottle(t, limits, "secret"); status != http.StatusOK {
+		t.Fatalf("got %d want %d", status, http.StatusOK)
+	}
+
+	// when
+	start := time.Now()
+	res, err := getClient().Run(message.GetFileRequest{Filename: filename})
+	elapsed := time.Since(start)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateGetFileRes(t, res)
+	if elapsed < 250*time.Millisecond {
+		t.Fatalf("downloaded 96 KiB at 320 KiB/s in %v", elapsed)
+	}
+
+	var current throttle.Limits
+	if err = json.Unmarshal(readBody(t, doAdmin(t, http.MethodGet, nil, "secret")), &current); err != nil {
+		t.Fatal(err)
+	}
+	if current.Classes["get"] != limits.Classes["get"] {
+		t.Fatalf("got %v want %v", current, limits)
+	}
+}
+
+func Test_shouldRejectAdminRequests_Without_Token(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerAdminAddr, fmt.Sprintf(":%d", adminPort))()
+	defer setEnv(&envs.ServerAdminToken, "secret")()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	status := putThrottle(t, throttle.Limits{Global: 1}, "wrong")
+
+	// then
+	if status != http.StatusUnauthorized {
+		t.Fatalf("got %d want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func Test_shouldThrottleHTTPDownloads_Per_Principal(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerAdminAddr, fmt.Sprintf(":%d", adminPort))()
+	defer setEnv(&envs.ServerAdminToken, "secret")()
+	defer setEnv(&envs.ServerHTTPAddr, fmt.Sprintf(":%d", httpPort))()
+
+	filename := "throttleHTTPTest.txt"
+	createFile(filepath.Join(testServerStoragePath, filename), 96*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	if status := putThrottle(t, throttle.Limits{PerPrincipal: 320 * 1024}, "secret"); status != http.StatusOK {
+		t.Fatalf("got %d want %d", status, http.StatusOK)
+	}
+
+	// when
+	start := time.Now()
+	body := readBody(t, doHTTP(t, http.MethodGet, "/files/"+filename, nil, nil))
+	elapsed := time.Since(start)
+
+	// then
+	if len(body) != 96*1024 {
+		t.Fatalf("got %d bytes want %d", len(body), 96*1024)
+	}
+	if elapsed < 250*time.Millisecond {
+		t.Fatalf("downloaded 96 KiB at 320 KiB/s in %v", elapsed)
+	}
+}
+
+func Test_shouldRefuseToServeAdmin_Without_Token(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerAdminAddr, fmt.Sprintf(":%d", adminPort))()
+	defer setEnv(&envs.ServerAdminToken, "")()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	// when
+	err := server.RunWithWaitGroup(ctx, wg, fmt.Sprintf(":%d", port))
+
+	// then
+	if err == nil || !strings.Contains(err.Error(), "SERVER_ADMIN_TOKEN") {
+		t.Fatalf("got %v want an error asking for SERVER_ADMIN_TOKEN", err)
+	}
+}
+
+func putThrottle(t *testing.T, limits throttle.Limits, token string) int {
+	body, err := json.Marshal(limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := doAdmin(t, http.MethodPut, body, token)
+	readBody(t, res)
+	return res.StatusCode
+}
+
+func doAdmin(t *testing.T, method string, body []byte, token string) *http.Response {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d/throttle", adminPort), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	// Connections kept alive would outlive the server of the test.
+	req.Close = true
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+const adminPort = 33311
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/tracing"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_shouldPropagateTraceFromClientToServer(t *testing.T) {
+	// given
+	filename := "tracingTest.txt"
+	createFile(filepath.Join(testClientStoragePath, filename), 16*1024)
+
+	spans := &spanRecorder{}
+	tracing.SetExporter(spans)
+	defer tracing.SetExporter(nil)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	webClient := getClient()
+	res, err := webClient.Run(message.PutFileRequest{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatePutFileRes(t, res)
+
+	// then
+	client, ok := spans.find("client.run", "")
+	if !ok {
+		t.Fatal("client span was not exported")
+	}
+	server, ok := spans.find("server.handle", client.TraceID)
+	if !ok {
+		t.Fatal("server span does not belong to the trace of the client")
+	}
+	if server.ParentSpanID != client.SpanID {
+		t.Fatalf("got parent %s want %s", server.ParentSpanID, client.SpanID)
+	}
+	if server.Attributes["type"] != "put" || server.Attributes["filename"] != filename {
+		t.Fatalf("unexpected server span attributes %v", server.Attributes)
+	}
+	for _, name := range []string{"net.stream_out", "net.stream_in", "file.lock_wait", "file.store"} {
+		if _, ok = spans.find(name, client.TraceID); !ok {
+			t.Errorf("trace does not contain a %s span", name)
+		}
+	}
+}
+
+// spanRecorder keeps the spans ended by the client and the server goroutines.
+type spanRecorder struct {
+	mutex sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(span tracing.SpanData) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// find returns the first span named name, of the trace traceID unless it is empty. The server ends
+// its spans after the client received the response, so find waits a while for them.
+func (r *spanRecorder) find(name string, traceID string) (tracing.SpanData, bool) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if span, ok := r.lookup(name, traceID); ok {
+			return span, true
+		}
+	}
+	return tracing.SpanData{}, false
+}
+
+func (r *spanRecorder) lookup(name string, traceID string) (tracing.SpanData, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, span := range r.spans {
+		if span.Name == name && (traceID == "" || span.TraceID == traceID) {
+			return span, true
+		}
+	}
+	return tracing.SpanData{}, false
+}
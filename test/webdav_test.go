@@ -0,0 +1,184 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_shouldServeFilesOverWebDAV(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerWebDAVAddr, fmt.Sprintf(":%d", webDAVPort))()
+	defer setEnv(&envs.ServerHTTPAddr, fmt.Sprintf(":%d", httpPort))()
+
+	filename := "webdavTest.txt"
+	serverFilePath := filepath.Join(testServerStoragePath, filename)
+	createFile(serverFilePath, 16*1024)
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	res := doWebDAV(t, "PROPFIND", "/", nil, map[string]string{"Depth": "1"})
+
+	// then
+	body := readBody(t, res)
+	if res.StatusCode != http.StatusMultiStatus || !bytes.Contains(body, []byte("/"+filename)) {
+		t.Fatalf("got %d with %s, want 207 listing %s", res.StatusCode, body, filename)
+	}
+
+	// and when
+	res = doWebDAV(t, http.MethodGet, "/"+filename, nil, nil)
+
+	// then
+	if body = readBody(t, res); res.StatusCode != http.StatusOK || len(body) != 16*1024 {
+		t.Fatalf("got %d with %d bytes, want 200 with %d bytes", res.StatusCode, len(body), 16*1024)
+	}
+
+	// and when
+	uploaded := []byte("uploaded over webdav")
+	res = doWebDAV(t, http.MethodPut, "/webdavUpload.txt", uploaded, nil)
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusCreated)
+	}
+
+	// and when
+	res = doWebDAV(t, "COPY", "/webdavUpload.txt", nil, map[string]string{"Destination": "/webdavCopy.txt"})
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusCreated)
+	}
+
+	// and when
+	res = doWebDAV(t, "MOVE", "/webdavCopy.txt", nil, map[string]string{"Destination": "/webdavMoved.txt"})
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusCreated || fileExists(filepath.Join(testServerStoragePath, "webdavCopy.txt")) {
+		t.Fatalf("got %d, want webdavCopy.txt moved", res.StatusCode)
+	}
+	res = doHTTP(t, http.MethodGet, "/files/webdavMoved.txt", nil, nil)
+	if body = readBody(t, res); !bytes.Equal(body, uploaded) {
+		t.Fatalf("got %q want %q", body, uploaded)
+	}
+
+	// and when
+	res = doWebDAV(t, "MKCOL", "/directory", nil, nil)
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	// and when
+	res = doWebDAV(t, "LOCK", "/"+filename, []byte(lockInfo), map[string]string{"Timeout": "Second-60"})
+
+	// then
+	readBody(t, res)
+	lockToken := res.Header.Get("Lock-Token")
+	if res.StatusCode != http.StatusOK || lockToken == "" {
+		t.Fatalf("got %d with Lock-Token %q, want 200 with a token", res.StatusCode, lockToken)
+	}
+	res = doHTTP(t, http.MethodDelete, "/files/"+filename, nil, nil)
+	if readBody(t, res); res.StatusCode != http.StatusLocked {
+		t.Fatalf("got %d want %d while locked", res.StatusCode, http.StatusLocked)
+	}
+	res = doWebDAV(t, http.MethodDelete, "/"+filename, nil, nil)
+	if readBody(t, res); res.StatusCode != http.StatusLocked {
+		t.Fatalf("got %d want %d without the lock token", res.StatusCode, http.StatusLocked)
+	}
+
+	// and when
+	res = doWebDAV(t, "UNLOCK", "/"+filename, nil, map[string]string{"Lock-Token": lockToken})
+
+	// then
+	readBody(t, res)
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusNoContent)
+	}
+	res = doWebDAV(t, http.MethodDelete, "/"+filename, nil, nil)
+	if readBody(t, res); res.StatusCode != http.StatusNoContent || fileExists(serverFilePath) {
+		t.Fatalf("got %d, want the file deleted", res.StatusCode)
+	}
+}
+
+func Test_shouldKeepFile_When_WebDAVUploadIsCutShort(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerWebDAVAddr, fmt.Sprintf(":%d", webDAVPort))()
+
+	filename := "webdavCutShortTest.txt"
+	serverFilePath := filepath.Join(testServerStoragePath, filename)
+	createFile(serverFilePath, 16*1024)
+	original, err := os.ReadFile(serverFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	// when
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", webDAVPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fmt.Fprintf(conn, "PUT /%s HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000\r\n\r\nonly a part", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	// then
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if stored, _ := os.ReadFile(serverFilePath); !bytes.Equal(stored, original) {
+			t.Fatalf("a cut short upload replaced the %d bytes of the file with %d", len(original), len(stored))
+		}
+	}
+	res := doWebDAV(t, http.MethodGet, "/"+filename, nil, nil)
+	if body := readBody(t, res); res.StatusCode != http.StatusOK || !bytes.Equal(body, original) {
+		t.Fatalf("got %d with %d bytes, want 200 with %d", res.StatusCode, len(body), len(original))
+	}
+	assertNoStagedUploads(t)
+}
+
+func doWebDAV(t *testing.T, method string, path string, body []byte, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d%s", webDAVPort, path), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range headers {
+		if key == "Destination" {
+			value = fmt.Sprintf("http://localhost:%d%s", webDAVPort, value)
+		}
+		req.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+var lockInfo = strings.TrimSpace(`
+<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope><D:exclusive/></D:lockscope>
+  <D:locktype><D:write/></D:locktype>
+</D:lockinfo>`)
+
+const webDAVPort = 33306
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mat-sik/file-server-go/internal/envs"
+	"github.com/mat-sik/file-server-go/internal/message"
+	"github.com/mat-sik/file-server-go/internal/netmsg"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func Test_shouldServeNativeProtocolOverWebSocket(t *testing.T) {
+	// given
+	defer setEnv(&envs.ServerWebSocketAddr, fmt.Sprintf(":%d", webSocketPort))()
+	defer setEnv(&envs.ServerWebSocketOrigins, "https://other.example,http://tool.example")()
+
+	cancel := runServerBlockTillListening()
+	defer cancel()
+
+	config, err := websocket.NewConfig(fmt.Sprintf("ws://localhost:%d/", webSocketPort), "http://tool.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.PayloadType = websocket.BinaryFrame
+	session := netmsg.NewSession(conn)
+	ctx := context.Background()
+
+	// when
+	filename := "webSocketUpload.txt"
+	content := bytes.Repeat([]byte("sent from a browser "), 4*1024)
+	if err = session.SendMessage(ctx, message.PutFileRequest{Filename: filename, Size: len(content)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.StreamToNet(ctx, bytes.NewReader(content), len(content)); err != nil {
+		t.Fatal(err)
+	}
+	_, res, err := session.ReceiveMessage(ctx)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, ok := res.(message.PutFileResponse); !ok || res.Status != http.StatusCreated {
+		t.Fatalf("got %+v want %d", res, http.StatusCreated)
+	}
+	if !fileExists(filepath.Join(testServerStoragePath, filename)) {
+		t.Fatalf("uploaded file is not stored")
+	}
+
+	// and when
+	if err = session.SendMessage(ctx, message.GetFileRequest{Filename: filename}); err != nil {
+		t.Fatal(err)
+	}
+	_, res, err = session.ReceiveMessage(ctx)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	getRes, ok := res.(message.GetFileResponse)
+	if !ok || getRes.Status != http.StatusOK {
+		t.Fatalf("got %+v want %d", res, http.StatusOK)
+	}
+	var downloaded bytes.Buffer
+	if err = session.StreamFromNet(ctx, &downloaded, getRes.TransferSize()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded.Bytes(), content) {
+		t.Fatalf("got %d bytes want %d", downloaded.Len(), len(content))
+	}
+
+	// and when
+	config, err = websocket.NewConfig(fmt.Sprintf("ws://localhost:%d/", webSocketPort), "http://evil.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = websocket.DialConfig(config)
+
+	// then
+	if err == nil {
+		t.Fatalf("a page of a foreign origin connected")
+	}
+}
+
+const webSocketPort = 33309